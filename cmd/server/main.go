@@ -18,12 +18,18 @@ import (
 	"github.com/qiangxue/go-rest-api/internal/config"
 	"github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/internal/healthcheck"
+	"github.com/qiangxue/go-rest-api/internal/links"
+	"github.com/qiangxue/go-rest-api/internal/notebooks"
 	"github.com/qiangxue/go-rest-api/internal/notes"
+	"github.com/qiangxue/go-rest-api/internal/savedsearches"
+	"github.com/qiangxue/go-rest-api/internal/tags"
+	"github.com/qiangxue/go-rest-api/internal/tokens"
+	"github.com/qiangxue/go-rest-api/internal/workspaces"
 	"github.com/qiangxue/go-rest-api/pkg/accesslog"
 	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
 	"github.com/qiangxue/go-rest-api/pkg/log"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
+	"github.com/qiangxue/go-rest-api/pkg/mail"
+	"github.com/qiangxue/go-rest-api/pkg/pagination"
 )
 
 // Version indicates the current version of the application.
@@ -57,17 +63,26 @@ func main() {
 		}
 	}()
 
-	dsn := "host=localhost user=postgres password=postgres dbname=go_restful port=5433 sslmode=disable TimeZone=Asia/Kolkata"
-	gormDB, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// permanently remove notes that have been in the trash for longer than the retention period
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	purger := notes.NewPurger(notes.NewRepository(dbcontext.New(db), logger),
+		time.Duration(cfg.TrashRetention)*24*time.Hour, time.Hour, logger)
+	go purger.Run(ctx)
+	go auth.NewPurger(auth.NewTokenRepository(dbcontext.New(db), logger), time.Hour, logger).Run(ctx)
+
+	// set up the search index of the notes
+	searchIndex, err := newSearchIndex(ctx, cfg, dbcontext.New(db), logger)
 	if err != nil {
-		panic("failed to connect database")
+		logger.Errorf("failed to build the search index: %s", err)
+		os.Exit(-1)
 	}
 
 	// build HTTP server
 	address := fmt.Sprintf(":%v", cfg.ServerPort)
 	hs := &http.Server{
 		Addr:    address,
-		Handler: buildHandler(logger, gormDB, dbcontext.New(db), cfg),
+		Handler: buildHandler(logger, dbcontext.New(db), cfg, searchIndex),
 	}
 
 	// start the HTTP server with graceful shutdown
@@ -79,8 +94,56 @@ func main() {
 	}
 }
 
+// newSearchIndex creates the search index of the notes for the configured backend.
+// The memory index is filled with the notes in the database before it is returned.
+func newSearchIndex(ctx context.Context, cfg *config.Config, db *dbcontext.DB, logger log.Logger) (notes.SearchIndex, error) {
+	if cfg.SearchBackend == config.SearchBackendMemory {
+		index := notes.NewMemorySearchIndex(notes.NewRepository(db, logger))
+		return index, index.Rebuild(ctx)
+	}
+	return notes.NewPostgresSearchIndex(db, logger), nil
+}
+
+// newPasswordHasher creates the hasher of the passwords of the users with the configured algorithm and parameters.
+func newPasswordHasher(cfg *config.Config) auth.PasswordHasher {
+	return auth.NewPasswordHasher(auth.PasswordParams{
+		Algorithm:   cfg.PasswordHash,
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+		Cost:        cfg.BcryptCost,
+	})
+}
+
+// newOIDCProvider creates the OpenID Connect provider users can log in with, or returns nil if none is configured.
+func newOIDCProvider(cfg *config.Config) *auth.OIDCProvider {
+	if cfg.OIDCIssuer == "" {
+		return nil
+	}
+	return auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  cfg.OIDCRedirectURL,
+	}, nil)
+}
+
+// newMailer creates the mailer sending emails through the configured SMTP server, or returns nil if none is configured.
+func newMailer(cfg *config.Config) mail.Mailer {
+	if cfg.SMTPHost == "" {
+		return nil
+	}
+	return mail.NewSMTPMailer(mail.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.MailFrom,
+	})
+}
+
 // buildHandler sets up the HTTP routing and builds an HTTP handler.
-func buildHandler(logger log.Logger, gormDB *gorm.DB, db *dbcontext.DB, cfg *config.Config) http.Handler {
+func buildHandler(logger log.Logger, db *dbcontext.DB, cfg *config.Config, searchIndex notes.SearchIndex) http.Handler {
 	router := routing.New()
 
 	router.Use(
@@ -95,17 +158,69 @@ func buildHandler(logger log.Logger, gormDB *gorm.DB, db *dbcontext.DB, cfg *con
 	// rg := router.Group("/api/v1")
 	rg := router.Group("/api")
 
-	authHandler := auth.Handler(cfg.JWTSigningKey)
+	workspaceRepo := workspaces.NewRepository(db, logger)
+	tokenRepo := auth.NewTokenRepository(db, logger)
+	accessTokenRepo := tokens.NewRepository(db, logger)
+	authHandler := auth.Handler(cfg.JWTSigningKey, tokenRepo, workspaceRepo, accessTokenRepo)
+	// workspaces, personal access tokens and logging out are not available to personal access tokens
+	jwtHandler := auth.Handler(cfg.JWTSigningKey, tokenRepo, workspaceRepo, nil)
 	rateLimiter := auth.RateLimiter()
-
+	loginThrottle := auth.NewLoginThrottle(auth.ThrottleConfig{
+		MaxFailures:   cfg.LoginMaxFailures,
+		MaxIPFailures: cfg.LoginMaxIPFailures,
+		Lockout:       time.Duration(cfg.LoginLockout) * time.Minute,
+	}, logger)
+
+	noteRepo := notes.NewRepository(db, logger)
+	noteService := notes.NewService(noteRepo, searchIndex, auth.NewRepository(db, logger), cfg.RevisionRetention, logger)
 	notes.RegisterHandlers(rg.Group(""),
-		notes.NewService(notes.NewRepository(gormDB, db, logger), logger),
+		noteService,
+		pagination.NewCursors(cfg.CursorSigningKey),
+		authHandler, rateLimiter, logger)
+
+	linkRepo := links.NewRepository(db, logger)
+	linkService := links.NewService(linkRepo, loginThrottle, logger)
+	links.RegisterHandlers(rg.Group(""), linkService, authHandler, rateLimiter, logger)
+	// share links are opened by people without an account, outside of the API
+	links.RegisterPublicHandlers(router.Group(""), linkService, logger)
+
+	savedSearchRepo := savedsearches.NewRepository(db, logger)
+	savedsearches.RegisterHandlers(rg.Group(""),
+		savedsearches.NewService(savedSearchRepo, noteService, logger),
+		authHandler, rateLimiter, logger)
+
+	notebookRepo := notebooks.NewRepository(db, logger)
+	notebooks.RegisterHandlers(rg.Group(""),
+		notebooks.NewService(notebookRepo, logger),
+		authHandler, rateLimiter, logger)
+
+	workspaces.RegisterHandlers(rg.Group(""),
+		workspaces.NewService(workspaceRepo, auth.NewRepository(db, logger), logger),
+		jwtHandler, rateLimiter, logger)
+
+	tagRepo := tags.NewRepository(db, logger)
+	tags.RegisterHandlers(rg.Group(""),
+		tags.NewService(tagRepo, logger),
 		authHandler, rateLimiter, logger)
 
+	tokens.RegisterHandlers(rg.Group(""),
+		tokens.NewService(accessTokenRepo, logger),
+		jwtHandler, rateLimiter, logger)
+
+	// the data of the other packages is deleted along with the accounts, starting with the workspaces, which
+	// refuse the deletion of their owners
+	accountData := auth.AccountData{
+		Repos: []auth.UserDataRepo{workspaceRepo, linkRepo, tagRepo, notebookRepo, savedSearchRepo, accessTokenRepo},
+		Notes: noteRepo,
+		Index: searchIndex,
+	}
 	auth.RegisterHandlers(rg.Group(""),
-		auth.NewService(auth.NewRepository(db, logger), cfg.JWTSigningKey, cfg.JWTExpiration, logger),
-		logger,
+		auth.NewService(auth.NewRepository(db, logger), tokenRepo, newPasswordHasher(cfg), newOIDCProvider(cfg),
+			newMailer(cfg), cfg.PasswordResetURL, accountData, cfg.JWTSigningKey,
+			time.Duration(cfg.AccessTokenExpiration)*time.Minute, time.Duration(cfg.RefreshTokenExpiration)*time.Hour, logger),
+		loginThrottle, jwtHandler, logger,
 	)
+	auth.RegisterAdminHandlers(rg.Group("/admin"), loginThrottle, jwtHandler, cfg.AdminUsers)
 
 	return router
 }
@@ -14,9 +14,8 @@ require (
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.4.0 // indirect
 	go.uber.org/zap v1.13.0
+	golang.org/x/crypto v0.14.0
 	golang.org/x/lint v0.0.0-20200130185559-910be7a94367 // indirect
 	golang.org/x/time v0.5.0
 	gopkg.in/yaml.v2 v2.2.2
-	gorm.io/driver/postgres v1.5.4
-	gorm.io/gorm v1.25.5
 )
@@ -1,19 +1,133 @@
 package auth
 
 import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
 	routing "github.com/go-ozzo/ozzo-routing/v2"
 	"github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/pkg/log"
 )
 
 // RegisterHandlers registers handlers for different HTTP requests.
-func RegisterHandlers(rg *routing.RouteGroup, service Service, logger log.Logger) {
-	rg.Post("/login", login(service, logger))
+// Failed logins are throttled by the given login throttle.
+func RegisterHandlers(rg *routing.RouteGroup, service Service, throttle *LoginThrottle, authHandler routing.Handler, logger log.Logger) {
+	rg.Post("/login", login(service, throttle, logger))
 	rg.Post("/signup", signup(service, logger))
+	rg.Post("/token/refresh", refresh(service, logger))
+	rg.Post("/logout", authHandler, logout(service, logger))
+	rg.Get("/oidc/login", oidcLogin(service, logger))
+	rg.Get("/oidc/callback", oidcCallback(service, logger))
+	rg.Post("/login/2fa", loginTwoFactor(service, throttle, logger))
+	rg.Post("/2fa/enroll", authHandler, enrollTOTP(service))
+	rg.Post("/2fa/verify", authHandler, verifyTOTP(service, logger))
+	rg.Post("/2fa/disable", authHandler, disableTOTP(service, logger))
+	rg.Post("/2fa/recovery-codes", authHandler, regenerateRecoveryCodes(service, logger))
+	rg.Get("/me", authHandler, getProfile(service))
+	rg.Patch("/me", authHandler, updateProfile(service, logger))
+	rg.Delete("/me", authHandler, deleteAccount(service, logger))
+	rg.Post("/me/password", authHandler, changePassword(service, logger))
+	rg.Post("/password/forgot", forgotPassword(service, throttle, logger))
+	rg.Post("/password/reset", resetPassword(service, logger))
+}
+
+// RegisterAdminHandlers registers the handlers of the administrators, who are the users with the given names.
+func RegisterAdminHandlers(rg *routing.RouteGroup, throttle *LoginThrottle, authHandler routing.Handler, admins []string) {
+	rg.Post("/users/<username>/unlock", authHandler, RequireAdmin(admins), unlock(throttle))
+}
+
+// unlock returns a handler that unlocks a username locked after too many failed logins.
+func unlock(throttle *LoginThrottle) routing.Handler {
+	return func(c *routing.Context) error {
+		throttle.Unlock(c.Request.Context(), c.Param("username"))
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// checkThrottle rejects the login attempt if the client has to wait before trying again.
+func checkThrottle(c *routing.Context, throttle *LoginThrottle, username, ip string) error {
+	wait := throttle.Check(c.Request.Context(), username, ip)
+	if wait <= 0 {
+		return nil
+	}
+	setRetryAfter(c, wait)
+	return errors.TooManyRequests("too many failed login attempts, please try again later")
+}
+
+// setRetryAfter tells the client how long to wait before trying again.
+func setRetryAfter(c *routing.Context, wait time.Duration) {
+	c.Response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// recordAttempt records the outcome of a login attempt with the throttle. Only rejected credentials and codes count
+// as failures.
+func recordAttempt(c *routing.Context, throttle *LoginThrottle, username, ip string, err error) {
+	ctx := c.Request.Context()
+	if err == nil {
+		if username != "" {
+			throttle.Succeed(ctx, username, ip)
+		}
+	} else if rejected(err) {
+		throttle.Fail(ctx, username, ip)
+	}
+}
+
+// rejected reports whether the error rejects the credentials or the code of a login attempt.
+func rejected(err error) bool {
+	e, ok := err.(errors.ErrorResponse)
+	return ok && e.Status == http.StatusUnauthorized
+}
+
+// oidcCookie is the cookie keeping the session of a login with the OpenID Connect provider.
+const oidcCookie = "oidc_session"
+
+// oidcLogin returns a handler that redirects the user to the OpenID Connect provider to log in.
+func oidcLogin(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		redirect, err := service.OIDCLogin(c.Request.Context())
+		if err != nil {
+			return err
+		}
+		http.SetCookie(c.Response, &http.Cookie{
+			Name:     oidcCookie,
+			Value:    redirect.Session,
+			Path:     "/",
+			MaxAge:   int(oidcSessionExpiration.Seconds()),
+			HttpOnly: true,
+			Secure:   c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(c.Response, c.Request, redirect.URL, http.StatusFound)
+		return nil
+	}
+}
+
+// oidcCallback returns a handler that completes the login when the OpenID Connect provider redirects the user back.
+func oidcCallback(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		if reason := c.Query("error"); reason != "" {
+			logger.With(c.Request.Context()).Infof("OIDC login failed: %v", reason)
+			return errors.Unauthorized("the login was not completed")
+		}
+		cookie, err := c.Request.Cookie(oidcCookie)
+		if err != nil {
+			return errors.Unauthorized("the login has expired")
+		}
+		http.SetCookie(c.Response, &http.Cookie{Name: oidcCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+
+		tokens, err := service.OIDCCallback(c.Request.Context(), cookie.Value, c.Query("state"), c.Query("code"))
+		if err != nil {
+			return err
+		}
+		return c.Write(tokens)
+	}
 }
 
 // login returns a handler that handles user login request.
-func login(service Service, logger log.Logger) routing.Handler {
+func login(service Service, throttle *LoginThrottle, logger log.Logger) routing.Handler {
 	return func(c *routing.Context) error {
 		var req struct {
 			Username string `json:"username"`
@@ -25,13 +139,21 @@ func login(service Service, logger log.Logger) routing.Handler {
 			return errors.BadRequest("")
 		}
 
-		token, err := service.Login(c.Request.Context(), req.Username, req.Password)
+		ip := clientIP(c.Request)
+		if err := checkThrottle(c, throttle, req.Username, ip); err != nil {
+			return err
+		}
+		result, err := service.Login(c.Request.Context(), req.Username, req.Password)
 		if err != nil {
+			recordAttempt(c, throttle, req.Username, ip, err)
 			return err
 		}
-		return c.Write(struct {
-			Token string `json:"token"`
-		}{token})
+		// the failures of the username are only cleared once the second factor is checked as well
+		if result.Challenge != nil {
+			return c.Write(result.Challenge)
+		}
+		recordAttempt(c, throttle, req.Username, ip, nil)
+		return c.Write(result.Tokens)
 	}
 }
 
@@ -40,6 +162,7 @@ func signup(service Service, logger log.Logger) routing.Handler {
 		var req struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
+			Email    string `json:"email"`
 		}
 
 		if err := c.Read(&req); err != nil {
@@ -47,15 +170,290 @@ func signup(service Service, logger log.Logger) routing.Handler {
 			return errors.BadRequest("")
 		}
 
-		token, err := service.Signup(c.Request.Context(), req.Username, req.Password)
+		tokens, err := service.Signup(c.Request.Context(), req.Username, req.Password, req.Email)
 		if err != nil {
 			c.Response.WriteHeader(400)
 			return c.Write(struct {
 				Error string `json:"error"`
 			}{err.Error()})
 		}
-		return c.Write(struct {
-			Token string `json:"token"`
-		}{token})
+		return c.Write(tokens)
+	}
+}
+
+// refresh returns a handler that exchanges a refresh token for new tokens.
+func refresh(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if err := c.Read(&req); err != nil || req.RefreshToken == "" {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		tokens, err := service.Refresh(c.Request.Context(), req.RefreshToken)
+		if err != nil {
+			return err
+		}
+		return c.Write(tokens)
+	}
+}
+
+// logout returns a handler that revokes the access token of the request and, if the refresh token
+// is given in the body, the session it belongs to.
+func logout(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if c.Request.ContentLength != 0 {
+			if err := c.Read(&req); err != nil {
+				logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+				return errors.BadRequest("")
+			}
+		}
+
+		tokenID, _ := c.Get("token_id").(string)
+		expiresAt, _ := c.Get("token_expires_at").(time.Time)
+		if err := service.Logout(c.Request.Context(), req.RefreshToken, tokenID, expiresAt); err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// loginTwoFactor returns a handler that completes a login by exchanging the challenge token and a code for tokens.
+// Wrong codes count as failed logins of the user, and revoke the challenge once it failed too often.
+func loginTwoFactor(service Service, throttle *LoginThrottle, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			ChallengeToken string `json:"challenge_token"`
+			Code           string `json:"code"`
+		}
+
+		if err := c.Read(&req); err != nil || req.ChallengeToken == "" || req.Code == "" {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		ctx := c.Request.Context()
+		ip := clientIP(c.Request)
+		if err := checkThrottle(c, throttle, "", ip); err != nil {
+			return err
+		}
+		challenge, err := service.ParseChallenge(ctx, req.ChallengeToken)
+		if err != nil {
+			recordAttempt(c, throttle, "", ip, err)
+			return err
+		}
+		if err := checkThrottle(c, throttle, challenge.Username, ip); err != nil {
+			return err
+		}
+		tokens, err := service.LoginTwoFactor(ctx, req.ChallengeToken, req.Code)
+		recordAttempt(c, throttle, challenge.Username, ip, err)
+		if rejected(err) && throttle.FailChallenge(ctx, challenge.ID) {
+			if err := service.RevokeChallenge(ctx, challenge); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return c.Write(tokens)
+	}
+}
+
+// enrollTOTP returns a handler that generates a new TOTP secret for the current user.
+func enrollTOTP(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		enrollment, err := service.EnrollTOTP(c.Request.Context())
+		if err != nil {
+			return err
+		}
+		return c.Write(enrollment)
+	}
+}
+
+// verifyTOTP returns a handler that enables two-factor authentication once the user enters a first code.
+func verifyTOTP(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		code, err := readCode(c, logger)
+		if err != nil {
+			return err
+		}
+
+		codes, err := service.VerifyTOTP(c.Request.Context(), code)
+		if err != nil {
+			return err
+		}
+		return c.Write(codes)
+	}
+}
+
+// disableTOTP returns a handler that disables two-factor authentication.
+func disableTOTP(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		code, err := readCode(c, logger)
+		if err != nil {
+			return err
+		}
+
+		if err := service.DisableTOTP(c.Request.Context(), code); err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// regenerateRecoveryCodes returns a handler that replaces the recovery codes of the current user.
+func regenerateRecoveryCodes(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		code, err := readCode(c, logger)
+		if err != nil {
+			return err
+		}
+
+		codes, err := service.RegenerateRecoveryCodes(c.Request.Context(), code)
+		if err != nil {
+			return err
+		}
+		return c.Write(codes)
+	}
+}
+
+// readCode reads the TOTP or recovery code confirming a change to two-factor authentication from the request body.
+func readCode(c *routing.Context, logger log.Logger) (string, error) {
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := c.Read(&req); err != nil || req.Code == "" {
+		logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+		return "", errors.BadRequest("")
+	}
+	return req.Code, nil
+}
+
+// changePassword returns a handler that changes the password of the current user.
+func changePassword(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		tokenID, _ := c.Get("token_id").(string)
+		if err := service.ChangePassword(c.Request.Context(), req.CurrentPassword, req.NewPassword, tokenID); err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// forgotPassword returns a handler that emails a password reset token. The email is sent in the background, so that
+// neither the status nor the duration of the response reveal whether the email address is registered. The requests
+// are throttled per email address and per client IP.
+func forgotPassword(service Service, throttle *LoginThrottle, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			Email string `json:"email"`
+		}
+
+		if err := c.Read(&req); err != nil || req.Email == "" {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		if wait := throttle.RequestReset(c.Request.Context(), normalizeEmail(req.Email), clientIP(c.Request)); wait > 0 {
+			setRetryAfter(c, wait)
+			return errors.TooManyRequests("too many password reset requests, please try again later")
+		}
+		if err := service.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusAccepted)
+		return nil
+	}
+}
+
+// resetPassword returns a handler that sets a new password with a password reset token.
+func resetPassword(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"new_password"`
+		}
+
+		if err := c.Read(&req); err != nil || req.Token == "" {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		if err := service.ResetPassword(c.Request.Context(), req.Token, req.NewPassword); err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// getProfile returns a handler that returns the profile of the current user.
+func getProfile(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		profile, err := service.GetProfile(c.Request.Context())
+		if err != nil {
+			return err
+		}
+		return c.Write(profile)
+	}
+}
+
+// updateProfile returns a handler that changes the profile of the current user.
+func updateProfile(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var input UpdateProfileRequest
+		if err := c.Read(&input); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		profile, err := service.UpdateProfile(c.Request.Context(), input)
+		if err != nil {
+			return err
+		}
+		return c.Write(profile)
+	}
+}
+
+// deleteAccount returns a handler that deletes the account of the current user.
+func deleteAccount(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			Password string `json:"password"`
+		}
+
+		if c.Request.ContentLength != 0 {
+			if err := c.Read(&req); err != nil {
+				logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+				return errors.BadRequest("")
+			}
+		}
+
+		if err := service.DeleteAccount(c.Request.Context(), req.Password); err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
 	}
 }
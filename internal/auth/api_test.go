@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/internal/test"
@@ -12,26 +13,192 @@ import (
 
 type mockService struct{}
 
-func (m mockService) Login(ctx context.Context, username, password string) (string, error) {
+func (m mockService) Login(ctx context.Context, username, password string) (LoginResult, error) {
 	if username == "test" && password == "pass" {
-		return "token-100", nil
+		return LoginResult{Tokens: Tokens{"token-100", "refresh-100", 900}}, nil
 	}
-	return "", errors.Unauthorized("")
+	if username == "2fa" && password == "pass" {
+		return LoginResult{Challenge: &Challenge{"challenge-100", 300, true}}, nil
+	}
+	return LoginResult{}, errors.Unauthorized("")
+}
+
+func (m mockService) LoginTwoFactor(ctx context.Context, challengeToken, code string) (Tokens, error) {
+	if challengeToken == "challenge-100" && code == "123456" {
+		return Tokens{"token-103", "refresh-103", 900}, nil
+	}
+	return Tokens{}, errors.Unauthorized("")
+}
+
+func (m mockService) ParseChallenge(ctx context.Context, challengeToken string) (ChallengeClaims, error) {
+	if challengeToken == "challenge-100" {
+		return ChallengeClaims{ID: "challenge-100", UserID: "100", Username: "2fa", ExpiresAt: time.Now().Add(time.Minute)}, nil
+	}
+	return ChallengeClaims{}, errors.Unauthorized("")
+}
+
+func (m mockService) RevokeChallenge(ctx context.Context, challenge ChallengeClaims) error {
+	return nil
+}
+
+func (m mockService) EnrollTOTP(ctx context.Context) (TOTPEnrollment, error) {
+	return TOTPEnrollment{"SECRET", "otpauth://totp/Notes:Tester?secret=SECRET"}, nil
+}
+
+func (m mockService) VerifyTOTP(ctx context.Context, code string) (RecoveryCodes, error) {
+	if code != "123456" {
+		return RecoveryCodes{}, errInvalidCode
+	}
+	return RecoveryCodes{[]string{"abcde-fghjk"}}, nil
+}
+
+func (m mockService) DisableTOTP(ctx context.Context, code string) error {
+	if code != "123456" {
+		return errInvalidCode
+	}
+	return nil
+}
+
+func (m mockService) RegenerateRecoveryCodes(ctx context.Context, code string) (RecoveryCodes, error) {
+	return m.VerifyTOTP(ctx, code)
+}
+
+func (m mockService) ChangePassword(ctx context.Context, currentPassword, newPassword, accessTokenID string) error {
+	if currentPassword != "pass" {
+		return errWrongPassword
+	}
+	return nil
+}
+
+func (m mockService) RequestPasswordReset(ctx context.Context, email string) error {
+	return nil
 }
 
-func (m mockService) Signup(ctx context.Context, username, password string) (string, error) {
-	return "", nil
+func (m mockService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if token != "reset-100" {
+		return errInvalidResetToken
+	}
+	return nil
+}
+
+func (m mockService) GetProfile(ctx context.Context) (Profile, error) {
+	return Profile{ID: "testuser", Name: "Tester", Email: "tester@example.com"}, nil
+}
+
+func (m mockService) UpdateProfile(ctx context.Context, input UpdateProfileRequest) (Profile, error) {
+	if err := input.Validate(); err != nil {
+		return Profile{}, err
+	}
+	profile := Profile{ID: "testuser", Name: "Tester"}
+	if input.DisplayName != nil {
+		profile.DisplayName = *input.DisplayName
+	}
+	return profile, nil
+}
+
+func (m mockService) DeleteAccount(ctx context.Context, password string) error {
+	if password != "pass" {
+		return errWrongPassword
+	}
+	return nil
+}
+
+func (m mockService) Signup(ctx context.Context, username, password, email string) (Tokens, error) {
+	return Tokens{}, nil
+}
+
+func (m mockService) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	if refreshToken == "refresh-100" {
+		return Tokens{"token-101", "refresh-101", 900}, nil
+	}
+	return Tokens{}, errors.Unauthorized("")
+}
+
+func (m mockService) Logout(ctx context.Context, refreshToken, accessTokenID string, expiresAt time.Time) error {
+	if CurrentUser(ctx) == nil {
+		return errors.Unauthorized("")
+	}
+	return nil
+}
+
+func (m mockService) OIDCLogin(ctx context.Context) (OIDCRedirect, error) {
+	return OIDCRedirect{"https://idp.example.com/authorize?state=state-100", "session-100"}, nil
+}
+
+func (m mockService) OIDCCallback(ctx context.Context, session, state, code string) (Tokens, error) {
+	if session == "session-100" && state == "state-100" && code == "code-100" {
+		return Tokens{"token-102", "refresh-102", 900}, nil
+	}
+	return Tokens{}, errors.Unauthorized("")
 }
 
 func TestAPI(t *testing.T) {
 	logger, _ := log.NewForTest()
 	router := test.MockRouter(logger)
-	RegisterHandlers(router.Group(""), mockService{}, logger)
+	throttle := NewLoginThrottle(ThrottleConfig{MaxFailures: 3, MaxIPFailures: 100, Lockout: time.Minute}, logger)
+	RegisterHandlers(router.Group(""), mockService{}, throttle, MockAuthHandler, logger)
+	RegisterAdminHandlers(router.Group("/admin"), throttle, MockAuthHandler, []string{"Tester"})
+	session := http.Header{"Cookie": {oidcCookie + "=session-100"}}
 
 	tests := []test.APITestCase{
-		{"success", "POST", "/login", `{"username":"test","password":"pass"}`, nil, http.StatusOK, `{"token":"token-100"}`},
+		{"success", "POST", "/login", `{"username":"test","password":"pass"}`, nil, http.StatusOK, `{"token":"token-100","refresh_token":"refresh-100","expires_in":900}`},
 		{"bad credential", "POST", "/login", `{"username":"test","password":"wrong pass"}`, nil, http.StatusUnauthorized, ""},
+		{"bad credential again", "POST", "/login", `{"username":"test","password":"wrong pass"}`, nil, http.StatusUnauthorized, ""},
+		{"bad credential locks", "POST", "/login", `{"username":"test","password":"wrong pass"}`, nil, http.StatusUnauthorized, ""},
+		{"locked", "POST", "/login", `{"username":"test","password":"pass"}`, nil, http.StatusTooManyRequests, ""},
+		{"unknown user", "POST", "/login", `{"username":"nobody","password":"pass"}`, nil, http.StatusUnauthorized, ""},
+		{"unknown user again", "POST", "/login", `{"username":"nobody","password":"pass"}`, nil, http.StatusUnauthorized, ""},
+		{"unknown user locked", "POST", "/login", `{"username":"nobody","password":"pass"}`, nil, http.StatusUnauthorized, ""},
+		{"unknown user locked alike", "POST", "/login", `{"username":"nobody","password":"pass"}`, nil, http.StatusTooManyRequests, ""},
+		{"unlock forbidden", "POST", "/admin/users/test/unlock", "", MockAuthHeaderFor("other"), http.StatusForbidden, ""},
+		{"unlock unauthenticated", "POST", "/admin/users/test/unlock", "", nil, http.StatusUnauthorized, ""},
+		{"unlock", "POST", "/admin/users/test/unlock", "", MockAuthHeader(), http.StatusNoContent, ""},
+		{"success after unlock", "POST", "/login", `{"username":"test","password":"pass"}`, nil, http.StatusOK, `*"token":"token-100"*`},
 		{"bad json", "POST", "/login", `"username":"test","password":"wrong pass"}`, nil, http.StatusBadRequest, ""},
+		{"refresh", "POST", "/token/refresh", `{"refresh_token":"refresh-100"}`, nil, http.StatusOK, `*"token":"token-101"*`},
+		{"refresh invalid", "POST", "/token/refresh", `{"refresh_token":"refresh-0"}`, nil, http.StatusUnauthorized, ""},
+		{"refresh missing", "POST", "/token/refresh", `{}`, nil, http.StatusBadRequest, ""},
+		{"logout", "POST", "/logout", `{"refresh_token":"refresh-100"}`, MockAuthHeader(), http.StatusNoContent, ""},
+		{"logout without body", "POST", "/logout", "", MockAuthHeader(), http.StatusNoContent, ""},
+		{"logout unauthenticated", "POST", "/logout", "", nil, http.StatusUnauthorized, ""},
+		{"login 2fa challenge", "POST", "/login", `{"username":"2fa","password":"pass"}`, nil, http.StatusOK, `{"challenge_token":"challenge-100","expires_in":300,"two_factor_required":true}`},
+		{"login 2fa", "POST", "/login/2fa", `{"challenge_token":"challenge-100","code":"123456"}`, nil, http.StatusOK, `*"token":"token-103"*`},
+		{"login 2fa invalid challenge", "POST", "/login/2fa", `{"challenge_token":"challenge-0","code":"123456"}`, nil, http.StatusUnauthorized, ""},
+		{"login 2fa wrong code", "POST", "/login/2fa", `{"challenge_token":"challenge-100","code":"000000"}`, nil, http.StatusUnauthorized, ""},
+		{"login 2fa wrong code again", "POST", "/login/2fa", `{"challenge_token":"challenge-100","code":"000001"}`, nil, http.StatusUnauthorized, ""},
+		{"login 2fa wrong code locks", "POST", "/login/2fa", `{"challenge_token":"challenge-100","code":"000002"}`, nil, http.StatusUnauthorized, ""},
+		{"login 2fa locked", "POST", "/login/2fa", `{"challenge_token":"challenge-100","code":"123456"}`, nil, http.StatusTooManyRequests, ""},
+		{"login locked by 2fa failures", "POST", "/login", `{"username":"2fa","password":"pass"}`, nil, http.StatusTooManyRequests, ""},
+		{"login 2fa missing code", "POST", "/login/2fa", `{"challenge_token":"challenge-100"}`, nil, http.StatusBadRequest, ""},
+		{"2fa enroll", "POST", "/2fa/enroll", "", MockAuthHeader(), http.StatusOK, `*"provisioning_uri":"otpauth://totp/*`},
+		{"2fa enroll unauthenticated", "POST", "/2fa/enroll", "", nil, http.StatusUnauthorized, ""},
+		{"2fa verify", "POST", "/2fa/verify", `{"code":"123456"}`, MockAuthHeader(), http.StatusOK, `{"recovery_codes":["abcde-fghjk"]}`},
+		{"2fa verify wrong code", "POST", "/2fa/verify", `{"code":"000000"}`, MockAuthHeader(), http.StatusBadRequest, ""},
+		{"2fa recovery codes", "POST", "/2fa/recovery-codes", `{"code":"123456"}`, MockAuthHeader(), http.StatusOK, `*"recovery_codes"*`},
+		{"2fa disable missing code", "POST", "/2fa/disable", `{}`, MockAuthHeader(), http.StatusBadRequest, ""},
+		{"2fa disable", "POST", "/2fa/disable", `{"code":"123456"}`, MockAuthHeader(), http.StatusNoContent, ""},
+		{"get profile", "GET", "/me", "", MockAuthHeader(), http.StatusOK, `*"email":"tester@example.com"*`},
+		{"get profile unauthenticated", "GET", "/me", "", nil, http.StatusUnauthorized, ""},
+		{"update profile", "PATCH", "/me", `{"display_name":"Test User"}`, MockAuthHeader(), http.StatusOK, `*"display_name":"Test User"*`},
+		{"update profile invalid", "PATCH", "/me", `{"timezone":"Mars/Olympus"}`, MockAuthHeader(), http.StatusBadRequest, `*"field":"timezone"*`},
+		{"delete account", "DELETE", "/me", `{"password":"pass"}`, MockAuthHeader(), http.StatusNoContent, ""},
+		{"delete account wrong password", "DELETE", "/me", `{"password":"wrong"}`, MockAuthHeader(), http.StatusBadRequest, ""},
+		{"change password", "POST", "/me/password", `{"current_password":"pass","new_password":"new"}`, MockAuthHeader(), http.StatusNoContent, ""},
+		{"change password wrong", "POST", "/me/password", `{"current_password":"wrong","new_password":"new"}`, MockAuthHeader(), http.StatusBadRequest, ""},
+		{"change password unauthenticated", "POST", "/me/password", `{"current_password":"pass","new_password":"new"}`, nil, http.StatusUnauthorized, ""},
+		{"forgot password", "POST", "/password/forgot", `{"email":"demo@example.com"}`, nil, http.StatusAccepted, ""},
+		{"forgot password missing email", "POST", "/password/forgot", `{}`, nil, http.StatusBadRequest, ""},
+		{"forgot password again", "POST", "/password/forgot", `{"email":"Demo@Example.com"}`, nil, http.StatusAccepted, ""},
+		{"forgot password third", "POST", "/password/forgot", `{"email":"demo@example.com"}`, nil, http.StatusAccepted, ""},
+		{"forgot password throttled", "POST", "/password/forgot", `{"email":"demo@example.com"}`, nil, http.StatusTooManyRequests, ""},
+		{"forgot password other email", "POST", "/password/forgot", `{"email":"other@example.com"}`, nil, http.StatusAccepted, ""},
+		{"reset password", "POST", "/password/reset", `{"token":"reset-100","new_password":"new"}`, nil, http.StatusNoContent, ""},
+		{"reset password invalid", "POST", "/password/reset", `{"token":"reset-0","new_password":"new"}`, nil, http.StatusBadRequest, ""},
+		{"oidc login", "GET", "/oidc/login", "", nil, http.StatusFound, ""},
+		{"oidc callback", "GET", "/oidc/callback?state=state-100&code=code-100", "", session, http.StatusOK, `*"token":"token-102"*`},
+		{"oidc callback wrong state", "GET", "/oidc/callback?state=state-0&code=code-100", "", session, http.StatusUnauthorized, ""},
+		{"oidc callback without session", "GET", "/oidc/callback?state=state-100&code=code-100", "", nil, http.StatusUnauthorized, ""},
+		{"oidc callback denied", "GET", "/oidc/callback?error=access_denied", "", session, http.StatusUnauthorized, ""},
 	}
 	for _, tc := range tests {
 		test.Endpoint(t, router, tc)
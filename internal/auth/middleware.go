@@ -2,7 +2,10 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -47,39 +50,240 @@ func RateLimiter() routing.Handler {
 	}
 }
 
-// Handler returns a JWT-based authentication middleware.
-func Handler(verificationKey string) routing.Handler {
-	return auth.JWT(verificationKey, auth.JWTOptions{TokenHandler: handleToken})
+// WorkspaceHeader is the request header selecting the active workspace of the user.
+const WorkspaceHeader = "X-Workspace-ID"
+
+// MemberFinder looks up the membership of users in workspaces. It is implemented by workspaces.Repository.
+type MemberFinder interface {
+	// GetMember returns the membership of the given user in the given workspace.
+	GetMember(ctx context.Context, workspaceID, userID string) (entity.WorkspaceMember, error)
+}
+
+// Denylist tells whether access tokens were revoked before they expired. It is implemented by TokenRepo.
+type Denylist interface {
+	// IsRevoked reports whether the access token with the given jti was revoked.
+	IsRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// the scopes of personal access tokens
+const (
+	// ScopeNotesRead allows reading notes and the tags, notebooks and saved searches organizing them.
+	ScopeNotesRead = "notes:read"
+	// ScopeNotesWrite allows creating, changing and deleting notes and the tags, notebooks and saved searches.
+	ScopeNotesWrite = "notes:write"
+	// ScopeShare allows sharing notes with other users and through public links.
+	ScopeShare = "share"
+)
+
+// Scopes lists the scopes that can be granted to personal access tokens.
+var Scopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopeShare}
+
+// AccessTokenPrefix starts every personal access token, so that they can be told apart from JWTs.
+const AccessTokenPrefix = "pat_"
+
+// AccessTokenFinder looks up personal access tokens. It is implemented by tokens.Repository.
+type AccessTokenFinder interface {
+	// GetByHash returns the personal access token with the given hash along with the name of its user.
+	GetByHash(ctx context.Context, hash string) (AccessToken, error)
+	// Touch records that the personal access token with the given ID was used at the given time.
+	Touch(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// AccessToken is a personal access token along with the name of its user.
+type AccessToken struct {
+	entity.PersonalAccessToken
+	Username string `json:"-"`
+}
+
+// lastUsedPrecision is how often the last-used time of a personal access token is updated at most.
+const lastUsedPrecision = time.Minute
+
+// Handler returns an authentication middleware accepting either a JWT or a personal access token. It rejects the
+// JWTs on the denylist, and activates the workspace given in the X-Workspace-ID header, which the user must be
+// a member of. Personal access tokens are rejected if tokens is nil; otherwise the routes behind the middleware
+// must declare the scopes they require with RequireScope.
+func Handler(verificationKey string, denylist Denylist, members MemberFinder, tokens AccessTokenFinder) routing.Handler {
+	jwtHandler := auth.JWT(verificationKey, auth.JWTOptions{TokenHandler: handleToken})
+	return func(c *routing.Context) error {
+		header := c.Request.Header.Get("Authorization")
+		if tokens != nil && strings.HasPrefix(header, "Bearer "+AccessTokenPrefix) {
+			if err := handleAccessToken(c, tokens, header[len("Bearer "):]); err != nil {
+				return err
+			}
+		} else {
+			if err := jwtHandler(c); err != nil {
+				return err
+			}
+			if err := handleRevocation(c, denylist); err != nil {
+				return err
+			}
+		}
+		return handleWorkspace(c, members)
+	}
+}
+
+// handleAccessToken stores the identity of the user owning the personal access token in the request context,
+// limited to the scopes of the token. Unknown and expired tokens are rejected.
+func handleAccessToken(c *routing.Context, tokens AccessTokenFinder, secret string) error {
+	ctx := c.Request.Context()
+	token, err := tokens.GetByHash(ctx, HashToken(secret))
+	if err == sql.ErrNoRows {
+		return errors.Unauthorized("")
+	} else if err != nil {
+		return err
+	}
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return errors.Unauthorized("the token has expired")
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedPrecision {
+		if err := tokens.Touch(ctx, token.ID, now); err != nil {
+			return err
+		}
+	}
+	c.Set("username", token.Username)
+	c.Set("user_id", token.UserID)
+	c.Request = c.Request.WithContext(context.WithValue(ctx, userKey, identity{
+		User:   entity.User{ID: token.UserID, Name: token.Username},
+		scopes: append([]string{}, token.Scopes...),
+	}))
+	return nil
+}
+
+// RequireScope returns a middleware rejecting the requests authenticated with a personal access token that lacks
+// the given scope. Requests authenticated with a JWT hold every scope.
+func RequireScope(scope string) routing.Handler {
+	return func(c *routing.Context) error {
+		if identity := CurrentUser(c.Request.Context()); identity != nil && !identity.HasScope(scope) {
+			return errors.Forbidden(fmt.Sprintf("the token lacks the %v scope", scope))
+		}
+		return nil
+	}
+}
+
+// RequireAdmin returns a middleware rejecting the requests of users who are not among the given administrators.
+// It must be used after the authentication middleware.
+func RequireAdmin(admins []string) routing.Handler {
+	return func(c *routing.Context) error {
+		if identity := CurrentUser(c.Request.Context()); identity != nil {
+			for _, admin := range admins {
+				if identity.GetName() == admin {
+					return nil
+				}
+			}
+		}
+		return errors.Forbidden("")
+	}
 }
 
 // handleToken stores the user identity in the request context so that it can be accessed elsewhere.
+// The ID and the expiry of the token are kept in the routing context. Tokens without an ID cannot be revoked
+// and are rejected.
 func handleToken(c *routing.Context, token *jwt.Token) error {
+	claims := token.Claims.(jwt.MapClaims)
+	tokenID, _ := claims["jti"].(string)
+	if tokenID == "" {
+		return errors.Unauthorized("")
+	}
 	ctx := WithUser(
 		c.Request.Context(),
-		token.Claims.(jwt.MapClaims)["id"].(string),
-		token.Claims.(jwt.MapClaims)["name"].(string),
+		claims["id"].(string),
+		claims["name"].(string),
 	)
-	c.Set("username", token.Claims.(jwt.MapClaims)["name"].(string))
-	c.Set("user_id", token.Claims.(jwt.MapClaims)["id"].(string))
+	c.Set("username", claims["name"].(string))
+	c.Set("user_id", claims["id"].(string))
+	c.Set("token_id", tokenID)
+	if exp, ok := claims["exp"].(float64); ok {
+		c.Set("token_expires_at", time.Unix(int64(exp), 0))
+	}
 	c.Request = c.Request.WithContext(ctx)
 	return nil
 }
 
+// handleRevocation rejects the request if its access token was revoked.
+func handleRevocation(c *routing.Context, denylist Denylist) error {
+	tokenID, _ := c.Get("token_id").(string)
+	revoked, err := denylist.IsRevoked(c.Request.Context(), tokenID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errors.Unauthorized("the token has been revoked")
+	}
+	return nil
+}
+
+// handleWorkspace stores the membership of the user in the workspace given in the X-Workspace-ID header in the
+// request context. A workspace the user is not a member of is reported as not found.
+func handleWorkspace(c *routing.Context, members MemberFinder) error {
+	workspaceID := c.Request.Header.Get(WorkspaceHeader)
+	if workspaceID == "" {
+		return nil
+	}
+	ctx := c.Request.Context()
+	member, err := members.GetMember(ctx, workspaceID, CurrentUser(ctx).GetID())
+	if err == sql.ErrNoRows {
+		return errors.NotFound("the workspace does not exist")
+	} else if err != nil {
+		return err
+	}
+	c.Request = c.Request.WithContext(WithWorkspace(ctx, member))
+	return nil
+}
+
 type contextKey int
 
 const (
 	userKey contextKey = iota
 )
 
+// identity is the Identity stored in the request context.
+type identity struct {
+	entity.User
+	workspace *entity.WorkspaceMember
+	// scopes limits a user authenticated with a personal access token to the scopes of the token.
+	// It is nil for users holding every scope.
+	scopes []string
+}
+
+// HasScope reports whether the user holds the given scope.
+func (i identity) HasScope(scope string) bool {
+	if i.scopes == nil {
+		return true
+	}
+	for _, s := range i.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWorkspace returns the membership of the user in the active workspace.
+func (i identity) GetWorkspace() *entity.WorkspaceMember {
+	return i.workspace
+}
+
 // WithUser returns a context that contains the user identity from the given JWT.
 func WithUser(ctx context.Context, id, name string) context.Context {
-	return context.WithValue(ctx, userKey, entity.User{ID: id, Name: name})
+	return context.WithValue(ctx, userKey, identity{User: entity.User{ID: id, Name: name}})
+}
+
+// WithWorkspace returns a context in which the workspace of the given membership is active for the current user.
+// The context is returned unchanged if it has no user identity.
+func WithWorkspace(ctx context.Context, member entity.WorkspaceMember) context.Context {
+	user, ok := ctx.Value(userKey).(identity)
+	if !ok {
+		return ctx
+	}
+	user.workspace = &member
+	return context.WithValue(ctx, userKey, user)
 }
 
 // CurrentUser returns the user identity from the given context.
 // Nil is returned if no user identity is found in the context.
 func CurrentUser(ctx context.Context) Identity {
-	if user, ok := ctx.Value(userKey).(entity.User); ok {
+	if user, ok := ctx.Value(userKey).(identity); ok {
 		return user
 	}
 	return nil
@@ -87,14 +291,25 @@ func CurrentUser(ctx context.Context) Identity {
 
 // MockAuthHandler creates a mock authentication middleware for testing purpose.
 // If the request contains an Authorization header whose value is "TEST", then
-// it considers the user is authenticated as "Tester" whose ID is "100".
+// it considers the user is authenticated as "Tester" whose ID is "testuser".
+// If the header value is "TEST <id>", the user is authenticated with the given ID and name instead,
+// and "TEST <id> <scopes>" authenticates the user as with a personal access token of the comma-separated scopes.
 // It fails the authentication otherwise.
 func MockAuthHandler(c *routing.Context) error {
-	if c.Request.Header.Get("Authorization") != "TEST" {
+	header := c.Request.Header.Get("Authorization")
+	id, name := "testuser", "Tester"
+	var scopes []string
+	if strings.HasPrefix(header, "TEST ") {
+		fields := strings.SplitN(header[5:], " ", 2)
+		id, name = fields[0], fields[0]
+		if len(fields) == 2 {
+			scopes = strings.Split(fields[1], ",")
+		}
+	} else if header != "TEST" {
 		return errors.Unauthorized("")
 	}
-	ctx := WithUser(c.Request.Context(), "testuser", "Tester")
-	c.Set("user_id", "testuser")
+	ctx := context.WithValue(c.Request.Context(), userKey, identity{User: entity.User{ID: id, Name: name}, scopes: scopes})
+	c.Set("user_id", id)
 
 	c.Request = c.Request.WithContext(ctx)
 	return nil
@@ -106,3 +321,18 @@ func MockAuthHeader() http.Header {
 	header.Add("Authorization", "TEST")
 	return header
 }
+
+// MockAuthHeaderFor returns an HTTP header that authenticates as the user with the given ID via MockAuthHandler.
+func MockAuthHeaderFor(id string) http.Header {
+	header := http.Header{}
+	header.Add("Authorization", "TEST "+id)
+	return header
+}
+
+// MockAuthHeaderWithScopes returns an HTTP header that authenticates as the user with the given ID via
+// MockAuthHandler, limited to the given scopes as with a personal access token.
+func MockAuthHeaderWithScopes(id string, scopes ...string) http.Header {
+	header := http.Header{}
+	header.Add("Authorization", "TEST "+id+" "+strings.Join(scopes, ","))
+	return header
+}
@@ -2,11 +2,15 @@ package auth
 
 import (
 	"context"
+	"database/sql"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/internal/test"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestCurrentUser(t *testing.T) {
@@ -21,7 +25,7 @@ func TestCurrentUser(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	assert.NotNil(t, Handler("test"))
+	assert.NotNil(t, Handler("test", &mockTokenRepo{}, mockMembers{}, nil))
 }
 
 func Test_handleToken(t *testing.T) {
@@ -29,18 +33,158 @@ func Test_handleToken(t *testing.T) {
 	ctx, _ := test.MockRoutingContext(req)
 	assert.Nil(t, CurrentUser(ctx.Request.Context()))
 
+	// tokens without an ID cannot be revoked
 	err := handleToken(ctx, &jwt.Token{
 		Claims: jwt.MapClaims{
 			"id":   "100",
 			"name": "test",
 		},
 	})
+	assert.Equal(t, errors.Unauthorized(""), err)
+
+	err = handleToken(ctx, &jwt.Token{
+		Claims: jwt.MapClaims{
+			"id":   "100",
+			"name": "test",
+			"jti":  "jti1",
+			"exp":  float64(1700000000),
+		},
+	})
 	assert.Nil(t, err)
 	identity := CurrentUser(ctx.Request.Context())
 	if assert.NotNil(t, identity) {
 		assert.Equal(t, "100", identity.GetID())
 		assert.Equal(t, "test", identity.GetName())
 	}
+	assert.Equal(t, "jti1", ctx.Get("token_id"))
+	assert.Equal(t, time.Unix(1700000000, 0), ctx.Get("token_expires_at"))
+}
+
+func Test_handleRevocation(t *testing.T) {
+	denylist := &mockTokenRepo{revoked: []entity.RevokedToken{{ID: "jti1"}}}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	ctx, _ := test.MockRoutingContext(req)
+
+	ctx.Set("token_id", "jti2")
+	assert.Nil(t, handleRevocation(ctx, denylist))
+	ctx.Set("token_id", "jti1")
+	assert.Equal(t, errors.Unauthorized("the token has been revoked"), handleRevocation(ctx, denylist))
+}
+
+func Test_handleWorkspace(t *testing.T) {
+	members := mockMembers{{ID: "m1", WorkspaceID: "ws1", UserID: "100", Role: entity.RoleMember}}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	ctx, _ := test.MockRoutingContext(req)
+	ctx.Request = ctx.Request.WithContext(WithUser(ctx.Request.Context(), "100", "test"))
+
+	// no workspace is active without the header
+	assert.Nil(t, handleWorkspace(ctx, members))
+	assert.Nil(t, CurrentUser(ctx.Request.Context()).GetWorkspace())
+
+	ctx.Request.Header.Set(WorkspaceHeader, "ws2")
+	assert.Equal(t, errors.NotFound("the workspace does not exist"), handleWorkspace(ctx, members))
+
+	ctx.Request.Header.Set(WorkspaceHeader, "ws1")
+	assert.Nil(t, handleWorkspace(ctx, members))
+	identity := CurrentUser(ctx.Request.Context())
+	if assert.NotNil(t, identity.GetWorkspace()) {
+		assert.Equal(t, "100", identity.GetID())
+		assert.Equal(t, entity.RoleMember, identity.GetWorkspace().Role)
+	}
+}
+
+func Test_handleAccessToken(t *testing.T) {
+	past, recent := time.Now().Add(-time.Hour), time.Now().Add(-time.Second)
+	tokens := &mockAccessTokens{items: []AccessToken{
+		{PersonalAccessToken: entity.PersonalAccessToken{ID: "t1", UserID: "100", Scopes: []string{ScopeNotesRead}, TokenHash: HashToken("pat_valid")}, Username: "test"},
+		{PersonalAccessToken: entity.PersonalAccessToken{ID: "t2", UserID: "100", TokenHash: HashToken("pat_expired"), ExpiresAt: &past}, Username: "test"},
+		{PersonalAccessToken: entity.PersonalAccessToken{ID: "t3", UserID: "100", TokenHash: HashToken("pat_recent"), LastUsedAt: &recent}, Username: "test"},
+	}}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	ctx, _ := test.MockRoutingContext(req)
+
+	assert.Equal(t, errors.Unauthorized(""), handleAccessToken(ctx, tokens, "pat_unknown"))
+	assert.Equal(t, errors.Unauthorized("the token has expired"), handleAccessToken(ctx, tokens, "pat_expired"))
+	assert.Nil(t, CurrentUser(ctx.Request.Context()))
+
+	assert.Nil(t, handleAccessToken(ctx, tokens, "pat_valid"))
+	identity := CurrentUser(ctx.Request.Context())
+	if assert.NotNil(t, identity) {
+		assert.Equal(t, "100", identity.GetID())
+		assert.Equal(t, "test", identity.GetName())
+		assert.True(t, identity.HasScope(ScopeNotesRead))
+		assert.False(t, identity.HasScope(ScopeNotesWrite))
+	}
+	assert.NotNil(t, tokens.items[0].LastUsedAt)
+
+	// the last-used time is not updated on every request
+	assert.Nil(t, handleAccessToken(ctx, tokens, "pat_recent"))
+	assert.Equal(t, recent, *tokens.items[2].LastUsedAt)
+}
+
+func TestHandler_accessToken(t *testing.T) {
+	tokens := &mockAccessTokens{items: []AccessToken{
+		{PersonalAccessToken: entity.PersonalAccessToken{ID: "t1", UserID: "100", TokenHash: HashToken("pat_valid")}, Username: "test"},
+	}}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Authorization", "Bearer pat_valid")
+	ctx, _ := test.MockRoutingContext(req)
+	assert.Nil(t, Handler("test", &mockTokenRepo{}, mockMembers{}, tokens)(ctx))
+	assert.Equal(t, "100", ctx.Get("user_id"))
+
+	// personal access tokens are not accepted without a finder
+	ctx, _ = test.MockRoutingContext(req)
+	assert.NotNil(t, Handler("test", &mockTokenRepo{}, mockMembers{}, nil)(ctx))
+	assert.Nil(t, CurrentUser(ctx.Request.Context()))
+}
+
+func TestRequireScope(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	ctx, _ := test.MockRoutingContext(req)
+	assert.Nil(t, RequireScope(ScopeShare)(ctx))
+
+	// users authenticated with a JWT hold every scope
+	ctx.Request = ctx.Request.WithContext(WithUser(ctx.Request.Context(), "100", "test"))
+	assert.Nil(t, RequireScope(ScopeShare)(ctx))
+
+	req.Header = MockAuthHeaderWithScopes("100", ScopeNotesRead, ScopeNotesWrite)
+	ctx, _ = test.MockRoutingContext(req)
+	assert.Nil(t, MockAuthHandler(ctx))
+	assert.Nil(t, RequireScope(ScopeNotesWrite)(ctx))
+	assert.Equal(t, errors.Forbidden("the token lacks the share scope"), RequireScope(ScopeShare)(ctx))
+}
+
+type mockAccessTokens struct {
+	items []AccessToken
+}
+
+func (m *mockAccessTokens) GetByHash(ctx context.Context, hash string) (AccessToken, error) {
+	for _, item := range m.items {
+		if item.TokenHash == hash {
+			return item, nil
+		}
+	}
+	return AccessToken{}, sql.ErrNoRows
+}
+
+func (m *mockAccessTokens) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items[i].LastUsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+type mockMembers []entity.WorkspaceMember
+
+func (m mockMembers) GetMember(ctx context.Context, workspaceID, userID string) (entity.WorkspaceMember, error) {
+	for _, member := range m {
+		if member.WorkspaceID == workspaceID && member.UserID == userID {
+			return member, nil
+		}
+	}
+	return entity.WorkspaceMember{}, sql.ErrNoRows
 }
 
 func TestMocks(t *testing.T) {
@@ -51,4 +195,10 @@ func TestMocks(t *testing.T) {
 	ctx, _ = test.MockRoutingContext(req)
 	assert.Nil(t, MockAuthHandler(ctx))
 	assert.NotNil(t, CurrentUser(ctx.Request.Context()))
+	req.Header = MockAuthHeaderFor("200")
+	ctx, _ = test.MockRoutingContext(req)
+	assert.Nil(t, MockAuthHandler(ctx))
+	if identity := CurrentUser(ctx.Request.Context()); assert.NotNil(t, identity) {
+		assert.Equal(t, "200", identity.GetID())
+	}
 }
@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// OIDCConfig configures the OpenID Connect provider the users can log in with.
+type OIDCConfig struct {
+	// Issuer is the URL of the provider, under which its discovery document is found.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL of the callback endpoint, as registered with the provider.
+	RedirectURL string
+}
+
+// OIDCClaims are the claims of a verified ID token that identify the user.
+type OIDCClaims struct {
+	Subject           string
+	Email             string
+	PreferredUsername string
+}
+
+// oidcMetadata is the part of the discovery document of a provider used for the authorization code flow.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// keyRefreshInterval is how often the signing keys of the provider are fetched again at most, when an ID token
+// is signed with an unknown key.
+const keyRefreshInterval = time.Minute
+
+// OIDCProvider logs users in with an OpenID Connect provider using the authorization code flow with PKCE.
+// The discovery document of the provider is fetched once, and its signing keys whenever they are rotated.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	metadata  *oidcMetadata
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewOIDCProvider creates an OpenID Connect provider. The default HTTP client is used if client is nil.
+func NewOIDCProvider(config OIDCConfig, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{config: config, client: client}
+}
+
+// Issuer returns the issuer of the provider.
+func (p *OIDCProvider) Issuer() string {
+	return p.config.Issuer
+}
+
+// AuthCodeURL returns the URL of the provider the user is sent to in order to log in. The provider passes
+// the state back to the callback endpoint and the nonce in the ID token, and only exchanges the code for the ID
+// token along with the verifier the challenge is derived from.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {"openid profile email"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange exchanges the authorization code for an ID token at the token endpoint of the provider.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	res, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %v: %v", res.StatusCode, body.Error)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("the token response has no ID token")
+	}
+	return body.IDToken, nil
+}
+
+// Verify verifies the signature of an ID token against the keys of the provider, and checks that the token
+// was issued by the provider to this client for the login with the given nonce and has not expired.
+func (p *OIDCProvider) Verify(ctx context.Context, idToken, nonce string) (OIDCClaims, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, metadata.JWKSURI, kid)
+	})
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	if !claims.VerifyIssuer(metadata.Issuer, true) {
+		return OIDCClaims{}, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if !hasAudience(claims, p.config.ClientID) {
+		return OIDCClaims{}, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return OIDCClaims{}, fmt.Errorf("the ID token has expired")
+	}
+	if value, _ := claims["nonce"].(string); value == "" || value != nonce {
+		return OIDCClaims{}, fmt.Errorf("unexpected nonce")
+	}
+	result := OIDCClaims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	if result.Subject == "" {
+		return OIDCClaims{}, fmt.Errorf("the ID token has no subject")
+	}
+	return result, nil
+}
+
+// discover fetches the discovery document of the provider unless it already has.
+func (p *OIDCProvider) discover(ctx context.Context) (oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+	var metadata oidcMetadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &metadata); err != nil {
+		return oidcMetadata{}, err
+	}
+	if metadata.Issuer != p.config.Issuer {
+		return oidcMetadata{}, fmt.Errorf("the discovery document is for issuer %v", metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return oidcMetadata{}, fmt.Errorf("the discovery document lacks endpoints")
+	}
+	p.metadata = &metadata
+	return metadata, nil
+}
+
+// key returns the signing key with the given ID. The keys are fetched again if the key is unknown, as the provider
+// may have rotated them. A token without a key ID can be verified if the provider has a single key.
+func (p *OIDCProvider) key(ctx context.Context, jwksURI, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key := findKey(p.keys, kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.fetchedAt) >= keyRefreshInterval {
+		keys, err := p.fetchKeys(ctx, jwksURI)
+		if err != nil {
+			return nil, err
+		}
+		p.keys, p.fetchedAt = keys, time.Now()
+	}
+	if key := findKey(p.keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %v", kid)
+}
+
+// findKey returns the key with the given ID, or the only key if kid is empty.
+func findKey(keys map[string]*rsa.PublicKey, kid string) *rsa.PublicKey {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return keys[kid]
+}
+
+// fetchKeys fetches the RSA signing keys of the provider from its JWKS endpoint.
+func (p *OIDCProvider) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %v: %v", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %v: %v", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// getJSON fetches a JSON document from the provider.
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v failed with status %v", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// hasAudience reports whether the aud claim, which is either a string or an array, contains the client ID.
+func hasAudience(claims jwt.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, value := range aud {
+			if value == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pkceChallenge derives the S256 code challenge from a PKCE code verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	errs "github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockIdP is a local OpenID Connect provider for testing purpose. Its authorization endpoint logs the user in
+// as the account with the given claims right away and redirects back with a code.
+type mockIdP struct {
+	*httptest.Server
+	clientID, clientSecret string
+
+	mu         sync.Mutex
+	key        *rsa.PrivateKey
+	kid        string
+	account    jwt.MapClaims
+	grants     map[string]url.Values
+	keyFetches int
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{clientID: "client", clientSecret: "secret", grants: map[string]url.Values{}}
+	idp.rotateKey(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.keyFetches++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"kid": idp.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != idp.clientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		idp.mu.Lock()
+		code := entity.GenerateID()
+		idp.grants[code] = query
+		idp.mu.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		clientID, secret, _ := r.BasicAuth()
+		grant, ok := idp.grants[r.PostFormValue("code")]
+		delete(idp.grants, r.PostFormValue("code"))
+		if clientID != idp.clientID || secret != idp.clientSecret || !ok ||
+			r.PostFormValue("grant_type") != "authorization_code" ||
+			r.PostFormValue("redirect_uri") != grant.Get("redirect_uri") ||
+			pkceChallenge(r.PostFormValue("code_verifier")) != grant.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		claims := jwt.MapClaims{"nonce": grant.Get("nonce")}
+		for name, value := range idp.account {
+			claims[name] = value
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(claims)})
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// rotateKey replaces the signing key of the provider.
+func (idp *mockIdP) rotateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.key, idp.kid = key, entity.GenerateID()
+	idp.mu.Unlock()
+}
+
+// sign returns an ID token with the given claims, which default to those of a valid token for the client.
+func (idp *mockIdP) sign(claims jwt.MapClaims) string {
+	values := jwt.MapClaims{
+		"iss": idp.URL,
+		"aud": idp.clientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		values[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, values)
+	token.Header["kid"] = idp.kid
+	signed, _ := token.SignedString(idp.key)
+	return signed
+}
+
+// login follows the redirect to the provider and returns the state and the code it redirects back with.
+func (idp *mockIdP) login(t *testing.T, redirect OIDCRedirect) (string, string) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(redirect.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("unexpected response from the authorization endpoint: %v", res.Status)
+	}
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func Test_service_OIDC(t *testing.T) {
+	logger, _ := log.NewForTest()
+	idp := newMockIdP(t)
+	defer idp.Close()
+	provider := NewOIDCProvider(OIDCConfig{idp.URL, idp.clientID, idp.clientSecret, "http://localhost/api/oidc/callback"}, nil)
+	repo := &mockRepository{items: []entity.User{{ID: "1", Name: "bob"}}}
+	s := NewService(repo, &mockTokenRepo{}, testHasher, provider, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger)
+	ctx := context.Background()
+
+	_, err := NewService(repo, &mockTokenRepo{}, testHasher, nil, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger).OIDCLogin(ctx)
+	assert.Equal(t, errOIDCDisabled, err)
+
+	// the first login links the account to a new user, and later logins to the same user
+	idp.account = jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.com", "preferred_username": "alice"}
+	for i := 0; i < 2; i++ {
+		redirect, err := s.OIDCLogin(ctx)
+		assert.Nil(t, err)
+		state, code := idp.login(t, redirect)
+		tokens, err := s.OIDCCallback(ctx, redirect.Session, state, code)
+		if assert.Nil(t, err) {
+			assert.NotEmpty(t, tokens.AccessToken)
+			assert.NotEmpty(t, tokens.RefreshToken)
+		}
+	}
+	if assert.Equal(t, 2, len(repo.items)) && assert.Equal(t, 1, len(repo.identities)) {
+		assert.Equal(t, "alice", repo.items[1].Name)
+		assert.Equal(t, repo.items[1].ID, repo.identities[0].UserID)
+		assert.Equal(t, idp.URL, repo.identities[0].Issuer)
+		assert.Equal(t, "alice@example.com", repo.identities[0].Email)
+	}
+	// users created with OIDC have no password to log in with
+	assert.Nil(t, s.(service).authenticate(ctx, "alice", ""))
+
+	// taken names get a suffix
+	idp.account = jwt.MapClaims{"sub": "sub-bob", "preferred_username": "bob"}
+	redirect, _ := s.OIDCLogin(ctx)
+	state, code := idp.login(t, redirect)
+	_, err = s.OIDCCallback(ctx, redirect.Session, state, code)
+	if assert.Nil(t, err) && assert.Equal(t, 3, len(repo.items)) {
+		assert.NotEqual(t, "bob", repo.items[2].Name)
+		assert.Equal(t, "bob-", repo.items[2].Name[:4])
+	}
+
+	// the state must match the session, and codes are only exchanged once
+	redirect, _ = s.OIDCLogin(ctx)
+	state, code = idp.login(t, redirect)
+	other, _ := s.OIDCLogin(ctx)
+	_, err = s.OIDCCallback(ctx, other.Session, state, code)
+	assert.Equal(t, errs.Unauthorized(""), err)
+	_, err = s.OIDCCallback(ctx, "invalid", state, code)
+	assert.Equal(t, errs.Unauthorized(""), err)
+	_, err = s.OIDCCallback(ctx, redirect.Session, state, code)
+	assert.Nil(t, err)
+	_, err = s.OIDCCallback(ctx, redirect.Session, state, code)
+	assert.Equal(t, errs.Unauthorized(""), err)
+
+	// access tokens are not accepted as sessions
+	accessToken, _ := s.(service).generateJWT(identity{User: repo.items[1]}, "jti")
+	_, err = s.OIDCCallback(ctx, accessToken, "", code)
+	assert.Equal(t, errs.Unauthorized(""), err)
+}
+
+func TestOIDCProvider_Exchange(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+	provider := NewOIDCProvider(OIDCConfig{idp.URL, idp.clientID, idp.clientSecret, "http://localhost/callback"}, nil)
+	ctx := context.Background()
+
+	// the code is only exchanged with the verifier of the challenge
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	assert.Nil(t, err)
+	_, code := idp.login(t, OIDCRedirect{URL: authURL})
+	_, err = provider.Exchange(ctx, code, "other")
+	assert.NotNil(t, err)
+	_, code = idp.login(t, OIDCRedirect{URL: authURL})
+	idToken, err := provider.Exchange(ctx, code, "verifier")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, idToken)
+
+	// the client must authenticate
+	wrongSecret := NewOIDCProvider(OIDCConfig{idp.URL, idp.clientID, "wrong", "http://localhost/callback"}, nil)
+	_, code = idp.login(t, OIDCRedirect{URL: authURL})
+	_, err = wrongSecret.Exchange(ctx, code, "verifier")
+	assert.NotNil(t, err)
+
+	// the discovery document must be for the configured issuer
+	wrongIssuer := NewOIDCProvider(OIDCConfig{idp.URL + "/", idp.clientID, idp.clientSecret, "http://localhost/callback"}, nil)
+	_, err = wrongIssuer.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	assert.NotNil(t, err)
+}
+
+func TestOIDCProvider_Verify(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+	provider := NewOIDCProvider(OIDCConfig{idp.URL, idp.clientID, idp.clientSecret, "http://localhost/callback"}, nil)
+	ctx := context.Background()
+
+	claims, err := provider.Verify(ctx, idp.sign(jwt.MapClaims{"sub": "100", "nonce": "n", "email": "a@example.com"}), "n")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "100", claims.Subject)
+		assert.Equal(t, "a@example.com", claims.Email)
+	}
+	claims, err = provider.Verify(ctx, idp.sign(jwt.MapClaims{"sub": "100", "nonce": "n", "aud": []interface{}{"other", idp.clientID}}), "n")
+	assert.Nil(t, err)
+
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": idp.URL, "aud": idp.clientID, "sub": "100", "nonce": "n", "exp": time.Now().Add(time.Hour).Unix()}).SignedString([]byte("secret"))
+	tests := []struct {
+		name    string
+		idToken string
+	}{
+		{"wrong nonce", idp.sign(jwt.MapClaims{"sub": "100", "nonce": "other"})},
+		{"wrong audience", idp.sign(jwt.MapClaims{"sub": "100", "nonce": "n", "aud": "other"})},
+		{"wrong issuer", idp.sign(jwt.MapClaims{"sub": "100", "nonce": "n", "iss": "https://evil.example.com"})},
+		{"expired", idp.sign(jwt.MapClaims{"sub": "100", "nonce": "n", "exp": time.Now().Add(-time.Minute).Unix()})},
+		{"no expiry", idp.sign(jwt.MapClaims{"sub": "100", "nonce": "n", "exp": nil})},
+		{"no subject", idp.sign(jwt.MapClaims{"nonce": "n"})},
+		{"symmetric signature", hmac},
+		{"malformed", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.Verify(ctx, tt.idToken, "n")
+			assert.NotNil(t, err)
+		})
+	}
+
+	// rotated keys are fetched again, but not more than once within keyRefreshInterval
+	idp.rotateKey(t)
+	rotated := idp.sign(jwt.MapClaims{"sub": "100", "nonce": "n"})
+	_, err = provider.Verify(ctx, rotated, "n")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, idp.keyFetches)
+	provider.fetchedAt = time.Time{}
+	_, err = provider.Verify(ctx, rotated, "n")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, idp.keyFetches)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the algorithms hashing the passwords of the users
+const (
+	// HashArgon2id hashes passwords with argon2id and stores them in the PHC string format.
+	HashArgon2id = "argon2id"
+	// HashBcrypt hashes passwords with bcrypt.
+	HashBcrypt = "bcrypt"
+)
+
+// legacyPrefix marks passwords stored in plain text before they were hashed. Such passwords are only accepted
+// with the prefix, which the migration hashing passwords adds to the existing rows, and are hashed on the next login.
+const legacyPrefix = "$plain$"
+
+// the sizes in bytes of the salts and the keys derived by argon2id
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// PasswordParams configures how new password hashes are computed.
+type PasswordParams struct {
+	// Algorithm is either HashArgon2id or HashBcrypt.
+	Algorithm string
+	// Memory is the memory in KiB used by argon2id.
+	Memory uint32
+	// Iterations is the number of passes of argon2id over the memory.
+	Iterations uint32
+	// Parallelism is the number of threads used by argon2id.
+	Parallelism uint8
+	// Cost is the cost of bcrypt.
+	Cost int
+}
+
+// PasswordHasher hashes passwords and verifies them against stored hashes.
+type PasswordHasher interface {
+	// Hash returns the hash of the password computed with the configured algorithm and parameters.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the stored hash. If it does, rehash tells whether the hash was
+	// computed in plain text, with another algorithm or with other parameters, and should be replaced.
+	Verify(password, hash string) (ok, rehash bool)
+}
+
+type passwordHasher struct {
+	params PasswordParams
+}
+
+// NewPasswordHasher creates a password hasher computing new hashes with the given parameters.
+func NewPasswordHasher(params PasswordParams) PasswordHasher {
+	return passwordHasher{params}
+}
+
+// Hash returns the hash of the password.
+func (h passwordHasher) Hash(password string) (string, error) {
+	if h.params.Algorithm == HashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.params.Cost)
+		return string(hash), err
+	}
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify compares the password with the hash in constant time. Hashes in an unknown format never match.
+func (h passwordHasher) Verify(password, hash string) (bool, bool) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, false
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false
+		}
+		return true, h.params.Algorithm != HashArgon2id || params.Memory != h.params.Memory ||
+			params.Iterations != h.params.Iterations || params.Parallelism != h.params.Parallelism
+	case strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$"):
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, err != nil || h.params.Algorithm != HashBcrypt || cost != h.params.Cost
+	case strings.HasPrefix(hash, legacyPrefix):
+		stored := strings.TrimPrefix(hash, legacyPrefix)
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) != 1 {
+			return false, false
+		}
+		return true, true
+	}
+	return false, false
+}
+
+// decodeArgon2 parses an argon2id hash in the PHC string format into its parameters, salt and key.
+func decodeArgon2(hash string) (PasswordParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return PasswordParams{}, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return PasswordParams{}, nil, nil, fmt.Errorf("unsupported argon2id version")
+	}
+	params := PasswordParams{Algorithm: HashArgon2id}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return PasswordParams{}, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return PasswordParams{}, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return PasswordParams{}, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_passwordHasher(t *testing.T) {
+	argon2 := NewPasswordHasher(PasswordParams{Algorithm: HashArgon2id, Memory: 64, Iterations: 1, Parallelism: 1})
+	stronger := NewPasswordHasher(PasswordParams{Algorithm: HashArgon2id, Memory: 128, Iterations: 2, Parallelism: 1})
+	bcrypt := NewPasswordHasher(PasswordParams{Algorithm: HashBcrypt, Cost: 4})
+	argon2Hash, _ := argon2.Hash("pass")
+	bcryptHash, _ := bcrypt.Hash("pass")
+
+	tests := []struct {
+		name       string
+		hasher     PasswordHasher
+		password   string
+		hash       string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{"argon2id", argon2, "pass", argon2Hash, true, false},
+		{"argon2id wrong password", argon2, "bad", argon2Hash, false, false},
+		{"argon2id weaker parameters", stronger, "pass", argon2Hash, true, true},
+		{"argon2id to bcrypt", bcrypt, "pass", argon2Hash, true, true},
+		{"bcrypt", bcrypt, "pass", bcryptHash, true, false},
+		{"bcrypt wrong password", bcrypt, "bad", bcryptHash, false, false},
+		{"bcrypt to argon2id", argon2, "pass", bcryptHash, true, true},
+		{"legacy", argon2, "pass", "$plain$pass", true, true},
+		{"legacy wrong password", argon2, "bad", "$plain$pass", false, false},
+		{"unknown format", argon2, "pass", "pass", false, false},
+		{"malformed argon2id", argon2, "pass", "$argon2id$v=19$m=64$salt", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := tt.hasher.Verify(tt.password, tt.hash)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantRehash, rehash)
+		})
+	}
+
+	// every hash is salted
+	other, _ := argon2.Hash("pass")
+	assert.NotEqual(t, argon2Hash, other)
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Purger removes the refresh tokens, the denied access tokens and the password resets that have expired.
+type Purger struct {
+	repo     TokenRepo
+	interval time.Duration
+	logger   log.Logger
+}
+
+// NewPurger creates a new purger that removes expired tokens, checking every interval.
+func NewPurger(repo TokenRepo, interval time.Duration, logger log.Logger) *Purger {
+	return &Purger{repo, interval, logger}
+}
+
+// Run purges the expired tokens immediately and then once every interval until the context is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := p.Purge(ctx); err != nil {
+			p.logger.With(ctx).Errorf("failed to purge expired tokens: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes the tokens that have expired and returns how many were removed.
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	count, err := p.repo.Purge(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		p.logger.With(ctx).Infof("purged %d expired tokens", count)
+	}
+	return count, nil
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurger_Purge(t *testing.T) {
+	logger, entries := log.NewForTest()
+	now := time.Now()
+	repo := &mockTokenRepo{
+		tokens: []entity.RefreshToken{
+			{ID: "expired", ExpiresAt: now.Add(-time.Hour)},
+			{ID: "active", ExpiresAt: now.Add(time.Hour)},
+		},
+		revoked: []entity.RevokedToken{{ID: "jti1", ExpiresAt: now.Add(-time.Minute)}},
+	}
+	p := NewPurger(repo, time.Hour, logger)
+
+	count, err := p.Purge(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 1, entries.Len())
+	assert.Equal(t, 1, len(repo.tokens))
+	assert.Zero(t, len(repo.revoked))
+
+	count, _ = p.Purge(context.Background())
+	assert.Zero(t, count)
+}
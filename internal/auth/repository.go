@@ -2,6 +2,9 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"time"
 
 	dbx "github.com/go-ozzo/ozzo-dbx"
 	"github.com/qiangxue/go-rest-api/internal/entity"
@@ -12,9 +15,72 @@ import (
 type UserRepo interface {
 	Get(ctx context.Context, name string) (entity.User, error)
 	GetByName(ctx context.Context, name string) (entity.User, error)
+	// GetByEmail returns the user with the given email address.
+	GetByEmail(ctx context.Context, email string) (entity.User, error)
 	Create(ctx context.Context, user entity.User) error
 	Update(ctx context.Context, user entity.User) error
 	Delete(ctx context.Context, id string) error
+	// GetIdentity returns the link of a user to the account with the given subject at the given OpenID Connect issuer.
+	GetIdentity(ctx context.Context, issuer, subject string) (entity.UserIdentity, error)
+	// CreateWithIdentity saves a new user along with the link to the account of the user at an OpenID Connect provider.
+	CreateWithIdentity(ctx context.Context, user entity.User, identity entity.UserIdentity) error
+	// GetTOTP returns the TOTP secret of the given user.
+	GetTOTP(ctx context.Context, userID string) (entity.TOTP, error)
+	// CreateTOTP saves a new TOTP secret.
+	CreateTOTP(ctx context.Context, totp entity.TOTP) error
+	// UpdateTOTP saves the changes to a TOTP secret.
+	UpdateTOTP(ctx context.Context, totp entity.TOTP) error
+	// UseTOTPStep records that the code of the given time step was accepted for the TOTP secret with the given ID.
+	// It returns sql.ErrNoRows if a code of the same or a later time step was accepted before.
+	UseTOTPStep(ctx context.Context, id string, step int64) error
+	// EnableTOTP saves the enabled TOTP secret and replaces the recovery codes of its user.
+	EnableTOTP(ctx context.Context, totp entity.TOTP, codes []entity.RecoveryCode) error
+	// DeleteTOTP removes the TOTP secret and the recovery codes of the given user.
+	DeleteTOTP(ctx context.Context, userID string) error
+	// QueryRecoveryCodes returns the unused recovery codes of the given user.
+	QueryRecoveryCodes(ctx context.Context, userID string) ([]entity.RecoveryCode, error)
+	// ReplaceRecoveryCodes replaces the recovery codes of the given user.
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codes []entity.RecoveryCode) error
+	// UseRecoveryCode marks the recovery code with the given ID as used. It returns sql.ErrNoRows if the code
+	// has already been used.
+	UseRecoveryCode(ctx context.Context, id string, usedAt time.Time) error
+	// DeleteAccount removes the given user along with their credentials and denies the given access tokens, within
+	// a transaction in which deleteData deletes the data the other packages keep about the user beforehand.
+	DeleteAccount(ctx context.Context, userID string, denied []entity.RevokedToken, deleteData func(ctx context.Context) error) error
+}
+
+// ErrWorkspaceOwner is returned when deleting the account of a user who still owns a workspace.
+var ErrWorkspaceOwner = errors.New("the user owns a workspace")
+
+// UserDataRepo is the repository of another package keeping data about the users, which is deleted along with
+// their accounts.
+type UserDataRepo interface {
+	// DeleteUserData deletes or anonymises the data of the given user. It is called within the transaction deleting
+	// the account, and runs its queries in the transaction of the context.
+	DeleteUserData(ctx context.Context, userID string) error
+}
+
+// NoteDataRepo is the repository of the notes, which are deleted or anonymised along with the accounts of their
+// authors.
+type NoteDataRepo interface {
+	// DeleteUserNotes deletes the personal notes of the given user and anonymises the others they wrote, within
+	// the transaction of the context. It returns the IDs of the deleted notes.
+	DeleteUserNotes(ctx context.Context, userID string) ([]string, error)
+}
+
+// NoteIndex is the search index of the notes, which the notes deleted along with an account are removed from.
+type NoteIndex interface {
+	Remove(ctx context.Context, id string) error
+}
+
+// AccountData holds the repositories of the data other packages keep about the users, which is deleted along with
+// their accounts.
+type AccountData struct {
+	// Repos delete their data of the user in order, before the notes are deleted.
+	Repos []UserDataRepo
+	Notes NoteDataRepo
+	// Index is the search index the deleted notes are removed from once the account is deleted.
+	Index NoteIndex
 }
 
 type repository struct {
@@ -39,6 +105,13 @@ func (r repository) GetByName(ctx context.Context, name string) (entity.User, er
 	return user, err
 }
 
+// GetByEmail reads the user with the given email address from the database.
+func (r repository) GetByEmail(ctx context.Context, email string) (entity.User, error) {
+	var user entity.User
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"email": email}).One(&user)
+	return user, err
+}
+
 func (r repository) Create(ctx context.Context, user entity.User) error {
 	return r.db.With(ctx).Model(&user).Insert()
 }
@@ -54,3 +127,316 @@ func (r repository) Delete(ctx context.Context, id string) error {
 	}
 	return r.db.With(ctx).Model(&item).Delete()
 }
+
+// GetIdentity reads the link to the account with the given subject at the given issuer from the database.
+func (r repository) GetIdentity(ctx context.Context, issuer, subject string) (entity.UserIdentity, error) {
+	var identity entity.UserIdentity
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"issuer": issuer, "subject": subject}).One(&identity)
+	return identity, err
+}
+
+// CreateWithIdentity saves a new user record and its link to an external account in the database within
+// a transaction.
+func (r repository) CreateWithIdentity(ctx context.Context, user entity.User, identity entity.UserIdentity) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := r.db.With(ctx).Model(&user).Insert(); err != nil {
+			return err
+		}
+		return r.db.With(ctx).Model(&identity).Insert()
+	})
+}
+
+// GetTOTP reads the TOTP secret of the given user from the database.
+func (r repository) GetTOTP(ctx context.Context, userID string) (entity.TOTP, error) {
+	var totp entity.TOTP
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"user_id": userID}).One(&totp)
+	return totp, err
+}
+
+// CreateTOTP saves a new TOTP secret record in the database.
+func (r repository) CreateTOTP(ctx context.Context, totp entity.TOTP) error {
+	return r.db.With(ctx).Model(&totp).Insert()
+}
+
+// UpdateTOTP saves the changes to a TOTP secret in the database.
+func (r repository) UpdateTOTP(ctx context.Context, totp entity.TOTP) error {
+	return r.db.With(ctx).Model(&totp).Update()
+}
+
+// UseTOTPStep saves the time step of the accepted code in the database unless a code of the same or a later time
+// step was accepted before. The check and the update happen in a single statement so that a code cannot be
+// used twice concurrently.
+func (r repository) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	result, err := r.db.With(ctx).Update("user_totp",
+		dbx.Params{"last_used_step": step},
+		dbx.And(dbx.HashExp{"id": id}, dbx.NewExp("last_used_step < {:step}", dbx.Params{"step": step})),
+	).Execute()
+	return affectedOne(result, err)
+}
+
+// EnableTOTP saves the enabled TOTP secret and replaces the recovery codes of its user in the database
+// within a transaction.
+func (r repository) EnableTOTP(ctx context.Context, totp entity.TOTP, codes []entity.RecoveryCode) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := r.db.With(ctx).Model(&totp).Update(); err != nil {
+			return err
+		}
+		return r.replaceRecoveryCodes(ctx, totp.UserID, codes)
+	})
+}
+
+// DeleteTOTP deletes the TOTP secret and the recovery codes of the given user from the database within a transaction.
+func (r repository) DeleteTOTP(ctx context.Context, userID string) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		for _, table := range []string{"recovery_codes", "user_totp"} {
+			if _, err := r.db.With(ctx).Delete(table, dbx.HashExp{"user_id": userID}).Execute(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// QueryRecoveryCodes retrieves the unused recovery codes of the given user from the database.
+func (r repository) QueryRecoveryCodes(ctx context.Context, userID string) ([]entity.RecoveryCode, error) {
+	var codes []entity.RecoveryCode
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"user_id": userID, "used_at": nil}).
+		OrderBy("id").
+		All(&codes)
+	return codes, err
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of the given user in the database within a transaction.
+func (r repository) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []entity.RecoveryCode) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		return r.replaceRecoveryCodes(ctx, userID, codes)
+	})
+}
+
+// replaceRecoveryCodes deletes the recovery codes of the given user from the database and saves the new ones.
+func (r repository) replaceRecoveryCodes(ctx context.Context, userID string, codes []entity.RecoveryCode) error {
+	if _, err := r.db.With(ctx).Delete("recovery_codes", dbx.HashExp{"user_id": userID}).Execute(); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if err := r.db.With(ctx).Model(&code).Insert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code as used in the database unless it already is, in a single statement.
+func (r repository) UseRecoveryCode(ctx context.Context, id string, usedAt time.Time) error {
+	result, err := r.db.With(ctx).Update("recovery_codes",
+		dbx.Params{"used_at": usedAt},
+		dbx.HashExp{"id": id, "used_at": nil},
+	).Execute()
+	return affectedOne(result, err)
+}
+
+// DeleteAccount removes the user and their credentials from the database, and saves the denied access tokens,
+// within a transaction which deleteData runs in beforehand.
+func (r repository) DeleteAccount(ctx context.Context, userID string, denied []entity.RevokedToken, deleteData func(ctx context.Context) error) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := deleteData(ctx); err != nil {
+			return err
+		}
+		for _, table := range []string{"refresh_tokens", "user_identities", "user_totp", "recovery_codes", "password_resets"} {
+			if _, err := r.db.With(ctx).Delete(table, dbx.HashExp{"user_id": userID}).Execute(); err != nil {
+				return err
+			}
+		}
+		for _, token := range denied {
+			_, err := r.db.With(ctx).NewQuery("INSERT INTO revoked_tokens (id, expires_at) VALUES ({:id}, {:expires_at}) " +
+				"ON CONFLICT (id) DO NOTHING").
+				Bind(dbx.Params{"id": token.ID, "expires_at": token.ExpiresAt}).
+				Execute()
+			if err != nil {
+				return err
+			}
+		}
+		return affectedOne(r.db.With(ctx).Delete("users", dbx.HashExp{"id": userID}).Execute())
+	})
+}
+
+// affectedOne returns sql.ErrNoRows if the executed statement changed no rows.
+func affectedOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TokenRepo persists the refresh tokens, the revoked access tokens and the password resets.
+type TokenRepo interface {
+	// CreateRefreshToken saves a new refresh token.
+	CreateRefreshToken(ctx context.Context, token entity.RefreshToken) error
+	// GetRefreshToken returns the refresh token with the given hash.
+	GetRefreshToken(ctx context.Context, hash string) (entity.RefreshToken, error)
+	// UseRefreshToken marks the refresh token with the given ID as exchanged. It returns sql.ErrNoRows if the
+	// token has already been exchanged or revoked.
+	UseRefreshToken(ctx context.Context, id string, usedAt time.Time) error
+	// QueryFamily returns the refresh tokens of the given family.
+	QueryFamily(ctx context.Context, familyID string) ([]entity.RefreshToken, error)
+	// QueryActive returns the refresh tokens of the given user that are neither revoked nor expired at the given time.
+	QueryActive(ctx context.Context, userID string, now time.Time) ([]entity.RefreshToken, error)
+	// RevokeFamily revokes every refresh token of the given family.
+	RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	// RevokeAccessToken adds an access token to the denylist.
+	RevokeAccessToken(ctx context.Context, token entity.RevokedToken) error
+	// IsRevoked reports whether the access token with the given jti is on the denylist.
+	IsRevoked(ctx context.Context, id string) (bool, error)
+	// CreatePasswordReset saves a new password reset.
+	CreatePasswordReset(ctx context.Context, reset entity.PasswordReset) error
+	// GetPasswordReset returns the password reset with the given token hash.
+	GetPasswordReset(ctx context.Context, hash string) (entity.PasswordReset, error)
+	// UsePasswordReset marks the password reset with the given ID as used. It returns sql.ErrNoRows if the
+	// reset has already been used.
+	UsePasswordReset(ctx context.Context, id string, usedAt time.Time) error
+	// RevokePasswordResets marks the unused password resets of the given user as used.
+	RevokePasswordResets(ctx context.Context, userID string, revokedAt time.Time) error
+	// Purge removes the refresh tokens, the denied access tokens and the password resets that expired before
+	// the given time, and returns how many were removed.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// tokenRepository persists tokens in database
+type tokenRepository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewTokenRepository creates a new token repository.
+func NewTokenRepository(db *dbcontext.DB, logger log.Logger) TokenRepo {
+	return tokenRepository{db, logger}
+}
+
+// CreateRefreshToken saves a new refresh token record in the database.
+func (r tokenRepository) CreateRefreshToken(ctx context.Context, token entity.RefreshToken) error {
+	return r.db.With(ctx).Model(&token).Insert()
+}
+
+// GetRefreshToken reads the refresh token with the given hash from the database.
+func (r tokenRepository) GetRefreshToken(ctx context.Context, hash string) (entity.RefreshToken, error) {
+	var token entity.RefreshToken
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"token_hash": hash}).One(&token)
+	return token, err
+}
+
+// UseRefreshToken marks the refresh token as exchanged in the database, unless it already is or was revoked.
+// The check and the update happen in a single statement so that a token cannot be exchanged twice concurrently.
+func (r tokenRepository) UseRefreshToken(ctx context.Context, id string, usedAt time.Time) error {
+	result, err := r.db.With(ctx).Update("refresh_tokens",
+		dbx.Params{"used_at": usedAt},
+		dbx.HashExp{"id": id, "used_at": nil, "revoked_at": nil},
+	).Execute()
+	return affectedOne(result, err)
+}
+
+// QueryFamily retrieves the refresh tokens of the given family from the database.
+func (r tokenRepository) QueryFamily(ctx context.Context, familyID string) ([]entity.RefreshToken, error) {
+	var tokens []entity.RefreshToken
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"family_id": familyID}).
+		OrderBy("created_at").
+		All(&tokens)
+	return tokens, err
+}
+
+// QueryActive retrieves the refresh tokens of the given user that are neither revoked nor expired from the database.
+func (r tokenRepository) QueryActive(ctx context.Context, userID string, now time.Time) ([]entity.RefreshToken, error) {
+	var tokens []entity.RefreshToken
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"user_id": userID, "revoked_at": nil}).
+		AndWhere(dbx.NewExp("expires_at > {:now}", dbx.Params{"now": now})).
+		OrderBy("created_at").
+		All(&tokens)
+	return tokens, err
+}
+
+// RevokeFamily marks the refresh tokens of the given family as revoked in the database.
+func (r tokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	_, err := r.db.With(ctx).Update("refresh_tokens",
+		dbx.Params{"revoked_at": revokedAt},
+		dbx.HashExp{"family_id": familyID, "revoked_at": nil},
+	).Execute()
+	return err
+}
+
+// RevokeAccessToken saves a denied access token in the database. Denying a token twice has no effect.
+func (r tokenRepository) RevokeAccessToken(ctx context.Context, token entity.RevokedToken) error {
+	_, err := r.db.With(ctx).NewQuery("INSERT INTO revoked_tokens (id, expires_at) VALUES ({:id}, {:expires_at}) " +
+		"ON CONFLICT (id) DO NOTHING").
+		Bind(dbx.Params{"id": token.ID, "expires_at": token.ExpiresAt}).
+		Execute()
+	return err
+}
+
+// IsRevoked looks up the access token with the given jti among the denied tokens in the database.
+func (r tokenRepository) IsRevoked(ctx context.Context, id string) (bool, error) {
+	var count int
+	err := r.db.With(ctx).
+		Select("COUNT(*)").
+		From("revoked_tokens").
+		Where(dbx.HashExp{"id": id}).
+		Row(&count)
+	return count > 0, err
+}
+
+// Purge deletes the expired refresh tokens, denied access tokens and password resets from the database.
+func (r tokenRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	var count int64
+	for _, table := range []string{"refresh_tokens", "revoked_tokens", "password_resets"} {
+		result, err := r.db.With(ctx).Delete(table, dbx.NewExp("expires_at < {:before}", dbx.Params{"before": before})).Execute()
+		if err != nil {
+			return 0, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		count += rows
+	}
+	return int(count), nil
+}
+
+// CreatePasswordReset saves a new password reset record in the database.
+func (r tokenRepository) CreatePasswordReset(ctx context.Context, reset entity.PasswordReset) error {
+	return r.db.With(ctx).Model(&reset).Insert()
+}
+
+// GetPasswordReset reads the password reset with the given token hash from the database.
+func (r tokenRepository) GetPasswordReset(ctx context.Context, hash string) (entity.PasswordReset, error) {
+	var reset entity.PasswordReset
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"token_hash": hash}).One(&reset)
+	return reset, err
+}
+
+// UsePasswordReset marks the password reset as used in the database, unless it already is. The check and
+// the update happen in a single statement so that a reset cannot be used twice concurrently.
+func (r tokenRepository) UsePasswordReset(ctx context.Context, id string, usedAt time.Time) error {
+	result, err := r.db.With(ctx).Update("password_resets",
+		dbx.Params{"used_at": usedAt},
+		dbx.HashExp{"id": id, "used_at": nil},
+	).Execute()
+	return affectedOne(result, err)
+}
+
+// RevokePasswordResets marks the unused password resets of the given user as used in the database.
+func (r tokenRepository) RevokePasswordResets(ctx context.Context, userID string, revokedAt time.Time) error {
+	_, err := r.db.With(ctx).Update("password_resets",
+		dbx.Params{"used_at": revokedAt},
+		dbx.HashExp{"user_id": userID, "used_at": nil},
+	).Execute()
+	return err
+}
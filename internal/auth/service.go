@@ -2,21 +2,184 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	stderrors "errors"
 	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/qiangxue/go-rest-api/internal/entity"
 	"github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/qiangxue/go-rest-api/pkg/mail"
 )
 
 // Service encapsulates the authentication logic.
 type Service interface {
 	// authenticate authenticates a user using username and password.
-	// It returns a JWT token if authentication succeeds. Otherwise, an error is returned.
-	Login(ctx context.Context, username, password string) (string, error)
-	Signup(ctx context.Context, username, password string) (string, error)
+	// It returns the tokens of a new session if authentication succeeds, or a challenge for the second factor
+	// if the user enabled two-factor authentication. Otherwise, an error is returned.
+	Login(ctx context.Context, username, password string) (LoginResult, error)
+	// LoginTwoFactor completes a login by answering its challenge with a TOTP code or a recovery code.
+	// A challenge can only be answered successfully once.
+	LoginTwoFactor(ctx context.Context, challengeToken, code string) (Tokens, error)
+	// ParseChallenge returns the claims of a challenge token handed out by Login, unless the token is invalid,
+	// expired or revoked.
+	ParseChallenge(ctx context.Context, challengeToken string) (ChallengeClaims, error)
+	// RevokeChallenge revokes a challenge so that it cannot be answered anymore.
+	RevokeChallenge(ctx context.Context, challenge ChallengeClaims) error
+	// Signup creates a user with the given name, password and optional email address, and starts a session.
+	Signup(ctx context.Context, username, password, email string) (Tokens, error)
+	// Refresh exchanges a refresh token for a new access token and a new refresh token.
+	Refresh(ctx context.Context, refreshToken string) (Tokens, error)
+	// Logout revokes the access token with the given ID, which expires at the given time, and the session
+	// of the refresh token, if any.
+	Logout(ctx context.Context, refreshToken, accessTokenID string, expiresAt time.Time) error
+	// OIDCLogin starts a login with the OpenID Connect provider.
+	OIDCLogin(ctx context.Context) (OIDCRedirect, error)
+	// OIDCCallback completes the login with the OpenID Connect provider started by the given session, and returns
+	// the tokens of a new session of the user linked to the account at the provider.
+	OIDCCallback(ctx context.Context, session, state, code string) (Tokens, error)
+	// EnrollTOTP generates a new TOTP secret for the current user, which is enabled once VerifyTOTP accepts a code.
+	EnrollTOTP(ctx context.Context) (TOTPEnrollment, error)
+	// VerifyTOTP enables two-factor authentication for the current user if the code matches the enrolled secret,
+	// and returns the recovery codes of the user.
+	VerifyTOTP(ctx context.Context, code string) (RecoveryCodes, error)
+	// DisableTOTP disables two-factor authentication for the current user, who must confirm with a code.
+	DisableTOTP(ctx context.Context, code string) error
+	// RegenerateRecoveryCodes replaces the recovery codes of the current user, who must confirm with a code.
+	RegenerateRecoveryCodes(ctx context.Context, code string) (RecoveryCodes, error)
+	// ChangePassword changes the password of the current user, who must confirm with the current password,
+	// and ends every session but the one of the access token with the given ID.
+	ChangePassword(ctx context.Context, currentPassword, newPassword, accessTokenID string) error
+	// RequestPasswordReset emails a password reset token to the user with the given email address, if any.
+	RequestPasswordReset(ctx context.Context, email string) error
+	// ResetPassword sets a new password for the user who received the reset token, and ends all their sessions.
+	ResetPassword(ctx context.Context, token, newPassword string) error
+	// GetProfile returns the profile of the current user.
+	GetProfile(ctx context.Context) (Profile, error)
+	// UpdateProfile changes the given fields of the profile of the current user.
+	UpdateProfile(ctx context.Context, input UpdateProfileRequest) (Profile, error)
+	// DeleteAccount deletes the current user, who must confirm with their password if they have one.
+	DeleteAccount(ctx context.Context, password string) error
+}
+
+// Profile is the account of a user as shown to the user.
+type Profile struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"display_name"`
+	Email       string    `json:"email"`
+	Timezone    string    `json:"timezone"`
+	Locale      string    `json:"locale"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// UpdateProfileRequest represents a profile update request. Only the fields that are given are changed, and empty
+// strings clear them.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	Email       *string `json:"email"`
+	Timezone    *string `json:"timezone"`
+	Locale      *string `json:"locale"`
+}
+
+// localePattern matches BCP 47 language tags, such as "en" or "pt-BR".
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// stringRule returns a validation rule checking non-empty strings with the given function.
+func stringRule(valid func(string) bool, message string) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		value, _ = validation.Indirect(value)
+		if str, _ := value.(string); str != "" && !valid(str) {
+			return stderrors.New(message)
+		}
+		return nil
+	})
+}
+
+// Validate validates the UpdateProfileRequest fields.
+func (m UpdateProfileRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.DisplayName, validation.Length(0, 128)),
+		validation.Field(&m.Email, validation.Length(0, 254), stringRule(func(email string) bool {
+			return validEmail(normalizeEmail(email))
+		}, "must be a valid email address")),
+		validation.Field(&m.Timezone, stringRule(func(name string) bool {
+			_, err := time.LoadLocation(name)
+			return err == nil && name != "Local"
+		}, "must be an IANA time zone name")),
+		validation.Field(&m.Locale, validation.Length(0, 35), validation.Match(localePattern).Error("must be a BCP 47 language tag")),
+	)
+}
+
+// LoginResult is the outcome of a successful password check: either the tokens of a new session, or a challenge
+// if the user also has to enter a code.
+type LoginResult struct {
+	Tokens    Tokens
+	Challenge *Challenge
+}
+
+// ChallengeClaims are the claims of a challenge token.
+type ChallengeClaims struct {
+	// ID identifies the challenge on the denylist of revoked tokens.
+	ID        string
+	UserID    string
+	Username  string
+	ExpiresAt time.Time
+}
+
+// Challenge asks for the second factor of a user who enabled two-factor authentication.
+type Challenge struct {
+	// ChallengeToken is exchanged for the tokens of a session along with a code by POST /login/2fa.
+	ChallengeToken string `json:"challenge_token"`
+	// ExpiresIn is the number of seconds the challenge token is valid for.
+	ExpiresIn int `json:"expires_in"`
+	// TwoFactorRequired is always true, so that clients can tell a challenge from tokens.
+	TwoFactorRequired bool `json:"two_factor_required"`
+}
+
+// TOTPEnrollment is the secret the user enters into an authenticator app, either as is or by scanning
+// the provisioning URI as a QR code.
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// RecoveryCodes are the one-time codes replacing TOTP codes when the user has lost the authenticator app.
+// They are only shown when they are generated.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// OIDCRedirect sends the user to the OpenID Connect provider to log in.
+type OIDCRedirect struct {
+	// URL is the authorization endpoint of the provider with the parameters of the login.
+	URL string
+	// Session keeps the state, the nonce and the PKCE verifier of the login until the provider calls back.
+	// It is signed, and meant to be stored in a cookie.
+	Session string
+}
+
+// Tokens are the tokens of a session. The access token authenticates the requests of the user and expires soon,
+// while the refresh token is exchanged for new tokens until the session ends.
+type Tokens struct {
+	// AccessToken is the JWT sent in the Authorization header. It is named "token" for older clients.
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the number of seconds the access token is valid for.
+	ExpiresIn int `json:"expires_in"`
 }
 
 // Identity represents an authenticated user identity.
@@ -25,93 +188,1022 @@ type Identity interface {
 	GetID() string
 	// GetName returns the user name.
 	GetName() string
+	// GetWorkspace returns the membership of the user in the active workspace, or nil if no workspace is active.
+	GetWorkspace() *entity.WorkspaceMember
+	// HasScope reports whether the user may use the routes requiring the given scope. Users authenticated with
+	// a personal access token only hold the scopes of the token.
+	HasScope(scope string) bool
 }
 
 type service struct {
-	signingKey      string
-	tokenExpiration int
-	logger          log.Logger
-	uRepo           UserRepo
+	signingKey        string
+	accessExpiration  time.Duration
+	refreshExpiration time.Duration
+	logger            log.Logger
+	uRepo             UserRepo
+	tRepo             TokenRepo
+	hasher            PasswordHasher
+	oidc              *OIDCProvider
+	mailer            mail.Mailer
+	resetURL          string
+	accountData       AccountData
+	// background runs the function in the background. It is replaced in tests.
+	background func(func())
 }
 
-// NewService creates a new authentication service.
-func NewService(userRepo UserRepo, signingKey string, tokenExpiration int, logger log.Logger) Service {
-	return service{signingKey, tokenExpiration, logger, userRepo}
+// detachedContext keeps the values of a request context, such as the request ID, for work that continues after
+// the response has been sent, without being canceled with the request.
+type detachedContext struct {
+	context.Context
 }
 
-// Login authenticates a user and generates a JWT token if authentication succeeds.
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+// NewService creates a new authentication service. Access tokens expire after accessExpiration, and refresh
+// tokens after refreshExpiration unless they are exchanged before. Logging in with OpenID Connect is disabled
+// if provider is nil, and resetting passwords if mailer is nil. The password reset emails link to resetURL
+// with the token appended, or only contain the token if resetURL is empty. The data of the other packages is
+// deleted along with the accounts through accountData.
+func NewService(userRepo UserRepo, tokenRepo TokenRepo, hasher PasswordHasher, provider *OIDCProvider, mailer mail.Mailer, resetURL string, accountData AccountData, signingKey string, accessExpiration, refreshExpiration time.Duration, logger log.Logger) Service {
+	return service{signingKey, accessExpiration, refreshExpiration, logger, userRepo, tokenRepo, hasher, provider, mailer, resetURL,
+		accountData, func(f func()) { go f() }}
+}
+
+// Login authenticates a user and starts a new session if authentication succeeds.
 // Otherwise, an error is returned.
-func (s service) Login(ctx context.Context, username, password string) (string, error) {
-	if identity := s.authenticate(ctx, username, password); identity != nil {
-		return s.generateJWT(identity)
+// Users who enabled two-factor authentication are challenged for a code instead.
+func (s service) Login(ctx context.Context, username, password string) (LoginResult, error) {
+	identity := s.authenticate(ctx, username, password)
+	if identity == nil {
+		return LoginResult{}, errors.Unauthorized("")
+	}
+	totp, err := s.uRepo.GetTOTP(ctx, identity.GetID())
+	if err == nil && totp.EnabledAt != nil {
+		challenge, err := s.sign(jwt.MapClaims{
+			"aud":  challengeAudience,
+			"sub":  identity.GetID(),
+			"name": identity.GetName(),
+			"jti":  entity.GenerateID(),
+			"exp":  time.Now().Add(challengeExpiration).Unix(),
+		})
+		if err != nil {
+			return LoginResult{}, err
+		}
+		return LoginResult{Challenge: &Challenge{challenge, int(challengeExpiration.Seconds()), true}}, nil
+	} else if err != nil && err != sql.ErrNoRows {
+		return LoginResult{}, err
 	}
-	return "", errors.Unauthorized("")
+	tokens, err := s.issueTokens(ctx, identity, entity.GenerateID())
+	return LoginResult{Tokens: tokens}, err
 }
 
 // authenticate authenticates a user using username and password.
 // If username and password are correct, an identity is returned. Otherwise, nil is returned.
+// Passwords stored in plain text or hashed with outdated parameters are hashed again on success.
 func (s service) authenticate(ctx context.Context, username, password string) Identity {
 	logger := s.logger.With(ctx, "user", username)
 
 	dbUser, err := s.uRepo.GetByName(ctx, username)
 	if err != nil {
 		logger.Infof("user not found: %v", err)
+		// hash the password anyway so that unknown users take as long to reject as wrong passwords
+		_, _ = s.hasher.Hash(password)
 		return nil
 	}
 
-	if dbUser.Name == username && dbUser.Password == password {
-		// TODO: salt, hash then compare password
-		logger.Debugf("authentication successful")
-		return dbUser
+	ok, rehash := s.hasher.Verify(password, dbUser.Password)
+	if !ok {
+		logger.Infof("authentication failed")
+		return nil
+	}
+	if rehash {
+		if err := s.rehash(ctx, dbUser, password); err != nil {
+			logger.Errorf("error rehashing password: %v", err)
+		}
 	}
-	// if username == "demo" && password == "pass" {
-	// 	logger.Infof("authentication successful")
-	// 	return entity.User{ID: "100", Name: "demo"}
-	// }
+	logger.Debugf("authentication successful")
+	return identity{User: dbUser}
+}
 
-	logger.Infof("authentication failed")
-	return nil
+// rehash replaces the stored password hash of the user with a hash computed with the current parameters.
+func (s service) rehash(ctx context.Context, user entity.User, password string) error {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return s.uRepo.Update(ctx, user)
 }
 
-// generateJWT generates a JWT that encodes an identity.
-func (s service) generateJWT(identity Identity) (string, error) {
+// generateJWT generates a JWT that encodes an identity. The token is identified by the given ID in its jti claim.
+func (s service) generateJWT(identity Identity, id string) (string, error) {
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   identity.GetID(),
 		"name": identity.GetName(),
-		"exp":  time.Now().Add(time.Duration(s.tokenExpiration) * time.Hour).Unix(),
+		"jti":  id,
+		"exp":  time.Now().Add(s.accessExpiration).Unix(),
 	}).SignedString([]byte(s.signingKey))
 }
 
-func (s service) Signup(ctx context.Context, username, password string) (string, error) {
+// issueTokens generates an access token for the identity together with a refresh token of the given family.
+// Only the hash of the refresh token is stored.
+func (s service) issueTokens(ctx context.Context, identity Identity, familyID string) (Tokens, error) {
+	accessTokenID := entity.GenerateID()
+	accessToken, err := s.generateJWT(identity, accessTokenID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	secret := make([]byte, refreshTokenLength)
+	if _, err := rand.Read(secret); err != nil {
+		return Tokens{}, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(secret)
+	now := time.Now()
+	err = s.tRepo.CreateRefreshToken(ctx, entity.RefreshToken{
+		ID:            entity.GenerateID(),
+		UserID:        identity.GetID(),
+		FamilyID:      familyID,
+		TokenHash:     HashToken(refreshToken),
+		AccessTokenID: accessTokenID,
+		ExpiresAt:     now.Add(s.refreshExpiration),
+		CreatedAt:     now,
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{accessToken, refreshToken, int(s.accessExpiration.Seconds())}, nil
+}
+
+// Refresh exchanges a refresh token for new tokens of the same session. Refresh tokens can only be exchanged once:
+// presenting a token again means that it has been stolen, so the whole session is revoked.
+func (s service) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	token, err := s.tRepo.GetRefreshToken(ctx, HashToken(refreshToken))
+	if err == sql.ErrNoRows {
+		return Tokens{}, errors.Unauthorized("")
+	} else if err != nil {
+		return Tokens{}, err
+	}
+	now := time.Now()
+	if token.RevokedAt != nil || now.After(token.ExpiresAt) {
+		return Tokens{}, errors.Unauthorized("")
+	}
+	if token.UsedAt != nil {
+		return Tokens{}, s.revokeReused(ctx, token, now)
+	}
+	if err := s.tRepo.UseRefreshToken(ctx, token.ID, now); err == sql.ErrNoRows {
+		// another request exchanged the token in the meantime
+		return Tokens{}, s.revokeReused(ctx, token, now)
+	} else if err != nil {
+		return Tokens{}, err
+	}
+	user, err := s.uRepo.Get(ctx, token.UserID)
+	if err == sql.ErrNoRows {
+		return Tokens{}, errors.Unauthorized("")
+	} else if err != nil {
+		return Tokens{}, err
+	}
+	return s.issueTokens(ctx, identity{User: user}, token.FamilyID)
+}
+
+// Logout ends the session of the refresh token if it belongs to the current user, and denies the access token
+// until it expires. Unknown refresh tokens are ignored.
+func (s service) Logout(ctx context.Context, refreshToken, accessTokenID string, expiresAt time.Time) error {
+	identity := CurrentUser(ctx)
+	if identity == nil {
+		return errors.Unauthorized("")
+	}
+	now := time.Now()
+	if refreshToken != "" {
+		token, err := s.tRepo.GetRefreshToken(ctx, HashToken(refreshToken))
+		if err == nil && token.UserID == identity.GetID() {
+			if err := s.revokeFamily(ctx, token.FamilyID, now); err != nil {
+				return err
+			}
+		} else if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	if accessTokenID != "" && expiresAt.After(now) {
+		return s.tRepo.RevokeAccessToken(ctx, entity.RevokedToken{ID: accessTokenID, ExpiresAt: expiresAt})
+	}
+	return nil
+}
+
+// revokeReused revokes the session of a refresh token that was presented again after it had been exchanged.
+// It returns the error to report to the client.
+func (s service) revokeReused(ctx context.Context, token entity.RefreshToken, now time.Time) error {
+	s.logger.With(ctx, "user", token.UserID).Infof("refresh token reused, revoking its session")
+	if err := s.revokeFamily(ctx, token.FamilyID, now); err != nil {
+		return err
+	}
+	return errors.Unauthorized("")
+}
+
+// revokeFamily revokes the refresh tokens of a session and denies the access tokens issued with them
+// that have not expired yet.
+func (s service) revokeFamily(ctx context.Context, familyID string, now time.Time) error {
+	tokens, err := s.tRepo.QueryFamily(ctx, familyID)
+	if err != nil {
+		return err
+	}
+	if err := s.tRepo.RevokeFamily(ctx, familyID, now); err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if expiresAt := token.CreatedAt.Add(s.accessExpiration); expiresAt.After(now) {
+			err := s.tRepo.RevokeAccessToken(ctx, entity.RevokedToken{ID: token.AccessTokenID, ExpiresAt: expiresAt})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// oidcSessionExpiration is how long users have to log in with the OpenID Connect provider.
+const oidcSessionExpiration = 10 * time.Minute
+
+// the audiences telling the tokens signed with the signing key apart from the access tokens
+const (
+	// oidcSessionAudience is the audience of the sessions of OpenID Connect logins.
+	oidcSessionAudience = "oidc_session"
+	// challengeAudience is the audience of the challenge tokens of two-factor authentication.
+	challengeAudience = "2fa_challenge"
+)
+
+// sign returns a JWT with the given claims signed with the signing key. Unlike access tokens, such tokens have no
+// jti claim and are rejected by the authentication middleware.
+func (s service) sign(claims jwt.MapClaims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.signingKey))
+}
+
+// parse verifies a JWT signed by sign for the given audience and returns its claims.
+func (s service) parse(token, audience string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(s.signingKey), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return nil, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+	return claims, nil
+}
+
+// errOIDCDisabled is returned when no OpenID Connect provider is configured.
+var errOIDCDisabled = errors.NotFound("single sign-on is not configured")
+
+// OIDCLogin generates the state, the nonce and the PKCE verifier of a new login with the OpenID Connect provider,
+// and keeps them in a signed session, as they are needed again when the provider calls back.
+func (s service) OIDCLogin(ctx context.Context) (OIDCRedirect, error) {
+	if s.oidc == nil {
+		return OIDCRedirect{}, errOIDCDisabled
+	}
+	var values [3]string
+	for i := range values {
+		secret := make([]byte, refreshTokenLength)
+		if _, err := rand.Read(secret); err != nil {
+			return OIDCRedirect{}, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(secret)
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+	url, err := s.oidc.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return OIDCRedirect{}, err
+	}
+	session, err := s.sign(jwt.MapClaims{
+		"aud":      oidcSessionAudience,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcSessionExpiration).Unix(),
+	})
+	if err != nil {
+		return OIDCRedirect{}, err
+	}
+	return OIDCRedirect{url, session}, nil
+}
+
+// OIDCCallback checks that the provider called back for the login of the session, exchanges the code for an ID
+// token and verifies it. The account at the provider is linked to a new user on the first login.
+func (s service) OIDCCallback(ctx context.Context, session, state, code string) (Tokens, error) {
+	if s.oidc == nil {
+		return Tokens{}, errOIDCDisabled
+	}
+	logger := s.logger.With(ctx)
+	claims, err := s.parse(session, oidcSessionAudience)
+	if err != nil {
+		logger.Infof("invalid OIDC session: %v", err)
+		return Tokens{}, errors.Unauthorized("")
+	}
+	expected, _ := claims["state"].(string)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(state)) != 1 {
+		logger.Infof("OIDC state mismatch")
+		return Tokens{}, errors.Unauthorized("")
+	}
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+	idToken, err := s.oidc.Exchange(ctx, code, verifier)
+	if err != nil {
+		logger.Infof("OIDC code exchange failed: %v", err)
+		return Tokens{}, errors.Unauthorized("")
+	}
+	account, err := s.oidc.Verify(ctx, idToken, nonce)
+	if err != nil {
+		logger.Infof("OIDC ID token rejected: %v", err)
+		return Tokens{}, errors.Unauthorized("")
+	}
+	user, err := s.linkIdentity(ctx, account)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return s.issueTokens(ctx, identity{User: user}, entity.GenerateID())
+}
+
+// linkIdentity returns the user linked to the account at the OpenID Connect provider. On the first login with
+// the account, a user without a password is created, named after the preferred username or the email address
+// of the account, or its subject. A suffix is added to the name if it is taken.
+func (s service) linkIdentity(ctx context.Context, account OIDCClaims) (entity.User, error) {
+	link, err := s.uRepo.GetIdentity(ctx, s.oidc.Issuer(), account.Subject)
+	if err == nil {
+		return s.uRepo.Get(ctx, link.UserID)
+	} else if err != sql.ErrNoRows {
+		return entity.User{}, err
+	}
+	name := account.PreferredUsername
+	if name == "" {
+		name = account.Email
+	}
+	if name == "" {
+		name = account.Subject
+	}
+	if _, err := s.uRepo.GetByName(ctx, name); err == nil {
+		name += "-" + entity.GenerateID()[:8]
+	} else if err != sql.ErrNoRows {
+		return entity.User{}, err
+	}
+	now := time.Now()
+	user := entity.User{ID: entity.GenerateID(), Name: name, CreatedAt: now, UpdatedAt: now}
+	link = entity.UserIdentity{
+		ID:        entity.GenerateID(),
+		UserID:    user.ID,
+		Issuer:    s.oidc.Issuer(),
+		Subject:   account.Subject,
+		Email:     account.Email,
+		CreatedAt: now,
+	}
+	if err := s.uRepo.CreateWithIdentity(ctx, user, link); err != nil {
+		return entity.User{}, err
+	}
+	s.logger.With(ctx, "user", user.ID).Infof("linked OIDC account %v to new user %v", account.Subject, name)
+	return user, nil
+}
+
+// refreshTokenLength is the number of random bytes in a refresh token.
+const refreshTokenLength = 32
+
+// HashToken returns the hex-encoded SHA-256 hash of a refresh token or a personal access token. Such tokens are
+// random enough for a fast hash to keep them safe.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s service) Signup(ctx context.Context, username, password, email string) (Tokens, error) {
 	if username == "" || password == "" {
-		return "", fmt.Errorf("username and password cannot be empty")
+		return Tokens{}, fmt.Errorf("username and password cannot be empty")
+	}
+	email = normalizeEmail(email)
+	if email != "" {
+		if !validEmail(email) {
+			return Tokens{}, fmt.Errorf("the email address is invalid")
+		}
+		if _, err := s.uRepo.GetByEmail(ctx, email); err == nil {
+			return Tokens{}, fmt.Errorf("the email address is already in use")
+		} else if err != sql.ErrNoRows {
+			return Tokens{}, fmt.Errorf("err getting user %w", err)
+		}
 	}
 
 	dbUser, err := s.uRepo.GetByName(ctx, username)
 	if err != nil && err.Error() != "sql: no rows in result set" {
-		return "", fmt.Errorf("err getting user %w", err)
+		return Tokens{}, fmt.Errorf("err getting user %w", err)
 	}
 	if dbUser.Name == username {
-		return "", fmt.Errorf("user already exists")
+		return Tokens{}, fmt.Errorf("user already exists")
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		s.logger.Errorf("error hashing password: %v", err)
+		return Tokens{}, fmt.Errorf("error creating user")
 	}
 	id := entity.GenerateID()
 
 	newUser := entity.User{
 		ID:        id,
 		Name:      username,
-		Password:  password,
+		Password:  hash,
+		Email:     email,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 	err = s.uRepo.Create(ctx, newUser)
 	if err != nil {
-		return "", fmt.Errorf("error creating user")
+		return Tokens{}, fmt.Errorf("error creating user")
 	}
-	token, err := s.generateJWT(newUser)
+	tokens, err := s.issueTokens(ctx, identity{User: newUser}, entity.GenerateID())
 	if err != nil {
 		s.logger.Errorf("error generating token: %v", err)
-		return "", fmt.Errorf("error generating token")
+		return Tokens{}, fmt.Errorf("error generating token")
+	}
+
+	return tokens, nil
+}
+
+// challengeExpiration is how long users have to enter the code of the second factor after the password.
+const challengeExpiration = 5 * time.Minute
+
+// recoveryCodeCount is the number of recovery codes generated at once.
+const recoveryCodeCount = 10
+
+// the errors of two-factor authentication
+var (
+	errInvalidCode       = errors.BadRequest("the code is invalid")
+	errTwoFactorEnabled  = errors.Conflict("two-factor authentication is already enabled")
+	errTwoFactorDisabled = errors.BadRequest("two-factor authentication is not enabled")
+)
+
+// LoginTwoFactor checks the challenge token handed out by Login and the code, which is either the TOTP code
+// of the user or one of the recovery codes, and starts a new session if both are valid. The challenge is revoked
+// once it has been answered.
+func (s service) LoginTwoFactor(ctx context.Context, challengeToken, code string) (Tokens, error) {
+	challenge, err := s.ParseChallenge(ctx, challengeToken)
+	if err != nil {
+		return Tokens{}, err
+	}
+	userID := challenge.UserID
+	totp, err := s.uRepo.GetTOTP(ctx, userID)
+	if err == sql.ErrNoRows || (err == nil && totp.EnabledAt == nil) {
+		return Tokens{}, errors.Unauthorized("")
+	} else if err != nil {
+		return Tokens{}, err
+	}
+	if err := s.checkSecondFactor(ctx, totp, code); err == errInvalidCode {
+		s.logger.With(ctx, "user", userID).Infof("2FA code rejected")
+		return Tokens{}, errors.Unauthorized("the code is invalid")
+	} else if err != nil {
+		return Tokens{}, err
+	}
+	user, err := s.uRepo.Get(ctx, userID)
+	if err == sql.ErrNoRows {
+		return Tokens{}, errors.Unauthorized("")
+	} else if err != nil {
+		return Tokens{}, err
+	}
+	if err := s.RevokeChallenge(ctx, challenge); err != nil {
+		return Tokens{}, err
+	}
+	return s.issueTokens(ctx, identity{User: user}, entity.GenerateID())
+}
+
+// ParseChallenge verifies the challenge token and checks that it is not on the denylist.
+func (s service) ParseChallenge(ctx context.Context, challengeToken string) (ChallengeClaims, error) {
+	logger := s.logger.With(ctx)
+	claims, err := s.parse(challengeToken, challengeAudience)
+	if err != nil {
+		logger.Infof("invalid 2FA challenge: %v", err)
+		return ChallengeClaims{}, errors.Unauthorized("")
+	}
+	var challenge ChallengeClaims
+	challenge.ID, _ = claims["jti"].(string)
+	challenge.UserID, _ = claims["sub"].(string)
+	challenge.Username, _ = claims["name"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		challenge.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	if challenge.ID == "" {
+		logger.Infof("invalid 2FA challenge: no jti")
+		return ChallengeClaims{}, errors.Unauthorized("")
+	}
+	if revoked, err := s.tRepo.IsRevoked(ctx, challenge.ID); err != nil {
+		return ChallengeClaims{}, err
+	} else if revoked {
+		logger.Infof("revoked 2FA challenge")
+		return ChallengeClaims{}, errors.Unauthorized("")
+	}
+	return challenge, nil
+}
+
+// RevokeChallenge adds the challenge to the denylist until it expires.
+func (s service) RevokeChallenge(ctx context.Context, challenge ChallengeClaims) error {
+	return s.tRepo.RevokeAccessToken(ctx, entity.RevokedToken{ID: challenge.ID, ExpiresAt: challenge.ExpiresAt})
+}
+
+// EnrollTOTP generates a new TOTP secret for the current user, replacing any secret that was not verified.
+func (s service) EnrollTOTP(ctx context.Context) (TOTPEnrollment, error) {
+	identity := CurrentUser(ctx)
+	if identity == nil {
+		return TOTPEnrollment{}, errors.Unauthorized("")
+	}
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	totp, err := s.uRepo.GetTOTP(ctx, identity.GetID())
+	switch {
+	case err == sql.ErrNoRows:
+		err = s.uRepo.CreateTOTP(ctx, entity.TOTP{
+			ID:        entity.GenerateID(),
+			UserID:    identity.GetID(),
+			Secret:    secret,
+			CreatedAt: time.Now(),
+		})
+	case err != nil:
+		return TOTPEnrollment{}, err
+	case totp.EnabledAt != nil:
+		return TOTPEnrollment{}, errTwoFactorEnabled
+	default:
+		totp.Secret, totp.LastUsedStep, totp.CreatedAt = secret, 0, time.Now()
+		err = s.uRepo.UpdateTOTP(ctx, totp)
+	}
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{secret, totpURI(secret, identity.GetName())}, nil
+}
+
+// VerifyTOTP enables two-factor authentication once the user proves that the authenticator app generates
+// the codes of the enrolled secret.
+func (s service) VerifyTOTP(ctx context.Context, code string) (RecoveryCodes, error) {
+	identity := CurrentUser(ctx)
+	if identity == nil {
+		return RecoveryCodes{}, errors.Unauthorized("")
+	}
+	totp, err := s.uRepo.GetTOTP(ctx, identity.GetID())
+	if err == sql.ErrNoRows {
+		return RecoveryCodes{}, errors.BadRequest("two-factor authentication has not been enrolled")
+	} else if err != nil {
+		return RecoveryCodes{}, err
+	}
+	if totp.EnabledAt != nil {
+		return RecoveryCodes{}, errTwoFactorEnabled
+	}
+	now := time.Now()
+	step := matchTOTP(totp.Secret, normalizeCode(code), now)
+	if step < 0 {
+		return RecoveryCodes{}, errInvalidCode
+	}
+	codes, records, err := generateRecoveryCodes(identity.GetID(), now)
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+	totp.EnabledAt, totp.LastUsedStep = &now, step
+	if err := s.uRepo.EnableTOTP(ctx, totp, records); err != nil {
+		return RecoveryCodes{}, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP deletes the TOTP secret and the recovery codes of the current user.
+func (s service) DisableTOTP(ctx context.Context, code string) error {
+	totp, err := s.enabledTOTP(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.checkSecondFactor(ctx, totp, code); err != nil {
+		return err
+	}
+	return s.uRepo.DeleteTOTP(ctx, totp.UserID)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user with new ones.
+func (s service) RegenerateRecoveryCodes(ctx context.Context, code string) (RecoveryCodes, error) {
+	totp, err := s.enabledTOTP(ctx)
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+	if err := s.checkSecondFactor(ctx, totp, code); err != nil {
+		return RecoveryCodes{}, err
+	}
+	codes, records, err := generateRecoveryCodes(totp.UserID, time.Now())
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+	if err := s.uRepo.ReplaceRecoveryCodes(ctx, totp.UserID, records); err != nil {
+		return RecoveryCodes{}, err
+	}
+	return codes, nil
+}
+
+// enabledTOTP returns the TOTP secret of the current user, who must have enabled two-factor authentication.
+func (s service) enabledTOTP(ctx context.Context) (entity.TOTP, error) {
+	identity := CurrentUser(ctx)
+	if identity == nil {
+		return entity.TOTP{}, errors.Unauthorized("")
+	}
+	totp, err := s.uRepo.GetTOTP(ctx, identity.GetID())
+	if err == sql.ErrNoRows || (err == nil && totp.EnabledAt == nil) {
+		return entity.TOTP{}, errTwoFactorDisabled
 	}
+	return totp, err
+}
+
+// checkSecondFactor accepts the current TOTP code of the user, unless it was used before, or an unused recovery code,
+// which is used up. It returns errInvalidCode otherwise.
+func (s service) checkSecondFactor(ctx context.Context, totp entity.TOTP, code string) error {
+	code = normalizeCode(code)
+	now := time.Now()
+	if step := matchTOTP(totp.Secret, code, now); step >= 0 {
+		if err := s.uRepo.UseTOTPStep(ctx, totp.ID, step); err == sql.ErrNoRows {
+			return errInvalidCode
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}
+	codes, err := s.uRepo.QueryRecoveryCodes(ctx, totp.UserID)
+	if err != nil {
+		return err
+	}
+	hash := HashToken(code)
+	for _, recoveryCode := range codes {
+		if subtle.ConstantTimeCompare([]byte(recoveryCode.CodeHash), []byte(hash)) != 1 {
+			continue
+		}
+		if err := s.uRepo.UseRecoveryCode(ctx, recoveryCode.ID, now); err == sql.ErrNoRows {
+			return errInvalidCode
+		} else if err != nil {
+			return err
+		}
+		s.logger.With(ctx, "user", totp.UserID).Infof("recovery code used")
+		return nil
+	}
+	return errInvalidCode
+}
+
+// recoveryCodeAlphabet is the Crockford base32 alphabet, which excludes the letters easily confused with digits.
+// Its 32 characters map random bytes to characters without bias.
+const recoveryCodeAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+// generateRecoveryCodes returns new recovery codes of the form "xxxxx-xxxxx" along with their records, which only
+// keep the hashes of the codes.
+func generateRecoveryCodes(userID string, now time.Time) (RecoveryCodes, []entity.RecoveryCode, error) {
+	codes := RecoveryCodes{Codes: make([]string, recoveryCodeCount)}
+	records := make([]entity.RecoveryCode, recoveryCodeCount)
+	random := make([]byte, 10)
+	for i := range codes.Codes {
+		if _, err := rand.Read(random); err != nil {
+			return RecoveryCodes{}, nil, err
+		}
+		code := make([]byte, 0, 11)
+		for j, b := range random {
+			if j == 5 {
+				code = append(code, '-')
+			}
+			code = append(code, recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)])
+		}
+		codes.Codes[i] = string(code)
+		records[i] = entity.RecoveryCode{
+			ID:        entity.GenerateID(),
+			UserID:    userID,
+			CodeHash:  HashToken(string(code)),
+			CreatedAt: now,
+		}
+	}
+	return codes, records, nil
+}
+
+// normalizeCode removes the spaces users may type in codes and lower-cases recovery codes.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.Join(strings.Fields(code), ""))
+}
+
+// passwordResetExpiration is how long a password reset token can be used.
+const passwordResetExpiration = time.Hour
+
+// the errors of password changes and resets
+var (
+	errPasswordResetDisabled = errors.NotFound("password resets are not configured")
+	errWrongPassword         = errors.BadRequest("the current password is wrong")
+	errInvalidResetToken     = errors.BadRequest("the reset token is invalid or has expired")
+	errEmptyPassword         = errors.BadRequest("the new password cannot be empty")
+)
+
+// ChangePassword verifies the current password of the user before hashing the new one. Users who signed up with
+// single sign-on have no password to confirm, and have to reset it instead. Pending password resets are revoked,
+// along with the other sessions of the user.
+func (s service) ChangePassword(ctx context.Context, currentPassword, newPassword, accessTokenID string) error {
+	identity := CurrentUser(ctx)
+	if identity == nil {
+		return errors.Unauthorized("")
+	}
+	if newPassword == "" {
+		return errEmptyPassword
+	}
+	user, err := s.uRepo.Get(ctx, identity.GetID())
+	if err != nil {
+		return err
+	}
+	if ok, _ := s.hasher.Verify(currentPassword, user.Password); !ok || user.Password == "" {
+		s.logger.With(ctx, "user", user.ID).Infof("password change rejected: wrong current password")
+		return errWrongPassword
+	}
+	if err := s.setPassword(ctx, user, newPassword, accessTokenID); err != nil {
+		return err
+	}
+	s.logger.With(ctx, "user", user.ID).Infof("password changed")
+	return nil
+}
+
+// RequestPasswordReset generates a reset token for the user with the email address and sends it to them.
+// The user is looked up and the email sent in the background, and unknown addresses are ignored, so that neither
+// the result nor the duration of the call reveal which addresses are registered.
+func (s service) RequestPasswordReset(ctx context.Context, email string) error {
+	if s.mailer == nil {
+		return errPasswordResetDisabled
+	}
+	email = normalizeEmail(email)
+	if !validEmail(email) {
+		return errors.BadRequest("the email address is invalid")
+	}
+	ctx = detachedContext{ctx}
+	s.background(func() {
+		if err := s.sendPasswordReset(ctx, email); err != nil {
+			s.logger.With(ctx).Errorf("failed to send the password reset email: %v", err)
+		}
+	})
+	return nil
+}
+
+// sendPasswordReset generates a reset token for the user with the email address, if any, and sends it to them.
+func (s service) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := s.uRepo.GetByEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		s.logger.With(ctx).Infof("password reset requested for an unknown email address")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	secret := make([]byte, refreshTokenLength)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	now := time.Now()
+	err = s.tRepo.CreatePasswordReset(ctx, entity.PasswordReset{
+		ID:        entity.GenerateID(),
+		UserID:    user.ID,
+		TokenHash: HashToken(token),
+		ExpiresAt: now.Add(passwordResetExpiration),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.mailer.Send(ctx, s.resetMessage(user, token)); err != nil {
+		return err
+	}
+	s.logger.With(ctx, "user", user.ID).Infof("password reset requested")
+	return nil
+}
+
+// resetMessage returns the email sending the password reset token to the user.
+func (s service) resetMessage(user entity.User, token string) mail.Message {
+	instructions := "use the following token to choose a new password:\n\n" + token
+	if s.resetURL != "" {
+		separator := "?"
+		if strings.Contains(s.resetURL, "?") {
+			separator = "&"
+		}
+		instructions = "open the following link to choose a new password:\n\n" +
+			s.resetURL + separator + url.Values{"token": {token}}.Encode()
+	}
+	return mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %v,\n\nSomeone asked to reset the password of your account. If it was you, %v\n\n"+
+			"It expires in %v minutes and works once. If you did not ask for it, you can ignore this email.\n",
+			user.Name, instructions, int(passwordResetExpiration.Minutes())),
+	}
+}
+
+// ResetPassword uses up the reset token before setting the new password, so that a token works only once.
+func (s service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if newPassword == "" {
+		return errEmptyPassword
+	}
+	reset, err := s.tRepo.GetPasswordReset(ctx, HashToken(token))
+	if err == sql.ErrNoRows {
+		return errInvalidResetToken
+	} else if err != nil {
+		return err
+	}
+	now := time.Now()
+	if reset.UsedAt != nil || now.After(reset.ExpiresAt) {
+		return errInvalidResetToken
+	}
+	if err := s.tRepo.UsePasswordReset(ctx, reset.ID, now); err == sql.ErrNoRows {
+		return errInvalidResetToken
+	} else if err != nil {
+		return err
+	}
+	user, err := s.uRepo.Get(ctx, reset.UserID)
+	if err == sql.ErrNoRows {
+		return errInvalidResetToken
+	} else if err != nil {
+		return err
+	}
+	if err := s.setPassword(ctx, user, newPassword, ""); err != nil {
+		return err
+	}
+	s.logger.With(ctx, "user", user.ID).Infof("password reset")
+	return nil
+}
+
+// setPassword saves the hash of the new password of the user, revokes the pending password resets and ends every
+// session of the user but the one of the access token with the given ID, if any.
+func (s service) setPassword(ctx context.Context, user entity.User, password, accessTokenID string) error {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	user.Password = hash
+	user.UpdatedAt = now
+	if err := s.uRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	if err := s.tRepo.RevokePasswordResets(ctx, user.ID, now); err != nil {
+		return err
+	}
+	tokens, err := s.tRepo.QueryActive(ctx, user.ID, now)
+	if err != nil {
+		return err
+	}
+	keep := ""
+	for _, token := range tokens {
+		if accessTokenID != "" && token.AccessTokenID == accessTokenID {
+			keep = token.FamilyID
+		}
+	}
+	revoked := map[string]bool{keep: true}
+	for _, token := range tokens {
+		if revoked[token.FamilyID] {
+			continue
+		}
+		if err := s.revokeFamily(ctx, token.FamilyID, now); err != nil {
+			return err
+		}
+		revoked[token.FamilyID] = true
+	}
+	return nil
+}
+
+// GetProfile returns the profile of the current user.
+func (s service) GetProfile(ctx context.Context) (Profile, error) {
+	identity := CurrentUser(ctx)
+	if identity == nil {
+		return Profile{}, errors.Unauthorized("")
+	}
+	user, err := s.uRepo.Get(ctx, identity.GetID())
+	if err != nil {
+		return Profile{}, err
+	}
+	return newProfile(user), nil
+}
+
+// UpdateProfile changes the profile of the current user. Email addresses are lower-cased, and cannot be shared
+// by two users.
+func (s service) UpdateProfile(ctx context.Context, req UpdateProfileRequest) (Profile, error) {
+	identity := CurrentUser(ctx)
+	if identity == nil {
+		return Profile{}, errors.Unauthorized("")
+	}
+	if err := req.Validate(); err != nil {
+		return Profile{}, err
+	}
+	user, err := s.uRepo.Get(ctx, identity.GetID())
+	if err != nil {
+		return Profile{}, err
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+	}
+	if req.Email != nil {
+		email := normalizeEmail(*req.Email)
+		if email != "" && email != user.Email {
+			if _, err := s.uRepo.GetByEmail(ctx, email); err == nil {
+				return Profile{}, errors.Conflict("the email address is already in use")
+			} else if err != sql.ErrNoRows {
+				return Profile{}, err
+			}
+		}
+		user.Email = email
+	}
+	if req.Timezone != nil {
+		user.Timezone = *req.Timezone
+	}
+	if req.Locale != nil {
+		user.Locale = *req.Locale
+	}
+	user.UpdatedAt = time.Now()
+	if err := s.uRepo.Update(ctx, user); err != nil {
+		return Profile{}, err
+	}
+	return newProfile(user), nil
+}
+
+// DeleteAccount deletes the current user along with their data, and denies the access tokens of their sessions.
+// Users owning a workspace have to delete it first, as it would be left without an owner.
+func (s service) DeleteAccount(ctx context.Context, password string) error {
+	identity := CurrentUser(ctx)
+	if identity == nil {
+		return errors.Unauthorized("")
+	}
+	user, err := s.uRepo.Get(ctx, identity.GetID())
+	if err != nil {
+		return err
+	}
+	if user.Password != "" {
+		if ok, _ := s.hasher.Verify(password, user.Password); !ok {
+			s.logger.With(ctx, "user", user.ID).Infof("account deletion rejected: wrong password")
+			return errWrongPassword
+		}
+	}
+	now := time.Now()
+	tokens, err := s.tRepo.QueryActive(ctx, user.ID, now)
+	if err != nil {
+		return err
+	}
+	var denied []entity.RevokedToken
+	for _, token := range tokens {
+		if expiresAt := token.CreatedAt.Add(s.accessExpiration); expiresAt.After(now) {
+			denied = append(denied, entity.RevokedToken{ID: token.AccessTokenID, ExpiresAt: expiresAt})
+		}
+	}
+	var notes []string
+	err = s.uRepo.DeleteAccount(ctx, user.ID, denied, func(ctx context.Context) error {
+		for _, repo := range s.accountData.Repos {
+			if err := repo.DeleteUserData(ctx, user.ID); err != nil {
+				return err
+			}
+		}
+		if s.accountData.Notes == nil {
+			return nil
+		}
+		var err error
+		notes, err = s.accountData.Notes.DeleteUserNotes(ctx, user.ID)
+		return err
+	})
+	if err == ErrWorkspaceOwner {
+		return errors.Conflict("delete the workspaces you own before deleting your account")
+	} else if err != nil {
+		return err
+	}
+	s.logger.With(ctx, "user", user.ID).Infof("account deleted")
+
+	// the account is gone already, so the notes left in the index are only logged
+	if s.accountData.Index != nil {
+		for _, id := range notes {
+			if err := s.accountData.Index.Remove(ctx, id); err != nil {
+				s.logger.With(ctx, "note", id).Errorf("failed to remove a deleted note from the search index: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// newProfile returns the profile of the user.
+func newProfile(user entity.User) Profile {
+	return Profile{
+		ID:          user.ID,
+		Name:        user.Name,
+		DisplayName: user.DisplayName,
+		Email:       user.Email,
+		Timezone:    user.Timezone,
+		Locale:      user.Locale,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+	}
+}
+
+// normalizeEmail trims and lower-cases an email address.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
 
-	return token, nil
+// validEmail reports whether the string is a bare email address, without a display name.
+func validEmail(email string) bool {
+	address, err := netmail.ParseAddress(email)
+	return err == nil && address.Address == email
 }
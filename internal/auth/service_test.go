@@ -4,55 +4,464 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/qiangxue/go-rest-api/internal/entity"
 	errs "github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/qiangxue/go-rest-api/pkg/mail"
 	"github.com/stretchr/testify/assert"
 )
 
 var errCRUD = errors.New("error crud")
 
+// testHasher hashes passwords with cheap parameters to keep the tests fast.
+var testHasher = NewPasswordHasher(PasswordParams{Algorithm: HashArgon2id, Memory: 64, Iterations: 1, Parallelism: 1})
+
 func Test_service_Authenticate(t *testing.T) {
 	logger, _ := log.NewForTest()
 
-	s := NewService(&mockRepository{}, "test", 100, logger)
+	s := NewService(&mockRepository{}, &mockTokenRepo{}, testHasher, nil, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger)
 	_, err := s.Login(context.Background(), "unknown", "bad")
 	assert.Equal(t, errs.Unauthorized(""), err)
 
-	token, err := s.Signup(context.Background(), "demo", "pass")
-	_ = token
+	_, err = s.Signup(context.Background(), "demo", "pass", "")
 	assert.Nil(t, err)
-	token, err = s.Login(context.Background(), "demo", "pass")
+	result, err := s.Login(context.Background(), "demo", "pass")
 	assert.Nil(t, err)
-	assert.NotEmpty(t, token)
+	assert.Nil(t, result.Challenge)
+	tokens := result.Tokens
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	assert.Equal(t, 3600, tokens.ExpiresIn)
+}
+
+func Test_service_Refresh(t *testing.T) {
+	logger, _ := log.NewForTest()
+	tokenRepo := &mockTokenRepo{}
+	s := NewService(&mockRepository{}, tokenRepo, testHasher, nil, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger)
+	ctx := context.Background()
+	first, _ := s.Signup(ctx, "demo", "pass", "")
+
+	_, err := s.Refresh(ctx, "unknown")
+	assert.Equal(t, errs.Unauthorized(""), err)
+
+	// refresh tokens rotate
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	assert.NotEqual(t, first.AccessToken, second.AccessToken)
+	third, err := s.Refresh(ctx, second.RefreshToken)
+	assert.Nil(t, err)
+
+	// reusing a refresh token revokes the whole family, including the access tokens issued with it
+	login, _ := s.Login(ctx, "demo", "pass")
+	other := login.Tokens
+	_, err = s.Refresh(ctx, first.RefreshToken)
+	assert.Equal(t, errs.Unauthorized(""), err)
+	_, err = s.Refresh(ctx, third.RefreshToken)
+	assert.Equal(t, errs.Unauthorized(""), err)
+	assert.Equal(t, 3, len(tokenRepo.revoked))
+	for _, token := range tokenRepo.tokens[:3] {
+		assert.NotNil(t, token.RevokedAt)
+	}
+
+	// other sessions are left alone
+	latest, err := s.Refresh(ctx, other.RefreshToken)
+	assert.Nil(t, err)
+
+	// expired refresh tokens cannot be exchanged
+	tokenRepo.tokens[len(tokenRepo.tokens)-1].ExpiresAt = time.Now().Add(-time.Minute)
+	_, err = s.Refresh(ctx, latest.RefreshToken)
+	assert.Equal(t, errs.Unauthorized(""), err)
+}
+
+func Test_service_Logout(t *testing.T) {
+	logger, _ := log.NewForTest()
+	tokenRepo := &mockTokenRepo{}
+	s := NewService(&mockRepository{}, tokenRepo, testHasher, nil, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger)
+	tokens, _ := s.Signup(context.Background(), "demo", "pass", "")
+	ctx := WithUser(context.Background(), tokenRepo.tokens[0].UserID, "demo")
+	expiresAt := time.Now().Add(time.Hour)
+
+	err := s.Logout(context.Background(), tokens.RefreshToken, "jti1", expiresAt)
+	assert.Equal(t, errs.Unauthorized(""), err)
+
+	// the refresh tokens of other users are ignored
+	err = s.Logout(WithUser(context.Background(), "other", "other"), tokens.RefreshToken, "jti2", expiresAt)
+	assert.Nil(t, err)
+	assert.Nil(t, tokenRepo.tokens[0].RevokedAt)
+
+	assert.Nil(t, s.Logout(ctx, tokens.RefreshToken, "jti3", expiresAt))
+	assert.NotNil(t, tokenRepo.tokens[0].RevokedAt)
+	revoked, _ := tokenRepo.IsRevoked(ctx, "jti3")
+	assert.True(t, revoked)
+	_, err = s.Refresh(ctx, tokens.RefreshToken)
+	assert.Equal(t, errs.Unauthorized(""), err)
 }
 
 func Test_service_authenticate(t *testing.T) {
 	logger, _ := log.NewForTest()
-	s := service{"test", 100, logger, &mockRepository{}}
+	s := service{"test", time.Hour, 24 * time.Hour, logger, &mockRepository{}, &mockTokenRepo{}, testHasher, nil, nil, "", AccountData{}, nil}
 	assert.Nil(t, s.authenticate(context.Background(), "unknown", "bad"))
 
-	_, err := s.Signup(context.Background(), "demo", "pass")
+	_, err := s.Signup(context.Background(), "demo", "pass", "")
 	assert.Nil(t, err)
 	assert.NotNil(t, s.authenticate(context.Background(), "demo", "pass"))
+	assert.Nil(t, s.authenticate(context.Background(), "demo", "bad"))
+}
+
+func Test_service_authenticate_rehash(t *testing.T) {
+	logger, _ := log.NewForTest()
+	bcryptHash, _ := NewPasswordHasher(PasswordParams{Algorithm: HashBcrypt, Cost: 4}).Hash("pass")
+	repo := &mockRepository{items: []entity.User{
+		{ID: "1", Name: "legacy", Password: legacyPrefix + "pass"},
+		{ID: "2", Name: "bcrypt", Password: bcryptHash},
+		{ID: "3", Name: "unmarked", Password: "pass"},
+	}}
+	s := service{"test", time.Hour, 24 * time.Hour, logger, repo, &mockTokenRepo{}, testHasher, nil, nil, "", AccountData{}, nil}
+
+	// plain text and weaker hashes are replaced on login
+	for _, name := range []string{"legacy", "bcrypt"} {
+		assert.NotNil(t, s.authenticate(context.Background(), name, "pass"))
+		user, _ := repo.GetByName(context.Background(), name)
+		assert.True(t, strings.HasPrefix(user.Password, "$argon2id$"))
+		ok, rehash := testHasher.Verify("pass", user.Password)
+		assert.True(t, ok)
+		assert.False(t, rehash)
+		assert.NotNil(t, s.authenticate(context.Background(), name, "pass"))
+	}
+
+	// passwords in plain text that were not marked by the migration are rejected
+	assert.Nil(t, s.authenticate(context.Background(), "unmarked", "pass"))
+}
+
+func Test_service_TwoFactor(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{}
+	s := NewService(repo, &mockTokenRepo{}, testHasher, nil, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger)
+	_, _ = s.Signup(context.Background(), "demo", "pass", "")
+	ctx := WithUser(context.Background(), repo.items[0].ID, "demo")
+	code := func(secret string, offset int64) string {
+		key, _ := totpEncoding.DecodeString(secret)
+		return totpCode(key, totpStep(time.Now())+offset)
+	}
+
+	_, err := s.VerifyTOTP(ctx, "123456")
+	assert.Equal(t, errs.BadRequest("two-factor authentication has not been enrolled"), err)
+	assert.Equal(t, errTwoFactorDisabled, s.DisableTOTP(ctx, "123456"))
+
+	// enrolling again replaces a secret that was not verified
+	first, err := s.EnrollTOTP(ctx)
+	assert.Nil(t, err)
+	enrollment, err := s.EnrollTOTP(ctx)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.Secret, enrollment.Secret)
+	assert.True(t, strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/Notes:demo?"))
+	assert.Equal(t, 1, len(repo.totps))
+
+	_, err = s.VerifyTOTP(ctx, code(first.Secret, 0))
+	assert.Equal(t, errInvalidCode, err)
+	recovery, err := s.VerifyTOTP(ctx, code(enrollment.Secret, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, recoveryCodeCount, len(recovery.Codes))
+	_, err = s.EnrollTOTP(ctx)
+	assert.Equal(t, errTwoFactorEnabled, err)
+
+	// logging in takes a second step
+	result, err := s.Login(context.Background(), "demo", "pass")
+	assert.Nil(t, err)
+	if !assert.NotNil(t, result.Challenge) {
+		return
+	}
+	assert.Empty(t, result.Tokens.AccessToken)
+	challenge := result.Challenge.ChallengeToken
+	_, err = s.LoginTwoFactor(context.Background(), "invalid", code(enrollment.Secret, 1))
+	assert.Equal(t, errs.Unauthorized(""), err)
+	accessToken, _ := s.(service).generateJWT(identity{User: repo.items[0]}, "jti")
+	_, err = s.LoginTwoFactor(context.Background(), accessToken, code(enrollment.Secret, 1))
+	assert.Equal(t, errs.Unauthorized(""), err)
+
+	// codes cannot be used twice
+	_, err = s.LoginTwoFactor(context.Background(), challenge, code(enrollment.Secret, 0))
+	assert.Equal(t, errs.Unauthorized("the code is invalid"), err)
+	tokens, err := s.LoginTwoFactor(context.Background(), challenge, code(enrollment.Secret, 1))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	// challenges can only be answered once, and not at all once revoked
+	_, err = s.LoginTwoFactor(context.Background(), challenge, recovery.Codes[0])
+	assert.Equal(t, errs.Unauthorized(""), err)
+	newChallenge := func() string {
+		result, _ := s.Login(context.Background(), "demo", "pass")
+		return result.Challenge.ChallengeToken
+	}
+	challenge = newChallenge()
+	claims, err := s.ParseChallenge(context.Background(), challenge)
+	assert.Nil(t, err)
+	assert.Equal(t, "demo", claims.Username)
+	assert.Equal(t, repo.items[0].ID, claims.UserID)
+	assert.Nil(t, s.RevokeChallenge(context.Background(), claims))
+	_, err = s.ParseChallenge(context.Background(), challenge)
+	assert.Equal(t, errs.Unauthorized(""), err)
+	_, err = s.LoginTwoFactor(context.Background(), challenge, recovery.Codes[0])
+	assert.Equal(t, errs.Unauthorized(""), err)
+
+	// recovery codes work once, and are only valid until they are regenerated
+	_, err = s.LoginTwoFactor(context.Background(), newChallenge(), " "+strings.ToUpper(recovery.Codes[0]))
+	assert.Nil(t, err)
+	_, err = s.LoginTwoFactor(context.Background(), newChallenge(), recovery.Codes[0])
+	assert.Equal(t, errs.Unauthorized("the code is invalid"), err)
+	regenerated, err := s.RegenerateRecoveryCodes(ctx, recovery.Codes[1])
+	assert.Nil(t, err)
+	_, err = s.LoginTwoFactor(context.Background(), newChallenge(), recovery.Codes[2])
+	assert.Equal(t, errs.Unauthorized("the code is invalid"), err)
+
+	// disabling requires a code
+	assert.Equal(t, errInvalidCode, s.DisableTOTP(ctx, "000000"))
+	assert.Nil(t, s.DisableTOTP(ctx, regenerated.Codes[0]))
+	assert.Equal(t, 0, len(repo.recoveryCodes))
+	result, err = s.Login(context.Background(), "demo", "pass")
+	assert.Nil(t, err)
+	assert.Nil(t, result.Challenge)
+	assert.NotEmpty(t, result.Tokens.AccessToken)
+}
+
+func Test_service_ChangePassword(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo, tokenRepo := &mockRepository{}, &mockTokenRepo{}
+	s := NewService(repo, tokenRepo, testHasher, nil, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger)
+	current, _ := s.Signup(context.Background(), "demo", "pass", "")
+	login, _ := s.Login(context.Background(), "demo", "pass")
+	ctx := WithUser(context.Background(), repo.items[0].ID, "demo")
+	jti := tokenRepo.tokens[0].AccessTokenID
+
+	assert.Equal(t, errWrongPassword, s.ChangePassword(ctx, "wrong", "new", jti))
+	assert.Equal(t, errEmptyPassword, s.ChangePassword(ctx, "pass", "", jti))
+	assert.Nil(t, s.ChangePassword(ctx, "pass", "new", jti))
+	_, err := s.Login(context.Background(), "demo", "pass")
+	assert.Equal(t, errs.Unauthorized(""), err)
+	_, err = s.Login(context.Background(), "demo", "new")
+	assert.Nil(t, err)
+
+	// the other sessions end, including their access tokens
+	assert.Nil(t, tokenRepo.tokens[0].RevokedAt)
+	assert.NotNil(t, tokenRepo.tokens[1].RevokedAt)
+	revoked, _ := tokenRepo.IsRevoked(ctx, tokenRepo.tokens[1].AccessTokenID)
+	assert.True(t, revoked)
+	_, err = s.Refresh(context.Background(), login.Tokens.RefreshToken)
+	assert.Equal(t, errs.Unauthorized(""), err)
+	_, err = s.Refresh(context.Background(), current.RefreshToken)
+	assert.Nil(t, err)
+}
+
+func Test_service_ResetPassword(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo, tokenRepo, mailer := &mockRepository{}, &mockTokenRepo{}, mail.NewMemoryMailer()
+	svc := NewService(repo, tokenRepo, testHasher, nil, mailer, "https://notes.example.com/reset", AccountData{}, "test", time.Hour, 24*time.Hour, logger).(service)
+	// the emails are sent in the background, when the pending functions are run
+	var pending []func()
+	svc.background = func(f func()) { pending = append(pending, f) }
+	runPending := func() {
+		for _, f := range pending {
+			f()
+		}
+		pending = nil
+	}
+	var s Service = svc
+	ctx := context.Background()
+	session, err := s.Signup(ctx, "demo", "pass", " Demo@Example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "demo@example.com", repo.items[0].Email)
+	_, err = s.Signup(ctx, "other", "pass", "demo@example.com")
+	assert.EqualError(t, err, "the email address is already in use")
+	_, err = s.Signup(ctx, "other", "pass", "Other <other@example.com>")
+	assert.EqualError(t, err, "the email address is invalid")
+
+	// unknown addresses are accepted without sending anything
+	assert.Nil(t, s.RequestPasswordReset(ctx, "unknown@example.com"))
+	runPending()
+	assert.Empty(t, mailer.Messages())
+	assert.Equal(t, errs.BadRequest("the email address is invalid"), s.RequestPasswordReset(ctx, "demo"))
+
+	requestToken := func() string {
+		assert.Nil(t, s.RequestPasswordReset(ctx, "DEMO@example.com"))
+		if !assert.Equal(t, 1, len(pending)) {
+			return ""
+		}
+		runPending()
+		messages := mailer.Messages()
+		msg := messages[len(messages)-1]
+		assert.Equal(t, "demo@example.com", msg.To)
+		index := strings.Index(msg.Body, "https://notes.example.com/reset?token=")
+		if !assert.True(t, index >= 0) {
+			return ""
+		}
+		return strings.Fields(msg.Body[index+len("https://notes.example.com/reset?token="):])[0]
+	}
+	token := requestToken()
+	assert.Equal(t, errInvalidResetToken, s.ResetPassword(ctx, "unknown", "new"))
+	assert.Equal(t, errEmptyPassword, s.ResetPassword(ctx, token, ""))
+	assert.Nil(t, s.ResetPassword(ctx, token, "new"))
+	_, err = s.Login(ctx, "demo", "new")
+	assert.Nil(t, err)
+	_, err = s.Refresh(ctx, session.RefreshToken)
+	assert.Equal(t, errs.Unauthorized(""), err)
+
+	// tokens work once, expire, and are revoked when the password changes
+	assert.Equal(t, errInvalidResetToken, s.ResetPassword(ctx, token, "again"))
+	token = requestToken()
+	tokenRepo.resets[len(tokenRepo.resets)-1].ExpiresAt = time.Now().Add(-time.Minute)
+	assert.Equal(t, errInvalidResetToken, s.ResetPassword(ctx, token, "again"))
+	token = requestToken()
+	assert.Nil(t, s.ChangePassword(WithUser(ctx, repo.items[0].ID, "demo"), "new", "newer", ""))
+	assert.Equal(t, errInvalidResetToken, s.ResetPassword(ctx, token, "again"))
+
+	s = NewService(repo, tokenRepo, testHasher, nil, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger)
+	assert.Equal(t, errPasswordResetDisabled, s.RequestPasswordReset(ctx, "demo@example.com"))
+}
+
+func Test_service_Profile(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{}
+	s := NewService(repo, &mockTokenRepo{}, testHasher, nil, nil, "", AccountData{}, "test", time.Hour, 24*time.Hour, logger)
+	_, _ = s.Signup(context.Background(), "demo", "pass", "demo@example.com")
+	_, _ = s.Signup(context.Background(), "other", "pass", "other@example.com")
+	ctx := WithUser(context.Background(), repo.items[0].ID, "demo")
+	str := func(value string) *string { return &value }
+
+	_, err := s.GetProfile(context.Background())
+	assert.Equal(t, errs.Unauthorized(""), err)
+	profile, err := s.GetProfile(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "demo", profile.Name)
+	assert.Equal(t, "demo@example.com", profile.Email)
+
+	// only the given fields change
+	profile, err = s.UpdateProfile(ctx, UpdateProfileRequest{DisplayName: str(" Demo User "), Timezone: str("Europe/Paris")})
+	assert.Nil(t, err)
+	assert.Equal(t, "Demo User", profile.DisplayName)
+	assert.Equal(t, "Europe/Paris", profile.Timezone)
+	assert.Equal(t, "demo@example.com", profile.Email)
+	profile, err = s.UpdateProfile(ctx, UpdateProfileRequest{Email: str("Demo@Example.org"), Locale: str("pt-BR")})
+	assert.Nil(t, err)
+	assert.Equal(t, "demo@example.org", profile.Email)
+	assert.Equal(t, "pt-BR", profile.Locale)
+	assert.Equal(t, "Demo User", profile.DisplayName)
+	user, _ := repo.Get(ctx, profile.ID)
+	assert.Equal(t, "demo@example.org", user.Email)
+
+	_, err = s.UpdateProfile(ctx, UpdateProfileRequest{Email: str("other@example.com")})
+	assert.Equal(t, errs.Conflict("the email address is already in use"), err)
+	for _, req := range []UpdateProfileRequest{
+		{Email: str("demo")},
+		{Timezone: str("Mars/Olympus")},
+		{Timezone: str("Local")},
+		{Locale: str("en_US")},
+		{DisplayName: str(strings.Repeat("a", 129))},
+	} {
+		_, err = s.UpdateProfile(ctx, req)
+		assert.NotNil(t, err)
+	}
+
+	// empty strings clear the fields
+	profile, err = s.UpdateProfile(ctx, UpdateProfileRequest{Email: str(""), Timezone: str(""), Locale: str("")})
+	assert.Nil(t, err)
+	assert.Equal(t, Profile{ID: profile.ID, Name: "demo", DisplayName: "Demo User", CreatedAt: profile.CreatedAt, UpdatedAt: profile.UpdatedAt}, profile)
+}
+
+func Test_service_DeleteAccount(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo, tokenRepo := &mockRepository{}, &mockTokenRepo{}
+	workspaces, others, index := &mockUserData{}, &mockUserData{}, &mockNoteIndex{}
+	notes := mockNoteData{}
+	data := AccountData{Repos: []UserDataRepo{workspaces, others}, Notes: notes, Index: index}
+	s := NewService(repo, tokenRepo, testHasher, nil, nil, "", data, "test", time.Hour, 24*time.Hour, logger)
+	_, _ = s.Signup(context.Background(), "demo", "pass", "")
+	_, _ = s.Login(context.Background(), "demo", "pass")
+	_, _ = s.Signup(context.Background(), "other", "pass", "")
+	ctx := WithUser(context.Background(), repo.items[0].ID, "demo")
+	notes[repo.items[0].ID] = []string{"note1", "note2"}
+
+	assert.Equal(t, errs.Unauthorized(""), s.DeleteAccount(context.Background(), "pass"))
+	assert.Equal(t, errWrongPassword, s.DeleteAccount(ctx, "wrong"))
+	workspaces.owners = []string{repo.items[0].ID}
+	assert.Equal(t, errs.Conflict("delete the workspaces you own before deleting your account"), s.DeleteAccount(ctx, "pass"))
+	assert.Equal(t, 2, len(repo.items))
+	assert.Empty(t, others.deleted)
+	assert.Empty(t, index.removed)
+
+	// the data of the other packages is deleted, and the access tokens of both sessions are denied
+	workspaces.owners = nil
+	assert.Nil(t, s.DeleteAccount(ctx, "pass"))
+	assert.Equal(t, 1, len(repo.items))
+	assert.Equal(t, "other", repo.items[0].Name)
+	assert.Equal(t, 1, len(workspaces.deleted))
+	assert.Equal(t, workspaces.deleted, others.deleted)
+	assert.Equal(t, []string{"note1", "note2"}, index.removed)
+	if assert.Equal(t, 2, len(repo.denied)) {
+		assert.Equal(t, tokenRepo.tokens[0].AccessTokenID, repo.denied[0].ID)
+		assert.Equal(t, tokenRepo.tokens[1].AccessTokenID, repo.denied[1].ID)
+	}
+	_, err := s.Login(context.Background(), "demo", "pass")
+	assert.Equal(t, errs.Unauthorized(""), err)
+}
+
+// mockUserData records the users whose data it deleted, and refuses to delete the data of the owners, as
+// the workspaces do.
+type mockUserData struct {
+	owners  []string
+	deleted []string
+}
+
+func (m *mockUserData) DeleteUserData(ctx context.Context, userID string) error {
+	for _, owner := range m.owners {
+		if owner == userID {
+			return ErrWorkspaceOwner
+		}
+	}
+	m.deleted = append(m.deleted, userID)
+	return nil
+}
+
+// mockNoteData maps the IDs of the users to the IDs of their personal notes.
+type mockNoteData map[string][]string
+
+func (m mockNoteData) DeleteUserNotes(ctx context.Context, userID string) ([]string, error) {
+	ids := m[userID]
+	delete(m, userID)
+	return ids, nil
+}
+
+type mockNoteIndex struct {
+	removed []string
+}
+
+func (m *mockNoteIndex) Remove(ctx context.Context, id string) error {
+	m.removed = append(m.removed, id)
+	return nil
 }
 
 func Test_service_GenerateJWT(t *testing.T) {
 	logger, _ := log.NewForTest()
-	s := service{"test", 100, logger, &mockRepository{}}
-	token, err := s.generateJWT(entity.User{
+	s := service{"test", time.Hour, 24 * time.Hour, logger, &mockRepository{}, &mockTokenRepo{}, testHasher, nil, nil, "", AccountData{}, nil}
+	token, err := s.generateJWT(identity{User: entity.User{
 		ID:   "100",
 		Name: "demo",
-	})
+	}}, "jti")
 	if assert.Nil(t, err) {
 		assert.NotEmpty(t, token)
 	}
 }
 
 type mockRepository struct {
-	items []entity.User
+	items         []entity.User
+	identities    []entity.UserIdentity
+	totps         []entity.TOTP
+	recoveryCodes []entity.RecoveryCode
+	denied        []entity.RevokedToken
 }
 
 func (m mockRepository) Get(ctx context.Context, id string) (entity.User, error) {
@@ -73,6 +482,15 @@ func (m mockRepository) GetByName(ctx context.Context, name string) (entity.User
 	return entity.User{}, sql.ErrNoRows
 }
 
+func (m mockRepository) GetByEmail(ctx context.Context, email string) (entity.User, error) {
+	for _, item := range m.items {
+		if item.Email != "" && item.Email == email {
+			return item, nil
+		}
+	}
+	return entity.User{}, sql.ErrNoRows
+}
+
 func (m mockRepository) Count(ctx context.Context) (int, error) {
 	return len(m.items), nil
 }
@@ -112,3 +530,249 @@ func (m *mockRepository) Delete(ctx context.Context, id string) error {
 	}
 	return nil
 }
+
+func (m mockRepository) GetIdentity(ctx context.Context, issuer, subject string) (entity.UserIdentity, error) {
+	for _, identity := range m.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return entity.UserIdentity{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) CreateWithIdentity(ctx context.Context, user entity.User, identity entity.UserIdentity) error {
+	if err := m.Create(ctx, user); err != nil {
+		return err
+	}
+	m.identities = append(m.identities, identity)
+	return nil
+}
+
+func (m mockRepository) GetTOTP(ctx context.Context, userID string) (entity.TOTP, error) {
+	for _, totp := range m.totps {
+		if totp.UserID == userID {
+			return totp, nil
+		}
+	}
+	return entity.TOTP{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) CreateTOTP(ctx context.Context, totp entity.TOTP) error {
+	m.totps = append(m.totps, totp)
+	return nil
+}
+
+func (m *mockRepository) UpdateTOTP(ctx context.Context, totp entity.TOTP) error {
+	for i, item := range m.totps {
+		if item.ID == totp.ID {
+			m.totps[i] = totp
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	for i, item := range m.totps {
+		if item.ID == id && item.LastUsedStep < step {
+			m.totps[i].LastUsedStep = step
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) EnableTOTP(ctx context.Context, totp entity.TOTP, codes []entity.RecoveryCode) error {
+	_ = m.UpdateTOTP(ctx, totp)
+	return m.ReplaceRecoveryCodes(ctx, totp.UserID, codes)
+}
+
+func (m *mockRepository) DeleteTOTP(ctx context.Context, userID string) error {
+	var totps []entity.TOTP
+	for _, item := range m.totps {
+		if item.UserID != userID {
+			totps = append(totps, item)
+		}
+	}
+	m.totps = totps
+	return m.ReplaceRecoveryCodes(ctx, userID, nil)
+}
+
+func (m mockRepository) QueryRecoveryCodes(ctx context.Context, userID string) ([]entity.RecoveryCode, error) {
+	var codes []entity.RecoveryCode
+	for _, code := range m.recoveryCodes {
+		if code.UserID == userID && code.UsedAt == nil {
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
+
+func (m *mockRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []entity.RecoveryCode) error {
+	var kept []entity.RecoveryCode
+	for _, code := range m.recoveryCodes {
+		if code.UserID != userID {
+			kept = append(kept, code)
+		}
+	}
+	m.recoveryCodes = append(kept, codes...)
+	return nil
+}
+
+func (m *mockRepository) UseRecoveryCode(ctx context.Context, id string, usedAt time.Time) error {
+	for i, code := range m.recoveryCodes {
+		if code.ID == id && code.UsedAt == nil {
+			m.recoveryCodes[i].UsedAt = &usedAt
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) DeleteAccount(ctx context.Context, userID string, denied []entity.RevokedToken, deleteData func(ctx context.Context) error) error {
+	if err := deleteData(ctx); err != nil {
+		return err
+	}
+	if err := m.Delete(ctx, userID); err != nil {
+		return err
+	}
+	_ = m.DeleteTOTP(ctx, userID)
+	var identities []entity.UserIdentity
+	for _, identity := range m.identities {
+		if identity.UserID != userID {
+			identities = append(identities, identity)
+		}
+	}
+	m.identities = identities
+	m.denied = append(m.denied, denied...)
+	return nil
+}
+
+type mockTokenRepo struct {
+	tokens  []entity.RefreshToken
+	revoked []entity.RevokedToken
+	resets  []entity.PasswordReset
+}
+
+func (m *mockTokenRepo) CreateRefreshToken(ctx context.Context, token entity.RefreshToken) error {
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *mockTokenRepo) GetRefreshToken(ctx context.Context, hash string) (entity.RefreshToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return entity.RefreshToken{}, sql.ErrNoRows
+}
+
+func (m *mockTokenRepo) UseRefreshToken(ctx context.Context, id string, usedAt time.Time) error {
+	for i, token := range m.tokens {
+		if token.ID == id && token.UsedAt == nil && token.RevokedAt == nil {
+			m.tokens[i].UsedAt = &usedAt
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockTokenRepo) QueryFamily(ctx context.Context, familyID string) ([]entity.RefreshToken, error) {
+	var tokens []entity.RefreshToken
+	for _, token := range m.tokens {
+		if token.FamilyID == familyID {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *mockTokenRepo) QueryActive(ctx context.Context, userID string, now time.Time) ([]entity.RefreshToken, error) {
+	var tokens []entity.RefreshToken
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.RevokedAt == nil && token.ExpiresAt.After(now) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *mockTokenRepo) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	for i, token := range m.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			m.tokens[i].RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (m *mockTokenRepo) RevokeAccessToken(ctx context.Context, token entity.RevokedToken) error {
+	if revoked, _ := m.IsRevoked(ctx, token.ID); !revoked {
+		m.revoked = append(m.revoked, token)
+	}
+	return nil
+}
+
+func (m *mockTokenRepo) IsRevoked(ctx context.Context, id string) (bool, error) {
+	for _, token := range m.revoked {
+		if token.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockTokenRepo) CreatePasswordReset(ctx context.Context, reset entity.PasswordReset) error {
+	m.resets = append(m.resets, reset)
+	return nil
+}
+
+func (m *mockTokenRepo) GetPasswordReset(ctx context.Context, hash string) (entity.PasswordReset, error) {
+	for _, reset := range m.resets {
+		if reset.TokenHash == hash {
+			return reset, nil
+		}
+	}
+	return entity.PasswordReset{}, sql.ErrNoRows
+}
+
+func (m *mockTokenRepo) UsePasswordReset(ctx context.Context, id string, usedAt time.Time) error {
+	for i, reset := range m.resets {
+		if reset.ID == id && reset.UsedAt == nil {
+			m.resets[i].UsedAt = &usedAt
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockTokenRepo) RevokePasswordResets(ctx context.Context, userID string, revokedAt time.Time) error {
+	for i, reset := range m.resets {
+		if reset.UserID == userID && reset.UsedAt == nil {
+			m.resets[i].UsedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (m *mockTokenRepo) Purge(ctx context.Context, before time.Time) (int, error) {
+	count := 0
+	var tokens []entity.RefreshToken
+	for _, token := range m.tokens {
+		if token.ExpiresAt.Before(before) {
+			count++
+		} else {
+			tokens = append(tokens, token)
+		}
+	}
+	var revoked []entity.RevokedToken
+	for _, token := range m.revoked {
+		if token.ExpiresAt.Before(before) {
+			count++
+		} else {
+			revoked = append(revoked, token)
+		}
+	}
+	m.tokens, m.revoked = tokens, revoked
+	return count, nil
+}
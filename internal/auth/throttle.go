@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// the exponential backoff of failed logins
+const (
+	// loginFreeFailures is the number of consecutive failures that are not delayed, to allow for typos.
+	loginFreeFailures = 3
+	// loginBaseDelay is the delay after the first delayed failure, which doubles with every further failure.
+	loginBaseDelay = time.Second
+	// loginMaxDelay caps the delay between two attempts.
+	loginMaxDelay = time.Minute
+	// challengeMaxFailures is the number of wrong codes after which a challenge of two-factor authentication is
+	// revoked, so that the password has to be entered again.
+	challengeMaxFailures = 3
+	// resetMaxRequests is the number of password reset emails that may be requested for an email address within
+	// the lockout period.
+	resetMaxRequests = 3
+)
+
+// ThrottleConfig configures how failed logins are throttled.
+type ThrottleConfig struct {
+	// MaxFailures is the number of consecutive failed logins after which the username is locked.
+	MaxFailures int
+	// MaxIPFailures is the number of failed logins after which a client IP is locked, whichever usernames it tried.
+	MaxIPFailures int
+	// Lockout is how long a username or a client IP stays locked. Failures older than that are forgotten.
+	Lockout time.Duration
+}
+
+// loginAttempts are the recent failed logins with a username or from a client IP.
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginThrottle tracks the failed logins per username and per client IP in memory. After a few failures with
+// a username, every further attempt must wait exponentially longer, and once too many attempts failed the username
+// or the client IP is locked for a while. Client IPs are not delayed before, as they may be shared by many users.
+// Usernames are tracked whether or not a user has them, so that the responses do not reveal which users exist.
+type LoginThrottle struct {
+	config ThrottleConfig
+	logger log.Logger
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	attempts map[string]*loginAttempts
+	sweptAt  time.Time
+}
+
+// NewLoginThrottle creates a new login throttle.
+func NewLoginThrottle(config ThrottleConfig, logger log.Logger) *LoginThrottle {
+	return &LoginThrottle{config: config, logger: logger, now: time.Now, attempts: map[string]*loginAttempts{}}
+}
+
+// Check returns how long the client has to wait before it may try to log in with the username, or zero if it may
+// try now. The username is empty while it is not known yet, in which case only the client IP is checked.
+func (t *LoginThrottle) Check(ctx context.Context, username, ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	wait := t.wait(throttleIPKey(ip), false, now)
+	if username != "" {
+		if w := t.wait(throttleUserKey(username), true, now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		t.logger.With(ctx, "user", username, "ip", ip).Infof("login throttled for %v", wait.Round(time.Second))
+	}
+	return wait
+}
+
+// Fail records a failed login with the username from the client IP, and locks either of them once they have failed
+// too often.
+func (t *LoginThrottle) Fail(ctx context.Context, username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now)
+	logger := t.logger.With(ctx, "user", username, "ip", ip)
+	if t.fail(throttleIPKey(ip), t.config.MaxIPFailures, now) {
+		logger.Infof("client IP locked until %v", now.Add(t.config.Lockout).Format(time.RFC3339))
+	}
+	if username == "" {
+		logger.Infof("second login step failed")
+		return
+	}
+	if t.fail(throttleUserKey(username), t.config.MaxFailures, now) {
+		logger.Infof("account locked until %v", now.Add(t.config.Lockout).Format(time.RFC3339))
+	}
+	logger.Infof("login failed (%d consecutive failures)", t.attempts[throttleUserKey(username)].failures)
+}
+
+// Succeed records a successful login with the username from the client IP, which clears the failures of the
+// username. The failures of the client IP are kept, as an attacker may own one of the accounts they try.
+func (t *LoginThrottle) Succeed(ctx context.Context, username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, throttleUserKey(username))
+	t.logger.With(ctx, "user", username, "ip", ip).Infof("login succeeded")
+}
+
+// FailChallenge records a wrong code for the challenge of two-factor authentication with the given ID, and reports
+// whether the challenge has now failed too often to be answered anymore.
+func (t *LoginThrottle) FailChallenge(ctx context.Context, id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now)
+	if !t.fail(throttleChallengeKey(id), challengeMaxFailures, now) {
+		return false
+	}
+	t.logger.With(ctx).Infof("2FA challenge revoked after %d wrong codes", challengeMaxFailures)
+	return true
+}
+
+// RequestReset records a request of a password reset email for the email address from the client IP, unless either
+// of them requested too many emails already. It returns how long the client has to wait before it may request an
+// email, or zero if the request was recorded. Email addresses are tracked whether or not a user has them.
+func (t *LoginThrottle) RequestReset(ctx context.Context, email, ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now)
+	wait := t.wait(throttleResetKey(email), false, now)
+	if w := t.wait(throttleResetIPKey(ip), false, now); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		t.logger.With(ctx, "ip", ip).Infof("password reset throttled for %v", wait.Round(time.Second))
+		return wait
+	}
+	t.fail(throttleResetKey(email), resetMaxRequests, now)
+	t.fail(throttleResetIPKey(ip), t.config.MaxIPFailures, now)
+	return 0
+}
+
+// CheckLink returns how long the client has to wait before it may try another password of the share link with
+// the given ID, or zero if it may try now. The passwords of a link are delayed and locked like those of a username.
+func (t *LoginThrottle) CheckLink(ctx context.Context, id string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	wait := t.wait(throttleLinkKey(id), true, t.now())
+	if wait > 0 {
+		t.logger.With(ctx, "link", id).Infof("share link password throttled for %v", wait.Round(time.Second))
+	}
+	return wait
+}
+
+// FailLink records a wrong password for the share link with the given ID, and locks the link once it has failed
+// too often.
+func (t *LoginThrottle) FailLink(ctx context.Context, id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now)
+	if t.fail(throttleLinkKey(id), t.config.MaxFailures, now) {
+		t.logger.With(ctx, "link", id).Infof("share link locked until %v", now.Add(t.config.Lockout).Format(time.RFC3339))
+	}
+}
+
+// SucceedLink clears the failures of the share link with the given ID once its password has been given.
+func (t *LoginThrottle) SucceedLink(ctx context.Context, id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, throttleLinkKey(id))
+}
+
+// Unlock clears the failures of the username, unlocking it. It reports whether the username was locked.
+func (t *LoginThrottle) Unlock(ctx context.Context, username string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	attempts, ok := t.attempts[throttleUserKey(username)]
+	locked := ok && t.now().Before(attempts.lockedUntil)
+	delete(t.attempts, throttleUserKey(username))
+	admin := ""
+	if user := CurrentUser(ctx); user != nil {
+		admin = user.GetName()
+	}
+	if locked {
+		t.logger.With(ctx, "user", username, "admin", admin).Infof("account unlocked")
+	}
+	return locked
+}
+
+// wait returns how long the given key has to wait before the next attempt, which is delayed by the backoff
+// if requested.
+func (t *LoginThrottle) wait(key string, backoff bool, now time.Time) time.Duration {
+	attempts, ok := t.attempts[key]
+	if !ok {
+		return 0
+	}
+	if now.Before(attempts.lockedUntil) {
+		return attempts.lockedUntil.Sub(now)
+	}
+	if !backoff || attempts.failures < loginFreeFailures {
+		return 0
+	}
+	delay := loginMaxDelay
+	if shift := attempts.failures - loginFreeFailures; shift < 16 {
+		if d := loginBaseDelay << uint(shift); d < delay {
+			delay = d
+		}
+	}
+	if wait := attempts.lastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// fail counts a failure of the given key and reports whether the key got locked by it.
+func (t *LoginThrottle) fail(key string, max int, now time.Time) bool {
+	attempts, ok := t.attempts[key]
+	if !ok || t.expired(attempts, now) {
+		attempts = &loginAttempts{}
+		t.attempts[key] = attempts
+	}
+	attempts.failures++
+	attempts.lastFailure = now
+	if attempts.failures >= max && !now.Before(attempts.lockedUntil) {
+		attempts.lockedUntil = now.Add(t.config.Lockout)
+		return true
+	}
+	return false
+}
+
+// expired reports whether the failures are old enough to be forgotten.
+func (t *LoginThrottle) expired(attempts *loginAttempts, now time.Time) bool {
+	return !now.Before(attempts.lockedUntil) && now.Sub(attempts.lastFailure) >= t.config.Lockout
+}
+
+// sweep forgets the expired failures, at most once per lockout period, so that the memory does not fill up
+// with the usernames tried by an attacker.
+func (t *LoginThrottle) sweep(now time.Time) {
+	if now.Sub(t.sweptAt) < t.config.Lockout {
+		return
+	}
+	for key, attempts := range t.attempts {
+		if t.expired(attempts, now) {
+			delete(t.attempts, key)
+		}
+	}
+	t.sweptAt = now
+}
+
+func throttleUserKey(username string) string {
+	return "user:" + username
+}
+
+func throttleIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func throttleChallengeKey(id string) string {
+	return "challenge:" + id
+}
+
+func throttleLinkKey(id string) string {
+	return "link:" + id
+}
+
+func throttleResetKey(email string) string {
+	return "reset:" + email
+}
+
+func throttleResetIPKey(ip string) string {
+	return "reset-ip:" + ip
+}
+
+// clientIP returns the IP address the request was sent from.
+func clientIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	logger, entries := log.NewForTest()
+	throttle := NewLoginThrottle(ThrottleConfig{MaxFailures: 6, MaxIPFailures: 8, Lockout: 10 * time.Minute}, logger)
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	throttle.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// the first failures are not delayed
+	for i := 0; i < loginFreeFailures-1; i++ {
+		throttle.Fail(ctx, "demo", "10.0.0.1")
+		assert.Zero(t, throttle.Check(ctx, "demo", "10.0.0.1"))
+	}
+	// then the delay doubles with every failure
+	throttle.Fail(ctx, "demo", "10.0.0.1")
+	assert.Equal(t, time.Second, throttle.Check(ctx, "demo", "10.0.0.1"))
+	assert.Equal(t, time.Second, throttle.Check(ctx, "demo", "10.0.0.2"))
+	assert.Zero(t, throttle.Check(ctx, "other", "10.0.0.1"))
+	throttle.Fail(ctx, "demo", "10.0.0.1")
+	assert.Equal(t, 2*time.Second, throttle.Check(ctx, "demo", "10.0.0.1"))
+	now = now.Add(2 * time.Second)
+	assert.Zero(t, throttle.Check(ctx, "demo", "10.0.0.1"))
+
+	// a success clears the failures of the username
+	throttle.Succeed(ctx, "demo", "10.0.0.1")
+	assert.Zero(t, throttle.Check(ctx, "demo", "10.0.0.1"))
+
+	// the username is locked after too many failures, whether or not it exists
+	for i := 0; i < 6; i++ {
+		throttle.Fail(ctx, "unknown", "10.0.0.3")
+	}
+	assert.Equal(t, 10*time.Minute, throttle.Check(ctx, "unknown", "10.0.0.4"))
+	assert.True(t, throttle.Unlock(ctx, "unknown"))
+	assert.Zero(t, throttle.Check(ctx, "unknown", "10.0.0.4"))
+	assert.False(t, throttle.Unlock(ctx, "unknown"))
+
+	// the client IP is locked after too many failures with any usernames
+	for i := 0; i < 8; i++ {
+		throttle.Fail(ctx, "", "10.0.0.5")
+		assert.Zero(t, throttle.Check(ctx, "demo", "10.0.0.1"))
+	}
+	assert.Equal(t, 10*time.Minute, throttle.Check(ctx, "demo", "10.0.0.5"))
+	assert.Equal(t, 10*time.Minute, throttle.Check(ctx, "", "10.0.0.5"))
+
+	// challenges of two-factor authentication fail once they got too many wrong codes
+	for i := 1; i < challengeMaxFailures; i++ {
+		assert.False(t, throttle.FailChallenge(ctx, "challenge1"))
+	}
+	assert.True(t, throttle.FailChallenge(ctx, "challenge1"))
+	assert.False(t, throttle.FailChallenge(ctx, "challenge2"))
+
+	// the passwords of share links are delayed and locked like those of usernames
+	for i := 0; i < loginFreeFailures; i++ {
+		assert.Zero(t, throttle.CheckLink(ctx, "link1"))
+		throttle.FailLink(ctx, "link1")
+	}
+	assert.Equal(t, time.Second, throttle.CheckLink(ctx, "link1"))
+	for i := loginFreeFailures; i < 6; i++ {
+		throttle.FailLink(ctx, "link1")
+	}
+	assert.Equal(t, 10*time.Minute, throttle.CheckLink(ctx, "link1"))
+	assert.Zero(t, throttle.CheckLink(ctx, "link2"))
+	throttle.FailLink(ctx, "link2")
+	throttle.SucceedLink(ctx, "link2")
+	assert.Zero(t, throttle.CheckLink(ctx, "link2"))
+
+	// password reset emails are limited per email address and per client IP, apart from the logins
+	for i := 0; i < resetMaxRequests; i++ {
+		assert.Zero(t, throttle.RequestReset(ctx, "demo@example.com", "10.0.0.6"))
+	}
+	assert.Equal(t, 10*time.Minute, throttle.RequestReset(ctx, "demo@example.com", "10.0.0.7"))
+	assert.Zero(t, throttle.Check(ctx, "demo", "10.0.0.6"))
+	for i := resetMaxRequests; i < 8; i++ {
+		assert.Zero(t, throttle.RequestReset(ctx, string(rune('a'+i))+"@example.com", "10.0.0.6"))
+	}
+	assert.Equal(t, 10*time.Minute, throttle.RequestReset(ctx, "other@example.com", "10.0.0.6"))
+
+	// locks expire, and the failures are forgotten afterwards
+	now = now.Add(10 * time.Minute)
+	assert.Zero(t, throttle.Check(ctx, "", "10.0.0.5"))
+	throttle.Fail(ctx, "", "10.0.0.5")
+	assert.Zero(t, throttle.Check(ctx, "", "10.0.0.5"))
+	// the expired failures of the other usernames and client IPs were swept
+	assert.Equal(t, 1, len(throttle.attempts))
+
+	var messages []string
+	for _, entry := range entries.All() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(t, messages, "login failed (1 consecutive failures)")
+	assert.Contains(t, messages, "login succeeded")
+	assert.Contains(t, messages, "account locked until 2026-10-17T12:10:02Z")
+	assert.Contains(t, messages, "account unlocked")
+	assert.Contains(t, messages, "client IP locked until 2026-10-17T12:10:02Z")
+}
+
+func Test_clientIP(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/login", nil)
+	req.RemoteAddr = "10.0.0.1:52000"
+	assert.Equal(t, "10.0.0.1", clientIP(req))
+	req.RemoteAddr = "[::1]:52000"
+	assert.Equal(t, "::1", clientIP(req))
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the parameters of the time-based one-time passwords, which are the defaults of authenticator apps
+const (
+	// totpPeriod is the time step of the passwords.
+	totpPeriod = 30 * time.Second
+	// totpDigits is the number of digits of the passwords.
+	totpDigits = 6
+	// totpSkew is the number of time steps before and after the current one whose passwords are also accepted,
+	// to allow for clock drift and slow typing.
+	totpSkew = 1
+	// totpSecretLength is the number of random bytes in a secret, as recommended by RFC 4226.
+	totpSecretLength = 20
+)
+
+// TOTPIssuer names the service in the authenticator apps of the users.
+const TOTPIssuer = "Notes"
+
+// totpEncoding encodes the secrets as authenticator apps expect them.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a new random base32-encoded secret.
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpURI returns the otpauth URI that sets up the secret of the given account in an authenticator app,
+// usually by scanning it as a QR code.
+func totpURI(secret, account string) string {
+	label := url.PathEscape(TOTPIssuer + ":" + account)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {TOTPIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpPeriod.Seconds()))},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpStep returns the time step of the given time.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod.Seconds())
+}
+
+// totpCode computes the password of the given time step as defined by RFC 6238 with HMAC-SHA1.
+func totpCode(secret []byte, step int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// matchTOTP returns the time step around the given time whose password is the code, or -1 if none is.
+func matchTOTP(secret, code string, now time.Time) int64 {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return -1
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return -1
+}
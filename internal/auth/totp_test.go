@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_totpCode(t *testing.T) {
+	// the SHA-1 test vectors of RFC 6238, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, totpCode(secret, totpStep(time.Unix(tt.time, 0))))
+	}
+}
+
+func Test_matchTOTP(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(secret))
+	key, _ := totpEncoding.DecodeString(secret)
+	now := time.Unix(1700000000, 0)
+	step := totpStep(now)
+
+	assert.Equal(t, step, matchTOTP(secret, totpCode(key, step), now))
+	assert.Equal(t, step-1, matchTOTP(secret, totpCode(key, step-1), now))
+	assert.Equal(t, step+1, matchTOTP(secret, totpCode(key, step+1), now))
+	assert.Equal(t, int64(-1), matchTOTP(secret, totpCode(key, step-2), now))
+	assert.Equal(t, int64(-1), matchTOTP(secret, "12345", now))
+	assert.Equal(t, int64(-1), matchTOTP("not base32!", totpCode(key, step), now))
+}
+
+func Test_totpURI(t *testing.T) {
+	uri, err := url.Parse(totpURI("JBSWY3DPEHPK3PXP", "demo user"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "otpauth", uri.Scheme)
+		assert.Equal(t, "totp", uri.Host)
+		assert.Equal(t, "/"+TOTPIssuer+":demo user", uri.Path)
+		assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+		assert.Equal(t, TOTPIssuer, uri.Query().Get("issuer"))
+		assert.Equal(t, "30", uri.Query().Get("period"))
+	}
+	assert.False(t, strings.Contains(totpURI("JBSWY3DPEHPK3PXP", "demo user"), " "))
+}
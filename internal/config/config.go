@@ -10,7 +10,34 @@ import (
 
 const (
 	defaultServerPort         = 8080
-	defaultJWTExpirationHours = 72
+	defaultAccessTokenMinutes = 15
+	defaultRefreshTokenHours  = 30 * 24
+	defaultRevisionRetention  = 50
+	defaultTrashRetentionDays = 30
+	defaultArgon2Memory       = 64 * 1024
+	defaultArgon2Iterations   = 3
+	defaultArgon2Parallelism  = 2
+	defaultBcryptCost         = 12
+	defaultLoginMaxFailures   = 10
+	defaultLoginMaxIPFailures = 100
+	defaultLoginLockout       = 15
+	defaultSMTPPort           = 587
+)
+
+// the search backends of the notes
+const (
+	// SearchBackendPostgres searches notes with the full-text search of Postgres.
+	SearchBackendPostgres = "postgres"
+	// SearchBackendMemory searches notes with an inverted index kept in the memory of the server.
+	SearchBackendMemory = "memory"
+)
+
+// the algorithms hashing the passwords of the users
+const (
+	// PasswordHashArgon2id hashes passwords with argon2id.
+	PasswordHashArgon2id = "argon2id"
+	// PasswordHashBcrypt hashes passwords with bcrypt.
+	PasswordHashBcrypt = "bcrypt"
 )
 
 // Config represents an application configuration.
@@ -21,8 +48,60 @@ type Config struct {
 	DSN string `yaml:"dsn" env:"DSN,secret"`
 	// JWT signing key. required.
 	JWTSigningKey string `yaml:"jwt_signing_key" env:"JWT_SIGNING_KEY,secret"`
-	// JWT expiration in hours. Defaults to 72 hours (3 days)
+	// access token (JWT) expiration in minutes. Defaults to 15 minutes
+	AccessTokenExpiration int `yaml:"access_token_expiration" env:"ACCESS_TOKEN_EXPIRATION"`
+	// refresh token expiration in hours. Defaults to 720 hours (30 days)
+	RefreshTokenExpiration int `yaml:"refresh_token_expiration" env:"REFRESH_TOKEN_EXPIRATION"`
+	// Deprecated: JWT expiration in hours, from before the access tokens were refreshed. It sets the refresh
+	// token expiration unless that is set as well.
 	JWTExpiration int `yaml:"jwt_expiration" env:"JWT_EXPIRATION"`
+	// the key signing pagination cursors. Defaults to the JWT signing key.
+	CursorSigningKey string `yaml:"cursor_signing_key" env:"CURSOR_SIGNING_KEY,secret"`
+	// the number of revisions kept for each note. Defaults to 50. Zero keeps every revision.
+	RevisionRetention int `yaml:"revision_retention" env:"REVISION_RETENTION"`
+	// the number of days a deleted note stays in the trash before it is purged. Defaults to 30 days.
+	TrashRetention int `yaml:"trash_retention" env:"TRASH_RETENTION"`
+	// the search backend of the notes, either "postgres" or "memory". Defaults to "postgres".
+	SearchBackend string `yaml:"search_backend" env:"SEARCH_BACKEND"`
+	// the algorithm hashing the passwords of the users, either "argon2id" or "bcrypt". Defaults to "argon2id".
+	PasswordHash string `yaml:"password_hash" env:"PASSWORD_HASH"`
+	// the memory in KiB used by argon2id. Defaults to 65536 (64 MiB).
+	Argon2Memory int `yaml:"argon2_memory" env:"ARGON2_MEMORY"`
+	// the number of passes of argon2id over the memory. Defaults to 3.
+	Argon2Iterations int `yaml:"argon2_iterations" env:"ARGON2_ITERATIONS"`
+	// the number of threads used by argon2id. Defaults to 2.
+	Argon2Parallelism int `yaml:"argon2_parallelism" env:"ARGON2_PARALLELISM"`
+	// the cost of bcrypt. Defaults to 12.
+	BcryptCost int `yaml:"bcrypt_cost" env:"BCRYPT_COST"`
+	// the issuer URL of the OpenID Connect provider users can log in with. Single sign-on is disabled if empty.
+	OIDCIssuer string `yaml:"oidc_issuer" env:"OIDC_ISSUER"`
+	// the client ID registered with the OpenID Connect provider. required with the issuer.
+	OIDCClientID string `yaml:"oidc_client_id" env:"OIDC_CLIENT_ID"`
+	// the client secret registered with the OpenID Connect provider.
+	OIDCClientSecret string `yaml:"oidc_client_secret" env:"OIDC_CLIENT_SECRET,secret"`
+	// the URL of the /api/oidc/callback endpoint registered with the OpenID Connect provider. required with the issuer.
+	OIDCRedirectURL string `yaml:"oidc_redirect_url" env:"OIDC_REDIRECT_URL"`
+	// the number of consecutive failed logins after which a username is locked. Defaults to 10.
+	LoginMaxFailures int `yaml:"login_max_failures" env:"LOGIN_MAX_FAILURES"`
+	// the number of failed logins after which a client IP is locked. Defaults to 100.
+	LoginMaxIPFailures int `yaml:"login_max_ip_failures" env:"LOGIN_MAX_IP_FAILURES"`
+	// how long a locked username or client IP stays locked, in minutes. Defaults to 15 minutes.
+	LoginLockout int `yaml:"login_lockout" env:"LOGIN_LOCKOUT"`
+	// the names of the users who may administer the server, such as unlocking locked usernames.
+	AdminUsers []string `yaml:"admin_users" env:"ADMIN_USERS"`
+	// the host of the SMTP server sending emails. Password resets are disabled if empty.
+	SMTPHost string `yaml:"smtp_host" env:"SMTP_HOST"`
+	// the port of the SMTP server. Defaults to 587.
+	SMTPPort int `yaml:"smtp_port" env:"SMTP_PORT"`
+	// the username authenticating with the SMTP server, if it requires authentication.
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	// the password authenticating with the SMTP server.
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD,secret"`
+	// the sender address of the emails. required with the SMTP host.
+	MailFrom string `yaml:"mail_from" env:"MAIL_FROM"`
+	// the URL of the page where users choose a new password, which receives the reset token in the token query
+	// parameter. The emails only contain the token if empty.
+	PasswordResetURL string `yaml:"password_reset_url" env:"PASSWORD_RESET_URL"`
 }
 
 // Validate validates the application configuration.
@@ -30,6 +109,23 @@ func (c Config) Validate() error {
 	return validation.ValidateStruct(&c,
 		validation.Field(&c.DSN, validation.Required),
 		validation.Field(&c.JWTSigningKey, validation.Required),
+		validation.Field(&c.AccessTokenExpiration, validation.Min(1)),
+		validation.Field(&c.RefreshTokenExpiration, validation.Min(1)),
+		validation.Field(&c.RevisionRetention, validation.Min(0)),
+		validation.Field(&c.TrashRetention, validation.Min(1)),
+		validation.Field(&c.SearchBackend, validation.In(SearchBackendPostgres, SearchBackendMemory)),
+		validation.Field(&c.PasswordHash, validation.In(PasswordHashArgon2id, PasswordHashBcrypt)),
+		validation.Field(&c.Argon2Memory, validation.Min(8*c.Argon2Parallelism), validation.Max(4*1024*1024)),
+		validation.Field(&c.Argon2Iterations, validation.Min(1), validation.Max(100)),
+		validation.Field(&c.Argon2Parallelism, validation.Min(1), validation.Max(255)),
+		validation.Field(&c.BcryptCost, validation.Min(4), validation.Max(31)),
+		validation.Field(&c.LoginMaxFailures, validation.Min(1)),
+		validation.Field(&c.LoginMaxIPFailures, validation.Min(1)),
+		validation.Field(&c.LoginLockout, validation.Min(1)),
+		validation.Field(&c.SMTPPort, validation.Min(1), validation.Max(65535)),
+		validation.Field(&c.MailFrom, validation.When(c.SMTPHost != "", validation.Required)),
+		validation.Field(&c.OIDCClientID, validation.When(c.OIDCIssuer != "", validation.Required)),
+		validation.Field(&c.OIDCRedirectURL, validation.When(c.OIDCIssuer != "", validation.Required)),
 	)
 }
 
@@ -37,8 +133,21 @@ func (c Config) Validate() error {
 func Load(file string, logger log.Logger) (*Config, error) {
 	// default config
 	c := Config{
-		ServerPort:    defaultServerPort,
-		JWTExpiration: defaultJWTExpirationHours,
+		ServerPort:             defaultServerPort,
+		AccessTokenExpiration:  defaultAccessTokenMinutes,
+		RefreshTokenExpiration: defaultRefreshTokenHours,
+		RevisionRetention:      defaultRevisionRetention,
+		TrashRetention:         defaultTrashRetentionDays,
+		SearchBackend:          SearchBackendPostgres,
+		PasswordHash:           PasswordHashArgon2id,
+		Argon2Memory:           defaultArgon2Memory,
+		Argon2Iterations:       defaultArgon2Iterations,
+		Argon2Parallelism:      defaultArgon2Parallelism,
+		BcryptCost:             defaultBcryptCost,
+		LoginMaxFailures:       defaultLoginMaxFailures,
+		LoginMaxIPFailures:     defaultLoginMaxIPFailures,
+		LoginLockout:           defaultLoginLockout,
+		SMTPPort:               defaultSMTPPort,
 	}
 
 	// load from YAML config file
@@ -55,6 +164,17 @@ func Load(file string, logger log.Logger) (*Config, error) {
 		return nil, err
 	}
 
+	if c.JWTExpiration != 0 {
+		logger.Infof("jwt_expiration is deprecated, use access_token_expiration and refresh_token_expiration instead")
+		if c.RefreshTokenExpiration == defaultRefreshTokenHours {
+			c.RefreshTokenExpiration = c.JWTExpiration
+		}
+	}
+
+	if c.CursorSigningKey == "" {
+		c.CursorSigningKey = c.JWTSigningKey
+	}
+
 	// validation
 	if err = c.Validate(); err != nil {
 		return nil, err
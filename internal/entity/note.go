@@ -6,13 +6,20 @@ import (
 
 // Note represents an note record.
 type Note struct {
-	ID             string    `json:"id"`
-	Title          string    `json:"title"`
-	Text           string    `json:"text"`
-	TextSearchable string    `json:"text_searchable"`
-	UserID         string    `json:"user_id"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Text      string    `json:"text"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Version is incremented every time the note is updated.
+	Version int `json:"version"`
+	// DeletedAt is set when the note is moved to the trash.
+	DeletedAt *time.Time `json:"deleted_at"`
+	// NotebookID is the notebook the note is filed in, or nil if it is not in any notebook.
+	NotebookID *string `json:"notebook_id"`
+	// WorkspaceID is the workspace owning the note, or nil if the note is a personal note of its user.
+	WorkspaceID *string `json:"workspace_id"`
 }
 
 func (u Note) TableName() string {
@@ -24,8 +31,35 @@ type SharedNote struct {
 	ID           string `json:"id"`
 	NoteID       string `json:"note_id"`
 	SharedUserID string `json:"shared_user_id"`
+	// Role is one of RoleViewer, RoleCommenter and RoleEditor.
+	Role string `json:"role"`
 }
 
+// the roles a note can be shared with, from the least to the most privileged
+const (
+	// RoleViewer may read the note.
+	RoleViewer = "viewer"
+	// RoleCommenter may read and comment on the note.
+	RoleCommenter = "commenter"
+	// RoleEditor may read and change the title and text of the note.
+	RoleEditor = "editor"
+)
+
 func (u SharedNote) TableName() string {
 	return "shared_notes"
 }
+
+// NoteRevision is a snapshot of the title and text of a note taken whenever the note is created or updated.
+type NoteRevision struct {
+	ID        string    `json:"id"`
+	NoteID    string    `json:"note_id"`
+	Revision  int       `json:"revision"`
+	Title     string    `json:"title"`
+	Text      string    `json:"text"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (u NoteRevision) TableName() string {
+	return "note_revisions"
+}
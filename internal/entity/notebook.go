@@ -0,0 +1,18 @@
+package entity
+
+import "time"
+
+// Notebook represents a folder of notes. Notebooks can be nested under a parent notebook.
+type Notebook struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// ParentID is nil for a top-level notebook.
+	ParentID  *string   `json:"parent_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (n Notebook) TableName() string {
+	return "notebooks"
+}
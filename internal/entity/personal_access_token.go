@@ -0,0 +1,26 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PersonalAccessToken lets scripts and integrations act on behalf of a user within the scopes of the token.
+type PersonalAccessToken struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	// Scopes lists the scopes granted to the token, such as "notes:read".
+	Scopes pq.StringArray `json:"scopes"`
+	// TokenHash is the SHA-256 hash of the token, which itself is only shown once when the token is created.
+	TokenHash string `json:"-"`
+	// ExpiresAt is the time the token stops working, or nil if it does not expire.
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (t PersonalAccessToken) TableName() string {
+	return "personal_access_tokens"
+}
@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SavedSearch represents a search of notes that a user saved to run again. Saved search names are unique per user.
+type SavedSearch struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	// Query is written in the query language of the search endpoint.
+	Query string `json:"query"`
+	// Mode is either "fulltext" or "fuzzy".
+	Mode string `json:"mode"`
+	// Sort is "relevance" or a field the notes are sorted by, in the "asc" or "desc" SortOrder.
+	Sort      string `json:"sort"`
+	SortOrder string `json:"order"`
+	// Tags, TagMode ("and" or "or"), CreatedAfter and UpdatedBefore filter the notes found.
+	Tags          pq.StringArray `json:"tags"`
+	TagMode       string         `json:"tag_mode"`
+	CreatedAfter  *time.Time     `json:"created_after"`
+	UpdatedBefore *time.Time     `json:"updated_before"`
+	// Pinned saved searches are meant to be shown as virtual folders next to the notebooks.
+	Pinned    bool      `json:"pinned"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (s SavedSearch) TableName() string {
+	return "saved_searches"
+}
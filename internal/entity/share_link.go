@@ -0,0 +1,23 @@
+package entity
+
+import "time"
+
+// ShareLink is a public read-only link to a note that can be opened without an account.
+type ShareLink struct {
+	ID     string `json:"id"`
+	NoteID string `json:"note_id"`
+	// Token is the unguessable part of the link URL.
+	Token string `json:"token"`
+	// PasswordHash is the bcrypt hash of the password protecting the link, or empty if the link has no password.
+	PasswordHash string `json:"-"`
+	// ExpiresAt is the time after which the link cannot be opened, or nil if the link does not expire.
+	ExpiresAt *time.Time `json:"expires_at"`
+	// MaxViews is the number of times the link can be opened, or 0 if the number is not limited.
+	MaxViews  int       `json:"max_views"`
+	Views     int       `json:"views"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (l ShareLink) TableName() string {
+	return "share_links"
+}
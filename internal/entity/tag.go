@@ -0,0 +1,26 @@
+package entity
+
+import (
+	"strings"
+	"time"
+)
+
+// Tag represents a label that a user attaches to notes. Tag names are unique per user.
+type Tag struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (t Tag) TableName() string {
+	return "tags"
+}
+
+// NormalizeTagName returns the canonical form of a tag name. It is lower case, has no leading "#"
+// and no surrounding whitespace, and any inner whitespace is collapsed into single spaces.
+func NormalizeTagName(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
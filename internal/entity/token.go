@@ -0,0 +1,51 @@
+package entity
+
+import "time"
+
+// RefreshToken is a long-lived token a client exchanges for a new access token. Every exchange replaces it with a
+// new refresh token of the same family, so that the reuse of a replaced token reveals that it was stolen.
+type RefreshToken struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	FamilyID string `json:"family_id"`
+	// TokenHash is the SHA-256 hash of the token, which itself is only known to the client.
+	TokenHash string `json:"-"`
+	// AccessTokenID is the jti of the access token issued together with the refresh token.
+	AccessTokenID string    `json:"-"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	// UsedAt is set once the token has been exchanged.
+	UsedAt *time.Time `json:"used_at"`
+	// RevokedAt is set once the token family has been revoked.
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (t RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RevokedToken denies the access token with the jti given as ID until the token expires.
+type RevokedToken struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (t RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// PasswordReset lets the user who received its token by email choose a new password once, until it expires.
+type PasswordReset struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// TokenHash is the SHA-256 hash of the token, which itself is only sent to the user.
+	TokenHash string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// UsedAt is set once the token has been used, or a newer password was set.
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (r PasswordReset) TableName() string {
+	return "password_resets"
+}
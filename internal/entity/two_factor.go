@@ -0,0 +1,36 @@
+package entity
+
+import "time"
+
+// TOTP is the secret a user shares with an authenticator app to generate the time-based one-time passwords
+// (RFC 6238) of two-factor authentication.
+type TOTP struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// Secret is the base32-encoded shared secret.
+	Secret string `json:"-"`
+	// EnabledAt is set once the user has verified a first code, after which logging in requires a code.
+	EnabledAt *time.Time `json:"enabled_at"`
+	// LastUsedStep is the time step of the last accepted code, which cannot be used again.
+	LastUsedStep int64     `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (t TOTP) TableName() string {
+	return "user_totp"
+}
+
+// RecoveryCode is a one-time code that replaces a time-based one-time password when the user has lost
+// the authenticator app.
+type RecoveryCode struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// CodeHash is the SHA-256 hash of the code, which itself is only shown to the user.
+	CodeHash  string     `json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (c RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
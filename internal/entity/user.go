@@ -4,13 +4,23 @@ import "time"
 
 // User represents a user.
 type User struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Password  string    `json:"-"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Password    string `json:"-"`
+	Email       string `json:"-"`
+	DisplayName string `json:"display_name"`
+	// Timezone is the IANA name of the time zone of the user, such as "Europe/Paris", or empty if not set.
+	Timezone string `json:"timezone"`
+	// Locale is the BCP 47 language tag of the user, such as "fr-CA", or empty if not set.
+	Locale    string    `json:"locale"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// DeletedUserID replaces the ID of a deleted user in the records that outlive the user, such as the notes they
+// wrote in a workspace.
+const DeletedUserID = "deleted"
+
 func (u User) TableName() string {
 	return "users"
 }
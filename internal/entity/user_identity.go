@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+// UserIdentity links a user to the account of the user at an OpenID Connect provider, which is identified by
+// the issuer of the provider and the subject of the account.
+type UserIdentity struct {
+	ID      string `json:"id"`
+	UserID  string `json:"user_id"`
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	// Email is the email address of the account when the user first logged in with it.
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (i UserIdentity) TableName() string {
+	return "user_identities"
+}
@@ -0,0 +1,55 @@
+package entity
+
+import "time"
+
+// Workspace is a team space whose members share the notes it owns.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (w Workspace) TableName() string {
+	return "workspaces"
+}
+
+// WorkspaceMember records that a user is a member of a workspace.
+type WorkspaceMember struct {
+	ID          string `json:"id"`
+	WorkspaceID string `json:"workspace_id"`
+	UserID      string `json:"user_id"`
+	// Role is one of RoleOwner, RoleAdmin, RoleMember and RoleGuest.
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (m WorkspaceMember) TableName() string {
+	return "workspace_members"
+}
+
+// WorkspaceInvitation invites a user to join a workspace with a role.
+type WorkspaceInvitation struct {
+	ID          string    `json:"id"`
+	WorkspaceID string    `json:"workspace_id"`
+	UserID      string    `json:"user_id"`
+	Role        string    `json:"role"`
+	InvitedBy   string    `json:"invited_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (i WorkspaceInvitation) TableName() string {
+	return "workspace_invitations"
+}
+
+// the roles of the members of a workspace, from the most to the least privileged
+const (
+	// RoleOwner created the workspace. Each workspace has a single owner.
+	RoleOwner = "owner"
+	// RoleAdmin may manage the notes, the members and the workspace itself.
+	RoleAdmin = "admin"
+	// RoleMember may create notes and change the notes of the workspace.
+	RoleMember = "member"
+	// RoleGuest may read the notes of the workspace.
+	RoleGuest = "guest"
+)
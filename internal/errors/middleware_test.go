@@ -63,6 +63,9 @@ func Test_buildErrorResponse(t *testing.T) {
 	res = buildErrorResponse(routing.NewHTTPError(http.StatusForbidden))
 	assert.Equal(t, http.StatusForbidden, res.Status)
 
+	res = buildErrorResponse(PreconditionFailed(""))
+	assert.Equal(t, http.StatusPreconditionFailed, res.Status)
+
 	res = buildErrorResponse(sql.ErrNoRows)
 	assert.Equal(t, http.StatusNotFound, res.Status)
 
@@ -67,6 +67,39 @@ func Forbidden(msg string) ErrorResponse {
 	}
 }
 
+// Conflict creates a new error response representing a conflict with the current state of a resource (HTTP 409)
+func Conflict(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "The request conflicts with the current state of the resource."
+	}
+	return ErrorResponse{
+		Status:  http.StatusConflict,
+		Message: msg,
+	}
+}
+
+// PreconditionFailed creates a new error response representing a failed conditional request (HTTP 412)
+func PreconditionFailed(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "The resource has been modified since you last retrieved it."
+	}
+	return ErrorResponse{
+		Status:  http.StatusPreconditionFailed,
+		Message: msg,
+	}
+}
+
+// TooManyRequests creates a new error response representing a request rejected by rate limiting (HTTP 429)
+func TooManyRequests(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "You have sent too many requests. Please try again later."
+	}
+	return ErrorResponse{
+		Status:  http.StatusTooManyRequests,
+		Message: msg,
+	}
+}
+
 // BadRequest creates a new error response representing a bad request (HTTP 400)
 func BadRequest(msg string) ErrorResponse {
 	if msg == "" {
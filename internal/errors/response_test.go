@@ -54,6 +54,30 @@ func TestForbidden(t *testing.T) {
 	assert.NotEmpty(t, res.Error())
 }
 
+func TestConflict(t *testing.T) {
+	res := Conflict("test")
+	assert.Equal(t, http.StatusConflict, res.StatusCode())
+	assert.Equal(t, "test", res.Error())
+	res = Conflict("")
+	assert.NotEmpty(t, res.Error())
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	res := PreconditionFailed("test")
+	assert.Equal(t, http.StatusPreconditionFailed, res.StatusCode())
+	assert.Equal(t, "test", res.Error())
+	res = PreconditionFailed("")
+	assert.NotEmpty(t, res.Error())
+}
+
+func TestTooManyRequests(t *testing.T) {
+	res := TooManyRequests("test")
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode())
+	assert.Equal(t, "test", res.Error())
+	res = TooManyRequests("")
+	assert.NotEmpty(t, res.Error())
+}
+
 func TestBadRequest(t *testing.T) {
 	res := BadRequest("test")
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode())
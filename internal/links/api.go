@@ -0,0 +1,151 @@
+package links
+
+import (
+	"bytes"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/go-ozzo/ozzo-routing/v2/content"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers managing the share links of notes.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, rateLimiter routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler) // the following endpoints require a valid JWT or personal access token
+	r.Use(rateLimiter)
+	share := auth.RequireScope(auth.ScopeShare)
+	r.Get("/notes/<id>/links", share, res.query)
+	r.Post("/notes/<id>/links", share, res.create)
+	r.Delete("/notes/<id>/links/<link_id>", share, res.delete)
+}
+
+// RegisterPublicHandlers sets up the routing of the HTTP handlers opening share links, which need no authentication.
+// The password of a protected link is sent in the X-Link-Password header, or posted in the password form field.
+// After too many wrong passwords, the link answers 429 Too Many Requests with a Retry-After header for a while.
+func RegisterPublicHandlers(r *routing.RouteGroup, service Service, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Get("/p/<token>", res.view)
+	r.Post("/p/<token>", res.view)
+}
+
+// passwordHeader is the request header carrying the password of a protected share link.
+const passwordHeader = "X-Link-Password"
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) query(c *routing.Context) error {
+	links, err := r.service.Query(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(links)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input CreateLinkRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.Read(&input); err != nil {
+			r.logger.With(c.Request.Context()).Info(err)
+			return errors.BadRequest("")
+		}
+	}
+
+	link, err := r.service.Create(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteWithStatus(link, http.StatusCreated)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	link, err := r.service.Delete(c.Request.Context(), c.Param("id"), c.Param("link_id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(link)
+}
+
+// view opens a share link. The note is rendered as an HTML page if the client prefers HTML, with a password
+// form in place of the note while the password of a protected link is missing or wrong.
+func (r resource) view(c *routing.Context) error {
+	password := c.Request.Header.Get(passwordHeader)
+	if c.Request.Method == http.MethodPost {
+		password = c.Request.PostFormValue("password")
+	}
+	note, err := r.service.View(c.Request.Context(), c.Param("token"), password)
+	c.Response.Header().Set("Cache-Control", "no-store")
+	if e, ok := err.(throttledError); ok {
+		c.Response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.wait.Seconds()))))
+		err = errors.TooManyRequests("too many wrong passwords, please try again later")
+	}
+
+	if content.NegotiateContentType(c.Request, []string{content.JSON, content.HTML}, content.JSON) != content.HTML {
+		if err != nil {
+			return err
+		}
+		return c.Write(note)
+	}
+
+	page := viewPage{Note: note}
+	status := http.StatusOK
+	if err != nil {
+		res, ok := err.(errors.ErrorResponse)
+		if !ok {
+			return err
+		}
+		page.Error, status = res.Message, res.Status
+		page.AskPassword = status == http.StatusUnauthorized
+	}
+	var buf bytes.Buffer
+	if err := viewTemplate.Execute(&buf, page); err != nil {
+		return err
+	}
+	c.SetDataWriter(content.DataWriters[content.HTML])
+	return c.WriteWithStatus(buf.Bytes(), status)
+}
+
+// viewPage is the data rendered by viewTemplate.
+type viewPage struct {
+	Note        PublicNote
+	Error       string
+	AskPassword bool
+}
+
+var viewTemplate = template.Must(template.New("view").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{if .Error}}Shared note{{else}}{{.Note.Title}}{{end}}</title>
+</head>
+<body>
+{{- if .Error}}
+<p>{{.Error}}</p>
+{{- if .AskPassword}}
+<form method="post">
+<input type="password" name="password" autofocus>
+<button type="submit">Open</button>
+</form>
+{{- end}}
+{{- else}}
+<h1>{{.Note.Title}}</h1>
+<p><small>Last updated {{.Note.UpdatedAt.Format "2006-01-02 15:04"}}</small></p>
+<div style="white-space: pre-wrap">{{.Note.Text}}</div>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,65 @@
+package links
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	repo := &mockLinkRepo{
+		items: []entity.ShareLink{
+			{ID: "l1", NoteID: "123", Token: "open", CreatedAt: time.Now()},
+			{ID: "l2", NoteID: "123", Token: "protected", PasswordHash: string(hash), MaxViews: 1, CreatedAt: time.Now()},
+			{ID: "l3", NoteID: "123", Token: "guarded", PasswordHash: string(hash), CreatedAt: time.Now()},
+		},
+		notes: []entity.Note{{ID: "123", Title: "plan <b>", Text: "text1", UserID: "testuser"}},
+	}
+	service := NewService(repo, auth.NewLoginThrottle(auth.ThrottleConfig{MaxFailures: 2, MaxIPFailures: 100, Lockout: time.Minute}, logger), logger)
+	RegisterHandlers(router.Group(""), service, auth.MockAuthHandler, auth.MockAuthHandler, logger)
+	RegisterPublicHandlers(router.Group(""), service, logger)
+	header := auth.MockAuthHeader()
+	other := auth.MockAuthHeaderFor("otheruser")
+	html := http.Header{"Accept": {"text/html"}}
+	password := http.Header{"X-Link-Password": {"wrong"}}
+	form := http.Header{"Accept": {"text/html"}, "Content-Type": {"application/x-www-form-urlencoded"}}
+
+	tests := []test.APITestCase{
+		{"query", "GET", "/notes/123/links", "", header, http.StatusOK, `*"token":"protected","expires_at":null*`},
+		{"query other", "GET", "/notes/123/links", "", other, http.StatusNotFound, ""},
+		{"create", "POST", "/notes/123/links", `{"max_views":5,"password":"pass"}`, header, http.StatusCreated, `*"max_views":5,"views":0*`},
+		{"create no body", "POST", "/notes/123/links", "", header, http.StatusCreated, `*"protected":false*`},
+		{"create expired", "POST", "/notes/123/links", `{"expires_at":"2020-01-01T00:00:00Z"}`, header, http.StatusBadRequest, ""},
+		{"create auth error", "POST", "/notes/123/links", "", nil, http.StatusUnauthorized, ""},
+		{"create other", "POST", "/notes/123/links", "", other, http.StatusNotFound, ""},
+		{"view json", "GET", "/p/open", "", nil, http.StatusOK, `*"title":"plan <b>","text":"text1"*`},
+		{"view html", "GET", "/p/open", "", html, http.StatusOK, `*<h1>plan &lt;b&gt;</h1>*`},
+		{"view unknown", "GET", "/p/unknown", "", nil, http.StatusNotFound, ""},
+		{"view unknown html", "GET", "/p/unknown", "", html, http.StatusNotFound, `*the link does not exist or has expired*`},
+		{"view no password", "GET", "/p/protected", "", nil, http.StatusUnauthorized, ""},
+		{"view password form", "GET", "/p/protected", "", html, http.StatusUnauthorized, `*<form method="post">*`},
+		{"view wrong password", "GET", "/p/protected", "", password, http.StatusUnauthorized, `*the password is incorrect*`},
+		{"view posted password", "POST", "/p/protected", "password=secret", form, http.StatusOK, `*<h1>plan &lt;b&gt;</h1>*`},
+		{"view limit reached", "POST", "/p/protected", "password=secret", form, http.StatusNotFound, ""},
+		{"view guarded wrong password", "GET", "/p/guarded", "", password, http.StatusUnauthorized, ""},
+		{"view guarded locked", "GET", "/p/guarded", "", password, http.StatusUnauthorized, ""},
+		{"view guarded throttled", "POST", "/p/guarded", "password=secret", form, http.StatusTooManyRequests, `*too many wrong passwords*`},
+		{"view guarded throttled json", "GET", "/p/guarded", "", http.Header{"X-Link-Password": {"secret"}}, http.StatusTooManyRequests, ""},
+		{"revoke other", "DELETE", "/notes/123/links/l1", "", other, http.StatusNotFound, ""},
+		{"revoke", "DELETE", "/notes/123/links/l1", "", header, http.StatusOK, `*"token":"open"*`},
+		{"view revoked", "GET", "/p/open", "", nil, http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,116 @@
+package links
+
+import (
+	"context"
+	"database/sql"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Repository encapsulates the logic to access share links from the data source.
+type Repository interface {
+	// Get returns the share link with the specified ID.
+	Get(ctx context.Context, id string) (entity.ShareLink, error)
+	// GetByToken returns the share link with the given token.
+	GetByToken(ctx context.Context, token string) (entity.ShareLink, error)
+	// Query returns the share links of the given note, the newest first.
+	Query(ctx context.Context, noteID string) ([]entity.ShareLink, error)
+	// Create saves a new share link in the storage.
+	Create(ctx context.Context, link entity.ShareLink) error
+	// Delete removes the share link with given ID from the storage.
+	Delete(ctx context.Context, id string) error
+	// CountView records that the share link with the given ID was opened.
+	// sql.ErrNoRows is returned if the link has no views left.
+	CountView(ctx context.Context, id string) error
+	// GetNote returns the note with the specified ID unless it is in the trash.
+	GetNote(ctx context.Context, id string) (entity.Note, error)
+	// DeleteUserData deletes the share links of the personal notes of the given user. It runs within
+	// the transaction of the context deleting the account of the user.
+	DeleteUserData(ctx context.Context, userID string) error
+}
+
+// repository persists share links in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new share link repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get reads the share link with the specified ID from the database.
+func (r repository) Get(ctx context.Context, id string) (entity.ShareLink, error) {
+	var link entity.ShareLink
+	err := r.db.With(ctx).Select().Model(id, &link)
+	return link, err
+}
+
+// GetByToken reads the share link with the given token from the database.
+func (r repository) GetByToken(ctx context.Context, token string) (entity.ShareLink, error) {
+	var link entity.ShareLink
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"token": token}).One(&link)
+	return link, err
+}
+
+// Query retrieves the share links of the given note from the database.
+func (r repository) Query(ctx context.Context, noteID string) ([]entity.ShareLink, error) {
+	var links []entity.ShareLink
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"note_id": noteID}).
+		OrderBy("created_at DESC", "id").
+		All(&links)
+	return links, err
+}
+
+// Create saves a new share link record in the database.
+func (r repository) Create(ctx context.Context, link entity.ShareLink) error {
+	return r.db.With(ctx).Model(&link).Insert()
+}
+
+// Delete deletes the share link with the specified ID from the database.
+func (r repository) Delete(ctx context.Context, id string) error {
+	link, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return r.db.With(ctx).Model(&link).Delete()
+}
+
+// CountView increments the views of the share link in the database, provided the link has views left.
+func (r repository) CountView(ctx context.Context, id string) error {
+	result, err := r.db.With(ctx).Update("share_links", dbx.Params{"views": dbx.NewExp("views + 1")},
+		dbx.And(dbx.HashExp{"id": id}, dbx.NewExp("max_views = 0 OR views < max_views"))).Execute()
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetNote reads the note with the specified ID from the database, excluding the notes in the trash.
+func (r repository) GetNote(ctx context.Context, id string) (entity.Note, error) {
+	var note entity.Note
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.And(dbx.HashExp{"id": id}, dbx.NewExp("deleted_at IS NULL"))).
+		One(&note)
+	return note, err
+}
+
+// DeleteUserData deletes the share links of the personal notes of the user from the database.
+func (r repository) DeleteUserData(ctx context.Context, userID string) error {
+	_, err := r.db.With(ctx).Delete("share_links",
+		dbx.NewExp("note_id IN (SELECT id FROM notes WHERE user_id = {:user} AND workspace_id IS NULL)",
+			dbx.Params{"user": userID})).Execute()
+	return err
+}
@@ -0,0 +1,139 @@
+package links
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	logger, _ := log.NewForTest()
+	db := test.DB(t)
+	test.ResetTables(t, db, "share_links", "notes")
+	repo := NewRepository(db, logger)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// note
+	err := db.With(ctx).Model(&entity.Note{ID: "note1", Title: "title1", Text: "text1", UserID: "user1", CreatedAt: now, UpdatedAt: now, Version: 1}).Insert()
+	assert.Nil(t, err)
+	note, err := repo.GetNote(ctx, "note1")
+	assert.Nil(t, err)
+	assert.Equal(t, "title1", note.Title)
+
+	// create
+	assert.Nil(t, repo.Create(ctx, entity.ShareLink{ID: "link1", NoteID: "note1", Token: "token1", MaxViews: 2, CreatedAt: now}))
+	assert.Nil(t, repo.Create(ctx, entity.ShareLink{ID: "link2", NoteID: "note1", Token: "token2", CreatedAt: now.Add(time.Second)}))
+	link, err := repo.GetByToken(ctx, "token1")
+	assert.Nil(t, err)
+	assert.Equal(t, "link1", link.ID)
+	links, err := repo.Query(ctx, "note1")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(links)) {
+		assert.Equal(t, "link2", links[0].ID)
+	}
+
+	// views
+	assert.Nil(t, repo.CountView(ctx, "link1"))
+	assert.Nil(t, repo.CountView(ctx, "link1"))
+	assert.Equal(t, sql.ErrNoRows, repo.CountView(ctx, "link1"))
+	link, _ = repo.Get(ctx, "link1")
+	assert.Equal(t, 2, link.Views)
+	assert.Nil(t, repo.CountView(ctx, "link2"))
+
+	// delete
+	assert.Nil(t, repo.Delete(ctx, "link1"))
+	_, err = repo.GetByToken(ctx, "token1")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+type mockLinkRepo struct {
+	items []entity.ShareLink
+	notes []entity.Note
+}
+
+func (m *mockLinkRepo) Get(ctx context.Context, id string) (entity.ShareLink, error) {
+	for _, item := range m.items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return entity.ShareLink{}, sql.ErrNoRows
+}
+
+func (m *mockLinkRepo) GetByToken(ctx context.Context, token string) (entity.ShareLink, error) {
+	for _, item := range m.items {
+		if item.Token == token {
+			return item, nil
+		}
+	}
+	return entity.ShareLink{}, sql.ErrNoRows
+}
+
+func (m *mockLinkRepo) Query(ctx context.Context, noteID string) ([]entity.ShareLink, error) {
+	var links []entity.ShareLink
+	for i := len(m.items) - 1; i >= 0; i-- {
+		if m.items[i].NoteID == noteID {
+			links = append(links, m.items[i])
+		}
+	}
+	return links, nil
+}
+
+func (m *mockLinkRepo) Create(ctx context.Context, link entity.ShareLink) error {
+	m.items = append(m.items, link)
+	return nil
+}
+
+func (m *mockLinkRepo) Delete(ctx context.Context, id string) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockLinkRepo) DeleteUserData(ctx context.Context, userID string) error {
+	personal := map[string]bool{}
+	for _, note := range m.notes {
+		if note.UserID == userID && note.WorkspaceID == nil {
+			personal[note.ID] = true
+		}
+	}
+	var links []entity.ShareLink
+	for _, item := range m.items {
+		if !personal[item.NoteID] {
+			links = append(links, item)
+		}
+	}
+	m.items = links
+	return nil
+}
+
+func (m *mockLinkRepo) CountView(ctx context.Context, id string) error {
+	for i, item := range m.items {
+		if item.ID == id && (item.MaxViews == 0 || item.Views < item.MaxViews) {
+			m.items[i].Views++
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockLinkRepo) GetNote(ctx context.Context, id string) (entity.Note, error) {
+	for _, note := range m.notes {
+		if note.ID == id && note.DeletedAt == nil {
+			return note, nil
+		}
+	}
+	return entity.Note{}, sql.ErrNoRows
+}
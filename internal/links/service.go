@@ -0,0 +1,227 @@
+package links
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Service encapsulates usecase logic for share links.
+type Service interface {
+	Create(ctx context.Context, noteID string, input CreateLinkRequest) (Link, error)
+	Query(ctx context.Context, noteID string) ([]Link, error)
+	Delete(ctx context.Context, noteID, id string) (Link, error)
+	View(ctx context.Context, token, password string) (PublicNote, error)
+}
+
+// Link represents the data about a share link.
+type Link struct {
+	entity.ShareLink
+	// Protected is true if the link can only be opened with a password.
+	Protected bool `json:"protected"`
+}
+
+// PublicNote represents a note opened through a share link.
+type PublicNote struct {
+	Title     string    `json:"title"`
+	Text      string    `json:"text"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateLinkRequest represents a share link creation request. All the fields are optional.
+type CreateLinkRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxViews  int        `json:"max_views"`
+	Password  string     `json:"password"`
+}
+
+// Validate validates the CreateLinkRequest fields.
+func (m CreateLinkRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.ExpiresAt, validation.By(inFuture)),
+		validation.Field(&m.MaxViews, validation.Min(0)),
+		// bcrypt ignores anything past the first 72 bytes
+		validation.Field(&m.Password, validation.Length(0, 72)),
+	)
+}
+
+// inFuture checks that an optional time is after the current time.
+func inFuture(value interface{}) error {
+	if t, _ := value.(*time.Time); t != nil && !t.After(time.Now()) {
+		return validation.NewError("validation_in_future", "must be in the future")
+	}
+	return nil
+}
+
+// errLinkUnavailable is returned for the links that do not exist, have expired or have no views left,
+// without telling which.
+var errLinkUnavailable = errors.NotFound("the link does not exist or has expired")
+
+// throttledError is returned for a protected link whose password failed too often, with how long the client has
+// to wait before trying again.
+type throttledError struct {
+	wait time.Duration
+}
+
+func (e throttledError) Error() string {
+	return "too many wrong passwords"
+}
+
+// tokenSize is the number of random bytes in a share link token.
+const tokenSize = 32
+
+type service struct {
+	repo     Repository
+	throttle *auth.LoginThrottle
+	logger   log.Logger
+}
+
+// NewService creates a new share link service. The wrong passwords of the protected links are tracked with
+// the throttle, which delays and locks the links that failed too often.
+func NewService(repo Repository, throttle *auth.LoginThrottle, logger log.Logger) Service {
+	return service{repo, throttle, logger}
+}
+
+// Create creates a share link to a note of the current user.
+func (s service) Create(ctx context.Context, noteID string, req CreateLinkRequest) (Link, error) {
+	if err := req.Validate(); err != nil {
+		return Link{}, err
+	}
+	if err := s.checkOwner(ctx, noteID); err != nil {
+		return Link{}, err
+	}
+	token, err := generateToken()
+	if err != nil {
+		return Link{}, err
+	}
+	link := entity.ShareLink{
+		ID:        entity.GenerateID(),
+		NoteID:    noteID,
+		Token:     token,
+		ExpiresAt: req.ExpiresAt,
+		MaxViews:  req.MaxViews,
+		CreatedAt: time.Now(),
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return Link{}, err
+		}
+		link.PasswordHash = string(hash)
+	}
+	if err := s.repo.Create(ctx, link); err != nil {
+		return Link{}, err
+	}
+	return newLink(link), nil
+}
+
+// Query returns the share links to a note of the current user.
+func (s service) Query(ctx context.Context, noteID string) ([]Link, error) {
+	if err := s.checkOwner(ctx, noteID); err != nil {
+		return nil, err
+	}
+	items, err := s.repo.Query(ctx, noteID)
+	if err != nil {
+		return nil, err
+	}
+	result := []Link{}
+	for _, item := range items {
+		result = append(result, newLink(item))
+	}
+	return result, nil
+}
+
+// Delete revokes a share link to a note of the current user.
+func (s service) Delete(ctx context.Context, noteID, id string) (Link, error) {
+	if err := s.checkOwner(ctx, noteID); err != nil {
+		return Link{}, err
+	}
+	link, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return Link{}, err
+	}
+	if link.NoteID != noteID {
+		return Link{}, errors.NotFound("")
+	}
+	if err = s.repo.Delete(ctx, id); err != nil {
+		return Link{}, err
+	}
+	return newLink(link), nil
+}
+
+// View returns the note behind a share link and counts the view. The password must be given for the links
+// protected by one, and a throttledError is returned while the link is throttled after wrong passwords.
+func (s service) View(ctx context.Context, token, password string) (PublicNote, error) {
+	link, err := s.repo.GetByToken(ctx, token)
+	if err == sql.ErrNoRows {
+		return PublicNote{}, errLinkUnavailable
+	} else if err != nil {
+		return PublicNote{}, err
+	}
+	if link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt) {
+		return PublicNote{}, errLinkUnavailable
+	}
+	if link.PasswordHash != "" {
+		if wait := s.throttle.CheckLink(ctx, link.ID); wait > 0 {
+			return PublicNote{}, throttledError{wait}
+		}
+		if password == "" {
+			return PublicNote{}, errors.Unauthorized("a password is required to open this link")
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			s.throttle.FailLink(ctx, link.ID)
+			return PublicNote{}, errors.Unauthorized("the password is incorrect")
+		}
+		s.throttle.SucceedLink(ctx, link.ID)
+	}
+	note, err := s.repo.GetNote(ctx, link.NoteID)
+	if err == sql.ErrNoRows {
+		return PublicNote{}, errLinkUnavailable
+	} else if err != nil {
+		return PublicNote{}, err
+	}
+	if err := s.repo.CountView(ctx, link.ID); err == sql.ErrNoRows {
+		return PublicNote{}, errLinkUnavailable
+	} else if err != nil {
+		return PublicNote{}, err
+	}
+	return PublicNote{Title: note.Title, Text: note.Text, UpdatedAt: note.UpdatedAt}, nil
+}
+
+// checkOwner returns an error unless the note with the specified ID belongs to the current user.
+func (s service) checkOwner(ctx context.Context, noteID string) error {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return errors.Unauthorized("")
+	}
+	note, err := s.repo.GetNote(ctx, noteID)
+	if err != nil {
+		return err
+	}
+	if note.UserID != identity.GetID() {
+		return errors.NotFound("")
+	}
+	return nil
+}
+
+func newLink(link entity.ShareLink) Link {
+	return Link{ShareLink: link, Protected: link.PasswordHash != ""}
+}
+
+// generateToken returns a random URL-safe token.
+func generateToken() (string, error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,110 @@
+package links
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateLinkRequest_Validate(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		model     CreateLinkRequest
+		wantError bool
+	}{
+		{"success", CreateLinkRequest{ExpiresAt: &future, MaxViews: 3, Password: "secret"}, false},
+		{"empty", CreateLinkRequest{}, false},
+		{"expired", CreateLinkRequest{ExpiresAt: &past}, true},
+		{"negative views", CreateLinkRequest{MaxViews: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockLinkRepo{notes: []entity.Note{{ID: "note1", Title: "title1", Text: "text1", UserID: "user1"}}}
+	s := NewService(repo, auth.NewLoginThrottle(auth.ThrottleConfig{MaxFailures: 3, MaxIPFailures: 100, Lockout: time.Minute}, logger), logger)
+
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+	other := auth.WithUser(context.Background(), "user2", "user2")
+
+	// only the owner manages the links of a note
+	_, err := s.Create(context.Background(), "note1", CreateLinkRequest{})
+	assert.Equal(t, errors.Unauthorized(""), err)
+	_, err = s.Create(other, "note1", CreateLinkRequest{})
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Query(other, "note1")
+	assert.Equal(t, errors.NotFound(""), err)
+
+	open, err := s.Create(ctx, "note1", CreateLinkRequest{})
+	assert.Nil(t, err)
+	assert.False(t, open.Protected)
+	assert.Equal(t, 43, len(open.Token))
+	limited, err := s.Create(ctx, "note1", CreateLinkRequest{MaxViews: 1, Password: "secret"})
+	assert.Nil(t, err)
+	assert.True(t, limited.Protected)
+	assert.NotEqual(t, open.Token, limited.Token)
+	links, _ := s.Query(ctx, "note1")
+	assert.Equal(t, 2, len(links))
+
+	// view
+	note, err := s.View(context.Background(), open.Token, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "title1", note.Title)
+	_, err = s.View(context.Background(), "unknown", "")
+	assert.Equal(t, errLinkUnavailable, err)
+
+	// password and view limit
+	_, err = s.View(context.Background(), limited.Token, "")
+	assert.Equal(t, errors.Unauthorized("a password is required to open this link"), err)
+	_, err = s.View(context.Background(), limited.Token, "wrong")
+	assert.Equal(t, errors.Unauthorized("the password is incorrect"), err)
+	_, err = s.View(context.Background(), limited.Token, "secret")
+	assert.Nil(t, err)
+	_, err = s.View(context.Background(), limited.Token, "secret")
+	assert.Equal(t, errLinkUnavailable, err)
+
+	// the passwords of a link are locked after too many wrong ones
+	guarded, err := s.Create(ctx, "note1", CreateLinkRequest{Password: "secret"})
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = s.View(context.Background(), guarded.Token, "wrong")
+		assert.Equal(t, errors.Unauthorized("the password is incorrect"), err)
+	}
+	_, err = s.View(context.Background(), guarded.Token, "secret")
+	if assert.IsType(t, throttledError{}, err) {
+		assert.InDelta(t, float64(time.Minute), float64(err.(throttledError).wait), float64(time.Second))
+	}
+
+	// expiry
+	expired := time.Now().Add(-time.Minute)
+	repo.items = append(repo.items, entity.ShareLink{ID: "old", NoteID: "note1", Token: "old", ExpiresAt: &expired})
+	_, err = s.View(context.Background(), "old", "")
+	assert.Equal(t, errLinkUnavailable, err)
+
+	// revoke
+	_, err = s.Delete(other, "note1", open.ID)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Delete(ctx, "note1", open.ID)
+	assert.Nil(t, err)
+	_, err = s.View(context.Background(), open.Token, "")
+	assert.Equal(t, errLinkUnavailable, err)
+
+	// links stop working once the note is trashed
+	now := time.Now()
+	repo.notes[0].DeletedAt = &now
+	_, err = s.View(context.Background(), limited.Token, "secret")
+	assert.Equal(t, errLinkUnavailable, err)
+}
@@ -0,0 +1,107 @@
+package notebooks
+
+import (
+	"net/http"
+	"strconv"
+
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, rateLimiter routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler) // the following endpoints require a valid JWT or personal access token
+	r.Use(rateLimiter)
+	read, write := auth.RequireScope(auth.ScopeNotesRead), auth.RequireScope(auth.ScopeNotesWrite)
+	r.Get("/notebooks/<id>", read, res.get)
+	r.Get("/notebooks/<id>/tree", read, res.getTree)
+	r.Get("/notebooks", read, res.query)
+	r.Post("/notebooks", write, res.create)
+	r.Put("/notebooks/<id>", write, res.update)
+	r.Delete("/notebooks/<id>", write, res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	notebook, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(notebook)
+}
+
+func (r resource) getTree(c *routing.Context) error {
+	tree, err := r.service.GetTree(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(tree)
+}
+
+func (r resource) query(c *routing.Context) error {
+	notebooks, err := r.service.Query(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	return c.Write(notebooks)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input CreateNotebookRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	notebook, err := r.service.Create(c.Request.Context(), input)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteWithStatus(notebook, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input UpdateNotebookRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	notebook, err := r.service.Update(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(notebook)
+}
+
+// delete deletes a notebook. A notebook that is not empty is only deleted with "cascade=true",
+// which moves all the notes in it and in its nested notebooks to the trash.
+func (r resource) delete(c *routing.Context) error {
+	cascade := false
+	if value := c.Query("cascade"); value != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(value); err != nil {
+			return errors.BadRequest("cascade must be either true or false")
+		}
+	}
+
+	notebook, err := r.service.Delete(c.Request.Context(), c.Param("id"), cascade)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(notebook)
+}
@@ -0,0 +1,54 @@
+package notebooks
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+
+	now := time.Now()
+	parent := "123"
+	repo := &mockNotebookRepo{
+		items: []entity.Notebook{
+			{ID: "123", UserID: "testuser", Name: "work", CreatedAt: now, UpdatedAt: now},
+			{ID: "456", UserID: "testuser", ParentID: &parent, Name: "projects", CreatedAt: now, UpdatedAt: now},
+		},
+		notes: []entity.Note{
+			{ID: "n1", Title: "plan", UserID: "testuser", NotebookID: &parent},
+		},
+	}
+
+	RegisterHandlers(router.Group(""), NewService(repo, logger), auth.MockAuthHandler, auth.MockAuthHandler, logger)
+	header := auth.MockAuthHeader()
+	other := auth.MockAuthHeaderFor("otheruser")
+
+	tests := []test.APITestCase{
+		{"get 123", "GET", "/notebooks/123", "", header, http.StatusOK, `*"name":"work"*`},
+		{"get all", "GET", "/notebooks", "", header, http.StatusOK, `*"name":"projects"*`},
+		{"get other", "GET", "/notebooks/123", "", other, http.StatusNotFound, ""},
+		{"get tree", "GET", "/notebooks/123/tree", "", header, http.StatusOK, `*"children":[{"id":"456"*`},
+		{"get tree notes", "GET", "/notebooks/123/tree", "", header, http.StatusOK, `*"notes":[{"id":"n1","title":"plan"*`},
+		{"create ok", "POST", "/notebooks", `{"name":"archive","parent_id":"456"}`, header, http.StatusCreated, `*"parent_id":"456"*`},
+		{"create auth error", "POST", "/notebooks", `{"name":"test"}`, nil, http.StatusUnauthorized, ""},
+		{"create input error", "POST", "/notebooks", `{"name":""}`, header, http.StatusBadRequest, ""},
+		{"update cycle", "PUT", "/notebooks/123", `{"parent_id":"456"}`, header, http.StatusBadRequest, ""},
+		{"update ok", "PUT", "/notebooks/456", `{"name":"ideas","parent_id":""}`, header, http.StatusOK, `*"parent_id":null*`},
+		{"delete not empty", "DELETE", "/notebooks/123", "", header, http.StatusConflict, ""},
+		{"delete invalid cascade", "DELETE", "/notebooks/123?cascade=maybe", "", header, http.StatusBadRequest, ""},
+		{"delete other", "DELETE", "/notebooks/123?cascade=true", "", other, http.StatusNotFound, ""},
+		{"delete cascade", "DELETE", "/notebooks/123?cascade=true", "", header, http.StatusOK, `*"name":"work"*`},
+		{"delete verify", "GET", "/notebooks/123", "", header, http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,132 @@
+package notebooks
+
+import (
+	"context"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Repository encapsulates the logic to access notebooks from the data source.
+type Repository interface {
+	// Get returns the notebook with the specified notebook ID.
+	Get(ctx context.Context, id string) (entity.Notebook, error)
+	// Query returns the notebooks of the given user ordered by name.
+	Query(ctx context.Context, userID string) ([]entity.Notebook, error)
+	// QuerySubtree returns the notebook with the specified ID together with all the notebooks nested in it.
+	QuerySubtree(ctx context.Context, id string) ([]entity.Notebook, error)
+	// QueryNotes returns the notes filed in the given notebooks, excluding the notes in the trash.
+	QueryNotes(ctx context.Context, notebookIDs []string) ([]entity.Note, error)
+	// Create saves a new notebook in the storage.
+	Create(ctx context.Context, notebook entity.Notebook) error
+	// Update updates the notebook with given ID in the storage.
+	Update(ctx context.Context, notebook entity.Notebook) error
+	// Delete removes the given notebooks from the storage and moves the notes filed in them to the trash.
+	Delete(ctx context.Context, ids []string, deletedAt time.Time) error
+	// DeleteUserData deletes the notebooks of the given user, leaving the notes filed in them unfiled. It runs
+	// within the transaction of the context deleting the account of the user.
+	DeleteUserData(ctx context.Context, userID string) error
+}
+
+// repository persists notebooks in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new notebook repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get reads the notebook with the specified ID from the database.
+func (r repository) Get(ctx context.Context, id string) (entity.Notebook, error) {
+	var notebook entity.Notebook
+	err := r.db.With(ctx).Select().Model(id, &notebook)
+	return notebook, err
+}
+
+// Query retrieves the notebooks of the given user from the database.
+func (r repository) Query(ctx context.Context, userID string) ([]entity.Notebook, error) {
+	var notebooks []entity.Notebook
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"user_id": userID}).
+		OrderBy("name", "id").
+		All(&notebooks)
+	return notebooks, err
+}
+
+// QuerySubtree retrieves the notebook with the specified ID and its descendants from the database.
+func (r repository) QuerySubtree(ctx context.Context, id string) ([]entity.Notebook, error) {
+	var notebooks []entity.Notebook
+	err := r.db.With(ctx).NewQuery(`WITH RECURSIVE subtree AS (
+		SELECT * FROM notebooks WHERE id = {:id}
+		UNION
+		SELECT notebooks.* FROM notebooks JOIN subtree ON notebooks.parent_id = subtree.id
+	) SELECT * FROM subtree ORDER BY name, id`).Bind(dbx.Params{"id": id}).All(&notebooks)
+	return notebooks, err
+}
+
+// QueryNotes retrieves the notes filed in the given notebooks from the database.
+func (r repository) QueryNotes(ctx context.Context, notebookIDs []string) ([]entity.Note, error) {
+	var notes []entity.Note
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.And(dbx.In("notebook_id", stringValues(notebookIDs)...), dbx.NewExp("deleted_at IS NULL"))).
+		OrderBy("title", "id").
+		All(&notes)
+	return notes, err
+}
+
+// Create saves a new notebook record in the database.
+func (r repository) Create(ctx context.Context, notebook entity.Notebook) error {
+	return r.db.With(ctx).Model(&notebook).Insert()
+}
+
+// Update saves the changes to a notebook in the database.
+func (r repository) Update(ctx context.Context, notebook entity.Notebook) error {
+	return r.db.With(ctx).Model(&notebook).Update()
+}
+
+// Delete deletes the given notebooks from the database within a transaction. The notes filed in them are
+// taken out of the notebooks and, unless they already are, moved to the trash.
+func (r repository) Delete(ctx context.Context, ids []string, deletedAt time.Time) error {
+	values := stringValues(ids)
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		_, err := r.db.With(ctx).Update("notes", dbx.Params{
+			"notebook_id": nil,
+			"deleted_at":  dbx.NewExp("COALESCE(deleted_at, {:deleted_at})", dbx.Params{"deleted_at": deletedAt}),
+		}, dbx.In("notebook_id", values...)).Execute()
+		if err != nil {
+			return err
+		}
+		_, err = r.db.With(ctx).Delete("notebooks", dbx.In("id", values...)).Execute()
+		return err
+	})
+}
+
+// stringValues converts the strings into values that can be used in an IN condition.
+func stringValues(items []string) []interface{} {
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		values[i] = item
+	}
+	return values
+}
+
+// DeleteUserData deletes the notebooks of the user from the database, after taking the notes out of them.
+func (r repository) DeleteUserData(ctx context.Context, userID string) error {
+	_, err := r.db.With(ctx).NewQuery("UPDATE notes SET notebook_id = NULL " +
+		"WHERE notebook_id IN (SELECT id FROM notebooks WHERE user_id = {:user})").
+		Bind(dbx.Params{"user": userID}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.With(ctx).Delete("notebooks", dbx.HashExp{"user_id": userID}).Execute()
+	return err
+}
@@ -0,0 +1,171 @@
+package notebooks
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	logger, _ := log.NewForTest()
+	db := test.DB(t)
+	test.ResetTables(t, db, "notebooks", "notes")
+	repo := NewRepository(db, logger)
+
+	ctx := context.Background()
+	now := time.Now()
+	root, child := "nb1", "nb2"
+
+	// create
+	assert.Nil(t, repo.Create(ctx, entity.Notebook{ID: root, UserID: "user1", Name: "root", CreatedAt: now, UpdatedAt: now}))
+	assert.Nil(t, repo.Create(ctx, entity.Notebook{ID: child, UserID: "user1", ParentID: &root, Name: "child", CreatedAt: now, UpdatedAt: now}))
+	assert.Nil(t, repo.Create(ctx, entity.Notebook{ID: "nb3", UserID: "user1", Name: "other", CreatedAt: now, UpdatedAt: now}))
+	notebook, err := repo.Get(ctx, child)
+	assert.Nil(t, err)
+	if assert.NotNil(t, notebook.ParentID) {
+		assert.Equal(t, root, *notebook.ParentID)
+	}
+
+	// update
+	notebook.Name = "renamed"
+	assert.Nil(t, repo.Update(ctx, notebook))
+	notebooks, err := repo.Query(ctx, "user1")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(notebooks))
+
+	// subtree
+	subtree, err := repo.QuerySubtree(ctx, root)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(subtree))
+
+	// notes
+	err = db.With(ctx).Model(&entity.Note{ID: "note1", Title: "title1", Text: "text1", UserID: "user1", CreatedAt: now, UpdatedAt: now, Version: 1, NotebookID: &child}).Insert()
+	assert.Nil(t, err)
+	notes, err := repo.QueryNotes(ctx, []string{root, child})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(notes))
+
+	// delete
+	assert.Nil(t, repo.Delete(ctx, []string{root, child}, now))
+	_, err = repo.Get(ctx, child)
+	assert.Equal(t, sql.ErrNoRows, err)
+	var note entity.Note
+	assert.Nil(t, db.With(ctx).Select().Model("note1", &note))
+	assert.Nil(t, note.NotebookID)
+	assert.NotNil(t, note.DeletedAt)
+	notebooks, _ = repo.Query(ctx, "user1")
+	assert.Equal(t, 1, len(notebooks))
+}
+
+type mockNotebookRepo struct {
+	items []entity.Notebook
+	notes []entity.Note
+}
+
+func (m *mockNotebookRepo) Get(ctx context.Context, id string) (entity.Notebook, error) {
+	for _, item := range m.items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return entity.Notebook{}, sql.ErrNoRows
+}
+
+func (m *mockNotebookRepo) Query(ctx context.Context, userID string) ([]entity.Notebook, error) {
+	var notebooks []entity.Notebook
+	for _, item := range m.items {
+		if item.UserID == userID {
+			notebooks = append(notebooks, item)
+		}
+	}
+	return notebooks, nil
+}
+
+func (m *mockNotebookRepo) QuerySubtree(ctx context.Context, id string) ([]entity.Notebook, error) {
+	var notebooks []entity.Notebook
+	ids := map[string]bool{id: true}
+	for changed := true; changed; {
+		changed = false
+		for _, item := range m.items {
+			if !ids[item.ID] && item.ParentID != nil && ids[*item.ParentID] {
+				ids[item.ID] = true
+				changed = true
+			}
+		}
+	}
+	for _, item := range m.items {
+		if ids[item.ID] {
+			notebooks = append(notebooks, item)
+		}
+	}
+	sort.Slice(notebooks, func(i, j int) bool { return notebooks[i].Name < notebooks[j].Name })
+	return notebooks, nil
+}
+
+func (m *mockNotebookRepo) QueryNotes(ctx context.Context, notebookIDs []string) ([]entity.Note, error) {
+	var notes []entity.Note
+	for _, note := range m.notes {
+		for _, id := range notebookIDs {
+			if note.DeletedAt == nil && note.NotebookID != nil && *note.NotebookID == id {
+				notes = append(notes, note)
+			}
+		}
+	}
+	return notes, nil
+}
+
+func (m *mockNotebookRepo) Create(ctx context.Context, notebook entity.Notebook) error {
+	m.items = append(m.items, notebook)
+	return nil
+}
+
+func (m *mockNotebookRepo) Update(ctx context.Context, notebook entity.Notebook) error {
+	for i, item := range m.items {
+		if item.ID == notebook.ID {
+			m.items[i] = notebook
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockNotebookRepo) Delete(ctx context.Context, ids []string, deletedAt time.Time) error {
+	deleted := map[string]bool{}
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	for i, note := range m.notes {
+		if note.NotebookID != nil && deleted[*note.NotebookID] {
+			m.notes[i].NotebookID = nil
+			if note.DeletedAt == nil {
+				m.notes[i].DeletedAt = &deletedAt
+			}
+		}
+	}
+	var notebooks []entity.Notebook
+	for _, item := range m.items {
+		if !deleted[item.ID] {
+			notebooks = append(notebooks, item)
+		}
+	}
+	m.items = notebooks
+	return nil
+}
+
+func (m *mockNotebookRepo) DeleteUserData(ctx context.Context, userID string) error {
+	var notebooks []entity.Notebook
+	for _, item := range m.items {
+		if item.UserID != userID {
+			notebooks = append(notebooks, item)
+		}
+	}
+	m.items = notebooks
+	return nil
+}
@@ -0,0 +1,276 @@
+package notebooks
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Service encapsulates usecase logic for notebooks.
+type Service interface {
+	Get(ctx context.Context, id string) (Notebook, error)
+	GetTree(ctx context.Context, id string) (Tree, error)
+	Query(ctx context.Context) ([]Notebook, error)
+	Create(ctx context.Context, input CreateNotebookRequest) (Notebook, error)
+	Update(ctx context.Context, id string, input UpdateNotebookRequest) (Notebook, error)
+	Delete(ctx context.Context, id string, cascade bool) (Notebook, error)
+}
+
+// Notebook represents the data about a notebook.
+type Notebook struct {
+	entity.Notebook
+}
+
+// Tree represents a notebook together with its notes and all the notebooks nested in it.
+type Tree struct {
+	Notebook
+	Notes    []NoteSummary `json:"notes"`
+	Children []Tree        `json:"children"`
+}
+
+// NoteSummary represents a note listed in a notebook tree.
+type NoteSummary struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateNotebookRequest represents a notebook creation request.
+// The notebook is created at the top level if ParentID is empty.
+type CreateNotebookRequest struct {
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id"`
+}
+
+// Validate validates the CreateNotebookRequest fields.
+func (m CreateNotebookRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 128)),
+	)
+}
+
+// UpdateNotebookRequest represents a notebook update request.
+// Fields that are not given are left unchanged. An empty ParentID moves the notebook to the top level.
+type UpdateNotebookRequest struct {
+	Name     string  `json:"name"`
+	ParentID *string `json:"parent_id"`
+}
+
+// Validate validates the UpdateNotebookRequest fields.
+func (m UpdateNotebookRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Length(1, 128)),
+	)
+}
+
+type service struct {
+	repo   Repository
+	logger log.Logger
+}
+
+// NewService creates a new notebook service.
+func NewService(repo Repository, logger log.Logger) Service {
+	return service{repo, logger}
+}
+
+// Get returns the notebook with the specified ID if it belongs to the current user.
+func (s service) Get(ctx context.Context, id string) (Notebook, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Notebook{}, errors.Unauthorized("")
+	}
+	notebook, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return Notebook{}, err
+	}
+	if notebook.UserID != identity.GetID() {
+		return Notebook{}, errors.NotFound("")
+	}
+	return Notebook{notebook}, nil
+}
+
+// GetTree returns the notebook with the specified ID with its notes and the whole hierarchy of notebooks nested in it.
+func (s service) GetTree(ctx context.Context, id string) (Tree, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return Tree{}, err
+	}
+	notebooks, err := s.repo.QuerySubtree(ctx, id)
+	if err != nil {
+		return Tree{}, err
+	}
+	ids := make([]string, len(notebooks))
+	children := map[string][]entity.Notebook{}
+	var root entity.Notebook
+	for i, notebook := range notebooks {
+		ids[i] = notebook.ID
+		if notebook.ID == id {
+			root = notebook
+		} else if notebook.ParentID != nil {
+			children[*notebook.ParentID] = append(children[*notebook.ParentID], notebook)
+		}
+	}
+	notes, err := s.repo.QueryNotes(ctx, ids)
+	if err != nil {
+		return Tree{}, err
+	}
+	notesByNotebook := map[string][]NoteSummary{}
+	for _, note := range notes {
+		notesByNotebook[*note.NotebookID] = append(notesByNotebook[*note.NotebookID], NoteSummary{note.ID, note.Title, note.UpdatedAt})
+	}
+	return buildTree(root, children, notesByNotebook), nil
+}
+
+// buildTree assembles the tree rooted at the given notebook from the children and notes of each notebook.
+func buildTree(notebook entity.Notebook, children map[string][]entity.Notebook, notes map[string][]NoteSummary) Tree {
+	tree := Tree{
+		Notebook: Notebook{notebook},
+		Notes:    []NoteSummary{},
+		Children: []Tree{},
+	}
+	if items, ok := notes[notebook.ID]; ok {
+		tree.Notes = items
+	}
+	for _, child := range children[notebook.ID] {
+		tree.Children = append(tree.Children, buildTree(child, children, notes))
+	}
+	return tree
+}
+
+// Query returns the notebooks of the current user.
+func (s service) Query(ctx context.Context) ([]Notebook, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	items, err := s.repo.Query(ctx, identity.GetID())
+	if err != nil {
+		return nil, err
+	}
+	result := []Notebook{}
+	for _, item := range items {
+		result = append(result, Notebook{item})
+	}
+	return result, nil
+}
+
+// Create creates a new notebook for the current user.
+func (s service) Create(ctx context.Context, req CreateNotebookRequest) (Notebook, error) {
+	if err := req.Validate(); err != nil {
+		return Notebook{}, err
+	}
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Notebook{}, errors.Unauthorized("")
+	}
+	now := time.Now()
+	notebook := entity.Notebook{
+		ID:        entity.GenerateID(),
+		UserID:    identity.GetID(),
+		Name:      req.Name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if req.ParentID != "" {
+		if err := s.checkParent(ctx, notebook, req.ParentID); err != nil {
+			return Notebook{}, err
+		}
+		notebook.ParentID = &req.ParentID
+	}
+	if err := s.repo.Create(ctx, notebook); err != nil {
+		return Notebook{}, err
+	}
+	return Notebook{notebook}, nil
+}
+
+// Update renames the notebook with the specified ID or moves it under another parent.
+func (s service) Update(ctx context.Context, id string, req UpdateNotebookRequest) (Notebook, error) {
+	if err := req.Validate(); err != nil {
+		return Notebook{}, err
+	}
+	notebook, err := s.Get(ctx, id)
+	if err != nil {
+		return Notebook{}, err
+	}
+	if req.Name != "" {
+		notebook.Name = req.Name
+	}
+	if req.ParentID != nil {
+		if *req.ParentID == "" {
+			notebook.ParentID = nil
+		} else {
+			if err := s.checkParent(ctx, notebook.Notebook, *req.ParentID); err != nil {
+				return Notebook{}, err
+			}
+			notebook.ParentID = req.ParentID
+		}
+	}
+	notebook.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, notebook.Notebook); err != nil {
+		return Notebook{}, err
+	}
+	return notebook, nil
+}
+
+// Delete deletes the notebook with the specified ID. A notebook that still contains notes or other notebooks
+// is only deleted if cascade is true, in which case the nested notebooks are deleted too and all their notes
+// are moved to the trash. Otherwise a conflict error is returned.
+func (s service) Delete(ctx context.Context, id string, cascade bool) (Notebook, error) {
+	notebook, err := s.Get(ctx, id)
+	if err != nil {
+		return Notebook{}, err
+	}
+	subtree, err := s.repo.QuerySubtree(ctx, id)
+	if err != nil {
+		return Notebook{}, err
+	}
+	ids := make([]string, len(subtree))
+	for i, item := range subtree {
+		ids[i] = item.ID
+	}
+	if !cascade {
+		if len(ids) > 1 {
+			return Notebook{}, errors.Conflict("the notebook contains other notebooks")
+		}
+		notes, err := s.repo.QueryNotes(ctx, ids)
+		if err != nil {
+			return Notebook{}, err
+		}
+		if len(notes) > 0 {
+			return Notebook{}, errors.Conflict("the notebook contains notes")
+		}
+	}
+	if err := s.repo.Delete(ctx, ids, time.Now()); err != nil {
+		return Notebook{}, err
+	}
+	return notebook, nil
+}
+
+// checkParent verifies that the given notebook can be nested in the notebook with the specified parent ID.
+// The parent must be another notebook of the same user, and it must not be nested in the given notebook,
+// as that would introduce a cycle into the hierarchy.
+func (s service) checkParent(ctx context.Context, notebook entity.Notebook, parentID string) error {
+	visited := map[string]bool{}
+	for id := parentID; id != ""; {
+		if id == notebook.ID || visited[id] {
+			return errors.BadRequest("a notebook cannot be nested in itself or in one of its descendants")
+		}
+		visited[id] = true
+		ancestor, err := s.repo.Get(ctx, id)
+		if err == sql.ErrNoRows || (err == nil && ancestor.UserID != notebook.UserID) {
+			return errors.BadRequest("the parent notebook does not exist")
+		} else if err != nil {
+			return err
+		}
+		id = ""
+		if ancestor.ParentID != nil {
+			id = *ancestor.ParentID
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package notebooks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateNotebookRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     CreateNotebookRequest
+		wantError bool
+	}{
+		{"success", CreateNotebookRequest{Name: "work"}, false},
+		{"required", CreateNotebookRequest{Name: ""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, _ := log.NewForTest()
+	s := NewService(&mockNotebookRepo{}, logger)
+
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+	other := auth.WithUser(context.Background(), "user2", "user2")
+
+	// unauthenticated
+	_, err := s.Create(context.Background(), CreateNotebookRequest{Name: "work"})
+	assert.Equal(t, errors.Unauthorized(""), err)
+
+	// create a hierarchy
+	root, err := s.Create(ctx, CreateNotebookRequest{Name: "root"})
+	assert.Nil(t, err)
+	assert.Nil(t, root.ParentID)
+	child, err := s.Create(ctx, CreateNotebookRequest{Name: "child", ParentID: root.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, root.ID, *child.ParentID)
+	_, err = s.Create(ctx, CreateNotebookRequest{Name: "orphan", ParentID: "unknown"})
+	assert.Equal(t, errors.BadRequest("the parent notebook does not exist"), err)
+	_, err = s.Create(other, CreateNotebookRequest{Name: "foreign", ParentID: root.ID})
+	assert.Equal(t, errors.BadRequest("the parent notebook does not exist"), err)
+
+	// access is limited to the owner
+	_, err = s.Get(other, root.ID)
+	assert.Equal(t, errors.NotFound(""), err)
+	notebooks, _ := s.Query(ctx)
+	assert.Equal(t, 2, len(notebooks))
+	notebooks, _ = s.Query(other)
+	assert.Equal(t, 0, len(notebooks))
+
+	// rename keeps the parent
+	child, err = s.Update(ctx, child.ID, UpdateNotebookRequest{Name: "renamed"})
+	assert.Nil(t, err)
+	assert.Equal(t, "renamed", child.Name)
+	assert.Equal(t, root.ID, *child.ParentID)
+
+	// cycles are rejected
+	_, err = s.Update(ctx, root.ID, UpdateNotebookRequest{ParentID: &child.ID})
+	assert.Equal(t, errors.BadRequest("a notebook cannot be nested in itself or in one of its descendants"), err)
+	_, err = s.Update(ctx, root.ID, UpdateNotebookRequest{ParentID: &root.ID})
+	assert.Equal(t, errors.BadRequest("a notebook cannot be nested in itself or in one of its descendants"), err)
+
+	// move to the top level
+	top := ""
+	child, err = s.Update(ctx, child.ID, UpdateNotebookRequest{ParentID: &top})
+	assert.Nil(t, err)
+	assert.Nil(t, child.ParentID)
+
+	// delete
+	_, err = s.Delete(other, child.ID, false)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Delete(ctx, child.ID, false)
+	assert.Nil(t, err)
+	_, err = s.Get(ctx, child.ID)
+	assert.NotNil(t, err)
+}
+
+func Test_service_tree(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNotebookRepo{}
+	s := NewService(repo, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	root, _ := s.Create(ctx, CreateNotebookRequest{Name: "root"})
+	child, _ := s.Create(ctx, CreateNotebookRequest{Name: "child", ParentID: root.ID})
+	grandchild, _ := s.Create(ctx, CreateNotebookRequest{Name: "grandchild", ParentID: child.ID})
+	repo.notes = []entity.Note{
+		{ID: "note1", Title: "in root", UserID: "user1", NotebookID: &root.ID},
+		{ID: "note2", Title: "in grandchild", UserID: "user1", NotebookID: &grandchild.ID},
+	}
+
+	tree, err := s.GetTree(ctx, root.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, root.ID, tree.ID)
+	assert.Equal(t, []NoteSummary{{ID: "note1", Title: "in root"}}, tree.Notes)
+	if assert.Equal(t, 1, len(tree.Children)) && assert.Equal(t, 1, len(tree.Children[0].Children)) {
+		assert.Equal(t, child.ID, tree.Children[0].ID)
+		assert.Equal(t, []NoteSummary{}, tree.Children[0].Notes)
+		assert.Equal(t, "note2", tree.Children[0].Children[0].Notes[0].ID)
+	}
+
+	// a notebook that is not empty is only deleted with cascade
+	_, err = s.Delete(ctx, child.ID, false)
+	assert.Equal(t, errors.Conflict("the notebook contains other notebooks"), err)
+	_, err = s.Delete(ctx, grandchild.ID, false)
+	assert.Equal(t, errors.Conflict("the notebook contains notes"), err)
+	_, err = s.Delete(ctx, child.ID, true)
+	assert.Nil(t, err)
+	notebooks, _ := s.Query(ctx)
+	assert.Equal(t, 1, len(notebooks))
+	assert.NotNil(t, repo.notes[1].DeletedAt)
+	assert.Nil(t, repo.notes[1].NotebookID)
+	assert.Nil(t, repo.notes[0].DeletedAt)
+}
@@ -1,9 +1,15 @@
 package notes
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/qiangxue/go-rest-api/internal/auth"
 	"github.com/qiangxue/go-rest-api/internal/entity"
 	"github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/pkg/log"
@@ -11,24 +17,40 @@ import (
 )
 
 // RegisterHandlers sets up the routing of the HTTP handlers.
-func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, rateLimiter routing.Handler, logger log.Logger) {
-	res := resource{service, logger}
+// cursors signs the cursors handed out when the notes are listed with cursor pagination.
+func RegisterHandlers(r *routing.RouteGroup, service Service, cursors *pagination.Cursors, authHandler routing.Handler, rateLimiter routing.Handler, logger log.Logger) {
+	res := resource{service, cursors, logger}
 
-	r.Use(authHandler) // the following endpoints require a valid JWT
+	r.Use(authHandler) // the following endpoints require a valid JWT or personal access token
 	r.Use(rateLimiter)
-	r.Get("/notes/<id>", res.get)
-	r.Get("/notes", res.query)
-
-	r.Post("/notes", res.create)
-	r.Put("/notes/<id>", res.update)
-	r.Delete("/notes/<id>", res.delete)
-	r.Post("/notes/<note_id>/share/<user_id>", res.share)
-
-	r.Get("/search", res.search) // create separate controller later
+	read, write, share := auth.RequireScope(auth.ScopeNotesRead), auth.RequireScope(auth.ScopeNotesWrite), auth.RequireScope(auth.ScopeShare)
+	r.Get("/notes/<id>", read, res.get)
+	r.Get("/notes", read, res.query)
+
+	r.Post("/notes", write, res.create)
+	r.Put("/notes/<id>", write, res.update)
+	r.Delete("/notes/<id>", write, res.delete)
+	r.Post("/notes/<note_id>/share/<user_id>", share, res.share)
+	r.Post("/notes/<id>/shares", share, res.shareWith)
+	r.Patch("/notes/<note_id>/share/<user_id>", share, res.updateShare)
+	r.Delete("/notes/<note_id>/share/<user_id>", share, res.unshare)
+	r.Get("/notes/<id>/shares", share, res.queryShares)
+
+	r.Get("/notes/<id>/revisions", read, res.queryRevisions)
+	r.Get("/notes/<id>/revisions/<revision>", read, res.getRevision)
+	r.Post("/notes/<id>/revisions/<revision>/restore", write, res.restoreRevision)
+	r.Get("/notes/<id>/diff", read, res.diffRevisions)
+
+	r.Get("/trash", read, res.queryTrash)
+	r.Post("/trash/<id>/restore", write, res.restoreFromTrash)
+
+	r.Get("/search", read, res.search) // create separate controller later
+	r.Get("/search/suggest", read, res.suggest)
 }
 
 type resource struct {
 	service Service
+	cursors *pagination.Cursors
 	logger  log.Logger
 }
 
@@ -39,64 +61,257 @@ func (r resource) get(c *routing.Context) error {
 		return err
 	}
 
+	c.Response.Header().Set("ETag", etag(note.Version))
+	if match := c.Request.Header.Get("If-None-Match"); match != "" {
+		if strings.TrimSpace(match) == "*" || containsVersion(parseETags(match, true), note.Version) {
+			c.Response.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
 	return c.Write(note)
 }
 
+// search returns a page of the notes visible to the current user that match the "q" parameter, most relevant first.
+// The "mode" parameter selects a full-text or a fuzzy search, and "sort" and "order" can replace the ranking.
 func (r resource) search(c *routing.Context) error {
 	ctx := c.Request.Context()
 
 	query := c.Request.URL.Query().Get("q")
-	userId := c.Get("user_id").(string)
+	mode, err := parseSearchMode(c)
+	if err != nil {
+		return err
+	}
+	sort, err := parseSearchSort(c)
+	if err != nil {
+		return err
+	}
+	filter, err := parseNoteFilter(c)
+	if err != nil {
+		return err
+	}
 
-	notes, err := r.service.SearchNotes(ctx, userId, query)
+	count, err := r.service.CountSearch(ctx, query, mode, filter)
 	if err != nil {
 		return err
 	}
+	pages := pagination.NewFromRequest(c.Request, count)
 
-	return c.Write(notes)
+	results, err := r.service.SearchNotes(ctx, query, mode, filter, sort, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = results
+
+	if link := pages.BuildLinkHeader(pageURL(c.Request.URL), pagination.DefaultPageSize); link != "" {
+		c.Response.Header().Set("Link", link)
+	}
+	return c.Write(pages)
 }
 
+// suggest returns the titles and words of the notes visible to the current user that complete the "prefix" parameter.
+func (r resource) suggest(c *routing.Context) error {
+	suggestions, err := r.service.Suggest(c.Request.Context(), c.Query("prefix"))
+	if err != nil {
+		return err
+	}
+	return c.Write(suggestions)
+}
+
+// parseSearchSort reads the order of search results from the "sort" and "order" query parameters.
+// Results are ranked by relevance unless one of the fields of a note listing is given.
+func parseSearchSort(c *routing.Context) (NoteSort, error) {
+	if field := c.Query("sort"); field == "" || field == SortRelevance {
+		return NoteSort{Field: SortRelevance}, nil
+	}
+	return parseNoteSort(c)
+}
+
+// parseSearchMode reads the search mode from the "mode" query parameter, which defaults to a full-text search.
+func parseSearchMode(c *routing.Context) (SearchMode, error) {
+	switch mode := SearchMode(c.Query("mode")); mode {
+	case "":
+		return SearchFullText, nil
+	case SearchFullText, SearchFuzzy:
+		return mode, nil
+	}
+	return "", errors.BadRequest(fmt.Sprintf(`mode must be either "%v" or "%v"`, SearchFullText, SearchFuzzy))
+}
+
+// query returns a page of the notes owned by or shared with the current user.
+// Pages are selected by number, or by cursor if the request has a "cursor" parameter.
+// The links to the neighbouring pages are sent in the Link header.
 func (r resource) query(c *routing.Context) error {
 	ctx := c.Request.Context()
-
-	userId, ok := c.Get("user_id").(string)
-	if !ok {
-		return errors.Unauthorized("user not found")
+	filter, err := parseNoteFilter(c)
+	if err != nil {
+		return err
+	}
+	sort, err := parseNoteSort(c)
+	if err != nil {
+		return err
+	}
+	cursor, ok, err := r.cursors.FromRequest(c.Request)
+	if err != nil {
+		return errors.BadRequest("invalid cursor")
+	}
+	if ok {
+		return r.queryCursor(c, filter, sort, cursor)
 	}
 
-	count, err := r.service.Count(ctx)
+	count, err := r.service.Count(ctx, filter)
 	if err != nil {
 		return err
 	}
 	pages := pagination.NewFromRequest(c.Request, count)
 
-	// fetch notes where user_id = userId
-	notes, err := r.service.QueryByUser(ctx, userId)
+	notes, err := r.service.Query(ctx, filter, sort, pages.Offset(), pages.Limit())
 	if err != nil {
 		return err
 	}
+	pages.Items = notes
 
-	// fetch shared notes
-	sharedNotes, err := r.service.QuerySharedNotes(ctx, userId)
+	if link := pages.BuildLinkHeader(pageURL(c.Request.URL), pagination.DefaultPageSize); link != "" {
+		c.Response.Header().Set("Link", link)
+	}
+	return c.Write(pages)
+}
+
+// queryCursor returns the page of notes that follows or precedes the cursor, along with the cursors of the neighbouring pages.
+func (r resource) queryCursor(c *routing.Context, filter NoteFilter, sort NoteSort, cursor *pagination.Cursor) error {
+	pages := pagination.NewCursorPagesFromRequest(c.Request)
+	notes, more, err := r.service.QueryCursor(c.Request.Context(), filter, sort, cursor, pages.Limit())
 	if err != nil {
 		return err
 	}
-	notes = append(notes, sharedNotes...)
-
 	pages.Items = notes
 
+	backward := cursor != nil && cursor.Backward
+	// there are notes after the page if more were found going forward or if the page was reached going backward,
+	// and likewise for the notes before the page
+	hasNext, hasPrev := more, cursor != nil
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+	if len(notes) > 0 {
+		if hasNext {
+			pages.NextCursor = r.cursors.Encode(sort.Cursor(notes[len(notes)-1], false))
+		}
+		if hasPrev {
+			pages.PrevCursor = r.cursors.Encode(sort.Cursor(notes[0], true))
+		}
+	} else if cursor != nil {
+		// an empty page turns back at the position of the cursor
+		turned := *cursor
+		turned.Backward = !backward
+		if backward {
+			pages.NextCursor = r.cursors.Encode(turned)
+		} else {
+			pages.PrevCursor = r.cursors.Encode(turned)
+		}
+	}
+
+	if link := pages.BuildLinkHeader(pageURL(c.Request.URL), pagination.DefaultPageSize); link != "" {
+		c.Response.Header().Set("Link", link)
+	}
 	return c.Write(pages)
 }
 
+// pageURL returns the given request URL without the pagination parameters, to be used as the base of the page links.
+func pageURL(u *url.URL) string {
+	query := u.Query()
+	query.Del(pagination.PageVar)
+	query.Del(pagination.PageSizeVar)
+	query.Del(pagination.CursorVar)
+	if len(query) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + query.Encode()
+}
+
+// parseNoteFilter reads the note filter from the "tag", "tag_mode", "created_after" and "updated_before" query parameters.
+// Tags may be given by repeating the "tag" parameter or as a comma-separated list. By default
+// the notes must carry all of the tags, while "tag_mode=or" matches the notes carrying any of them.
+// Times are expected in RFC 3339 format.
+func parseNoteFilter(c *routing.Context) (NoteFilter, error) {
+	var names []string
+	for _, value := range c.Request.URL.Query()["tag"] {
+		names = append(names, strings.Split(value, ",")...)
+	}
+	filter := NoteFilter{Tags: normalizeTags(names)}
+	switch c.Query("tag_mode") {
+	case "", "and":
+	case "or":
+		filter.AnyTag = true
+	default:
+		return NoteFilter{}, errors.BadRequest(`tag_mode must be either "and" or "or"`)
+	}
+	var err error
+	if filter.CreatedAfter, err = parseTime("created_after", c.Query("created_after")); err != nil {
+		return NoteFilter{}, err
+	}
+	if filter.UpdatedBefore, err = parseTime("updated_before", c.Query("updated_before")); err != nil {
+		return NoteFilter{}, err
+	}
+	return filter, nil
+}
+
+// parseTime parses a time taken from the named request parameter. A zero time is returned if the value is empty.
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.BadRequest(fmt.Sprintf("%v must be a time in RFC 3339 format", name))
+	}
+	return t, nil
+}
+
+// parseNoteSort reads the order of a note listing from the "sort" and "order" query parameters.
+// Notes are sorted by the time they were last updated unless another field is given. Dates are sorted
+// in descending order by default, and titles in ascending order.
+func parseNoteSort(c *routing.Context) (NoteSort, error) {
+	sort := NoteSort{Field: c.Query("sort", SortUpdatedAt)}
+	switch sort.Field {
+	case SortUpdatedAt, SortCreatedAt:
+		sort.Desc = true
+	case SortTitle:
+	default:
+		return NoteSort{}, errors.BadRequest(fmt.Sprintf(`sort must be one of "%v", "%v" and "%v"`, SortUpdatedAt, SortCreatedAt, SortTitle))
+	}
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		sort.Desc = false
+	case "desc":
+		sort.Desc = true
+	default:
+		return NoteSort{}, errors.BadRequest(`order must be either "asc" or "desc"`)
+	}
+	return sort, nil
+}
+
+// share shares a note with a user. The role can be given in the optional request body.
 func (r resource) share(c *routing.Context) error {
 	note_id := c.Param("note_id")
 	user_id := c.Param("user_id")
 
+	var body struct {
+		Role string `json:"role"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.Read(&body); err != nil {
+			r.logger.With(c.Request.Context()).Info(err)
+			return errors.BadRequest("")
+		}
+	}
 	id := entity.GenerateID()
 	input := ShareNoteRequest{
 		ID:           id,
 		NoteID:       note_id,
 		SharedUserID: user_id,
+		Role:         body.Role,
 	}
 	note, err := r.service.ShareNote(c.Request.Context(), c.Param("note_id"), input)
 	if err != nil {
@@ -106,20 +321,69 @@ func (r resource) share(c *routing.Context) error {
 	return c.Write(note)
 }
 
+// shareWith shares a note with the user given by username in the request body.
+func (r resource) shareWith(c *routing.Context) error {
+	var input ShareNoteRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	input.NoteID = c.Param("id")
+
+	share, err := r.service.ShareNote(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteWithStatus(share, http.StatusCreated)
+}
+
+func (r resource) updateShare(c *routing.Context) error {
+	var input UpdateShareRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	share, err := r.service.UpdateShare(c.Request.Context(), c.Param("note_id"), c.Param("user_id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(share)
+}
+
+func (r resource) unshare(c *routing.Context) error {
+	share, err := r.service.Unshare(c.Request.Context(), c.Param("note_id"), c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(share)
+}
+
+func (r resource) queryShares(c *routing.Context) error {
+	shares, err := r.service.QueryShares(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(shares)
+}
+
 func (r resource) create(c *routing.Context) error {
 	var input CreateNoteRequest
 	if err := c.Read(&input); err != nil {
 		r.logger.With(c.Request.Context()).Info(err)
 		return errors.BadRequest("")
 	}
-	userId := c.Get("user_id").(string)
-	input.UserID = userId
 
 	note, err := r.service.Create(c.Request.Context(), input)
 	if err != nil {
 		return err
 	}
 
+	c.Response.Header().Set("ETag", etag(note.Version))
 	return c.WriteWithStatus(note, http.StatusCreated)
 }
 
@@ -130,19 +394,172 @@ func (r resource) update(c *routing.Context) error {
 		return errors.BadRequest("")
 	}
 
-	note, err := r.service.Update(c.Request.Context(), c.Param("id"), input)
+	version, err := r.ifMatchVersion(c)
 	if err != nil {
 		return err
 	}
 
+	note, err := r.service.Update(c.Request.Context(), c.Param("id"), version, input)
+	if err != nil {
+		return err
+	}
+
+	c.Response.Header().Set("ETag", etag(note.Version))
 	return c.Write(note)
 }
 
 func (r resource) delete(c *routing.Context) error {
-	note, err := r.service.Delete(c.Request.Context(), c.Param("id"))
+	version, err := r.ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	note, err := r.service.Delete(c.Request.Context(), c.Param("id"), version)
 	if err != nil {
 		return err
 	}
 
 	return c.Write(note)
 }
+
+// ifMatchVersion returns the note version that the If-Match header of the request requires.
+// Zero is returned if the header is absent or is "*", in which case any version is acceptable.
+func (r resource) ifMatchVersion(c *routing.Context) (int, error) {
+	header := c.Request.Header.Get("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return 0, nil
+	}
+	versions := parseETags(header, false)
+	switch len(versions) {
+	case 0:
+		return 0, errors.PreconditionFailed("")
+	case 1:
+		return versions[0], nil
+	}
+	// several entity tags are given: the request may proceed if any of them is the current one
+	note, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return 0, err
+	}
+	if !containsVersion(versions, note.Version) {
+		return 0, errors.PreconditionFailed("")
+	}
+	return note.Version, nil
+}
+
+// etag returns the entity tag identifying the given version of a note.
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// parseETags returns the note versions listed in an If-Match or If-None-Match header.
+// Weak entity tags are only accepted if weak is true, as If-None-Match uses the weak comparison
+// while If-Match uses the strong one. Tags that do not identify a note version are ignored.
+func parseETags(header string, weak bool) []int {
+	var versions []int
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		if version, err := strconv.Atoi(tag[1 : len(tag)-1]); err == nil && version > 0 {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// containsVersion reports whether the given version is in the list.
+func containsVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (r resource) queryTrash(c *routing.Context) error {
+	notes, err := r.service.QueryTrash(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	return c.Write(notes)
+}
+
+func (r resource) restoreFromTrash(c *routing.Context) error {
+	note, err := r.service.RestoreFromTrash(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(note)
+}
+
+func (r resource) queryRevisions(c *routing.Context) error {
+	revisions, err := r.service.QueryRevisions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(revisions)
+}
+
+func (r resource) getRevision(c *routing.Context) error {
+	revision, err := parseRevision("revision", c.Param("revision"))
+	if err != nil {
+		return err
+	}
+
+	rev, err := r.service.GetRevision(c.Request.Context(), c.Param("id"), revision)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(rev)
+}
+
+func (r resource) restoreRevision(c *routing.Context) error {
+	revision, err := parseRevision("revision", c.Param("revision"))
+	if err != nil {
+		return err
+	}
+
+	note, err := r.service.RestoreRevision(c.Request.Context(), c.Param("id"), revision)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(note)
+}
+
+func (r resource) diffRevisions(c *routing.Context) error {
+	from, err := parseRevision("from", c.Query("from"))
+	if err != nil {
+		return err
+	}
+	to, err := parseRevision("to", c.Query("to"))
+	if err != nil {
+		return err
+	}
+
+	diff, err := r.service.DiffRevisions(c.Request.Context(), c.Param("id"), from, to)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(diff)
+}
+
+// parseRevision parses a revision number taken from the named request parameter.
+func parseRevision(name, value string) (int, error) {
+	revision, err := strconv.Atoi(value)
+	if err != nil || revision < 1 {
+		return 0, errors.BadRequest(fmt.Sprintf("%v must be a positive revision number", name))
+	}
+	return revision, nil
+}
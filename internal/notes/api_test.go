@@ -2,6 +2,7 @@ package notes
 
 import (
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
@@ -9,6 +10,8 @@ import (
 	"github.com/qiangxue/go-rest-api/internal/entity"
 	"github.com/qiangxue/go-rest-api/internal/test"
 	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/qiangxue/go-rest-api/pkg/pagination"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestAPI(t *testing.T) {
@@ -17,30 +20,138 @@ func TestAPI(t *testing.T) {
 
 	now := time.Now()
 	repo := &mockNoteRepo{items: []entity.Note{
-		{"123", "note123", "text123", "text_searchable123", "testuser", now, now},
+		{ID: "123", Title: "note123", Text: "text123", UserID: "testuser", CreatedAt: now, UpdatedAt: now, Version: 1},
+	}, notebooks: []entity.Notebook{
+		{ID: "nb1", UserID: "testuser", Name: "work", CreatedAt: now, UpdatedAt: now},
 	}}
 
 	// ignore rate limiter and use mock auth handler itself for now
-	RegisterHandlers(router.Group(""), NewService(repo, logger), auth.MockAuthHandler, auth.MockAuthHandler, logger)
+	RegisterHandlers(router.Group(""), NewService(repo, NewMemorySearchIndex(repo), mockUsers{"other": "otheruser"}, 0, logger), pagination.NewCursors("secret"), auth.MockAuthHandler, auth.MockAuthHandler, logger)
 	header := auth.MockAuthHeader()
+	other := auth.MockAuthHeaderFor("otheruser")
+	readOnly := auth.MockAuthHeaderWithScopes("testuser", auth.ScopeNotesRead)
 
 	tests := []test.APITestCase{
 		{"get 123", "GET", "/notes/123", "", header, http.StatusOK, `*text123*`},
+		{"get not modified", "GET", "/notes/123", "", withHeader(header, "If-None-Match", `"1"`), http.StatusNotModified, ""},
+		{"get modified", "GET", "/notes/123", "", withHeader(header, "If-None-Match", `"7"`), http.StatusOK, `*text123*`},
 		{"get all", "GET", "/notes", "", header, http.StatusOK, `*text123*`},
 		{"get unknown", "GET", "/albums/1234", "", header, http.StatusNotFound, ""},
 		{"create ok", "POST", "/notes", `{"title":"test", "text": "text1"}`, header, http.StatusCreated, "*test*"},
 		{"create ok count", "GET", "/notes", "", header, http.StatusOK, `*"total_count":2*`},
 		{"create auth error", "POST", "/notes", `{"title":"test2", "text": "text2"}`, nil, http.StatusUnauthorized, ""},
+		{"get read scope", "GET", "/notes/123", "", readOnly, http.StatusOK, `*text123*`},
+		{"create read scope", "POST", "/notes", `{"title":"test2", "text": "text2"}`, readOnly, http.StatusForbidden, ""},
+		{"share read scope", "GET", "/notes/123/shares", "", readOnly, http.StatusForbidden, ""},
 		{"create input error", "POST", "/notes", `{"title":"test2"}`, header, http.StatusBadRequest, ""},
-		{"update ok", "PUT", "/notes/123", `{"title":"test_changed"}`, header, http.StatusOK, "*test_changed*"},
+		{"create unknown notebook", "POST", "/notes", `{"title":"test2", "text": "text2", "notebook_id": "999"}`, header, http.StatusBadRequest, ""},
+		{"update stale", "PUT", "/notes/123", `{"title":"stale"}`, withHeader(header, "If-Match", `"7"`), http.StatusPreconditionFailed, ""},
+		{"update weak etag", "PUT", "/notes/123", `{"title":"stale"}`, withHeader(header, "If-Match", `W/"1"`), http.StatusPreconditionFailed, ""},
+		{"update ok", "PUT", "/notes/123", `{"title":"test_changed"}`, withHeader(header, "If-Match", `"1"`), http.StatusOK, `*"version":2*`},
+		{"update stale after change", "PUT", "/notes/123", `{"title":"stale"}`, withHeader(header, "If-Match", `"1"`), http.StatusPreconditionFailed, ""},
+		{"update any etag", "PUT", "/notes/123", `{"title":"test_changed"}`, withHeader(header, "If-Match", `"1", "3"`), http.StatusPreconditionFailed, ""},
 		{"update verify", "GET", "/notes/123", "", header, http.StatusOK, `*test_changed*`},
+		{"update keeps text", "GET", "/notes/123", "", header, http.StatusOK, `*"text":"text123"*`},
+		{"move to notebook", "PUT", "/notes/123", `{"notebook_id":"nb1"}`, header, http.StatusOK, `*"title":"test_changed","text":"text123"*`},
+		{"move verify", "GET", "/notes/123", "", header, http.StatusOK, `*"notebook_id":"nb1"*`},
+		{"update empty title", "PUT", "/notes/123", `{"title":""}`, header, http.StatusBadRequest, ""},
 		{"update auth error", "PUT", "/notes/123", `{"title":"notesxyz"}`, nil, http.StatusUnauthorized, ""},
 		{"update input error", "PUT", "/notes/123", `"name":"notesxyz"}`, header, http.StatusBadRequest, ""},
-		{"delete ok", "DELETE", "/notes/123", ``, header, http.StatusOK, "*test_changed*"},
+		{"other get not shared", "GET", "/notes/123", "", other, http.StatusNotFound, ""},
+		{"other update not shared", "PUT", "/notes/123", `{"title":"hijacked"}`, other, http.StatusNotFound, ""},
+		{"other delete not shared", "DELETE", "/notes/123", ``, other, http.StatusNotFound, ""},
+		{"other share not shared", "POST", "/notes/123/share/otheruser", ``, other, http.StatusNotFound, ""},
+		{"other get all", "GET", "/notes", "", other, http.StatusOK, `*"total_count":0*`},
+		{"share invalid role", "POST", "/notes/123/share/otheruser", `{"role":"owner"}`, header, http.StatusBadRequest, ""},
+		{"share ok", "POST", "/notes/123/share/otheruser", `{"role":"editor"}`, header, http.StatusOK, `*"shared_user_id":"otheruser"*`},
+		{"other get shared", "GET", "/notes/123", "", other, http.StatusOK, `*test_changed*`},
+		{"other update shared", "PUT", "/notes/123", `{"text":"edited by other"}`, other, http.StatusOK, "*edited by other*"},
+		{"other delete shared", "DELETE", "/notes/123", ``, other, http.StatusForbidden, ""},
+		{"shares", "GET", "/notes/123/shares", "", header, http.StatusOK, `*"role":"editor"*`},
+		{"other shares", "GET", "/notes/123/shares", "", other, http.StatusOK, `*"shared_user_id":"otheruser"*`},
+		{"stranger shares", "GET", "/notes/123/shares", "", auth.MockAuthHeaderFor("stranger"), http.StatusNotFound, ""},
+		{"other update share", "PATCH", "/notes/123/share/otheruser", `{"role":"editor"}`, other, http.StatusForbidden, ""},
+		{"update share invalid", "PATCH", "/notes/123/share/otheruser", `{"role":"owner"}`, header, http.StatusBadRequest, ""},
+		{"update share unknown", "PATCH", "/notes/123/share/nobody", `{"role":"viewer"}`, header, http.StatusNotFound, ""},
+		{"update share ok", "PATCH", "/notes/123/share/otheruser", `{"role":"viewer"}`, header, http.StatusOK, `*"role":"viewer"*`},
+		{"other get viewer", "GET", "/notes/123", "", other, http.StatusOK, `*test_changed*`},
+		{"other update viewer", "PUT", "/notes/123", `{"title":"by viewer"}`, other, http.StatusForbidden, ""},
+		{"unshare unknown", "DELETE", "/notes/123/share/nobody", "", header, http.StatusNotFound, ""},
+		{"unshare ok", "DELETE", "/notes/123/share/otheruser", "", header, http.StatusOK, `*"shared_user_id":"otheruser"*`},
+		{"other get unshared", "GET", "/notes/123", "", other, http.StatusNotFound, ""},
+		{"share by username unknown", "POST", "/notes/123/shares", `{"username":"nobody"}`, header, http.StatusNotFound, ""},
+		{"share by username owner", "POST", "/notes/123/shares", `{"username":"other","role":"owner"}`, header, http.StatusBadRequest, ""},
+		{"share by username ok", "POST", "/notes/123/shares", `{"username":"other","role":"editor"}`, header, http.StatusCreated, `*"shared_user_id":"otheruser"*`},
+		{"share by username again", "POST", "/notes/123/shares", `{"username":"other"}`, header, http.StatusConflict, ""},
+		{"unshare by username", "DELETE", "/notes/123/share/otheruser", "", header, http.StatusOK, `*"shared_user_id":"otheruser"*`},
+		{"revisions", "GET", "/notes/123/revisions", "", header, http.StatusOK, `*"revision":2*`},
+		{"get revision", "GET", "/notes/123/revisions/1", "", header, http.StatusOK, `*"revision":1*`},
+		{"get revision invalid", "GET", "/notes/123/revisions/abc", "", header, http.StatusBadRequest, ""},
+		{"get revision unknown", "GET", "/notes/123/revisions/99", "", header, http.StatusNotFound, ""},
+		{"diff", "GET", "/notes/123/diff?from=1&to=2", "", header, http.StatusOK, `*"op":"equal"*`},
+		{"diff missing revision", "GET", "/notes/123/diff?from=1", "", header, http.StatusBadRequest, ""},
+		{"restore", "POST", "/notes/123/revisions/1/restore", "", header, http.StatusOK, `*test_changed*`},
+		{"other revisions not shared", "GET", "/notes/123/revisions", "", auth.MockAuthHeaderFor("stranger"), http.StatusNotFound, ""},
+		{"delete stale", "DELETE", "/notes/123", ``, withHeader(header, "If-Match", `"1"`), http.StatusPreconditionFailed, ""},
+		{"delete ok", "DELETE", "/notes/123", ``, withHeader(header, "If-Match", "*"), http.StatusOK, "*test_changed*"},
 		{"delete verify", "DELETE", "/notes/123", ``, header, http.StatusNotFound, ""},
+		{"trash", "GET", "/trash", "", header, http.StatusOK, `*"deleted_at"*`},
+		{"trash other", "GET", "/trash", "", other, http.StatusOK, `[]`},
+		{"restore other", "POST", "/trash/123/restore", "", other, http.StatusNotFound, ""},
+		{"restore ok", "POST", "/trash/123/restore", "", header, http.StatusOK, "*test_changed*"},
+		{"restore verify", "GET", "/notes/123", "", header, http.StatusOK, "*test_changed*"},
 		{"delete auth error", "DELETE", "/notes/123", ``, nil, http.StatusUnauthorized, ""},
+		{"create tagged", "POST", "/notes", `{"title":"tagged", "text": "text2", "tags": ["#Work", "urgent"]}`, header, http.StatusCreated, `*"tags":["urgent","work"]*`},
+		{"filter tag", "GET", "/notes?tag=work", "", header, http.StatusOK, `*"total_count":1*`},
+		{"filter tags and", "GET", "/notes?tag=work&tag=home", "", header, http.StatusOK, `*"total_count":0*`},
+		{"filter tags or", "GET", "/notes?tag=work,home&tag_mode=or", "", header, http.StatusOK, `*"total_count":1*`},
+		{"filter tag mode invalid", "GET", "/notes?tag=work&tag_mode=xor", "", header, http.StatusBadRequest, ""},
+		{"sort by title", "GET", "/notes?sort=title&order=desc&per_page=1&page=2", "", header, http.StatusOK, `*"title":"test",*`},
+		{"sort invalid", "GET", "/notes?sort=text", "", header, http.StatusBadRequest, ""},
+		{"order invalid", "GET", "/notes?order=up", "", header, http.StatusBadRequest, ""},
+		{"filter created after", "GET", "/notes?created_after=2999-01-01T00:00:00Z", "", header, http.StatusOK, `*"total_count":0*`},
+		{"cursor first page", "GET", "/notes?cursor=&sort=title&per_page=1", "", header, http.StatusOK, `*"next_cursor":"*`},
+		{"cursor invalid", "GET", "/notes?cursor=abc", "", header, http.StatusBadRequest, ""},
+		{"filter updated before invalid", "GET", "/notes?updated_before=yesterday", "", header, http.StatusBadRequest, ""},
+		{"search", "GET", "/search?q=text2", "", header, http.StatusOK, `*"headline":"<mark>text2</mark>"*`},
+		{"search paginated", "GET", "/search?q=text2&per_page=1", "", header, http.StatusOK, `*"per_page":1,"page_count":1,"total_count":1*`},
+		{"search query language", "GET", `/search?q=tag:work+owner:me+shared:false+-draft+"text2"`, "", header, http.StatusOK, `*"total_count":1*`},
+		{"search syntax error", "GET", "/search?q=text2+shared:maybe", "", header, http.StatusBadRequest, `*"details":{"position":14}*`},
+		{"search empty query", "GET", "/search?q=", "", header, http.StatusBadRequest, ""},
+		{"search tag mode invalid", "GET", "/search?q=text&tag=work&tag_mode=xor", "", header, http.StatusBadRequest, ""},
+		{"search fuzzy", "GET", "/search?q=txet2&mode=fuzzy", "", header, http.StatusOK, `*"total_count":0*`},
+		{"search fuzzy typo", "GET", "/search?q=texxt2&mode=fuzzy", "", header, http.StatusOK, `*"total_count":1*`},
+		{"search sorted", "GET", "/search?q=text2&sort=title&order=desc", "", header, http.StatusOK, `*"total_count":1*`},
+		{"search sort invalid", "GET", "/search?q=text2&sort=rank", "", header, http.StatusBadRequest, ""},
+		{"search mode invalid", "GET", "/search?q=text2&mode=exact", "", header, http.StatusBadRequest, ""},
+		{"suggest", "GET", "/search/suggest?prefix=tex", "", header, http.StatusOK, `*{"text":"text2","count":1}*`},
+		{"suggest empty prefix", "GET", "/search/suggest?prefix=", "", header, http.StatusBadRequest, ""},
+		{"suggest auth error", "GET", "/search/suggest?prefix=tex", "", nil, http.StatusUnauthorized, ""},
 	}
 	for _, tc := range tests {
 		test.Endpoint(t, router, tc)
 	}
 }
+
+// withHeader returns a copy of the header with the given field added.
+func withHeader(header http.Header, key, value string) http.Header {
+	h := header.Clone()
+	h.Set(key, value)
+	return h
+}
+
+func Test_pageURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/api/notes", "/api/notes"},
+		{"/api/notes?page=2&per_page=10", "/api/notes"},
+		{"/api/notes?sort=title&page=2", "/api/notes?sort=title"},
+		{"/api/notes?sort=title&cursor=abc", "/api/notes?sort=title"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		assert.Equal(t, tt.want, pageURL(u))
+	}
+}
@@ -0,0 +1,75 @@
+package notes
+
+import "strings"
+
+const (
+	// DiffEqual marks a line present in both versions.
+	DiffEqual = "equal"
+	// DiffInsert marks a line present only in the newer version.
+	DiffInsert = "insert"
+	// DiffDelete marks a line present only in the older version.
+	DiffDelete = "delete"
+)
+
+// DiffLine represents a single line of a line-based diff.
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// diffLines computes the line-based diff that turns a into b.
+// It is based on the longest common subsequence of the lines, so unchanged lines are kept as DiffEqual
+// and every other line is reported as either DiffDelete or DiffInsert.
+func diffLines(a, b string) []DiffLine {
+	x, y := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	result := []DiffLine{}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			result = append(result, DiffLine{DiffEqual, x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, DiffLine{DiffDelete, x[i]})
+			i++
+		default:
+			result = append(result, DiffLine{DiffInsert, y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		result = append(result, DiffLine{DiffDelete, x[i]})
+	}
+	for ; j < len(y); j++ {
+		result = append(result, DiffLine{DiffInsert, y[j]})
+	}
+	return result
+}
+
+// splitLines splits the text into lines. An empty text has no lines.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
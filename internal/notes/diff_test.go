@@ -0,0 +1,27 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_diffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffLine
+	}{
+		{"both empty", "", "", []DiffLine{}},
+		{"added", "", "a\nb", []DiffLine{{DiffInsert, "a"}, {DiffInsert, "b"}}},
+		{"removed", "a\nb", "", []DiffLine{{DiffDelete, "a"}, {DiffDelete, "b"}}},
+		{"unchanged", "a\nb", "a\nb", []DiffLine{{DiffEqual, "a"}, {DiffEqual, "b"}}},
+		{"changed line", "a\nb\nc", "a\nx\nc", []DiffLine{{DiffEqual, "a"}, {DiffDelete, "b"}, {DiffInsert, "x"}, {DiffEqual, "c"}}},
+		{"windows line endings", "a\r\nb", "a\nb\nc", []DiffLine{{DiffEqual, "a"}, {DiffEqual, "b"}, {DiffInsert, "c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, diffLines(tt.a, tt.b))
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package notes
+
+import (
+	"context"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Purger permanently removes the notes that have stayed in the trash longer than the retention period.
+type Purger struct {
+	repo      Repository
+	retention time.Duration
+	interval  time.Duration
+	logger    log.Logger
+}
+
+// NewPurger creates a new purger that removes notes trashed more than retention ago, checking every interval.
+func NewPurger(repo Repository, retention, interval time.Duration, logger log.Logger) *Purger {
+	return &Purger{repo, retention, interval, logger}
+}
+
+// Run purges the expired notes immediately and then once every interval until the context is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := p.Purge(ctx); err != nil {
+			p.logger.With(ctx).Errorf("failed to purge trashed notes: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge permanently removes the notes trashed before the retention window and returns how many were removed.
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	count, err := p.repo.Purge(ctx, time.Now().Add(-p.retention))
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		p.logger.With(ctx).Infof("purged %d notes from the trash", count)
+	}
+	return count, nil
+}
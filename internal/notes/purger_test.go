@@ -0,0 +1,46 @@
+package notes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurger_Purge(t *testing.T) {
+	logger, entries := log.NewForTest()
+	now := time.Now()
+	expired, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	repo := &mockNoteRepo{items: []entity.Note{
+		{ID: "active", UserID: "user1"},
+		{ID: "expired", UserID: "user1", DeletedAt: &expired},
+		{ID: "recent", UserID: "user1", DeletedAt: &recent},
+	}}
+	p := NewPurger(repo, 24*time.Hour, time.Hour, logger)
+
+	count, err := p.Purge(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 1, entries.Len())
+	_, err = repo.Get(context.Background(), "expired")
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, len(repo.items))
+
+	count, _ = p.Purge(context.Background())
+	assert.Zero(t, count)
+}
+
+func TestPurger_Run(t *testing.T) {
+	logger, _ := log.NewForTest()
+	expired := time.Now().Add(-48 * time.Hour)
+	repo := &mockNoteRepo{items: []entity.Note{{ID: "expired", UserID: "user1", DeletedAt: &expired}}}
+	p := NewPurger(repo, 24*time.Hour, time.Hour, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Run(ctx)
+	assert.Zero(t, len(repo.items))
+}
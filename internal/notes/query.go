@@ -0,0 +1,263 @@
+package notes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+)
+
+// SearchQuery is a search query parsed from the query language accepted by the search endpoint, e.g.
+//
+//	tag:work owner:me shared:true before:2025-01-01 "exact phrase" -draft title:plan
+//
+// Words and quoted phrases must appear in the title or text of a note, and are excluded when prefixed by "-".
+// The fields restrict the notes searched: "tag" to the notes with the tag, "owner" to the notes owned by the
+// user with the given ID (or "me"), "shared" to the notes shared with the current user (or not shared with them),
+// "before" and "after" to the notes updated before or after a date, and "title" to the notes whose title contains
+// the word or phrase.
+type SearchQuery struct {
+	// Terms are the words that must appear in the title or text.
+	Terms []string
+	// Phrases are the phrases that must appear in the title or text.
+	Phrases []string
+	// Excluded are the words and phrases that must not appear in the title or text.
+	Excluded []string
+	// Title are the words and phrases that must appear in the title.
+	Title []string
+	// Tags are the names of the tags the notes must carry.
+	Tags []string
+	// Owner is the ID of the user owning the notes, or empty for any owner.
+	Owner string
+	// Shared, if not nil, selects the notes shared with the current user, or the ones not shared with them.
+	Shared *bool
+	// After selects the notes updated at or after the time, unless it is zero.
+	After time.Time
+	// Before selects the notes updated before the time, unless it is zero.
+	Before time.Time
+	// Fuzzy matches the words and phrases to find by trigram similarity rather than by their stems, which tolerates
+	// typos. Excluded words and phrases are still matched by their stems.
+	Fuzzy bool
+}
+
+// HasText reports whether the query searches the title or text of the notes, rather than only filtering them.
+func (q SearchQuery) HasText() bool {
+	return len(q.Terms) > 0 || len(q.Phrases) > 0 || len(q.Excluded) > 0 || len(q.Title) > 0
+}
+
+// ownerMe is the owner field value standing for the current user.
+const ownerMe = "me"
+
+// searchDateLayout is the layout of the dates given to the "before" and "after" fields.
+const searchDateLayout = "2006-01-02"
+
+// QuerySyntaxError describes a problem found while parsing a search query.
+type QuerySyntaxError struct {
+	// Pos is the 1-based position of the character at which the problem was found.
+	Pos int
+	// Msg describes the problem.
+	Msg string
+}
+
+// Error returns the error message including the position of the problem.
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("%v at position %v", e.Msg, e.Pos)
+}
+
+// BadRequest converts the error into a bad request error response carrying the position in its details.
+func (e *QuerySyntaxError) BadRequest() errors.ErrorResponse {
+	res := errors.BadRequest(e.Error())
+	res.Details = map[string]int{"position": e.Pos}
+	return res
+}
+
+// ParseSearchQuery parses a search query. A *QuerySyntaxError is returned if the query is malformed.
+func ParseSearchQuery(input string) (SearchQuery, error) {
+	p := queryParser{input: []rune(input)}
+	return p.parse()
+}
+
+// queryParser parses the search query language from left to right, one item at a time.
+type queryParser struct {
+	input []rune
+	pos   int
+	query SearchQuery
+}
+
+func (p *queryParser) parse() (SearchQuery, error) {
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return p.query, nil
+		}
+		if err := p.parseItem(); err != nil {
+			return SearchQuery{}, err
+		}
+	}
+}
+
+// parseItem parses a word, a quoted phrase or a field, any of them optionally excluded with "-".
+func (p *queryParser) parseItem() error {
+	start := p.pos
+	excluded := p.input[p.pos] == '-'
+	if excluded {
+		p.pos++
+		if p.pos >= len(p.input) || unicode.IsSpace(p.input[p.pos]) {
+			return p.errorAt(start, `missing word or phrase after "-"`)
+		}
+	}
+
+	if p.input[p.pos] == '"' {
+		phrase, err := p.parsePhrase()
+		if err != nil {
+			return err
+		}
+		if phrase == "" {
+			return nil
+		}
+		if excluded {
+			p.query.Excluded = append(p.query.Excluded, phrase)
+		} else {
+			p.query.Phrases = append(p.query.Phrases, phrase)
+		}
+		return nil
+	}
+
+	wordStart := p.pos
+	word, err := p.parseWord()
+	if err != nil {
+		return err
+	}
+	// a field without a value, like "note:" in "TODO: note: fix", is searched as a word
+	if field, value, ok := splitField(word); ok && (value != "" || p.atPhrase()) {
+		if excluded {
+			return p.errorAt(start, fmt.Sprintf("the %q field cannot be excluded", field))
+		}
+		return p.parseField(field, value, wordStart)
+	}
+	if excluded {
+		p.query.Excluded = append(p.query.Excluded, word)
+	} else {
+		p.query.Terms = append(p.query.Terms, word)
+	}
+	return nil
+}
+
+// parseField applies the value of a field to the query. The field name starts at the given position.
+// A field value is either the rest of the word or, if that is empty, the quoted phrase that follows.
+func (p *queryParser) parseField(field, value string, start int) error {
+	valueStart := start + len([]rune(field)) + 1
+	if value == "" && p.pos < len(p.input) && p.input[p.pos] == '"' {
+		phrase, err := p.parsePhrase()
+		if err != nil {
+			return err
+		}
+		value = phrase
+	}
+	if value == "" {
+		return p.errorAt(valueStart, fmt.Sprintf("missing value for the %q field", field))
+	}
+
+	switch field {
+	case "tag":
+		p.query.Tags = append(p.query.Tags, entity.NormalizeTagName(value))
+	case "title":
+		p.query.Title = append(p.query.Title, value)
+	case "owner":
+		p.query.Owner = value
+	case "shared":
+		shared, err := strconv.ParseBool(value)
+		if err != nil {
+			return p.errorAt(valueStart, `the "shared" field must be either "true" or "false"`)
+		}
+		p.query.Shared = &shared
+	case "before", "after":
+		t, err := time.Parse(searchDateLayout, value)
+		if err != nil {
+			return p.errorAt(valueStart, fmt.Sprintf("the %q field must be a date in YYYY-MM-DD format", field))
+		}
+		if field == "before" {
+			p.query.Before = t
+		} else {
+			p.query.After = t
+		}
+	}
+	return nil
+}
+
+// parsePhrase parses a phrase enclosed in double quotes and returns it with the spaces collapsed.
+func (p *queryParser) parsePhrase() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.input) && p.input[p.pos] != '"' {
+		p.pos++
+	}
+	if p.pos >= len(p.input) {
+		return "", p.errorAt(start, "unterminated quoted phrase")
+	}
+	phrase := strings.Join(strings.Fields(string(p.input[start+1:p.pos])), " ")
+	p.pos++
+	if p.pos < len(p.input) && !unicode.IsSpace(p.input[p.pos]) {
+		return "", p.errorAt(p.pos, "missing space after quoted phrase")
+	}
+	return phrase, nil
+}
+
+// parseWord parses a run of characters up to the next space or the double quote starting a field value.
+func (p *queryParser) parseWord() (string, error) {
+	start := p.pos
+	for p.pos < len(p.input) && !unicode.IsSpace(p.input[p.pos]) {
+		if p.input[p.pos] == '"' {
+			if _, value, ok := splitField(string(p.input[start:p.pos])); ok && value == "" {
+				break
+			}
+			return "", p.errorAt(p.pos, "unexpected double quote")
+		}
+		p.pos++
+	}
+	return string(p.input[start:p.pos]), nil
+}
+
+// atPhrase reports whether a quoted phrase starts at the current position.
+func (p *queryParser) atPhrase() bool {
+	return p.pos < len(p.input) && p.input[p.pos] == '"'
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// errorAt returns a syntax error for the character at the given 0-based index.
+func (p *queryParser) errorAt(index int, msg string) error {
+	return &QuerySyntaxError{Pos: index + 1, Msg: msg}
+}
+
+// queryFields are the names of the fields of the query language.
+var queryFields = map[string]bool{
+	"tag":    true,
+	"title":  true,
+	"owner":  true,
+	"shared": true,
+	"before": true,
+	"after":  true,
+}
+
+// splitField splits a word of the form "name:value" into the field name and value.
+// Only the names of the known fields are split off, so that words like "10:30" or "Re:" are searched as is.
+func splitField(word string) (field, value string, ok bool) {
+	i := strings.IndexRune(word, ':')
+	if i <= 0 {
+		return "", "", false
+	}
+	field = strings.ToLower(word[:i])
+	if !queryFields[field] {
+		return "", "", false
+	}
+	return field, word[i+1:], true
+}
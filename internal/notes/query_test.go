@@ -0,0 +1,77 @@
+package notes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name  string
+		input string
+		want  SearchQuery
+		err   string
+	}{
+		{"words", "quick  brown", SearchQuery{Terms: []string{"quick", "brown"}}, ""},
+		{"phrase", `"exact   phrase" fox`, SearchQuery{Phrases: []string{"exact phrase"}, Terms: []string{"fox"}}, ""},
+		{"empty phrase", `"" fox`, SearchQuery{Terms: []string{"fox"}}, ""},
+		{"excluded", `-draft -"old notes"`, SearchQuery{Excluded: []string{"draft", "old notes"}}, ""},
+		{"hyphenated word", "e-mail", SearchQuery{Terms: []string{"e-mail"}}, ""},
+		{"word with colon", "10:30", SearchQuery{Terms: []string{"10:30"}}, ""},
+		{"tags", "tag:work TAG:#Home", SearchQuery{Tags: []string{"work", "home"}}, ""},
+		{"title", `title:plan title:"road map"`, SearchQuery{Title: []string{"plan", "road map"}}, ""},
+		{"owner", "owner:me", SearchQuery{Owner: "me"}, ""},
+		{"shared", "shared:true", SearchQuery{Shared: &yes}, ""},
+		{"not shared", "shared:false", SearchQuery{Shared: &no}, ""},
+		{"dates", "after:2024-06-01 before:2025-01-01", SearchQuery{
+			After:  time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			Before: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		}, ""},
+		{"all", `tag:work owner:me shared:true before:2025-01-01 "exact phrase" -draft`, SearchQuery{
+			Tags:     []string{"work"},
+			Owner:    "me",
+			Shared:   &yes,
+			Before:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			Phrases:  []string{"exact phrase"},
+			Excluded: []string{"draft"},
+		}, ""},
+		{"unterminated phrase", `fox "exact phrase`, SearchQuery{}, "unterminated quoted phrase at position 5"},
+		{"quote in word", `fo"x`, SearchQuery{}, "unexpected double quote at position 3"},
+		{"text after phrase", `"fox"y`, SearchQuery{}, "missing space after quoted phrase at position 6"},
+		{"lone minus", "fox - dog", SearchQuery{}, `missing word or phrase after "-" at position 5`},
+		{"unknown field", "fox color:red", SearchQuery{Terms: []string{"fox", "color:red"}}, ""},
+		{"word ending with colon", "Re: budget", SearchQuery{Terms: []string{"Re:", "budget"}}, ""},
+		{"words ending with colons", "TODO: fix note:", SearchQuery{Terms: []string{"TODO:", "fix", "note:"}}, ""},
+		{"field without value", "tag: work", SearchQuery{Terms: []string{"tag:", "work"}}, ""},
+		{"excluded field without value", "-tag:", SearchQuery{Excluded: []string{"tag:"}}, ""},
+		{"unknown field before phrase", `re:"budget"`, SearchQuery{}, "unexpected double quote at position 4"},
+		{"missing value", `tag:"" work`, SearchQuery{}, `missing value for the "tag" field at position 5`},
+		{"excluded field", "-tag:work", SearchQuery{}, `the "tag" field cannot be excluded at position 1`},
+		{"invalid date", "before:yesterday", SearchQuery{}, `the "before" field must be a date in YYYY-MM-DD format at position 8`},
+		{"invalid shared", "shared:maybe", SearchQuery{}, `the "shared" field must be either "true" or "false" at position 8`},
+		{"position in runes", `ünïcode "open`, SearchQuery{}, "unterminated quoted phrase at position 9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSearchQuery(tt.input)
+			if tt.err != "" {
+				if assert.NotNil(t, err) {
+					assert.Equal(t, tt.err, err.Error())
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestQuerySyntaxError_BadRequest(t *testing.T) {
+	err := (&QuerySyntaxError{Pos: 3, Msg: "unexpected double quote"}).BadRequest()
+	assert.Equal(t, 400, err.StatusCode())
+	assert.Equal(t, "unexpected double quote at position 3", err.Message)
+	assert.Equal(t, map[string]int{"position": 3}, err.Details)
+}
@@ -2,47 +2,116 @@ package notes
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	dbx "github.com/go-ozzo/ozzo-dbx"
 	"github.com/qiangxue/go-rest-api/internal/entity"
 	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
 	"github.com/qiangxue/go-rest-api/pkg/log"
-	"gorm.io/gorm"
 )
 
 // Repository encapsulates the logic to access notes from the data source.
 type Repository interface {
 	// Get returns the note with the specified note ID.
 	Get(ctx context.Context, id string) (entity.Note, error)
-	// Count returns the number of notes.
-	Count(ctx context.Context) (int, error)
-	// Query returns the list of notes with the given offset and limit.
-	Query(ctx context.Context, offset, limit int) ([]entity.Note, error)
-	QueryByUserID(ctx context.Context, userID string) ([]entity.Note, error)
-	// Create saves a new note in the storage.
-	Create(ctx context.Context, note entity.Note) error
-	// Update updates the note with given ID in the storage.
-	Update(ctx context.Context, note entity.Note) error
-	// Delete removes the note with given ID from the storage.
-	Delete(ctx context.Context, id string) error
+	// Count returns the number of notes visible to the given user that match the filter.
+	Count(ctx context.Context, userID string, filter NoteFilter) (int, error)
+	// Query returns the list of notes visible to the given user that match the filter, in the given order
+	// and with the given offset and limit.
+	Query(ctx context.Context, userID string, filter NoteFilter, sort NoteSort, offset, limit int) ([]entity.Note, error)
+	// QueryKeyset returns up to limit notes visible to the given user that match the filter, in the given order,
+	// that follow the keyset position, or precede it if the position is backward. A nil position starts at the
+	// beginning of the listing. The notes are always returned in the listing order.
+	QueryKeyset(ctx context.Context, userID string, filter NoteFilter, sort NoteSort, position *Keyset, limit int) ([]entity.Note, error)
+	QueryByUserID(ctx context.Context, userID string, filter NoteFilter) ([]entity.Note, error)
+	// Create saves a new note in the storage together with its first revision, and sets the revision number.
+	Create(ctx context.Context, note entity.Note, revision *entity.NoteRevision) error
+	// Update updates the note with given ID in the storage and increments its version. If revision is not nil,
+	// it is saved with the note, numbered after the latest revision of the note, and all but the latest keep
+	// revisions are removed unless keep is zero.
+	// note.Version must be the version the changes are based on. If the stored note has a different
+	// version, errVersionConflict is returned and nothing is changed.
+	Update(ctx context.Context, note entity.Note, revision *entity.NoteRevision, keep int) error
+	// Delete moves the note with given ID to the trash.
+	Delete(ctx context.Context, id string, deletedAt time.Time) error
+	// Restore moves the note with given ID out of the trash.
+	Restore(ctx context.Context, id string) error
+	// QueryTrash returns the personal notes of the given user that are in the trash, or the notes of the given
+	// workspace if the workspace ID is not empty.
+	QueryTrash(ctx context.Context, userID, workspaceID string) ([]entity.Note, error)
+	// Purge permanently removes the notes moved to the trash before the given time, along with their
+	// shares, links, revisions and tags. It returns the number of notes removed.
+	Purge(ctx context.Context, before time.Time) (int, error)
 
 	SharedNoteCreate(ctx context.Context, note *entity.SharedNote) error
 	GetSharedNoteByID(ctx context.Context, id string) (entity.SharedNote, error)
+	// GetSharedNote returns the share of the given note with the given user.
+	GetSharedNote(ctx context.Context, noteID, userID string) (entity.SharedNote, error)
+	// QueryShares returns the shares of the given note ordered by user ID.
+	QueryShares(ctx context.Context, noteID string) ([]entity.SharedNote, error)
+	// SharedNoteUpdate saves the changed role of a share.
+	SharedNoteUpdate(ctx context.Context, share entity.SharedNote) error
+	// SharedNoteDelete removes the share of the given note with the given user.
+	SharedNoteDelete(ctx context.Context, noteID, userID string) error
 
-	QuerySharedNotes(ctx context.Context, userID string) ([]entity.Note, error) // returns notes that are shared with the user
-	SearchNotes(ctx context.Context, userID string, query string) ([]entity.Note, error)
+	QuerySharedNotes(ctx context.Context, userID string, filter NoteFilter) ([]entity.Note, error) // returns notes that are shared with the user
+	// QueryAll returns all notes that are not in the trash.
+	QueryAll(ctx context.Context) ([]entity.Note, error)
+	// QuerySearchable returns the notes with the given IDs that are visible to the given user and match the filter
+	// and the fields of the search query. The words and phrases of the query are ignored.
+	QuerySearchable(ctx context.Context, userID string, ids []string, query SearchQuery, filter NoteFilter) ([]entity.Note, error)
+
+	// SetTags replaces the tags of the given note with the tags of the given names, creating the tags
+	// that the user does not have yet.
+	SetTags(ctx context.Context, noteID, userID string, names []string) error
+	// QueryTags returns the tag names of each of the given notes, ordered by name.
+	QueryTags(ctx context.Context, noteIDs []string) (map[string][]string, error)
+
+	// GetNotebook returns the notebook with the specified ID.
+	GetNotebook(ctx context.Context, id string) (entity.Notebook, error)
+
+	// GetRevision returns the revision of the given note with the given revision number.
+	GetRevision(ctx context.Context, noteID string, revision int) (entity.NoteRevision, error)
+	// QueryRevisions returns the revisions of the given note, newest first.
+	QueryRevisions(ctx context.Context, noteID string) ([]entity.NoteRevision, error)
+
+	// DeleteUserNotes deletes the personal notes of the given user along with their revisions, tags and shares,
+	// attributes the other notes and revisions they wrote to entity.DeletedUserID, and removes the shares with them.
+	// It runs within the transaction of the context deleting the account of the user, and returns the IDs of
+	// the deleted notes.
+	DeleteUserNotes(ctx context.Context, userID string) ([]string, error)
 }
 
+// Keyset identifies the position of a note in a sorted listing for keyset pagination.
+type Keyset struct {
+	// Key is the value of the sort field of the note.
+	Key interface{}
+	// ID is the ID of the note.
+	ID string
+	// Backward selects the notes before the position rather than after it.
+	Backward bool
+}
+
+// errVersionConflict is returned when a note has been modified after the version an update is based on.
+var errVersionConflict = errors.New("note has been modified concurrently")
+
+// searchBatchSize is the number of note IDs QuerySearchable looks up per query, keeping the queries well below
+// the limit of 65535 bind parameters per statement of PostgreSQL.
+const searchBatchSize = 1000
+
 // repository persists notes in database
 type repository struct {
-	gormDB *gorm.DB
 	db     *dbcontext.DB
 	logger log.Logger
 }
 
 // NewRepository creates a new note repository
-func NewRepository(gormDB *gorm.DB, db *dbcontext.DB, logger log.Logger) Repository {
-	return repository{gormDB, db, logger}
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
 }
 
 // Get reads the note with the specified ID from the database.
@@ -54,48 +123,162 @@ func (r repository) Get(ctx context.Context, id string) (entity.Note, error) {
 
 // Create saves a new note record in the database.
 // It returns the ID of the newly inserted note record.
-func (r repository) Create(ctx context.Context, note entity.Note) error {
-	return r.db.With(ctx).Model(&note).Insert()
+func (r repository) Create(ctx context.Context, note entity.Note, revision *entity.NoteRevision) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := r.db.With(ctx).Model(&note).Insert(); err != nil {
+			return err
+		}
+		return r.createRevision(ctx, revision)
+	})
 }
 
-// Update saves the changes to an note in the database.
-func (r repository) Update(ctx context.Context, note entity.Note) error {
-	return r.db.With(ctx).Model(&note).Update()
+// Update saves the changes to an note in the database if it still has the version the changes are based on,
+// together with the revision recording them, if any, and prunes the older revisions within a transaction.
+func (r repository) Update(ctx context.Context, note entity.Note, revision *entity.NoteRevision, keep int) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		result, err := r.db.With(ctx).Update("notes", dbx.Params{
+			"title":       note.Title,
+			"text":        note.Text,
+			"user_id":     note.UserID,
+			"created_at":  note.CreatedAt,
+			"updated_at":  note.UpdatedAt,
+			"version":     note.Version + 1,
+			"notebook_id": note.NotebookID,
+		}, dbx.HashExp{"id": note.ID, "version": note.Version}).Execute()
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			return errVersionConflict
+		}
+		if revision == nil {
+			return nil
+		}
+		if err := r.createRevision(ctx, revision); err != nil {
+			return err
+		}
+		if keep <= 0 {
+			return nil
+		}
+		return r.pruneRevisions(ctx, note.ID, keep)
+	})
 }
 
-// Delete deletes an note with the specified ID from the database.
-func (r repository) Delete(ctx context.Context, id string) error {
+// Delete moves the note with the specified ID to the trash by setting its deletion time.
+func (r repository) Delete(ctx context.Context, id string, deletedAt time.Time) error {
 	note, err := r.Get(ctx, id)
 	if err != nil {
 		return err
 	}
-	return r.db.With(ctx).Model(&note).Delete()
+	_, err = r.db.With(ctx).Update("notes", dbx.Params{"deleted_at": deletedAt}, dbx.HashExp{"id": note.ID}).Execute()
+	return err
 }
 
-// Count returns the number of the note records in the database.
-func (r repository) Count(ctx context.Context) (int, error) {
+// Restore moves the note with the specified ID out of the trash by clearing its deletion time.
+func (r repository) Restore(ctx context.Context, id string) error {
+	_, err := r.db.With(ctx).Update("notes", dbx.Params{"deleted_at": nil}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// QueryTrash retrieves the trashed notes of the given user or workspace from the database, most recently deleted first.
+func (r repository) QueryTrash(ctx context.Context, userID, workspaceID string) ([]entity.Note, error) {
+	var notes []entity.Note
+	owner := dbx.HashExp{"user_id": userID, "workspace_id": nil}
+	if workspaceID != "" {
+		owner = dbx.HashExp{"workspace_id": workspaceID}
+	}
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.And(owner, dbx.NewExp("deleted_at IS NOT NULL"))).
+		OrderBy("deleted_at DESC").
+		All(&notes)
+	return notes, err
+}
+
+// Purge deletes the notes trashed before the given time from the database, together with their shares, links, revisions and tags.
+func (r repository) Purge(ctx context.Context, before time.Time) (int, error) {
+	var count int64
+	err := r.db.Transactional(ctx, func(ctx context.Context) error {
+		trashed := dbx.NewExp("note_id IN (SELECT id FROM notes WHERE deleted_at < {:before})", dbx.Params{"before": before})
+		for _, table := range []string{"shared_notes", "share_links", "note_revisions", "note_tags"} {
+			if _, err := r.db.With(ctx).Delete(table, trashed).Execute(); err != nil {
+				return err
+			}
+		}
+		result, err := r.db.With(ctx).Delete("notes", dbx.NewExp("deleted_at < {:before}", dbx.Params{"before": before})).Execute()
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		return err
+	})
+	return int(count), err
+}
+
+// Count returns the number of the note records in the database that are visible to the given user and match the filter.
+func (r repository) Count(ctx context.Context, userID string, filter NoteFilter) (int, error) {
 	var count int
-	err := r.db.With(ctx).Select("COUNT(*)").From("notes").Row(&count)
+	err := r.db.With(ctx).Select("COUNT(*)").From("notes").Where(dbx.And(visibleTo(userID, filter.WorkspaceID), matching(filter))).Row(&count)
 	return count, err
 }
 
-// Query retrieves the note records with the specified offset and limit from the database.
-func (r repository) Query(ctx context.Context, offset, limit int) ([]entity.Note, error) {
+// Query retrieves the note records visible to the given user that match the filter in the given order
+// with the specified offset and limit from the database.
+func (r repository) Query(ctx context.Context, userID string, filter NoteFilter, sort NoteSort, offset, limit int) ([]entity.Note, error) {
 	var notes []entity.Note
 	err := r.db.With(ctx).
 		Select().
-		OrderBy("id").
+		Where(dbx.And(visibleTo(userID, filter.WorkspaceID), matching(filter))).
+		OrderBy(orderBy(sort)...).
 		Offset(int64(offset)).
 		Limit(int64(limit)).
 		All(&notes)
 	return notes, err
 }
 
-func (r repository) QueryByUserID(ctx context.Context, userID string) ([]entity.Note, error) {
+// QueryKeyset retrieves the note records visible to the given user that match the filter and follow or precede
+// the given position in the listing from the database. It compares the sort key and ID of the notes with
+// those of the position as a row value, which lets the database seek through the index instead of skipping rows.
+func (r repository) QueryKeyset(ctx context.Context, userID string, filter NoteFilter, sort NoteSort, position *Keyset, limit int) ([]entity.Note, error) {
+	condition := dbx.And(visibleTo(userID, filter.WorkspaceID), matching(filter))
+	if position != nil {
+		if position.Backward {
+			// walk the listing in reverse from the position and restore the order afterwards
+			sort.Desc = !sort.Desc
+		}
+		op := ">"
+		if sort.Desc {
+			op = "<"
+		}
+		column, ok := sortColumns[sort.Field]
+		if !ok {
+			column = "notes.id"
+		}
+		condition = dbx.And(condition, dbx.NewExp(fmt.Sprintf("(%v, notes.id) %v ({:key}, {:key_id})", column, op),
+			dbx.Params{"key": position.Key, "key_id": position.ID}))
+	}
+	var notes []entity.Note
+	err := r.db.With(ctx).
+		Select().
+		Where(condition).
+		OrderBy(orderBy(sort)...).
+		Limit(int64(limit)).
+		All(&notes)
+	if position != nil && position.Backward {
+		for i, j := 0, len(notes)-1; i < j; i, j = i+1, j-1 {
+			notes[i], notes[j] = notes[j], notes[i]
+		}
+	}
+	return notes, err
+}
+
+func (r repository) QueryByUserID(ctx context.Context, userID string, filter NoteFilter) ([]entity.Note, error) {
 	var notes []entity.Note
 	err := r.db.With(ctx).
 		Select().
-		Where(dbx.HashExp{"user_id": userID}).
+		Where(dbx.And(dbx.HashExp{"user_id": userID}, notDeleted, matching(filter))).
 		OrderBy("id").
 		All(&notes)
 	return notes, err
@@ -111,25 +294,349 @@ func (r repository) GetSharedNoteByID(ctx context.Context, id string) (entity.Sh
 	return note, err
 }
 
-func (r repository) QuerySharedNotes(ctx context.Context, userID string) ([]entity.Note, error) {
-	var notes []entity.Note
+func (r repository) GetSharedNote(ctx context.Context, noteID, userID string) (entity.SharedNote, error) {
+	var note entity.SharedNote
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"note_id": noteID, "shared_user_id": userID}).One(&note)
+	return note, err
+}
+
+// QueryShares retrieves the shares of the given note from the database.
+func (r repository) QueryShares(ctx context.Context, noteID string) ([]entity.SharedNote, error) {
+	var shares []entity.SharedNote
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"note_id": noteID}).
+		OrderBy("shared_user_id").
+		All(&shares)
+	return shares, err
+}
+
+// SharedNoteUpdate saves the changes to a share in the database.
+func (r repository) SharedNoteUpdate(ctx context.Context, share entity.SharedNote) error {
+	_, err := r.db.With(ctx).Update("shared_notes", dbx.Params{"role": share.Role},
+		dbx.HashExp{"note_id": share.NoteID, "shared_user_id": share.SharedUserID}).Execute()
+	return err
+}
 
-	tx := r.gormDB.Raw("SELECT notes.* FROM notes LEFT JOIN shared_notes ON shared_notes.note_id = notes.id WHERE shared_notes.shared_user_id = ?", userID).Scan(&notes)
-	if tx.Error != nil {
-		return nil, tx.Error
+// SharedNoteDelete deletes the share of the given note with the given user from the database.
+// sql.ErrNoRows is returned if the note is not shared with the user.
+func (r repository) SharedNoteDelete(ctx context.Context, noteID, userID string) error {
+	result, err := r.db.With(ctx).Delete("shared_notes", dbx.HashExp{"note_id": noteID, "shared_user_id": userID}).Execute()
+	if err != nil {
+		return err
 	}
-	return notes, nil
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-func (r repository) SearchNotes(ctx context.Context, userID string, query string) ([]entity.Note, error) {
+func (r repository) QuerySharedNotes(ctx context.Context, userID string, filter NoteFilter) ([]entity.Note, error) {
 	var notes []entity.Note
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.And(sharedWith(userID), notDeleted, matching(filter))).
+		OrderBy("id").
+		All(&notes)
+	return notes, err
+}
 
-	tx := r.gormDB.Raw("SELECT notes.* FROM notes LEFT JOIN shared_notes ON shared_notes.note_id = notes.id WHERE (shared_notes.shared_user_id = ? OR notes.user_id = ?) "+
-		" AND notes.text @@ to_tsquery('english', ?)", userID, userID, query).Scan(&notes)
+// QueryAll retrieves all notes that are not in the trash from the database.
+func (r repository) QueryAll(ctx context.Context) ([]entity.Note, error) {
+	var notes []entity.Note
+	err := r.db.With(ctx).Select().Where(notDeleted).OrderBy("id").All(&notes)
+	return notes, err
+}
 
-	if tx.Error != nil {
-		return nil, tx.Error
+// QuerySearchable retrieves the notes with the given IDs that are visible to the given user and match the filter
+// and the fields of the search query from the database.
+func (r repository) QuerySearchable(ctx context.Context, userID string, ids []string, query SearchQuery, filter NoteFilter) ([]entity.Note, error) {
+	notes := []entity.Note{}
+	for _, batch := range batchIDs(ids, searchBatchSize) {
+		values := make([]interface{}, len(batch))
+		for i, id := range batch {
+			values[i] = id
+		}
+		var found []entity.Note
+		err := r.db.With(ctx).
+			Select().
+			Where(dbx.And(dbx.In("notes.id", values...), visibleTo(userID, filter.WorkspaceID), searchFields(userID, query), matching(filter))).
+			All(&found)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, found...)
 	}
 	return notes, nil
+}
+
+// batchIDs splits the IDs into consecutive batches of at most size IDs.
+func batchIDs(ids []string, size int) [][]string {
+	var batches [][]string
+	for len(ids) > size {
+		batches = append(batches, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		batches = append(batches, ids)
+	}
+	return batches
+}
+
+// SetTags replaces the tags of a note in the database within a transaction.
+// Tags are looked up by name in the namespace of the given user and created if missing.
+func (r repository) SetTags(ctx context.Context, noteID, userID string, names []string) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := r.db.With(ctx).Delete("note_tags", dbx.HashExp{"note_id": noteID}).Execute(); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, name := range names {
+			var tagID string
+			err := r.db.With(ctx).NewQuery("INSERT INTO tags (id, user_id, name, created_at, updated_at) " +
+				"VALUES ({:id}, {:user_id}, {:name}, {:now}, {:now}) " +
+				"ON CONFLICT (user_id, name) DO UPDATE SET name = EXCLUDED.name RETURNING id").
+				Bind(dbx.Params{"id": entity.GenerateID(), "user_id": userID, "name": name, "now": now}).
+				Row(&tagID)
+			if err != nil {
+				return err
+			}
+			_, err = r.db.With(ctx).Insert("note_tags", dbx.Params{"note_id": noteID, "tag_id": tagID}).Execute()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
+// QueryTags retrieves the tag names of the given notes from the database.
+func (r repository) QueryTags(ctx context.Context, noteIDs []string) (map[string][]string, error) {
+	tags := map[string][]string{}
+	if len(noteIDs) == 0 {
+		return tags, nil
+	}
+	ids := make([]interface{}, len(noteIDs))
+	for i, id := range noteIDs {
+		ids[i] = id
+	}
+	var rows []struct {
+		NoteID string `db:"note_id"`
+		Name   string `db:"name"`
+	}
+	err := r.db.With(ctx).
+		Select("note_tags.note_id", "tags.name").
+		From("note_tags").
+		InnerJoin("tags", dbx.NewExp("tags.id = note_tags.tag_id")).
+		Where(dbx.In("note_tags.note_id", ids...)).
+		OrderBy("tags.name").
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		tags[row.NoteID] = append(tags[row.NoteID], row.Name)
+	}
+	return tags, nil
+}
+
+// GetNotebook reads the notebook with the specified ID from the database.
+func (r repository) GetNotebook(ctx context.Context, id string) (entity.Notebook, error) {
+	var notebook entity.Notebook
+	err := r.db.With(ctx).Select().Model(id, &notebook)
+	return notebook, err
+}
+
+// createRevision saves a new note revision in the database and sets its revision number. It must be called in
+// the transaction writing the note, whose row lock keeps concurrent writes from taking the same number.
+func (r repository) createRevision(ctx context.Context, revision *entity.NoteRevision) error {
+	return r.db.With(ctx).NewQuery("INSERT INTO note_revisions (id, note_id, revision, title, text, user_id, created_at) " +
+		"SELECT {:id}, {:note_id}, COALESCE(MAX(revision), 0) + 1, {:title}, {:text}, {:user_id}, {:created_at} " +
+		"FROM note_revisions WHERE note_id = {:note_id} RETURNING revision").
+		Bind(dbx.Params{
+			"id":         revision.ID,
+			"note_id":    revision.NoteID,
+			"title":      revision.Title,
+			"text":       revision.Text,
+			"user_id":    revision.UserID,
+			"created_at": revision.CreatedAt,
+		}).
+		Row(&revision.Revision)
+}
+
+// GetRevision reads the revision of a note with the given revision number from the database.
+func (r repository) GetRevision(ctx context.Context, noteID string, revision int) (entity.NoteRevision, error) {
+	var rev entity.NoteRevision
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"note_id": noteID, "revision": revision}).
+		One(&rev)
+	return rev, err
+}
+
+// QueryRevisions retrieves the revisions of a note from the database, newest first.
+func (r repository) QueryRevisions(ctx context.Context, noteID string) ([]entity.NoteRevision, error) {
+	var revisions []entity.NoteRevision
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"note_id": noteID}).
+		OrderBy("revision DESC").
+		All(&revisions)
+	return revisions, err
+}
+
+// pruneRevisions deletes all but the latest keep revisions of a note from the database.
+func (r repository) pruneRevisions(ctx context.Context, noteID string, keep int) error {
+	_, err := r.db.With(ctx).Delete("note_revisions", dbx.And(
+		dbx.HashExp{"note_id": noteID},
+		dbx.NewExp("revision <= (SELECT MAX(revision) FROM note_revisions WHERE note_id = {:note_id}) - {:keep}",
+			dbx.Params{"note_id": noteID, "keep": keep}),
+	)).Execute()
+	return err
+}
+
+// DeleteUserNotes deletes the personal notes of the user from the database and anonymises the others they wrote.
+func (r repository) DeleteUserNotes(ctx context.Context, userID string) ([]string, error) {
+	var ids []string
+	err := r.db.With(ctx).
+		Select("id").
+		From("notes").
+		Where(dbx.HashExp{"user_id": userID, "workspace_id": nil}).
+		Column(&ids)
+	if err != nil {
+		return nil, err
+	}
+	params := dbx.Params{"user": userID, "deleted": entity.DeletedUserID}
+	personal := dbx.NewExp("note_id IN (SELECT id FROM notes WHERE user_id = {:user} AND workspace_id IS NULL)", params)
+	for _, table := range []string{"shared_notes", "note_revisions", "note_tags"} {
+		if _, err := r.db.With(ctx).Delete(table, personal).Execute(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.db.With(ctx).Delete("notes", dbx.HashExp{"user_id": userID, "workspace_id": nil}).Execute(); err != nil {
+		return nil, err
+	}
+
+	// the notes in workspaces belong to the workspace, and the revisions to the notes
+	for _, table := range []string{"notes", "note_revisions"} {
+		_, err := r.db.With(ctx).Update(table, dbx.Params{"user_id": entity.DeletedUserID}, dbx.HashExp{"user_id": userID}).Execute()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.db.With(ctx).Delete("shared_notes", dbx.HashExp{"shared_user_id": userID}).Execute(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// notDeleted is a condition matching the notes that are not in the trash.
+var notDeleted = dbx.NewExp("notes.deleted_at IS NULL")
+
+// sharedWith returns a condition matching the notes shared with the given user.
+func sharedWith(userID string) dbx.Expression {
+	return dbx.NewExp("notes.id IN (SELECT note_id FROM shared_notes WHERE shared_user_id = {:uid})", dbx.Params{"uid": userID})
+}
+
+// visibleTo returns a condition matching the notes of the given workspace or, if the workspace ID is empty,
+// the personal notes of the given user and the notes shared with them, excluding trashed notes.
+func visibleTo(userID, workspaceID string) dbx.Expression {
+	if workspaceID != "" {
+		return dbx.And(dbx.HashExp{"notes.workspace_id": workspaceID}, notDeleted)
+	}
+	return dbx.And(
+		dbx.Or(dbx.HashExp{"notes.user_id": userID, "notes.workspace_id": nil}, sharedWith(userID)),
+		notDeleted,
+	)
+}
+
+// searchFields returns a condition matching the notes that satisfy the fields of the search query other than
+// the title, or nil if the query has none of them.
+func searchFields(userID string, query SearchQuery) dbx.Expression {
+	var conditions []dbx.Expression
+	if tags := tagged(NoteFilter{Tags: query.Tags}, "qtag"); tags != nil {
+		conditions = append(conditions, tags)
+	}
+	if query.Owner != "" {
+		conditions = append(conditions, dbx.HashExp{"notes.user_id": query.Owner})
+	}
+	if query.Shared != nil {
+		if *query.Shared {
+			conditions = append(conditions, dbx.Not(dbx.HashExp{"notes.user_id": userID}), sharedWith(userID))
+		} else {
+			conditions = append(conditions, dbx.HashExp{"notes.user_id": userID})
+		}
+	}
+	if !query.After.IsZero() {
+		conditions = append(conditions, dbx.NewExp("notes.updated_at >= {:after}", dbx.Params{"after": query.After}))
+	}
+	if !query.Before.IsZero() {
+		conditions = append(conditions, dbx.NewExp("notes.updated_at < {:before}", dbx.Params{"before": query.Before}))
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	return dbx.And(conditions...)
+}
+
+// sortColumns maps the fields notes can be sorted by to their columns.
+var sortColumns = map[string]string{
+	SortUpdatedAt: "notes.updated_at",
+	SortCreatedAt: "notes.created_at",
+	SortTitle:     "notes.title",
+}
+
+// orderBy returns the ORDER BY columns for the given sort order. Notes are sorted by ID if no field is given.
+func orderBy(sort NoteSort) []string {
+	direction := " ASC"
+	if sort.Desc {
+		direction = " DESC"
+	}
+	column, ok := sortColumns[sort.Field]
+	if !ok {
+		return []string{"notes.id" + direction}
+	}
+	return []string{column + direction, "notes.id" + direction}
+}
+
+// matching returns a condition matching the notes that satisfy every criterion of the filter.
+// Nil is returned if the filter is empty.
+func matching(filter NoteFilter) dbx.Expression {
+	var conditions []dbx.Expression
+	if tags := tagged(filter, "ftag"); tags != nil {
+		conditions = append(conditions, tags)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, dbx.NewExp("notes.created_at > {:created_after}", dbx.Params{"created_after": filter.CreatedAfter}))
+	}
+	if !filter.UpdatedBefore.IsZero() {
+		conditions = append(conditions, dbx.NewExp("notes.updated_at < {:updated_before}", dbx.Params{"updated_before": filter.UpdatedBefore}))
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	return dbx.And(conditions...)
+}
+
+// tagged returns a condition matching the notes that carry every tag of the filter, or any of them if
+// filter.AnyTag is set. Nil is returned if the filter has no tags, which leaves the notes unfiltered.
+// The parameters are named after the prefix, so that several of the conditions can be combined in one query.
+func tagged(filter NoteFilter, prefix string) dbx.Expression {
+	if len(filter.Tags) == 0 {
+		return nil
+	}
+	params := dbx.Params{}
+	placeholders := make([]string, len(filter.Tags))
+	for i, name := range filter.Tags {
+		key := fmt.Sprintf("%s%d", prefix, i)
+		params[key] = name
+		placeholders[i] = "{:" + key + "}"
+	}
+	sql := "SELECT note_tags.note_id FROM note_tags JOIN tags ON tags.id = note_tags.tag_id" +
+		" WHERE tags.name IN (" + strings.Join(placeholders, ", ") + ")"
+	if !filter.AnyTag {
+		params[prefix+"_count"] = len(filter.Tags)
+		sql += " GROUP BY note_tags.note_id HAVING COUNT(DISTINCT tags.name) = {:" + prefix + "_count}"
+	}
+	return dbx.NewExp("notes.id IN ("+sql+")", params)
 }
@@ -4,15 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"sort"
 	"testing"
 	"time"
 
+	dbx "github.com/go-ozzo/ozzo-dbx"
 	"github.com/qiangxue/go-rest-api/internal/entity"
 	"github.com/qiangxue/go-rest-api/internal/test"
 	"github.com/qiangxue/go-rest-api/pkg/log"
 	"github.com/stretchr/testify/assert"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 )
 
 var errCRUD = errors.New("error crud")
@@ -31,34 +31,53 @@ var errCRUD = errors.New("error crud")
 func TestRepository(t *testing.T) {
 	logger, _ := log.NewForTest()
 	db := test.DB(t)
-	gormDSN := "host=localhost user=postgres password=postgres dbname=postgres port=5433 sslmode=disable TimeZone=Asia/Shanghai"
-	gormDB, err := gorm.Open(postgres.Open(gormDSN), &gorm.Config{})
-	if err != nil {
-		logger.Error(err)
-		t.FailNow()
-	}
-	test.ResetTables(t, db, "notes")
-	repo := NewRepository(gormDB, db, logger)
+	test.ResetTables(t, db, "notes", "shared_notes", "note_revisions", "tags", "note_tags")
+	repo := NewRepository(db, logger)
 
 	ctx := context.Background()
 
 	// initial count
-	count, err := repo.Count(ctx)
+	count, err := repo.Count(ctx, "user1", NoteFilter{})
 	assert.Nil(t, err)
 
 	// create
+	first := entity.NoteRevision{ID: entity.GenerateID(), NoteID: "test1", Title: "title1", Text: "text1", UserID: "user1", CreatedAt: time.Now()}
 	err = repo.Create(ctx, entity.Note{
-		ID:             "test1",
-		Title:          "title1",
-		Text:           "text1",
-		TextSearchable: "text1",
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
-	})
+		ID:        "test1",
+		Title:     "title1",
+		Text:      "text1",
+		UserID:    "user1",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}, &first)
 	assert.Nil(t, err)
-	count2, _ := repo.Count(ctx)
+	assert.Equal(t, 1, first.Revision)
+	count2, _ := repo.Count(ctx, "user1", NoteFilter{})
 	assert.Equal(t, 1, count2-count)
 
+	// share
+	other, _ := repo.Count(ctx, "user2", NoteFilter{})
+	assert.Zero(t, other)
+	_, err = repo.GetSharedNote(ctx, "test1", "user2")
+	assert.Equal(t, sql.ErrNoRows, err)
+	err = repo.SharedNoteCreate(ctx, &entity.SharedNote{ID: "share1", NoteID: "test1", SharedUserID: "user2", Role: entity.RoleViewer})
+	assert.Nil(t, err)
+	share, err := repo.GetSharedNote(ctx, "test1", "user2")
+	assert.Nil(t, err)
+	assert.Equal(t, "share1", share.ID)
+	share.Role = entity.RoleEditor
+	assert.Nil(t, repo.SharedNoteUpdate(ctx, share))
+	shares, err := repo.QueryShares(ctx, "test1")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(shares)) {
+		assert.Equal(t, entity.RoleEditor, shares[0].Role)
+	}
+	assert.Nil(t, repo.SharedNoteCreate(ctx, &entity.SharedNote{ID: "share2", NoteID: "test1", SharedUserID: "user3", Role: entity.RoleViewer}))
+	assert.Nil(t, repo.SharedNoteDelete(ctx, "test1", "user3"))
+	assert.Equal(t, sql.ErrNoRows, repo.SharedNoteDelete(ctx, "test1", "user3"))
+	other, _ = repo.Count(ctx, "user2", NoteFilter{})
+	assert.Equal(t, 1, other)
+
 	// get
 	note, err := repo.Get(ctx, "test1")
 	assert.Nil(t, err)
@@ -67,32 +86,217 @@ func TestRepository(t *testing.T) {
 	assert.Equal(t, sql.ErrNoRows, err)
 
 	// update
+	second := entity.NoteRevision{ID: entity.GenerateID(), NoteID: "test1", Title: "title1 updated", UserID: "user1", CreatedAt: time.Now()}
 	err = repo.Update(ctx, entity.Note{
 		ID:        "test1",
 		Title:     "title1 updated",
+		UserID:    "user1",
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
-	})
+	}, &second, 0)
 	assert.Nil(t, err)
+	assert.Equal(t, 2, second.Revision)
 	note, _ = repo.Get(ctx, "test1")
 	assert.Equal(t, "title1 updated", note.Title)
+	assert.Equal(t, 1, note.Version)
+	stale := entity.NoteRevision{ID: entity.GenerateID(), NoteID: "test1", Title: "stale", UserID: "user1", CreatedAt: time.Now()}
+	err = repo.Update(ctx, entity.Note{
+		ID:     "test1",
+		Title:  "stale",
+		UserID: "user1",
+	}, &stale, 0)
+	assert.Equal(t, errVersionConflict, err)
 
 	// query
-	notes, err := repo.Query(ctx, 0, count2)
+	notes, err := repo.Query(ctx, "user1", NoteFilter{}, NoteSort{Field: SortTitle}, 0, count2)
+	assert.Nil(t, err)
+	assert.Equal(t, count2, len(notes))
+	notes, err = repo.QueryKeyset(ctx, "user1", NoteFilter{}, NoteSort{Field: SortTitle}, &Keyset{Key: "", ID: ""}, count2)
 	assert.Nil(t, err)
 	assert.Equal(t, count2, len(notes))
+	notes, err = repo.QueryKeyset(ctx, "user1", NoteFilter{}, NoteSort{Field: SortTitle}, &Keyset{Key: "title1", ID: "test1"}, count2)
+	assert.Nil(t, err)
+	assert.Equal(t, count2-1, len(notes))
+
+	// search
+	all, err := repo.QueryAll(ctx)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, all)
+	searchable, err := repo.QuerySearchable(ctx, "user1", []string{"test1", "unknown"}, SearchQuery{Owner: "user1"}, NoteFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(searchable))
+	searchable, err = repo.QuerySearchable(ctx, "user1", []string{"test1"}, SearchQuery{Owner: "user2"}, NoteFilter{})
+	assert.Nil(t, err)
+	assert.Empty(t, searchable)
+	index := NewPostgresSearchIndex(db, logger)
+	hits, err := index.Search(ctx, "user1", SearchQuery{Terms: []string{"title1"}, Excluded: []string{"missing"}}, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(hits)) {
+		assert.Equal(t, "test1", hits[0].ID)
+		assert.True(t, hits[0].Rank > 0)
+	}
+	count, err = index.Count(ctx, "user1", SearchQuery{Title: []string{"title1"}, Owner: "user1"}, NoteFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	hits, err = index.Search(ctx, "user1", SearchQuery{Terms: []string{"title1"}, Fuzzy: true}, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	assert.Nil(t, err)
+	if assert.NotEmpty(t, hits) {
+		assert.Equal(t, "test1", hits[0].ID)
+	}
+	suggestions, err := index.Suggest(ctx, "user1", "title", NoteFilter{}, 10)
+	assert.Nil(t, err)
+	assert.Contains(t, suggestions.Titles, Suggestion{Text: "title1", Count: 1})
+	assert.Contains(t, suggestions.Terms, Suggestion{Text: "title1", Count: 1})
+
+	// tags
+	assert.Nil(t, repo.SetTags(ctx, "test1", "user1", []string{"work", "urgent"}))
+	tags, err := repo.QueryTags(ctx, []string{"test1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"urgent", "work"}, tags["test1"])
+	assert.Nil(t, repo.SetTags(ctx, "test1", "user1", []string{"work"}))
+	tags, _ = repo.QueryTags(ctx, []string{"test1"})
+	assert.Equal(t, []string{"work"}, tags["test1"])
+	tagged, _ := repo.Count(ctx, "user1", NoteFilter{Tags: []string{"work"}})
+	assert.Equal(t, 1, tagged)
+	tagged, _ = repo.Count(ctx, "user1", NoteFilter{Tags: []string{"work", "urgent"}})
+	assert.Zero(t, tagged)
+	tagged, _ = repo.Count(ctx, "user1", NoteFilter{Tags: []string{"work", "urgent"}, AnyTag: true})
+	assert.Equal(t, 1, tagged)
+	notes, err = repo.QuerySharedNotes(ctx, "user2", NoteFilter{Tags: []string{"work"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(notes))
+	// the tags of the search query and of the filter both apply
+	searchable, err = repo.QuerySearchable(ctx, "user1", []string{"test1"}, SearchQuery{Tags: []string{"work"}}, NoteFilter{Tags: []string{"urgent"}})
+	assert.Nil(t, err)
+	assert.Empty(t, searchable)
+	count, err = index.Count(ctx, "user1", SearchQuery{Tags: []string{"work"}}, NoteFilter{Tags: []string{"work", "urgent"}, AnyTag: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// revisions, of which the stale update saved none
+	rev, err := repo.GetRevision(ctx, "test1", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "title1 updated", rev.Title)
+	revisions, err := repo.QueryRevisions(ctx, "test1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(revisions))
+	// an update without a revision keeps them, and an update with one prunes the older ones
+	updated := entity.Note{ID: "test1", Title: "title1 updated", UserID: "user1", Version: 1, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	assert.Nil(t, repo.Update(ctx, updated, nil, 1))
+	revisions, _ = repo.QueryRevisions(ctx, "test1")
+	assert.Equal(t, 2, len(revisions))
+	third := entity.NoteRevision{ID: entity.GenerateID(), NoteID: "test1", Title: "title1 updated", UserID: "user1", CreatedAt: time.Now()}
+	updated.Version = 2
+	assert.Nil(t, repo.Update(ctx, updated, &third, 1))
+	revisions, err = repo.QueryRevisions(ctx, "test1")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(revisions)) {
+		assert.Equal(t, 3, revisions[0].Revision)
+	}
 
 	// delete
-	err = repo.Delete(ctx, "test1")
+	err = repo.Delete(ctx, "test1", time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	note, err = repo.Get(ctx, "test1")
+	assert.Nil(t, err)
+	assert.NotNil(t, note.DeletedAt)
+	count3, _ := repo.Count(ctx, "user1", NoteFilter{})
+	assert.Equal(t, count, count3)
+	trash, err := repo.QueryTrash(ctx, "user1", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(trash))
+	err = repo.Delete(ctx, "test0", time.Now())
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// restore
+	err = repo.Restore(ctx, "test1")
+	assert.Nil(t, err)
+	note, _ = repo.Get(ctx, "test1")
+	assert.Nil(t, note.DeletedAt)
+
+	// purge
+	_ = repo.Delete(ctx, "test1", time.Now().Add(-time.Hour))
+	purged, err := repo.Purge(ctx, time.Now().Add(-2*time.Hour))
 	assert.Nil(t, err)
+	assert.Zero(t, purged)
+	purged, err = repo.Purge(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, purged)
 	_, err = repo.Get(ctx, "test1")
 	assert.Equal(t, sql.ErrNoRows, err)
-	err = repo.Delete(ctx, "test1")
+	_, err = repo.GetSharedNote(ctx, "test1", "user2")
 	assert.Equal(t, sql.ErrNoRows, err)
+	revisions, _ = repo.QueryRevisions(ctx, "test1")
+	assert.Zero(t, len(revisions))
+}
+
+func Test_tagged(t *testing.T) {
+	db := dbx.NewFromDB(nil, "postgres")
+	params := dbx.Params{}
+	where := dbx.And(
+		searchFields("user1", SearchQuery{Tags: []string{"work"}}),
+		matching(NoteFilter{Tags: []string{"home", "urgent"}}),
+	).Build(db, params)
+	assert.Contains(t, where, "IN ({:qtag0})")
+	assert.Contains(t, where, "IN ({:ftag0}, {:ftag1})")
+	assert.Equal(t, dbx.Params{"qtag0": "work", "qtag_count": 1, "ftag0": "home", "ftag1": "urgent", "ftag_count": 2}, params)
+	assert.Nil(t, tagged(NoteFilter{}, "ftag"))
+}
+
+func Test_batchIDs(t *testing.T) {
+	assert.Nil(t, batchIDs(nil, 2))
+	assert.Equal(t, [][]string{{"a", "b"}}, batchIDs([]string{"a", "b"}, 2))
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, batchIDs([]string{"a", "b", "c", "d", "e"}, 2))
 }
 
 type mockNoteRepo struct {
-	items []entity.Note
+	items     []entity.Note
+	shares    []entity.SharedNote
+	revisions []entity.NoteRevision
+	tags      map[string][]string
+	notebooks []entity.Notebook
+}
+
+// matches reports whether the note satisfies the criteria of the filter.
+func (m *mockNoteRepo) matches(note entity.Note, filter NoteFilter) bool {
+	if !filter.CreatedAfter.IsZero() && !note.CreatedAt.After(filter.CreatedAfter) {
+		return false
+	}
+	if !filter.UpdatedBefore.IsZero() && !note.UpdatedAt.Before(filter.UpdatedBefore) {
+		return false
+	}
+	if len(filter.Tags) == 0 {
+		return true
+	}
+	found := 0
+	for _, name := range filter.Tags {
+		for _, tag := range m.tags[note.ID] {
+			if tag == name {
+				found++
+				break
+			}
+		}
+	}
+	if filter.AnyTag {
+		return found > 0
+	}
+	return found == len(filter.Tags)
+}
+
+// visible reports whether the note belongs to the given workspace or, if the workspace ID is empty, whether it is
+// a personal note of the given user or shared with them.
+func (m *mockNoteRepo) visible(note entity.Note, userID, workspaceID string) bool {
+	if note.DeletedAt != nil {
+		return false
+	}
+	if workspaceID != "" {
+		return note.WorkspaceID != nil && *note.WorkspaceID == workspaceID
+	}
+	if note.UserID == userID && note.WorkspaceID == nil {
+		return true
+	}
+	_, err := m.GetSharedNote(context.Background(), note.ID, userID)
+	return err == nil
 }
 
 func (m *mockNoteRepo) Get(ctx context.Context, id string) (entity.Note, error) {
@@ -104,62 +308,355 @@ func (m *mockNoteRepo) Get(ctx context.Context, id string) (entity.Note, error)
 	return entity.Note{}, sql.ErrNoRows
 }
 
-func (m *mockNoteRepo) Count(ctx context.Context) (int, error) {
-	return len(m.items), nil
+func (m *mockNoteRepo) Count(ctx context.Context, userID string, filter NoteFilter) (int, error) {
+	notes, _ := m.Query(ctx, userID, filter, NoteSort{}, 0, 0)
+	return len(notes), nil
+}
+
+func (m *mockNoteRepo) Query(ctx context.Context, userID string, filter NoteFilter, order NoteSort, offset, limit int) ([]entity.Note, error) {
+	var notes []entity.Note
+	for _, item := range m.items {
+		if m.visible(item, userID, filter.WorkspaceID) && m.matches(item, filter) {
+			notes = append(notes, item)
+		}
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		a, b := notes[i], notes[j]
+		if order.Desc {
+			a, b = b, a
+		}
+		switch order.Field {
+		case SortUpdatedAt:
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		case SortCreatedAt:
+			return a.CreatedAt.Before(b.CreatedAt)
+		case SortTitle:
+			return a.Title < b.Title
+		}
+		return a.ID < b.ID
+	})
+	if offset > len(notes) {
+		offset = len(notes)
+	}
+	notes = notes[offset:]
+	if limit > 0 && limit < len(notes) {
+		notes = notes[:limit]
+	}
+	return notes, nil
 }
 
-func (m *mockNoteRepo) Query(ctx context.Context, offset, limit int) ([]entity.Note, error) {
-	return m.items, nil
+func (m *mockNoteRepo) QueryKeyset(ctx context.Context, userID string, filter NoteFilter, order NoteSort, position *Keyset, limit int) ([]entity.Note, error) {
+	notes, _ := m.Query(ctx, userID, filter, order, 0, 0)
+	if position != nil {
+		for i, note := range notes {
+			if note.ID == position.ID {
+				if position.Backward {
+					notes = notes[:i]
+					if len(notes) > limit {
+						notes = notes[len(notes)-limit:]
+					}
+					return notes, nil
+				}
+				notes = notes[i+1:]
+				break
+			}
+		}
+	}
+	if len(notes) > limit {
+		notes = notes[:limit]
+	}
+	return notes, nil
 }
 
-func (m *mockNoteRepo) QueryByUserID(ctx context.Context, userID string) ([]entity.Note, error) {
-	return m.items, nil
+func (m *mockNoteRepo) QueryByUserID(ctx context.Context, userID string, filter NoteFilter) ([]entity.Note, error) {
+	var notes []entity.Note
+	for _, item := range m.items {
+		if item.UserID == userID && item.DeletedAt == nil && m.matches(item, filter) {
+			notes = append(notes, item)
+		}
+	}
+	return notes, nil
 }
 
-func (m *mockNoteRepo) Create(ctx context.Context, note entity.Note) error {
+func (m *mockNoteRepo) Create(ctx context.Context, note entity.Note, revision *entity.NoteRevision) error {
 	if note.Title == "error" {
 		return errCRUD
 	}
 	m.items = append(m.items, note)
+	m.createRevision(revision)
 	return nil
 }
 
-func (m *mockNoteRepo) Update(ctx context.Context, note entity.Note) error {
+func (m *mockNoteRepo) Update(ctx context.Context, note entity.Note, revision *entity.NoteRevision, keep int) error {
 	if note.Title == "error" {
 		return errCRUD
 	}
 	for i, item := range m.items {
 		if item.ID == note.ID {
+			if item.Version != note.Version {
+				return errVersionConflict
+			}
+			note.Version++
 			m.items[i] = note
 			break
 		}
 	}
+	if revision != nil {
+		m.createRevision(revision)
+		if keep > 0 {
+			m.pruneRevisions(note.ID, keep)
+		}
+	}
 	return nil
 }
 
-func (m *mockNoteRepo) Delete(ctx context.Context, id string) error {
+func (m *mockNoteRepo) Delete(ctx context.Context, id string, deletedAt time.Time) error {
 	for i, item := range m.items {
 		if item.ID == id {
-			m.items[i] = m.items[len(m.items)-1]
-			m.items = m.items[:len(m.items)-1]
-			break
+			m.items[i].DeletedAt = &deletedAt
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockNoteRepo) Restore(ctx context.Context, id string) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items[i].DeletedAt = nil
 		}
 	}
 	return nil
 }
 
+func (m *mockNoteRepo) QueryTrash(ctx context.Context, userID, workspaceID string) ([]entity.Note, error) {
+	var notes []entity.Note
+	for _, item := range m.items {
+		owned := item.UserID == userID && item.WorkspaceID == nil
+		if workspaceID != "" {
+			owned = item.WorkspaceID != nil && *item.WorkspaceID == workspaceID
+		}
+		if owned && item.DeletedAt != nil {
+			notes = append(notes, item)
+		}
+	}
+	return notes, nil
+}
+
+func (m *mockNoteRepo) Purge(ctx context.Context, before time.Time) (int, error) {
+	var notes []entity.Note
+	for _, item := range m.items {
+		if item.DeletedAt == nil || !item.DeletedAt.Before(before) {
+			notes = append(notes, item)
+		}
+	}
+	count := len(m.items) - len(notes)
+	m.items = notes
+	return count, nil
+}
+
 func (m *mockNoteRepo) SharedNoteCreate(ctx context.Context, note *entity.SharedNote) error {
+	m.shares = append(m.shares, *note)
 	return nil
 }
 
 func (m *mockNoteRepo) GetSharedNoteByID(ctx context.Context, id string) (entity.SharedNote, error) {
-	return entity.SharedNote{}, nil
+	for _, share := range m.shares {
+		if share.ID == id {
+			return share, nil
+		}
+	}
+	return entity.SharedNote{}, sql.ErrNoRows
+}
+
+func (m *mockNoteRepo) GetSharedNote(ctx context.Context, noteID, userID string) (entity.SharedNote, error) {
+	for _, share := range m.shares {
+		if share.NoteID == noteID && share.SharedUserID == userID {
+			return share, nil
+		}
+	}
+	return entity.SharedNote{}, sql.ErrNoRows
+}
+
+func (m *mockNoteRepo) QueryShares(ctx context.Context, noteID string) ([]entity.SharedNote, error) {
+	var shares []entity.SharedNote
+	for _, share := range m.shares {
+		if share.NoteID == noteID {
+			shares = append(shares, share)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].SharedUserID < shares[j].SharedUserID })
+	return shares, nil
+}
+
+func (m *mockNoteRepo) SharedNoteUpdate(ctx context.Context, share entity.SharedNote) error {
+	for i, item := range m.shares {
+		if item.NoteID == share.NoteID && item.SharedUserID == share.SharedUserID {
+			m.shares[i] = share
+		}
+	}
+	return nil
+}
+
+func (m *mockNoteRepo) SharedNoteDelete(ctx context.Context, noteID, userID string) error {
+	for i, share := range m.shares {
+		if share.NoteID == noteID && share.SharedUserID == userID {
+			m.shares = append(m.shares[:i], m.shares[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockNoteRepo) QuerySharedNotes(ctx context.Context, userID string, filter NoteFilter) ([]entity.Note, error) {
+	notes := []entity.Note{}
+	for _, item := range m.items {
+		if item.UserID != userID && m.visible(item, userID, "") && m.matches(item, filter) {
+			notes = append(notes, item)
+		}
+	}
+	return notes, nil
 }
 
-func (m *mockNoteRepo) QuerySharedNotes(ctx context.Context, userID string) ([]entity.Note, error) {
-	return []entity.Note{}, nil
+func (m *mockNoteRepo) QueryAll(ctx context.Context) ([]entity.Note, error) {
+	var notes []entity.Note
+	for _, item := range m.items {
+		if item.DeletedAt == nil {
+			notes = append(notes, item)
+		}
+	}
+	return notes, nil
 }
 
-func (m *mockNoteRepo) SearchNotes(ctx context.Context, userID string, query string) ([]entity.Note, error) {
-	return []entity.Note{}, nil
+func (m *mockNoteRepo) QuerySearchable(ctx context.Context, userID string, ids []string, query SearchQuery, filter NoteFilter) ([]entity.Note, error) {
+	notes, _ := m.Query(ctx, userID, filter, NoteSort{}, 0, 0)
+	result := []entity.Note{}
+	for _, note := range notes {
+		for _, id := range ids {
+			if note.ID == id && m.searchable(note, userID, query) {
+				result = append(result, note)
+			}
+		}
+	}
+	return result, nil
+}
+
+// searchable reports whether the note satisfies the fields of the query other than the title.
+func (m *mockNoteRepo) searchable(note entity.Note, userID string, query SearchQuery) bool {
+	if len(query.Tags) > 0 && !m.matches(note, NoteFilter{Tags: query.Tags}) {
+		return false
+	}
+	if query.Owner != "" && note.UserID != query.Owner {
+		return false
+	}
+	if query.Shared != nil && *query.Shared == (note.UserID == userID) {
+		return false
+	}
+	if !query.After.IsZero() && note.UpdatedAt.Before(query.After) {
+		return false
+	}
+	if !query.Before.IsZero() && !note.UpdatedAt.Before(query.Before) {
+		return false
+	}
+	return true
+}
+
+func (m *mockNoteRepo) SetTags(ctx context.Context, noteID, userID string, names []string) error {
+	if m.tags == nil {
+		m.tags = map[string][]string{}
+	}
+	m.tags[noteID] = append([]string{}, names...)
+	sort.Strings(m.tags[noteID])
+	return nil
+}
+
+func (m *mockNoteRepo) QueryTags(ctx context.Context, noteIDs []string) (map[string][]string, error) {
+	tags := map[string][]string{}
+	for _, id := range noteIDs {
+		if names, ok := m.tags[id]; ok {
+			tags[id] = names
+		}
+	}
+	return tags, nil
+}
+
+func (m *mockNoteRepo) GetNotebook(ctx context.Context, id string) (entity.Notebook, error) {
+	for _, notebook := range m.notebooks {
+		if notebook.ID == id {
+			return notebook, nil
+		}
+	}
+	return entity.Notebook{}, sql.ErrNoRows
+}
+
+func (m *mockNoteRepo) createRevision(revision *entity.NoteRevision) {
+	revision.Revision = 1
+	for _, item := range m.revisions {
+		if item.NoteID == revision.NoteID && item.Revision >= revision.Revision {
+			revision.Revision = item.Revision + 1
+		}
+	}
+	m.revisions = append(m.revisions, *revision)
+}
+
+func (m *mockNoteRepo) GetRevision(ctx context.Context, noteID string, revision int) (entity.NoteRevision, error) {
+	for _, item := range m.revisions {
+		if item.NoteID == noteID && item.Revision == revision {
+			return item, nil
+		}
+	}
+	return entity.NoteRevision{}, sql.ErrNoRows
+}
+
+func (m *mockNoteRepo) QueryRevisions(ctx context.Context, noteID string) ([]entity.NoteRevision, error) {
+	var revisions []entity.NoteRevision
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		if m.revisions[i].NoteID == noteID {
+			revisions = append(revisions, m.revisions[i])
+		}
+	}
+	return revisions, nil
+}
+
+func (m *mockNoteRepo) pruneRevisions(noteID string, keep int) {
+	latest := 0
+	for _, item := range m.revisions {
+		if item.NoteID == noteID && item.Revision > latest {
+			latest = item.Revision
+		}
+	}
+	var revisions []entity.NoteRevision
+	for _, item := range m.revisions {
+		if item.NoteID != noteID || item.Revision > latest-keep {
+			revisions = append(revisions, item)
+		}
+	}
+	m.revisions = revisions
+}
+
+func (m *mockNoteRepo) DeleteUserNotes(ctx context.Context, userID string) ([]string, error) {
+	var ids []string
+	var notes []entity.Note
+	for _, item := range m.items {
+		if item.UserID == userID && item.WorkspaceID == nil {
+			ids = append(ids, item.ID)
+			continue
+		}
+		if item.UserID == userID {
+			item.UserID = entity.DeletedUserID
+		}
+		notes = append(notes, item)
+	}
+	m.items = notes
+	return ids, nil
+}
+
+// mockUsers finds the users whose names are the keys of the map, with the values as their IDs.
+type mockUsers map[string]string
+
+func (m mockUsers) GetByName(ctx context.Context, name string) (entity.User, error) {
+	if id, ok := m[name]; ok {
+		return entity.User{ID: id, Name: name}, nil
+	}
+	return entity.User{}, sql.ErrNoRows
 }
@@ -0,0 +1,61 @@
+package notes
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+)
+
+// SearchIndex finds the notes matching search queries.
+// Index and Remove keep the index up to date as notes are created, changed and moved to the trash.
+type SearchIndex interface {
+	// Count returns the number of notes visible to the given user that match the filter and the search query.
+	Count(ctx context.Context, userID string, query SearchQuery, filter NoteFilter) (int, error)
+	// Search returns the notes visible to the given user that match the filter and the search query,
+	// in the given sort order and with the given offset and limit.
+	Search(ctx context.Context, userID string, query SearchQuery, filter NoteFilter, sort NoteSort, offset, limit int) ([]SearchHit, error)
+	// Index adds the note to the index or updates it.
+	Index(ctx context.Context, note entity.Note) error
+	// Remove removes the note with the given ID from the index.
+	Remove(ctx context.Context, id string) error
+	// Suggest returns the titles starting with the prefix and the words starting with its last word that appear
+	// in the notes visible to the given user that match the filter, the most frequent first and at most limit of each.
+	Suggest(ctx context.Context, userID string, prefix string, filter NoteFilter, limit int) (Suggestions, error)
+}
+
+// SearchHit is a note matching a full-text search along with its rank and highlighted excerpt.
+type SearchHit struct {
+	entity.Note
+	Rank     float64
+	Headline string
+}
+
+// Suggestions holds the completions of a search prefix.
+type Suggestions struct {
+	// Titles are the titles of notes starting with the prefix.
+	Titles []Suggestion `json:"titles"`
+	// Terms are the words starting with the last word of the prefix.
+	Terms []Suggestion `json:"terms"`
+}
+
+// Suggestion is a completion of a search prefix.
+type Suggestion struct {
+	// Text is the title or the lower-cased word.
+	Text string `json:"text"`
+	// Count is the number of notes with the title or containing the word.
+	Count int `json:"count"`
+}
+
+// lastWord returns the lower-cased run of letters and digits ending the prefix, which is empty if the prefix ends
+// with any other character.
+func lastWord(prefix string) string {
+	i := strings.LastIndexFunc(prefix, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if i >= 0 {
+		_, size := utf8.DecodeRuneInString(prefix[i:])
+		prefix = prefix[i+size:]
+	}
+	return strings.ToLower(prefix)
+}
@@ -0,0 +1,663 @@
+package notes
+
+import (
+	"context"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+)
+
+const (
+	// bm25K1 controls how quickly the score of a term saturates as it is repeated in a note.
+	bm25K1 = 1.2
+	// bm25B controls how much the score of a term is normalized by the length of the note.
+	bm25B = 0.75
+	// headlineWords is the number of words in the excerpts of search results.
+	headlineWords = 35
+	// fuzzyThreshold is the similarity above which a word of a note matches a word of a fuzzy search, as the default
+	// word_similarity_threshold of pg_trgm.
+	fuzzyThreshold = 0.6
+)
+
+// the fields of the notes the memory index searches
+const (
+	fieldTitle = iota
+	fieldText
+	fieldCount
+)
+
+// fieldWeights weighs matches in the title above those in the text.
+var fieldWeights = [fieldCount]float64{2, 1}
+
+// MemorySearchIndex is an in-process inverted index of the notes that ranks matches with BM25, and fuzzy matches by
+// the trigram similarity of their words.
+// Words are lower-cased and stemmed, and stop words are left out. The index only holds the title and text of the
+// notes: visibility, tags and the other fields of a search are checked against the repository once the matching
+// notes are found. As it lives in the memory of a single server, it is meant for development, testing and
+// deployments running a single server.
+type MemorySearchIndex struct {
+	repo Repository
+
+	mu        sync.RWMutex
+	documents map[string]*memoryDocument
+	// postings maps each term to the notes containing it.
+	postings map[string]map[string]*memoryPosting
+	// words maps each lower-cased word, stop words included, to the notes containing it.
+	words map[string]*memoryWord
+	// totalLengths holds the sum of the lengths of each field over all notes.
+	totalLengths [fieldCount]int
+}
+
+// memoryDocument is a note in the memory index.
+type memoryDocument struct {
+	// lengths holds the number of terms in each field.
+	lengths [fieldCount]int
+	// terms holds the distinct terms of the note.
+	terms []string
+	// title is the lower-cased title of the note.
+	title string
+	// words holds the distinct words of the note.
+	words []string
+}
+
+// memoryWord is a word of the notes in the memory index.
+type memoryWord struct {
+	// trigrams holds the trigrams of the word.
+	trigrams map[string]bool
+	// fields maps the notes containing the word to the bit set of the fields they contain it in.
+	fields map[string]uint
+}
+
+// memoryPosting holds the positions of a term in each field of a note.
+type memoryPosting struct {
+	positions [fieldCount][]int
+}
+
+// token is a word found in a text.
+type token struct {
+	// term is the stemmed word, or empty for a stop word.
+	term string
+	// word is the lower-cased word.
+	word string
+	// pos is the position of the word in the text, counting stop words.
+	pos int
+	// start and end are the byte offsets of the word in the text.
+	start, end int
+}
+
+// NewMemorySearchIndex creates an empty memory search index that checks the matching notes against the repository.
+func NewMemorySearchIndex(repo Repository) *MemorySearchIndex {
+	return &MemorySearchIndex{
+		repo:      repo,
+		documents: map[string]*memoryDocument{},
+		postings:  map[string]map[string]*memoryPosting{},
+		words:     map[string]*memoryWord{},
+	}
+}
+
+// Rebuild indexes all notes of the repository that are not in the trash, replacing the current content of the index.
+func (idx *MemorySearchIndex) Rebuild(ctx context.Context) error {
+	notes, err := idx.repo.QueryAll(ctx)
+	if err != nil {
+		return err
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.documents = map[string]*memoryDocument{}
+	idx.postings = map[string]map[string]*memoryPosting{}
+	idx.words = map[string]*memoryWord{}
+	idx.totalLengths = [fieldCount]int{}
+	for _, note := range notes {
+		idx.add(note)
+	}
+	return nil
+}
+
+// Index adds the note to the index or updates it.
+func (idx *MemorySearchIndex) Index(ctx context.Context, note entity.Note) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(note.ID)
+	idx.add(note)
+	return nil
+}
+
+// Remove removes the note with the given ID from the index.
+func (idx *MemorySearchIndex) Remove(ctx context.Context, id string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+	return nil
+}
+
+// Count returns the number of notes visible to the given user that match the filter and the search query.
+func (idx *MemorySearchIndex) Count(ctx context.Context, userID string, query SearchQuery, filter NoteFilter) (int, error) {
+	hits, _, err := idx.search(ctx, userID, query, filter, NoteSort{Field: SortRelevance})
+	return len(hits), err
+}
+
+// Search returns the notes visible to the given user that match the filter and the search query, ranked by BM25.
+// Queries that only filter the notes without searching their text return the most recently updated notes first.
+// Sort orders other than SortRelevance replace the ranking.
+func (idx *MemorySearchIndex) Search(ctx context.Context, userID string, query SearchQuery, filter NoteFilter, sort NoteSort, offset, limit int) ([]SearchHit, error) {
+	hits, marked, err := idx.search(ctx, userID, query, filter, sort)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(hits) {
+		offset = len(hits)
+	}
+	hits = hits[offset:]
+	if limit >= 0 && limit < len(hits) {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		hits[i].Headline = headline(hits[i].Text, marked)
+	}
+	return hits, nil
+}
+
+// Suggest returns the titles starting with the prefix and the words starting with its last word, ranked by the
+// number of notes visible to the given user that match the filter they appear in.
+func (idx *MemorySearchIndex) Suggest(ctx context.Context, userID, prefix string, filter NoteFilter, limit int) (Suggestions, error) {
+	titlePrefix, word := strings.ToLower(prefix), lastWord(prefix)
+	candidates := map[string]bool{}
+	words := map[string][]string{}
+	idx.mu.RLock()
+	for id, doc := range idx.documents {
+		if strings.HasPrefix(doc.title, titlePrefix) {
+			candidates[id] = true
+		}
+	}
+	if word != "" {
+		for w, entry := range idx.words {
+			if !strings.HasPrefix(w, word) {
+				continue
+			}
+			for id := range entry.fields {
+				words[w] = append(words[w], id)
+				candidates[id] = true
+			}
+		}
+	}
+	idx.mu.RUnlock()
+
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	notes, err := idx.repo.QuerySearchable(ctx, userID, ids, SearchQuery{}, filter)
+	if err != nil {
+		return Suggestions{}, err
+	}
+	visible := map[string]bool{}
+	titles := map[string]int{}
+	for _, note := range notes {
+		visible[note.ID] = true
+		if strings.HasPrefix(strings.ToLower(note.Title), titlePrefix) {
+			titles[note.Title]++
+		}
+	}
+	terms := map[string]int{}
+	for w, ids := range words {
+		for _, id := range ids {
+			if visible[id] {
+				terms[w]++
+			}
+		}
+	}
+	return Suggestions{Titles: topSuggestions(titles, limit), Terms: topSuggestions(terms, limit)}, nil
+}
+
+// search returns all notes visible to the given user that match the filter and the search query, in rank order,
+// without their headlines, along with a function reporting whether a token of a note matches the words to find.
+func (idx *MemorySearchIndex) search(ctx context.Context, userID string, query SearchQuery, filter NoteFilter, order NoteSort) ([]SearchHit, func(token) bool, error) {
+	scores, marked := idx.match(query)
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	notes, err := idx.repo.QuerySearchable(ctx, userID, ids, query, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hits := make([]SearchHit, 0, len(notes))
+	for _, note := range notes {
+		hits = append(hits, SearchHit{Note: note, Rank: scores[note.ID]})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if order.Field != SortRelevance {
+			return sortedBefore(hits[i].Note, hits[j].Note, order)
+		}
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		if !query.HasText() && !hits[i].UpdatedAt.Equal(hits[j].UpdatedAt) {
+			return hits[i].UpdatedAt.After(hits[j].UpdatedAt)
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits, marked, nil
+}
+
+// match returns the IDs of the indexed notes matching the query along with their scores, and a function reporting
+// whether a token of a note matches the words to find. None of the excluded words or phrases may appear in the
+// matching notes. Words are matched as phrases of one word, and stop words match anything.
+func (idx *MemorySearchIndex) match(query SearchQuery) (map[string]float64, func(token) bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var scores map[string]float64
+	var marked func(token) bool
+	if query.Fuzzy {
+		scores, marked = idx.matchFuzzy(query)
+	} else {
+		scores, marked = idx.matchStems(query)
+	}
+	for _, text := range query.Excluded {
+		tokens := indexedTokens(text)
+		for id := range scores {
+			if len(tokens) > 0 && idx.matchPhrase(id, tokens, []int{fieldTitle, fieldText}) {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores, marked
+}
+
+// matchStems returns the IDs of the notes containing every word and phrase to find, in the title for those of the
+// title field, along with their BM25 scores. The caller must hold the read lock.
+func (idx *MemorySearchIndex) matchStems(query SearchQuery) (map[string]float64, func(token) bool) {
+	type phrase struct {
+		tokens []token
+		fields []int
+	}
+	var required []phrase
+	anyField := []int{fieldTitle, fieldText}
+	for _, text := range append(append([]string{}, query.Terms...), query.Phrases...) {
+		required = append(required, phrase{indexedTokens(text), anyField})
+	}
+	for _, text := range query.Title {
+		required = append(required, phrase{indexedTokens(text), []int{fieldTitle}})
+	}
+
+	// only the notes containing the rarest of the words to find can match, or any note if there is no such word
+	var rarest map[string]*memoryPosting
+	restricted := false
+	for _, p := range required {
+		for _, t := range p.tokens {
+			if postings := idx.postings[t.term]; !restricted || len(postings) < len(rarest) {
+				rarest, restricted = postings, true
+			}
+		}
+	}
+	var candidates []string
+	if restricted {
+		for id := range rarest {
+			candidates = append(candidates, id)
+		}
+	} else {
+		for id := range idx.documents {
+			candidates = append(candidates, id)
+		}
+	}
+
+	scores := map[string]float64{}
+	for _, id := range candidates {
+		matched := true
+		for _, p := range required {
+			if !idx.matchPhrase(id, p.tokens, p.fields) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			scores[id] = 0
+		}
+	}
+
+	terms := map[string]bool{}
+	for _, p := range required {
+		for _, t := range p.tokens {
+			if terms[t.term] {
+				continue
+			}
+			terms[t.term] = true
+			idx.score(t.term, scores)
+		}
+	}
+	return scores, func(t token) bool { return terms[t.term] }
+}
+
+// matchFuzzy returns the IDs of the notes in which every word to find is similar to a word of the note, of the title
+// for the words of the title field. The score of a note sums the best similarity of each word to find, weighing
+// the title above the text. The caller must hold the read lock.
+func (idx *MemorySearchIndex) matchFuzzy(query SearchQuery) (map[string]float64, func(token) bool) {
+	type fuzzyWord struct {
+		word   string
+		fields uint
+	}
+	var required []fuzzyWord
+	for _, text := range append(append([]string{}, query.Terms...), query.Phrases...) {
+		for _, t := range indexedTokens(text) {
+			required = append(required, fuzzyWord{t.word, 1<<fieldTitle | 1<<fieldText})
+		}
+	}
+	for _, text := range query.Title {
+		for _, t := range indexedTokens(text) {
+			required = append(required, fuzzyWord{t.word, 1 << fieldTitle})
+		}
+	}
+
+	scores := map[string]float64{}
+	for id := range idx.documents {
+		scores[id] = 0
+	}
+	similar := map[string]bool{}
+	for _, w := range required {
+		trigrams := wordTrigrams(w.word)
+		best := map[string]float64{}
+		for word, entry := range idx.words {
+			similarity := trigramSimilarity(trigrams, entry.trigrams)
+			if similarity < fuzzyThreshold {
+				continue
+			}
+			for id, fields := range entry.fields {
+				for field := 0; field < fieldCount; field++ {
+					if fields&w.fields&(1<<field) == 0 {
+						continue
+					}
+					similar[word] = true
+					if s := fieldWeights[field] * similarity; s > best[id] {
+						best[id] = s
+					}
+				}
+			}
+		}
+		for id := range scores {
+			if s, ok := best[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores, func(t token) bool { return similar[t.word] }
+}
+
+// sortedBefore reports whether note a comes before note b when sorted by the given field, or by ID if they are equal.
+func sortedBefore(a, b entity.Note, order NoteSort) bool {
+	cmp := 0
+	switch order.Field {
+	case SortUpdatedAt:
+		cmp = compareTimes(a.UpdatedAt, b.UpdatedAt)
+	case SortCreatedAt:
+		cmp = compareTimes(a.CreatedAt, b.CreatedAt)
+	case SortTitle:
+		cmp = strings.Compare(a.Title, b.Title)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.ID, b.ID)
+	}
+	if order.Desc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// compareTimes returns -1, 0 or 1 if a is before, equal to or after b.
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// matchPhrase reports whether the tokens appear one after the other in one of the given fields of the note.
+func (idx *MemorySearchIndex) matchPhrase(id string, tokens []token, fields []int) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	postings := make([]*memoryPosting, len(tokens))
+	for i, t := range tokens {
+		if postings[i] = idx.postings[t.term][id]; postings[i] == nil {
+			return false
+		}
+	}
+	for _, field := range fields {
+	next:
+		for _, start := range postings[0].positions[field] {
+			for i := 1; i < len(tokens); i++ {
+				want := start + tokens[i].pos - tokens[0].pos
+				positions := postings[i].positions[field]
+				if j := sort.SearchInts(positions, want); j == len(positions) || positions[j] != want {
+					continue next
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// score adds the BM25 score of the term to the scores of the notes containing it. The term frequencies of the fields
+// are weighed and normalized by the field lengths before being combined, as in BM25F.
+func (idx *MemorySearchIndex) score(term string, scores map[string]float64) {
+	postings := idx.postings[term]
+	n, df := float64(len(idx.documents)), float64(len(postings))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	for id, posting := range postings {
+		if _, ok := scores[id]; !ok {
+			continue
+		}
+		doc := idx.documents[id]
+		tf := 0.0
+		for field := 0; field < fieldCount; field++ {
+			if len(posting.positions[field]) == 0 {
+				continue
+			}
+			avg := float64(idx.totalLengths[field]) / n
+			norm := 1 - bm25B + bm25B*float64(doc.lengths[field])/avg
+			tf += fieldWeights[field] * float64(len(posting.positions[field])) / norm
+		}
+		scores[id] += idf * tf / (bm25K1 + tf)
+	}
+}
+
+// add indexes the note. The caller must hold the write lock.
+func (idx *MemorySearchIndex) add(note entity.Note) {
+	doc := &memoryDocument{title: strings.ToLower(note.Title)}
+	for field, text := range [fieldCount]string{note.Title, note.Text} {
+		for _, t := range tokenize(text) {
+			entry := idx.words[t.word]
+			if entry == nil {
+				entry = &memoryWord{trigrams: wordTrigrams(t.word), fields: map[string]uint{}}
+				idx.words[t.word] = entry
+			}
+			if _, ok := entry.fields[note.ID]; !ok {
+				doc.words = append(doc.words, t.word)
+			}
+			entry.fields[note.ID] |= 1 << field
+			if t.term == "" {
+				continue
+			}
+
+			posting := idx.postings[t.term][note.ID]
+			if posting == nil {
+				if idx.postings[t.term] == nil {
+					idx.postings[t.term] = map[string]*memoryPosting{}
+				}
+				posting = &memoryPosting{}
+				idx.postings[t.term][note.ID] = posting
+				doc.terms = append(doc.terms, t.term)
+			}
+			posting.positions[field] = append(posting.positions[field], t.pos)
+			doc.lengths[field]++
+		}
+		idx.totalLengths[field] += doc.lengths[field]
+	}
+	idx.documents[note.ID] = doc
+}
+
+// remove removes the note with the given ID from the index. The caller must hold the write lock.
+func (idx *MemorySearchIndex) remove(id string) {
+	doc, ok := idx.documents[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	for _, word := range doc.words {
+		delete(idx.words[word].fields, id)
+		if len(idx.words[word].fields) == 0 {
+			delete(idx.words, word)
+		}
+	}
+	for field := 0; field < fieldCount; field++ {
+		idx.totalLengths[field] -= doc.lengths[field]
+	}
+	delete(idx.documents, id)
+}
+
+// tokenize splits the text into words made of letters and digits, lower-cases and stems them, and blanks the terms
+// of stop words.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text + " " {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			t := token{word: strings.ToLower(text[start:i]), pos: len(tokens), start: start, end: i}
+			if !stopWords[t.word] {
+				t.term = stem(t.word)
+			}
+			tokens = append(tokens, t)
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// indexedTokens returns the tokens of the text that are not stop words.
+func indexedTokens(text string) []token {
+	var tokens []token
+	for _, t := range tokenize(text) {
+		if t.term != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// wordTrigrams returns the trigrams of the word padded with two spaces in front and one behind, as pg_trgm does.
+func wordTrigrams(word string) map[string]bool {
+	runes := []rune("  " + word + " ")
+	trigrams := make(map[string]bool, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		trigrams[string(runes[i:i+3])] = true
+	}
+	return trigrams
+}
+
+// trigramSimilarity returns the share of the trigrams of a word to find that a word of a note has.
+func trigramSimilarity(find, word map[string]bool) float64 {
+	common := 0
+	for trigram := range find {
+		if word[trigram] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(find))
+}
+
+// topSuggestions returns at most limit suggestions of the counted texts, the most frequent first.
+func topSuggestions(counts map[string]int, limit int) []Suggestion {
+	suggestions := make([]Suggestion, 0, len(counts))
+	for text, count := range counts {
+		if count > 0 {
+			suggestions = append(suggestions, Suggestion{Text: text, Count: count})
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Count != suggestions[j].Count {
+			return suggestions[i].Count > suggestions[j].Count
+		}
+		return suggestions[i].Text < suggestions[j].Text
+	})
+	if limit >= 0 && limit < len(suggestions) {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// headline returns an excerpt of the text starting a few words before the first of the marked words, with the marked
+// words wrapped in <mark> tags. The excerpt starts at the beginning of the text if none of the words are marked.
+// The text is HTML-escaped, so that the <mark> tags are the only markup in the excerpt.
+func headline(text string, marked func(token) bool) string {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return ""
+	}
+	first := 0
+	for i, t := range tokens {
+		if marked(t) {
+			first = i - 5
+			break
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+	last := first + headlineWords
+	if last > len(tokens) {
+		last = len(tokens)
+	}
+
+	var b strings.Builder
+	offset := tokens[first].start
+	for _, t := range tokens[first:last] {
+		if marked(t) {
+			b.WriteString(html.EscapeString(text[offset:t.start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(text[t.start:t.end]))
+			b.WriteString("</mark>")
+			offset = t.end
+		}
+	}
+	b.WriteString(html.EscapeString(text[offset:tokens[last-1].end]))
+	return b.String()
+}
+
+// stopWords are the English words too common to be worth indexing, as listed by Snowball.
+var stopWords = func() map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.Fields(`
+		i me my myself we our ours ourselves you your yours yourself yourselves he him his himself she her hers
+		herself it its itself they them their theirs themselves what which who whom this that these those am is are
+		was were be been being have has had having do does did doing a an the and but if or because as until while
+		of at by for with about against between into through during before after above below to from up down in out
+		on off over under again further then once here there when where why how all any both each few more most
+		other some such no nor not only own same so than too very s t can will just don should now`) {
+		words[word] = true
+	}
+	return words
+}()
@@ -0,0 +1,147 @@
+package notes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySearchIndex(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	repo := &mockNoteRepo{items: []entity.Note{
+		{ID: "1", Title: "Network setup", Text: "The printer is connected to the office network.", UserID: "user1", UpdatedAt: now},
+		{ID: "2", Title: "Meeting", Text: "Discussed the connection of the new office to the network of the company.", UserID: "user1", UpdatedAt: now.Add(-time.Hour)},
+		{ID: "3", Title: "Draft", Text: "A state of the art printer.", UserID: "user1", UpdatedAt: now.Add(-2 * time.Hour)},
+		{ID: "4", Title: "Network", Text: "Not visible to user1.", UserID: "user2", UpdatedAt: now},
+	}}
+	index := NewMemorySearchIndex(repo)
+	assert.Nil(t, index.Rebuild(ctx))
+
+	ids := func(query SearchQuery) []string {
+		hits, err := index.Search(ctx, "user1", query, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+		assert.Nil(t, err)
+		result := []string{}
+		for _, hit := range hits {
+			result = append(result, hit.ID)
+		}
+		return result
+	}
+
+	// stemming lets "connections" match "connected" and "connection"
+	assert.Equal(t, []string{"1", "2"}, ids(SearchQuery{Terms: []string{"connections"}}))
+	// matches in the title and in shorter notes rank higher
+	assert.Equal(t, []string{"1", "2"}, ids(SearchQuery{Terms: []string{"network"}}))
+	// every word must match
+	assert.Equal(t, []string{"1"}, ids(SearchQuery{Terms: []string{"network", "printer"}}))
+	// stop words are ignored, and the shorter note ranks first
+	assert.Equal(t, []string{"3", "1"}, ids(SearchQuery{Terms: []string{"the", "printer"}}))
+	// phrases match words in order, counting the stop words between them
+	assert.Equal(t, []string{"3"}, ids(SearchQuery{Phrases: []string{"state of the art"}}))
+	assert.Equal(t, []string{}, ids(SearchQuery{Phrases: []string{"art of the state"}}))
+	assert.Equal(t, []string{"2"}, ids(SearchQuery{Phrases: []string{"new office"}}))
+	// excluded words and phrases
+	assert.Equal(t, []string{"2"}, ids(SearchQuery{Terms: []string{"network"}, Excluded: []string{"printer"}}))
+	assert.Equal(t, []string{"1", "2"}, ids(SearchQuery{Excluded: []string{"state of the art"}}))
+	// title-only matches
+	assert.Equal(t, []string{"1"}, ids(SearchQuery{Title: []string{"network"}}))
+	// fuzzy searches tolerate typos, weighing the title above the text, and still exclude by stems
+	assert.Equal(t, []string{"1", "2"}, ids(SearchQuery{Terms: []string{"netwrk"}, Fuzzy: true}))
+	assert.Equal(t, []string{"1"}, ids(SearchQuery{Terms: []string{"netwrk", "printr"}, Fuzzy: true}))
+	assert.Equal(t, []string{"1"}, ids(SearchQuery{Title: []string{"netwrk"}, Fuzzy: true}))
+	assert.Equal(t, []string{"2"}, ids(SearchQuery{Terms: []string{"netwrk"}, Excluded: []string{"printers"}, Fuzzy: true}))
+	assert.Equal(t, []string{}, ids(SearchQuery{Terms: []string{"xyz"}, Fuzzy: true}))
+	// queries without words return the most recently updated notes first
+	assert.Equal(t, []string{"1", "2", "3"}, ids(SearchQuery{Owner: "user1"}))
+
+	hits, _ := index.Search(ctx, "user1", SearchQuery{Terms: []string{"printer"}}, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	if assert.Equal(t, 2, len(hits)) {
+		assert.True(t, hits[0].Rank > 0)
+		assert.Equal(t, "A state of the art <mark>printer</mark>", hits[0].Headline)
+	}
+	hits, _ = index.Search(ctx, "user1", SearchQuery{Terms: []string{"printr"}, Fuzzy: true}, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	if assert.Equal(t, 2, len(hits)) {
+		assert.Equal(t, "The <mark>printer</mark> is connected to the office network", hits[0].Headline)
+	}
+	// other sort orders replace the ranking
+	hits, _ = index.Search(ctx, "user1", SearchQuery{Terms: []string{"network"}}, NoteFilter{}, NoteSort{Field: SortTitle}, 0, 10)
+	if assert.Equal(t, 2, len(hits)) {
+		assert.Equal(t, "2", hits[0].ID)
+	}
+	count, err := index.Count(ctx, "user1", SearchQuery{Terms: []string{"network"}}, NoteFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	hits, _ = index.Search(ctx, "user1", SearchQuery{Terms: []string{"network"}}, NoteFilter{}, NoteSort{Field: SortRelevance}, 1, 10)
+	assert.Equal(t, 1, len(hits))
+
+	// updates replace the indexed text
+	assert.Nil(t, index.Index(ctx, entity.Note{ID: "3", Title: "Draft", Text: "Nothing to see", UserID: "user1"}))
+	repo.items[2].Text = "Nothing to see"
+	assert.Equal(t, []string{"1"}, ids(SearchQuery{Terms: []string{"printer"}}))
+	assert.Equal(t, []string{"3"}, ids(SearchQuery{Terms: []string{"see"}}))
+
+	// removed notes are no longer found
+	assert.Nil(t, index.Remove(ctx, "1"))
+	assert.Equal(t, []string{}, ids(SearchQuery{Terms: []string{"printer"}}))
+	assert.Equal(t, []string{"2"}, ids(SearchQuery{Terms: []string{"network"}}))
+}
+
+func TestMemorySearchIndex_Suggest(t *testing.T) {
+	ctx := context.Background()
+	repo := &mockNoteRepo{items: []entity.Note{
+		{ID: "1", Title: "Network setup", Text: "The printer is on the network.", UserID: "user1"},
+		{ID: "2", Title: "Network setup", Text: "Printers and networking.", UserID: "user1"},
+		{ID: "3", Title: "Meeting", Text: "The printer is broken.", UserID: "user1"},
+		{ID: "4", Title: "Networks", Text: "Not visible to user1.", UserID: "user2"},
+	}}
+	index := NewMemorySearchIndex(repo)
+	assert.Nil(t, index.Rebuild(ctx))
+
+	suggestions, err := index.Suggest(ctx, "user1", "Net", NoteFilter{}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []Suggestion{{Text: "Network setup", Count: 2}}, suggestions.Titles)
+	assert.Equal(t, []Suggestion{{Text: "network", Count: 2}, {Text: "networking", Count: 1}}, suggestions.Terms)
+
+	// words are completed from the last word of the prefix
+	suggestions, err = index.Suggest(ctx, "user1", "broken pri", NoteFilter{}, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []Suggestion{}, suggestions.Titles)
+	assert.Equal(t, []Suggestion{{Text: "printer", Count: 2}}, suggestions.Terms)
+
+	// a prefix ending with a space only completes titles
+	suggestions, err = index.Suggest(ctx, "user1", "meeting ", NoteFilter{}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []Suggestion{}, suggestions.Titles)
+	assert.Equal(t, []Suggestion{}, suggestions.Terms)
+}
+
+func Test_lastWord(t *testing.T) {
+	assert.Equal(t, "wor", lastWord("Hello Wor"))
+	assert.Equal(t, "b", lastWord("a-b"))
+	assert.Equal(t, "", lastWord("hello "))
+	assert.Equal(t, "café", lastWord("Café"))
+}
+
+func Test_tokenize(t *testing.T) {
+	tokens := tokenize("The Quick-brown foxes, 42!")
+	assert.Equal(t, []token{
+		{term: "", word: "the", pos: 0, start: 0, end: 3},
+		{term: "quick", word: "quick", pos: 1, start: 4, end: 9},
+		{term: "brown", word: "brown", pos: 2, start: 10, end: 15},
+		{term: "fox", word: "foxes", pos: 3, start: 16, end: 21},
+		{term: "42", word: "42", pos: 4, start: 23, end: 25},
+	}, tokens)
+}
+
+func Test_headline(t *testing.T) {
+	apple := func(t token) bool { return t.term == "appl" }
+	assert.Equal(t, "buy <mark>apples</mark> and <mark>Apple</mark> pie", headline("buy apples and Apple pie", apple))
+	assert.Equal(t, "no match here", headline("no match here", apple))
+	assert.Equal(t, "", headline("", apple))
+	assert.Equal(t, "f g h i j <mark>apple</mark>", headline("a b c d e f g h i j apple", apple))
+	assert.Equal(t, "an &lt;script&gt;<mark>apple</mark>&lt;/script&gt; &amp; pie",
+		headline("an <script>apple</script> & pie", apple))
+}
@@ -0,0 +1,221 @@
+package notes
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// postgresSearchIndex searches notes with the full-text search of Postgres. The text_searchable column it searches
+// is generated by the database from the title and text of the notes, so there is nothing to index.
+type postgresSearchIndex struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewPostgresSearchIndex creates a search index backed by the full-text search of Postgres.
+func NewPostgresSearchIndex(db *dbcontext.DB, logger log.Logger) SearchIndex {
+	return postgresSearchIndex{db, logger}
+}
+
+// Count returns the number of notes visible to the given user that match the filter and the search query.
+func (i postgresSearchIndex) Count(ctx context.Context, userID string, query SearchQuery, filter NoteFilter) (int, error) {
+	var count int
+	tables, params := searchTables(query)
+	err := i.db.With(ctx).
+		Select("COUNT(*)").
+		From(tables...).
+		Where(dbx.And(visibleTo(userID, filter.WorkspaceID), searching(userID, query), matching(filter))).
+		Bind(params).
+		Row(&count)
+	return count, err
+}
+
+// Search retrieves the notes visible to the given user that match the filter and the search query
+// from the database. The notes are ranked by cover density, weighing matches in the title above those in the text,
+// and come with an excerpt of the text in which the matches are highlighted. Fuzzy searches rank the notes by the
+// trigram similarity of the words and phrases instead, and return the beginning of the text without highlights.
+// Queries that only filter the notes without searching their text return the most recently updated notes first.
+// Sort orders other than SortRelevance replace the ranking.
+func (i postgresSearchIndex) Search(ctx context.Context, userID string, query SearchQuery, filter NoteFilter, sort NoteSort, offset, limit int) ([]SearchHit, error) {
+	var hits []SearchHit
+	tables, params := searchTables(query)
+	columns := []string{"notes.*", "0 AS rank", "left(notes.text, 200) AS headline"}
+	order := []string{"notes.updated_at DESC", "notes.id"}
+	if query.Fuzzy && query.HasText() {
+		columns[1] = fuzzyRank(query, params) + " AS rank"
+		order = []string{"rank DESC", "notes.id"}
+	} else if query.HasText() {
+		columns = []string{
+			"notes.*",
+			"ts_rank_cd(notes.text_searchable, search_query) AS rank",
+			"ts_headline('english', notes.text, search_query, '" + headlineOptions + "') AS headline",
+		}
+		order = []string{"rank DESC", "notes.id"}
+	}
+	if sort.Field != SortRelevance {
+		order = orderBy(sort)
+	}
+	err := i.db.With(ctx).
+		Select(columns...).
+		From(tables...).
+		Where(dbx.And(visibleTo(userID, filter.WorkspaceID), searching(userID, query), matching(filter))).
+		OrderBy(order...).
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		Bind(params).
+		All(&hits)
+	for i := range hits {
+		hits[i].Headline = markHeadline(hits[i].Headline)
+	}
+	return hits, err
+}
+
+// Suggest retrieves from the database the titles and the words of the notes visible to the given user that match
+// the filter and complete the prefix, ordered by the number of notes they appear in.
+func (i postgresSearchIndex) Suggest(ctx context.Context, userID string, prefix string, filter NoteFilter, limit int) (Suggestions, error) {
+	suggestions := Suggestions{Titles: []Suggestion{}, Terms: []Suggestion{}}
+	err := i.db.With(ctx).
+		Select("notes.title AS text", "COUNT(*) AS count").
+		From("notes").
+		Where(dbx.And(visibleTo(userID, filter.WorkspaceID), matching(filter), dbx.Like("lower(notes.title)", strings.ToLower(prefix)).Match(false, true))).
+		GroupBy("notes.title").
+		OrderBy("count DESC", "text").
+		Limit(int64(limit)).
+		All(&suggestions.Titles)
+	if err != nil {
+		return Suggestions{}, err
+	}
+	if word := lastWord(prefix); word != "" {
+		err = i.db.With(ctx).
+			Select("word AS text", "COUNT(DISTINCT notes.id) AS count").
+			From("notes", "regexp_split_to_table(lower(notes.title || ' ' || notes.text), '[^[:alnum:]]+') word").
+			Where(dbx.And(visibleTo(userID, filter.WorkspaceID), matching(filter), dbx.Like("word", word).Match(false, true))).
+			GroupBy("word").
+			OrderBy("count DESC", "text").
+			Limit(int64(limit)).
+			All(&suggestions.Terms)
+	}
+	return suggestions, err
+}
+
+// Index does nothing as the database maintains the search vectors of the notes.
+func (i postgresSearchIndex) Index(ctx context.Context, note entity.Note) error {
+	return nil
+}
+
+// Remove does nothing as trashed notes are excluded from the search by the query.
+func (i postgresSearchIndex) Remove(ctx context.Context, id string) error {
+	return nil
+}
+
+// searchTables returns the tables to search the notes from. For queries that search the text of the notes,
+// they include the tsquery built from the words and phrases of the query as "search_query". For fuzzy queries,
+// the tsquery only holds the excluded words and phrases.
+func searchTables(query SearchQuery) ([]string, dbx.Params) {
+	if !tsQueried(query) {
+		return []string{"notes"}, dbx.Params{}
+	}
+	var parts []string
+	params := dbx.Params{}
+	add := func(prefix string, values []string, negate bool) {
+		for i, value := range values {
+			name := fmt.Sprintf("%v%v", prefix, i)
+			part := fmt.Sprintf("phraseto_tsquery('english', {:%v})", name)
+			if negate {
+				part = "!!" + part
+			}
+			parts = append(parts, part)
+			params[name] = value
+		}
+	}
+	if !query.Fuzzy {
+		add("term", query.Terms, false)
+		add("phrase", query.Phrases, false)
+		add("title", query.Title, false)
+	}
+	add("excluded", query.Excluded, true)
+	return []string{"notes", "(" + strings.Join(parts, " && ") + ") search_query"}, params
+}
+
+// tsQueried reports whether the notes are searched with a tsquery built from the query.
+func tsQueried(query SearchQuery) bool {
+	if query.Fuzzy {
+		return len(query.Excluded) > 0
+	}
+	return query.HasText()
+}
+
+// searching returns a condition matching the notes that satisfy the search query, or nil for an empty query.
+// Fuzzy queries match the words and phrases that are similar to the ones in the notes, as measured by pg_trgm.
+func searching(userID string, query SearchQuery) dbx.Expression {
+	var conditions []dbx.Expression
+	if tsQueried(query) {
+		conditions = append(conditions, dbx.NewExp("notes.text_searchable @@ search_query"))
+	}
+	if query.Fuzzy {
+		for i, value := range append(append([]string{}, query.Terms...), query.Phrases...) {
+			name := fmt.Sprintf("fuzzy%v", i)
+			conditions = append(conditions, dbx.NewExp(
+				fmt.Sprintf("({:%v} <%% notes.title OR {:%v} <%% notes.text)", name, name),
+				dbx.Params{name: value},
+			))
+		}
+	}
+	for i, title := range query.Title {
+		name := fmt.Sprintf("title%v", i)
+		condition := "to_tsvector('english', notes.title) @@ phraseto_tsquery('english', {:%v})"
+		if query.Fuzzy {
+			name = fmt.Sprintf("fuzzy_title%v", i)
+			condition = "{:%v} <%% notes.title"
+		}
+		conditions = append(conditions, dbx.NewExp(fmt.Sprintf(condition, name), dbx.Params{name: title}))
+	}
+	if fields := searchFields(userID, query); fields != nil {
+		conditions = append(conditions, fields)
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	return dbx.And(conditions...)
+}
+
+// fuzzyRank returns the expression ranking the notes matched by a fuzzy query, adding its parameters to params.
+// It sums the word similarity of each word and phrase of the query, weighing the title twice as much as the text.
+func fuzzyRank(query SearchQuery, params dbx.Params) string {
+	parts := []string{"0"}
+	for i, value := range append(append([]string{}, query.Terms...), query.Phrases...) {
+		name := fmt.Sprintf("fuzzy%v", i)
+		params[name] = value
+		parts = append(parts, fmt.Sprintf("greatest(2 * word_similarity({:%v}, notes.title), word_similarity({:%v}, notes.text))", name, name))
+	}
+	for i, value := range query.Title {
+		name := fmt.Sprintf("fuzzy_title%v", i)
+		params[name] = value
+		parts = append(parts, fmt.Sprintf("2 * word_similarity({:%v}, notes.title)", name))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// headlineStart and headlineStop delimit the matches in the excerpts returned by ts_headline. They are characters
+// of the private use area rather than HTML tags, as the excerpts are not escaped by the database.
+const (
+	headlineStart = "\uE000"
+	headlineStop  = "\uE001"
+)
+
+// headlineOptions tells ts_headline how to highlight the matches in the excerpts of search results.
+const headlineOptions = "StartSel=" + headlineStart + ", StopSel=" + headlineStop + ", MaxFragments=3"
+
+// markHeadline HTML-escapes an excerpt returned by the database and wraps its matches in <mark> tags.
+func markHeadline(excerpt string) string {
+	excerpt = html.EscapeString(excerpt)
+	excerpt = strings.Replace(excerpt, headlineStart, "<mark>", -1)
+	return strings.Replace(excerpt, headlineStop, "</mark>", -1)
+}
@@ -0,0 +1,13 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_markHeadline(t *testing.T) {
+	assert.Equal(t, "buy <mark>apples</mark> &amp; pie", markHeadline("buy "+headlineStart+"apples"+headlineStop+" & pie"))
+	assert.Equal(t, "&lt;script&gt;<mark>alert</mark>(1)&lt;/script&gt;",
+		markHeadline("<script>"+headlineStart+"alert"+headlineStop+"(1)</script>"))
+}
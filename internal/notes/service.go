@@ -2,27 +2,97 @@ package notes
 
 import (
 	"context"
+	"database/sql"
+	"strings"
 	"time"
+	"unicode"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/qiangxue/go-rest-api/internal/auth"
 	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/qiangxue/go-rest-api/pkg/pagination"
 )
 
 // Service encapsulates usecase logic for notes.
 type Service interface {
 	Get(ctx context.Context, id string) (Note, error)
-	Query(ctx context.Context, offset, limit int) ([]Note, error)
-	QueryByUser(ctx context.Context, userId string) ([]Note, error)
-	Count(ctx context.Context) (int, error)
+	Query(ctx context.Context, filter NoteFilter, sort NoteSort, offset, limit int) ([]Note, error)
+	QueryCursor(ctx context.Context, filter NoteFilter, sort NoteSort, cursor *pagination.Cursor, limit int) ([]Note, bool, error)
+	QueryByUser(ctx context.Context, filter NoteFilter) ([]Note, error)
+	Count(ctx context.Context, filter NoteFilter) (int, error)
 	Create(ctx context.Context, input CreateNoteRequest) (Note, error)
-	Update(ctx context.Context, id string, input UpdateNoteRequest) (Note, error)
-	Delete(ctx context.Context, id string) (Note, error)
+	Update(ctx context.Context, id string, version int, input UpdateNoteRequest) (Note, error)
+	Delete(ctx context.Context, id string, version int) (Note, error)
 	ShareNote(ctx context.Context, noteID string, input ShareNoteRequest) (SharedNote, error)
-	QuerySharedNotes(ctx context.Context, userID string) ([]Note, error)
-	SearchNotes(ctx context.Context, userID string, query string) ([]Note, error)
+	QueryShares(ctx context.Context, noteID string) ([]SharedNote, error)
+	UpdateShare(ctx context.Context, noteID, userID string, input UpdateShareRequest) (SharedNote, error)
+	Unshare(ctx context.Context, noteID, userID string) (SharedNote, error)
+	QuerySharedNotes(ctx context.Context, filter NoteFilter) ([]Note, error)
+	CountSearch(ctx context.Context, query string, mode SearchMode, filter NoteFilter) (int, error)
+	SearchNotes(ctx context.Context, query string, mode SearchMode, filter NoteFilter, sort NoteSort, offset, limit int) ([]SearchResult, error)
+	Suggest(ctx context.Context, prefix string) (Suggestions, error)
+	QueryRevisions(ctx context.Context, id string) ([]NoteRevision, error)
+	GetRevision(ctx context.Context, id string, revision int) (NoteRevision, error)
+	DiffRevisions(ctx context.Context, id string, from, to int) (RevisionDiff, error)
+	RestoreRevision(ctx context.Context, id string, revision int) (Note, error)
+	QueryTrash(ctx context.Context) ([]Note, error)
+	RestoreFromTrash(ctx context.Context, id string) (Note, error)
 }
 
+// SearchResult is a note found by a full-text search.
+type SearchResult struct {
+	Note
+	// Rank is the relevance of the note to the query. Higher ranks are more relevant.
+	Rank float64 `json:"rank"`
+	// Headline is an HTML-escaped excerpt of the text of the note with the matches wrapped in <mark> tags.
+	Headline string `json:"headline"`
+}
+
+// SearchMode selects how the words and phrases of a search query are matched.
+type SearchMode string
+
+const (
+	// SearchFullText matches the stems of the words.
+	SearchFullText SearchMode = "fulltext"
+	// SearchFuzzy matches the words by trigram similarity, which tolerates typos.
+	SearchFuzzy SearchMode = "fuzzy"
+)
+
+// suggestionLimit is the maximum number of titles and of words suggested to complete a search prefix.
+const suggestionLimit = 10
+
+// rolePermissions maps the roles a note can be shared with to the permission they grant.
+var rolePermissions = map[string]permission{
+	entity.RoleViewer:    permRead,
+	entity.RoleCommenter: permComment,
+	entity.RoleEditor:    permWrite,
+}
+
+// workspacePermissions maps the roles of workspace members to the permission they grant on the notes of the
+// workspace. Members also manage the notes they created.
+var workspacePermissions = map[string]permission{
+	entity.RoleOwner:  permManage,
+	entity.RoleAdmin:  permManage,
+	entity.RoleMember: permWrite,
+	entity.RoleGuest:  permRead,
+}
+
+// permission represents the level of access a user holds on a note.
+type permission int
+
+const (
+	// permRead allows reading a note.
+	permRead permission = iota
+	// permComment allows commenting on a note.
+	permComment
+	// permWrite allows changing the title and text of a note.
+	permWrite
+	// permManage allows deleting and sharing a note. Only the owner and the workspace admins hold it.
+	permManage
+)
+
 // Note represents the data about an note.
 //
 //	type Note struct {
@@ -35,69 +105,385 @@ type Note struct {
 	UserID    string    `json:"user_id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+	Tags      []string  `json:"tags"`
+	// NotebookID is the notebook the note is filed in, or nil if it is not in any notebook.
+	NotebookID *string `json:"notebook_id"`
+	// WorkspaceID is the workspace owning the note, or nil for a personal note.
+	WorkspaceID *string `json:"workspace_id"`
+	// DeletedAt is set when the note is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// NoteFilter narrows down the notes returned by the listing and search operations.
+type NoteFilter struct {
+	// Tags lists the normalized names of the tags the notes must carry.
+	Tags []string
+	// AnyTag makes a note match if it carries any of the tags rather than all of them.
+	AnyTag bool
+	// CreatedAfter, if not zero, only matches the notes created after the given time.
+	CreatedAfter time.Time
+	// UpdatedBefore, if not zero, only matches the notes last updated before the given time.
+	UpdatedBefore time.Time
+	// WorkspaceID, if not empty, only matches the notes owned by the workspace. Otherwise only the personal notes
+	// of the user and the notes shared with them match. It is set by the service from the active workspace.
+	WorkspaceID string
+}
+
+const (
+	// SortUpdatedAt orders notes by the time they were last updated.
+	SortUpdatedAt = "updated_at"
+	// SortCreatedAt orders notes by the time they were created.
+	SortCreatedAt = "created_at"
+	// SortTitle orders notes by their titles.
+	SortTitle = "title"
+	// SortRelevance orders search results by their rank, most relevant first. It only applies to searches.
+	SortRelevance = "relevance"
+)
+
+// NoteSort specifies the order of the notes in a listing. Notes with equal sort keys are ordered by ID.
+type NoteSort struct {
+	// Field is one of SortUpdatedAt, SortCreatedAt and SortTitle, or SortRelevance for searches.
+	Field string
+	// Desc orders the notes in descending order.
+	Desc bool
+}
+
+// String returns the name of the sort order, such as "updated_at:desc".
+func (s NoteSort) String() string {
+	if s.Desc {
+		return s.Field + ":desc"
+	}
+	return s.Field + ":asc"
+}
+
+// Cursor returns the pagination cursor pointing to the position of the given note in a listing sorted by s.
+func (s NoteSort) Cursor(note Note, backward bool) pagination.Cursor {
+	key := note.Title
+	switch s.Field {
+	case SortUpdatedAt:
+		key = note.UpdatedAt.Format(time.RFC3339Nano)
+	case SortCreatedAt:
+		key = note.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return pagination.Cursor{Sort: s.String(), Key: key, ID: note.ID, Backward: backward}
+}
+
+// keyset converts a pagination cursor created for the sort order s into a position in the note listing.
+func (s NoteSort) keyset(cursor pagination.Cursor) (*Keyset, error) {
+	if cursor.Sort != s.String() {
+		return nil, errors.BadRequest("the cursor does not match the sort order")
+	}
+	position := &Keyset{Key: cursor.Key, ID: cursor.ID, Backward: cursor.Backward}
+	if s.Field == SortUpdatedAt || s.Field == SortCreatedAt {
+		t, err := time.Parse(time.RFC3339Nano, cursor.Key)
+		if err != nil {
+			return nil, errors.BadRequest("invalid cursor")
+		}
+		position.Key = t
+	}
+	return position, nil
+}
+
+// newNote converts a note entity into the data returned to the API clients.
+// The tags of the note are left empty.
+func newNote(note entity.Note) Note {
+	return Note{
+		ID:          note.ID,
+		Title:       note.Title,
+		Text:        note.Text,
+		UserID:      note.UserID,
+		CreatedAt:   note.CreatedAt,
+		UpdatedAt:   note.UpdatedAt,
+		Version:     note.Version,
+		Tags:        []string{},
+		NotebookID:  note.NotebookID,
+		WorkspaceID: note.WorkspaceID,
+		DeletedAt:   note.DeletedAt,
+	}
+}
+
+// newNotes converts note entities into the data returned to the API clients, including their tags.
+func (s service) newNotes(ctx context.Context, items []entity.Note) ([]Note, error) {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	tags, err := s.repo.QueryTags(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := []Note{}
+	for _, item := range items {
+		note := newNote(item)
+		if names, ok := tags[item.ID]; ok {
+			note.Tags = names
+		}
+		result = append(result, note)
+	}
+	return result, nil
+}
+
+// newNoteWithTags converts a note entity into the data returned to the API clients, including its tags.
+func (s service) newNoteWithTags(ctx context.Context, item entity.Note) (Note, error) {
+	notes, err := s.newNotes(ctx, []entity.Note{item})
+	if err != nil {
+		return Note{}, err
+	}
+	return notes[0], nil
+}
+
+// normalizeTags normalizes the given tag names and removes the blank and duplicate ones.
+// A nil slice is returned as is, so that it can still be told apart from an empty one.
+func normalizeTags(names []string) []string {
+	if names == nil {
+		return nil
+	}
+	result := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = entity.NormalizeTagName(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	return result
 }
 
 type SharedNote struct {
 	entity.SharedNote
 }
 
+// NoteRevision represents a revision of a note.
+type NoteRevision struct {
+	entity.NoteRevision
+}
+
+// RevisionDiff represents the line-based difference between two revisions of a note.
+type RevisionDiff struct {
+	NoteID string     `json:"note_id"`
+	From   int        `json:"from"`
+	To     int        `json:"to"`
+	Title  []DiffLine `json:"title"`
+	Text   []DiffLine `json:"text"`
+}
+
 // CreateNoteRequest represents an note creation request.
+// The note is not filed in any notebook if NotebookID is empty.
 type CreateNoteRequest struct {
-	Title  string `json:"title"`
-	Text   string `json:"text"`
-	UserID string `json:"user_id"`
+	Title      string   `json:"title"`
+	Text       string   `json:"text"`
+	Tags       []string `json:"tags"`
+	NotebookID string   `json:"notebook_id"`
 }
 
 // ShareNoteRequest represents an note sharing request.
+// The user to share the note with is given either by ID or by Username.
+// The note is shared with the viewer role if no role is given.
 type ShareNoteRequest struct {
 	ID           string `json:"id"`
 	NoteID       string `json:"note_id"`
 	SharedUserID string `json:"shared_user_id"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
 }
 
 func (s ShareNoteRequest) Validate() error {
 	return validation.ValidateStruct(&s,
-		validation.Field(&s.SharedUserID, validation.Required),
+		validation.Field(&s.SharedUserID, validation.When(s.Username == "", validation.Required)),
 		validation.Field(&s.NoteID, validation.Required),
+		validation.Field(&s.Role, validation.In(entity.RoleViewer, entity.RoleCommenter, entity.RoleEditor)),
 	)
 }
 
+// UpdateShareRequest represents a request to change the role of a collaborator.
+type UpdateShareRequest struct {
+	Role string `json:"role"`
+}
+
+// Validate validates the UpdateShareRequest fields.
+func (m UpdateShareRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Role, validation.Required, validation.In(entity.RoleViewer, entity.RoleCommenter, entity.RoleEditor)),
+	)
+}
+
+// ShareNote shares the note with another user. Only the owner of the note may share it, and not with themselves.
+// A note cannot be shared twice with the same user.
 func (s service) ShareNote(ctx context.Context, noteID string, req ShareNoteRequest) (SharedNote, error) {
 	if err := req.Validate(); err != nil {
 		return SharedNote{}, err
 	}
+	note, err := s.authorize(ctx, noteID, permManage)
+	if err != nil {
+		return SharedNote{}, err
+	}
+	if req.Username != "" {
+		user, err := s.users.GetByName(ctx, req.Username)
+		if err == sql.ErrNoRows {
+			return SharedNote{}, errors.NotFound("the user does not exist")
+		} else if err != nil {
+			return SharedNote{}, err
+		}
+		req.SharedUserID = user.GetID()
+	}
+	if req.SharedUserID == note.UserID {
+		return SharedNote{}, errors.BadRequest("a note cannot be shared with its owner")
+	}
+	if _, err := s.repo.GetSharedNote(ctx, noteID, req.SharedUserID); err == nil {
+		return SharedNote{}, errors.Conflict("the note is already shared with this user")
+	} else if err != sql.ErrNoRows {
+		return SharedNote{}, err
+	}
+	if req.Role == "" {
+		req.Role = entity.RoleViewer
+	}
 	id := entity.GenerateID()
 	sharedNote := entity.SharedNote{
 		ID:           id,
 		NoteID:       noteID,
 		SharedUserID: req.SharedUserID,
+		Role:         req.Role,
 	}
-	err := s.repo.SharedNoteCreate(ctx, &sharedNote)
-	if err != nil {
+	if err := s.repo.SharedNoteCreate(ctx, &sharedNote); err != nil {
 		return SharedNote{}, err
 	}
 	return s.GetSharedNoteByID(ctx, id)
 }
 
-func (s service) SearchNotes(ctx context.Context, userID string, query string) ([]Note, error) {
-	notes, err := s.repo.SearchNotes(ctx, userID, query)
+// QueryShares returns the collaborators of the note with the specified ID. Everyone who can read the note may
+// list them.
+func (s service) QueryShares(ctx context.Context, noteID string) ([]SharedNote, error) {
+	if _, err := s.authorize(ctx, noteID, permRead); err != nil {
+		return nil, err
+	}
+	items, err := s.repo.QueryShares(ctx, noteID)
 	if err != nil {
 		return nil, err
 	}
-	result := []Note{}
-	for _, note := range notes {
-		result = append(result, Note{
-			ID:        note.ID,
-			Title:     note.Title,
-			Text:      note.Text,
-			UserID:    note.UserID,
-			CreatedAt: note.CreatedAt,
-			UpdatedAt: note.UpdatedAt,
-		})
+	result := []SharedNote{}
+	for _, item := range items {
+		result = append(result, SharedNote{item})
 	}
 	return result, nil
 }
 
+// UpdateShare changes the role of the given collaborator of the note. Only the owner of the note may change it.
+func (s service) UpdateShare(ctx context.Context, noteID, userID string, req UpdateShareRequest) (SharedNote, error) {
+	if err := req.Validate(); err != nil {
+		return SharedNote{}, err
+	}
+	if _, err := s.authorize(ctx, noteID, permManage); err != nil {
+		return SharedNote{}, err
+	}
+	share, err := s.repo.GetSharedNote(ctx, noteID, userID)
+	if err != nil {
+		return SharedNote{}, err
+	}
+	share.Role = req.Role
+	if err := s.repo.SharedNoteUpdate(ctx, share); err != nil {
+		return SharedNote{}, err
+	}
+	return SharedNote{share}, nil
+}
+
+// Unshare revokes the access of the given collaborator to the note. The owner of the note may revoke anyone's
+// access, while collaborators may only give up their own.
+func (s service) Unshare(ctx context.Context, noteID, userID string) (SharedNote, error) {
+	perm := permManage
+	if identity := auth.CurrentUser(ctx); identity != nil && identity.GetID() == userID {
+		perm = permRead
+	}
+	if _, err := s.authorize(ctx, noteID, perm); err != nil {
+		return SharedNote{}, err
+	}
+	share, err := s.repo.GetSharedNote(ctx, noteID, userID)
+	if err != nil {
+		return SharedNote{}, err
+	}
+	if err := s.repo.SharedNoteDelete(ctx, noteID, userID); err != nil {
+		return SharedNote{}, err
+	}
+	return SharedNote{share}, nil
+}
+
+// CountSearch returns the number of notes visible to the current user that match the given query.
+func (s service) CountSearch(ctx context.Context, query string, mode SearchMode, filter NoteFilter) (int, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return 0, errors.Unauthorized("")
+	}
+	q, err := parseSearchQuery(query, mode, identity.GetID())
+	if err != nil {
+		return 0, err
+	}
+	return s.index.Count(ctx, identity.GetID(), q, scope(identity, filter))
+}
+
+// SearchNotes returns the notes visible to the current user that match the given query, most relevant first
+// unless another sort order is given.
+func (s service) SearchNotes(ctx context.Context, query string, mode SearchMode, filter NoteFilter, sort NoteSort, offset, limit int) ([]SearchResult, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	q, err := parseSearchQuery(query, mode, identity.GetID())
+	if err != nil {
+		return nil, err
+	}
+	hits, err := s.index.Search(ctx, identity.GetID(), q, scope(identity, filter), sort, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]entity.Note, len(hits))
+	for i, hit := range hits {
+		items[i] = hit.Note
+	}
+	notes, err := s.newNotes(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	results := []SearchResult{}
+	for i, note := range notes {
+		results = append(results, SearchResult{Note: note, Rank: hits[i].Rank, Headline: hits[i].Headline})
+	}
+	return results, nil
+}
+
+// Suggest returns the titles and words of the notes visible to the current user that complete the given prefix,
+// most frequent first.
+func (s service) Suggest(ctx context.Context, prefix string) (Suggestions, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Suggestions{}, errors.Unauthorized("")
+	}
+	prefix = strings.TrimLeftFunc(prefix, unicode.IsSpace)
+	if prefix == "" {
+		return Suggestions{}, errors.BadRequest("the prefix must not be empty")
+	}
+	return s.index.Suggest(ctx, identity.GetID(), prefix, scope(identity, NoteFilter{}), suggestionLimit)
+}
+
+// parseSearchQuery parses a search query of the given user in the given mode, resolving "owner:me" to the user's ID.
+func parseSearchQuery(input string, mode SearchMode, userID string) (SearchQuery, error) {
+	if strings.TrimSpace(input) == "" {
+		return SearchQuery{}, errQueryRequired
+	}
+	query, err := ParseSearchQuery(input)
+	if err != nil {
+		return SearchQuery{}, err.(*QuerySyntaxError).BadRequest()
+	}
+	if query.Owner == ownerMe {
+		query.Owner = userID
+	}
+	query.Fuzzy = mode == SearchFuzzy
+	return query, nil
+}
+
+// errQueryRequired is returned when a search is requested without a query.
+var errQueryRequired = errors.BadRequest("the search query must not be empty")
+
 func (s service) GetSharedNoteByID(ctx context.Context, id string) (SharedNote, error) {
 	sharedNote, err := s.repo.GetSharedNoteByID(ctx, id)
 	if err != nil {
@@ -111,172 +497,464 @@ func (m CreateNoteRequest) Validate() error {
 	return validation.ValidateStruct(&m,
 		validation.Field(&m.Title, validation.Required, validation.Length(0, 128)),
 		validation.Field(&m.Text, validation.Required, validation.Length(0, 1024)),
+		validation.Field(&m.Tags, validation.Each(validation.Length(0, 64))),
 	)
 }
 
 // UpdateNoteRequest represents an note update request.
+// The tags of the note are left unchanged if Tags is nil, and removed if it is empty.
+// Likewise the note stays in its notebook if NotebookID is nil, and is taken out of it if NotebookID is empty.
 type UpdateNoteRequest struct {
-	Title string `json:"title"`
-	Text  string `json:"text"`
+	// Title and Text are only changed if they are given.
+	Title      *string  `json:"title"`
+	Text       *string  `json:"text"`
+	Tags       []string `json:"tags"`
+	NotebookID *string  `json:"notebook_id"`
 }
 
-// Validate validates the CreateNoteRequest fields.
+// Validate validates the UpdateNoteRequest fields.
 func (m UpdateNoteRequest) Validate() error {
 	return validation.ValidateStruct(&m,
-		validation.Field(&m.Title, validation.Length(1, 128)),
-		validation.Field(&m.Text, validation.Length(1, 1024)),
+		validation.Field(&m.Title, validation.NilOrNotEmpty, validation.Length(1, 128)),
+		validation.Field(&m.Text, validation.NilOrNotEmpty, validation.Length(1, 1024)),
+		validation.Field(&m.Tags, validation.Each(validation.Length(0, 64))),
 	)
 }
 
+// UserFinder looks up users by their names. It is implemented by auth.UserRepo.
+type UserFinder interface {
+	GetByName(ctx context.Context, name string) (entity.User, error)
+}
+
 type service struct {
-	repo   Repository
-	logger log.Logger
+	repo              Repository
+	index             SearchIndex
+	users             UserFinder
+	revisionRetention int
+	logger            log.Logger
+}
+
+// NewService creates a new note service that searches notes with the given index and finds the users to share
+// notes with by name with the given finder.
+// revisionRetention is the number of revisions kept for each note. Zero keeps every revision.
+func NewService(repo Repository, index SearchIndex, users UserFinder, revisionRetention int, logger log.Logger) Service {
+	return service{repo, index, users, revisionRetention, logger}
+}
+
+// authorize returns the note with the specified ID if the current user holds the given permission on it.
+// The owner of a personal note holds every permission, the members of the workspace owning a note hold the
+// permission of their role while the workspace is active, and users the note is shared with hold the permission
+// of their share. A note the current user cannot access at all is reported as not found so that its existence
+// is not revealed.
+func (s service) authorize(ctx context.Context, id string, perm permission) (entity.Note, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return entity.Note{}, errors.Unauthorized("")
+	}
+	note, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return entity.Note{}, err
+	}
+	if note.DeletedAt != nil {
+		return entity.Note{}, errors.NotFound("")
+	}
+	if note.WorkspaceID == nil && note.UserID == identity.GetID() {
+		return note, nil
+	}
+	if granted, ok := workspacePermission(identity, note); ok {
+		if perm > granted {
+			return entity.Note{}, errors.Forbidden("")
+		}
+		return note, nil
+	}
+	share, err := s.repo.GetSharedNote(ctx, id, identity.GetID())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.Note{}, errors.NotFound("")
+		}
+		return entity.Note{}, err
+	}
+	if perm > rolePermissions[share.Role] {
+		return entity.Note{}, errors.Forbidden("")
+	}
+	return note, nil
+}
+
+// workspacePermission returns the permission the user holds on a note of a workspace through their membership.
+// It reports false if the note is not owned by the active workspace of the user.
+func workspacePermission(identity auth.Identity, note entity.Note) (permission, bool) {
+	member := identity.GetWorkspace()
+	if member == nil || note.WorkspaceID == nil || *note.WorkspaceID != member.WorkspaceID {
+		return 0, false
+	}
+	if member.Role == entity.RoleMember && note.UserID == identity.GetID() {
+		return permManage, true
+	}
+	return workspacePermissions[member.Role], true
 }
 
-// NewService creates a new note service.
-func NewService(repo Repository, logger log.Logger) Service {
-	return service{repo, logger}
+// scope limits the filter to the notes of the active workspace of the user, or to the personal and shared notes
+// of the user if no workspace is active.
+func scope(identity auth.Identity, filter NoteFilter) NoteFilter {
+	filter.WorkspaceID = ""
+	if member := identity.GetWorkspace(); member != nil {
+		filter.WorkspaceID = member.WorkspaceID
+	}
+	return filter
 }
 
 // Get returns the note with the specified the note ID.
 func (s service) Get(ctx context.Context, id string) (Note, error) {
-	note, err := s.repo.Get(ctx, id)
+	note, err := s.authorize(ctx, id, permRead)
 	if err != nil {
 		return Note{}, err
 	}
-	return Note{
-		ID:        note.ID,
-		Title:     note.Title,
-		Text:      note.Text,
-		UserID:    note.UserID,
-		CreatedAt: note.CreatedAt,
-		UpdatedAt: note.UpdatedAt,
-	}, nil
+	return s.newNoteWithTags(ctx, note)
 }
 
-// Create creates a new note.
+// Create creates a new note. The note is owned by the active workspace, if any, where guests cannot create notes.
 func (s service) Create(ctx context.Context, req CreateNoteRequest) (Note, error) {
+	req.Tags = normalizeTags(req.Tags)
 	if err := req.Validate(); err != nil {
 		return Note{}, err
 	}
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Note{}, errors.Unauthorized("")
+	}
 	id := entity.GenerateID()
 	now := time.Now()
 	note := entity.Note{
-		ID:             id,
-		Title:          req.Title,
-		Text:           req.Text,
-		TextSearchable: req.Text,
-		UserID:         req.UserID,
-		CreatedAt:      now,
-		UpdatedAt:      now,
-	}
-	err := s.repo.Create(ctx, note)
-	if err != nil {
+		ID:        id,
+		Title:     req.Title,
+		Text:      req.Text,
+		UserID:    identity.GetID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	if member := identity.GetWorkspace(); member != nil {
+		if workspacePermissions[member.Role] < permWrite {
+			return Note{}, errors.Forbidden("")
+		}
+		note.WorkspaceID = &member.WorkspaceID
+	}
+	if err := s.fileNote(ctx, &note, req.NotebookID); err != nil {
+		return Note{}, err
+	}
+	revision := s.newRevision(ctx, note)
+	if err := s.repo.Create(ctx, note, &revision); err != nil {
+		return Note{}, err
+	}
+	if len(req.Tags) > 0 {
+		if err := s.repo.SetTags(ctx, id, note.UserID, req.Tags); err != nil {
+			return Note{}, err
+		}
+	}
+	if err := s.index.Index(ctx, note); err != nil {
 		return Note{}, err
 	}
 	return s.Get(ctx, id)
 }
 
 // Update updates the note with the specified ID.
-func (s service) Update(ctx context.Context, id string, req UpdateNoteRequest) (Note, error) {
+// If version is not zero, the update only succeeds if the note is still at that version.
+// Tags are kept in the namespace of the note owner, even when a user the note is shared with changes them.
+func (s service) Update(ctx context.Context, id string, version int, req UpdateNoteRequest) (Note, error) {
+	req.Tags = normalizeTags(req.Tags)
 	if err := req.Validate(); err != nil {
 		return Note{}, err
 	}
 
-	note, err := s.Get(ctx, id)
+	noteE, err := s.authorize(ctx, id, permWrite)
 	if err != nil {
-		return note, err
+		return Note{}, err
 	}
-	note.Title = req.Title
-	note.Text = req.Text
-	note.UpdatedAt = time.Now()
+	if version != 0 && noteE.Version != version {
+		return Note{}, errors.PreconditionFailed("")
+	}
+	if req.NotebookID != nil {
+		if err := s.fileNote(ctx, &noteE, *req.NotebookID); err != nil {
+			return Note{}, err
+		}
+	}
+	title, text := noteE.Title, noteE.Text
+	if req.Title != nil {
+		noteE.Title = *req.Title
+	}
+	if req.Text != nil {
+		noteE.Text = *req.Text
+	}
+	noteE.UpdatedAt = time.Now()
 
-	noteE := entity.Note{
-		ID:             note.ID,
-		Title:          note.Title,
-		Text:           note.Text,
-		TextSearchable: note.Text,
-		UserID:         note.UserID,
-		CreatedAt:      note.CreatedAt,
-		UpdatedAt:      note.UpdatedAt,
+	// only changes of the title or text are recorded as revisions
+	var revision *entity.NoteRevision
+	if noteE.Title != title || noteE.Text != text {
+		rev := s.newRevision(ctx, noteE)
+		revision = &rev
 	}
-	if err := s.repo.Update(ctx, noteE); err != nil {
-		return note, err
+	if err := s.repo.Update(ctx, noteE, revision, s.revisionRetention); err != nil {
+		if err == errVersionConflict {
+			return Note{}, errors.PreconditionFailed("")
+		}
+		return Note{}, err
 	}
-	return note, nil
+	noteE.Version++
+	if req.Tags != nil {
+		if err := s.repo.SetTags(ctx, id, noteE.UserID, req.Tags); err != nil {
+			return Note{}, err
+		}
+	}
+	if err := s.index.Index(ctx, noteE); err != nil {
+		return Note{}, err
+	}
+	return s.newNoteWithTags(ctx, noteE)
+}
+
+// fileNote moves the note into the notebook with the specified ID, or out of its notebook if the ID is empty.
+// The notebook must belong to the owner of the note, and only the owner may move the note.
+func (s service) fileNote(ctx context.Context, note *entity.Note, notebookID string) error {
+	current := ""
+	if note.NotebookID != nil {
+		current = *note.NotebookID
+	}
+	if notebookID == current {
+		return nil
+	}
+	if identity := auth.CurrentUser(ctx); identity == nil || identity.GetID() != note.UserID {
+		return errors.Forbidden("")
+	}
+	if notebookID == "" {
+		note.NotebookID = nil
+		return nil
+	}
+	notebook, err := s.repo.GetNotebook(ctx, notebookID)
+	if err == sql.ErrNoRows || (err == nil && notebook.UserID != note.UserID) {
+		return errors.BadRequest("the notebook does not exist")
+	} else if err != nil {
+		return err
+	}
+	note.NotebookID = &notebookID
+	return nil
 }
 
-// Delete deletes the note with the specified ID.
-func (s service) Delete(ctx context.Context, id string) (Note, error) {
-	note, err := s.Get(ctx, id)
+// Delete moves the note with the specified ID to the trash.
+// If version is not zero, the note is only deleted if it is still at that version.
+func (s service) Delete(ctx context.Context, id string, version int) (Note, error) {
+	note, err := s.authorize(ctx, id, permManage)
 	if err != nil {
 		return Note{}, err
 	}
-	if err = s.repo.Delete(ctx, id); err != nil {
+	if version != 0 && note.Version != version {
+		return Note{}, errors.PreconditionFailed("")
+	}
+	now := time.Now()
+	if err = s.repo.Delete(ctx, id, now); err != nil {
 		return Note{}, err
 	}
-	return note, nil
+	if err = s.index.Remove(ctx, id); err != nil {
+		return Note{}, err
+	}
+	note.DeletedAt = &now
+	return s.newNoteWithTags(ctx, note)
 }
 
-// Count returns the number of notes.
-func (s service) Count(ctx context.Context) (int, error) {
-	return s.repo.Count(ctx)
+// QueryTrash returns the notes of the current user, or of the active workspace, that are in the trash.
+func (s service) QueryTrash(ctx context.Context) ([]Note, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	items, err := s.repo.QueryTrash(ctx, identity.GetID(), scope(identity, NoteFilter{}).WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return s.newNotes(ctx, items)
 }
 
-// Query returns the notes with the specified offset and limit.
-func (s service) Query(ctx context.Context, offset, limit int) ([]Note, error) {
-	notes, err := s.repo.Query(ctx, offset, limit)
+// RestoreFromTrash moves the note with the specified ID out of the trash.
+// Only the owner of a personal note, or the members of the workspace owning the note who may delete it, may restore it.
+func (s service) RestoreFromTrash(ctx context.Context, id string) (Note, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Note{}, errors.Unauthorized("")
+	}
+	note, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return Note{}, err
+	}
+	if note.DeletedAt == nil {
+		return Note{}, errors.NotFound("")
+	}
+	if note.WorkspaceID == nil {
+		if note.UserID != identity.GetID() {
+			return Note{}, errors.NotFound("")
+		}
+	} else if granted, ok := workspacePermission(identity, note); !ok {
+		return Note{}, errors.NotFound("")
+	} else if granted < permManage {
+		return Note{}, errors.Forbidden("")
+	}
+	if err := s.repo.Restore(ctx, id); err != nil {
+		return Note{}, err
+	}
+	note.DeletedAt = nil
+	if err := s.index.Index(ctx, note); err != nil {
+		return Note{}, err
+	}
+	return s.newNoteWithTags(ctx, note)
+}
+
+// Count returns the number of notes visible to the current user that match the filter.
+func (s service) Count(ctx context.Context, filter NoteFilter) (int, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return 0, errors.Unauthorized("")
+	}
+	return s.repo.Count(ctx, identity.GetID(), scope(identity, filter))
+}
+
+// Query returns the notes visible to the current user that match the filter, in the given order and
+// with the specified offset and limit.
+func (s service) Query(ctx context.Context, filter NoteFilter, sort NoteSort, offset, limit int) ([]Note, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	notes, err := s.repo.Query(ctx, identity.GetID(), scope(identity, filter), sort, offset, limit)
 	if err != nil {
 		return nil, err
 	}
-	result := []Note{}
-	for _, note := range notes {
-		result = append(result, Note{
-			ID:        note.ID,
-			Title:     note.Title,
-			Text:      note.Text,
-			UserID:    note.UserID,
-			CreatedAt: note.CreatedAt,
-			UpdatedAt: note.UpdatedAt,
-		})
+	return s.newNotes(ctx, notes)
+}
+
+// QueryCursor returns up to limit notes visible to the current user that match the filter, in the given order,
+// that follow the cursor, or precede it if the cursor is backward. A nil cursor starts at the first note.
+// It also reports whether there are more notes beyond the returned ones in the same direction.
+func (s service) QueryCursor(ctx context.Context, filter NoteFilter, sort NoteSort, cursor *pagination.Cursor, limit int) ([]Note, bool, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, false, errors.Unauthorized("")
 	}
-	return result, nil
+	var position *Keyset
+	if cursor != nil {
+		var err error
+		if position, err = sort.keyset(*cursor); err != nil {
+			return nil, false, err
+		}
+	}
+	// fetch one more note than requested to find out whether there are more
+	items, err := s.repo.QueryKeyset(ctx, identity.GetID(), scope(identity, filter), sort, position, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	more := len(items) > limit
+	if more {
+		if position != nil && position.Backward {
+			items = items[1:]
+		} else {
+			items = items[:limit]
+		}
+	}
+	notes, err := s.newNotes(ctx, items)
+	return notes, more, err
 }
 
-func (s service) QueryByUser(ctx context.Context, userID string) ([]Note, error) {
-	items, err := s.repo.QueryByUserID(ctx, userID)
+// QueryByUser returns the notes owned by the current user that match the filter.
+func (s service) QueryByUser(ctx context.Context, filter NoteFilter) ([]Note, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	items, err := s.repo.QueryByUserID(ctx, identity.GetID(), filter)
 	if err != nil {
 		return nil, err
 	}
-	result := []Note{}
-	for _, item := range items {
-		result = append(result, Note{
-			ID:        item.ID,
-			Title:     item.Title,
-			Text:      item.Text,
-			UserID:    item.UserID,
-			CreatedAt: item.CreatedAt,
-			UpdatedAt: item.UpdatedAt,
-		})
+	return s.newNotes(ctx, items)
+}
+
+// QuerySharedNotes returns the notes other users have shared with the current user that match the filter.
+func (s service) QuerySharedNotes(ctx context.Context, filter NoteFilter) ([]Note, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	items, err := s.repo.QuerySharedNotes(ctx, identity.GetID(), filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.newNotes(ctx, items)
+}
+
+// newRevision returns the revision recording the current title and text of the note, to be saved with the note.
+func (s service) newRevision(ctx context.Context, note entity.Note) entity.NoteRevision {
+	userID := note.UserID
+	if identity := auth.CurrentUser(ctx); identity != nil {
+		userID = identity.GetID()
+	}
+	return entity.NoteRevision{
+		ID:        entity.GenerateID(),
+		NoteID:    note.ID,
+		Title:     note.Title,
+		Text:      note.Text,
+		UserID:    userID,
+		CreatedAt: note.UpdatedAt,
 	}
-	return result, nil
 }
 
-func (s service) QuerySharedNotes(ctx context.Context, userID string) ([]Note, error) {
-	items, err := s.repo.QuerySharedNotes(ctx, userID)
+// QueryRevisions returns the revisions of the note with the specified ID, newest first.
+func (s service) QueryRevisions(ctx context.Context, id string) ([]NoteRevision, error) {
+	if _, err := s.authorize(ctx, id, permRead); err != nil {
+		return nil, err
+	}
+	items, err := s.repo.QueryRevisions(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	result := []Note{}
+	result := []NoteRevision{}
 	for _, item := range items {
-		result = append(result, Note{
-			ID:        item.ID,
-			Title:     item.Title,
-			Text:      item.Text,
-			UserID:    item.UserID,
-			CreatedAt: item.CreatedAt,
-			UpdatedAt: item.UpdatedAt,
-		})
+		result = append(result, NoteRevision{item})
 	}
 	return result, nil
 }
+
+// GetRevision returns the given revision of the note with the specified ID.
+func (s service) GetRevision(ctx context.Context, id string, revision int) (NoteRevision, error) {
+	if _, err := s.authorize(ctx, id, permRead); err != nil {
+		return NoteRevision{}, err
+	}
+	rev, err := s.repo.GetRevision(ctx, id, revision)
+	if err != nil {
+		return NoteRevision{}, err
+	}
+	return NoteRevision{rev}, nil
+}
+
+// DiffRevisions returns the line-based difference between two revisions of the note with the specified ID.
+func (s service) DiffRevisions(ctx context.Context, id string, from, to int) (RevisionDiff, error) {
+	older, err := s.GetRevision(ctx, id, from)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+	newer, err := s.repo.GetRevision(ctx, id, to)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+	return RevisionDiff{
+		NoteID: id,
+		From:   from,
+		To:     to,
+		Title:  diffLines(older.Title, newer.Title),
+		Text:   diffLines(older.Text, newer.Text),
+	}, nil
+}
+
+// RestoreRevision brings the title and text of the note with the specified ID back to the given revision.
+// The restored content is recorded as a new revision, so the restore itself can be undone.
+func (s service) RestoreRevision(ctx context.Context, id string, revision int) (Note, error) {
+	rev, err := s.GetRevision(ctx, id, revision)
+	if err != nil {
+		return Note{}, err
+	}
+	return s.Update(ctx, id, 0, UpdateNoteRequest{Title: &rev.Title, Text: &rev.Text})
+}
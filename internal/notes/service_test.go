@@ -3,7 +3,11 @@ package notes
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
 	"github.com/qiangxue/go-rest-api/pkg/log"
 	"github.com/stretchr/testify/assert"
 )
@@ -26,56 +30,86 @@ func TestCreateNoteRequest_Validate(t *testing.T) {
 	}
 }
 
+func TestUpdateNoteRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     UpdateNoteRequest
+		wantError bool
+	}{
+		{"success", UpdateNoteRequest{Title: stringPtr("test"), Text: stringPtr("text213")}, false},
+		{"partial", UpdateNoteRequest{Tags: []string{"work"}}, false},
+		{"empty title", UpdateNoteRequest{Title: stringPtr("")}, true},
+		{"empty text", UpdateNoteRequest{Text: stringPtr("")}, true},
+		{"too long", UpdateNoteRequest{Title: stringPtr("1234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890123456789012345678901234567890")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
 func Test_service_CRUD(t *testing.T) {
 	logger, _ := log.NewForTest()
-	s := NewService(&mockNoteRepo{}, logger)
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
 
-	ctx := context.Background()
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	// unauthenticated
+	_, err := s.Create(context.Background(), CreateNoteRequest{Title: "test", Text: "text1"})
+	assert.Equal(t, errors.Unauthorized(""), err)
 
 	// initial count
-	count, _ := s.Count(ctx)
+	count, _ := s.Count(ctx, NoteFilter{})
 	assert.Equal(t, 0, count)
 
 	// successful creation
 	note, err := s.Create(ctx, CreateNoteRequest{Title: "test", Text: "text1"})
 	assert.Nil(t, err)
 	assert.NotEmpty(t, note.ID)
+	assert.Equal(t, "user1", note.UserID)
 	id := note.ID
 	assert.Equal(t, "test", note.Title)
 	assert.Equal(t, "text1", note.Text)
 	assert.NotEmpty(t, note.CreatedAt)
 	assert.NotEmpty(t, note.UpdatedAt)
-	count, _ = s.Count(ctx)
+	count, _ = s.Count(ctx, NoteFilter{})
 	assert.Equal(t, 1, count)
 
 	// validation error in creation
 	_, err = s.Create(ctx, CreateNoteRequest{Title: "", Text: "text1"})
 	assert.NotNil(t, err)
-	count, _ = s.Count(ctx)
+	count, _ = s.Count(ctx, NoteFilter{})
 	assert.Equal(t, 1, count)
 
 	// unexpected error in creation
 	_, err = s.Create(ctx, CreateNoteRequest{Title: "error", Text: "text1"})
 	assert.Equal(t, errCRUD, err)
-	count, _ = s.Count(ctx)
+	count, _ = s.Count(ctx, NoteFilter{})
 	assert.Equal(t, 1, count)
 
 	_, _ = s.Create(ctx, CreateNoteRequest{Title: "test2", Text: "text2"})
 
 	// update
-	note, err = s.Update(ctx, id, UpdateNoteRequest{Title: "test updated"})
+	note, err = s.Update(ctx, id, 0, UpdateNoteRequest{Title: stringPtr("test updated")})
 	assert.Nil(t, err)
 	assert.Equal(t, "test updated", note.Title)
-	_, err = s.Update(ctx, "none", UpdateNoteRequest{Title: "test updated"})
+	_, err = s.Update(ctx, "none", 0, UpdateNoteRequest{Title: stringPtr("test updated")})
 	assert.NotNil(t, err)
 
-	count, _ = s.Count(ctx)
+	count, _ = s.Count(ctx, NoteFilter{})
 	assert.Equal(t, 2, count)
 
 	// unexpected error in update
-	_, err = s.Update(ctx, id, UpdateNoteRequest{Title: "error"})
+	_, err = s.Update(ctx, id, 0, UpdateNoteRequest{Title: stringPtr("error")})
 	assert.Equal(t, errCRUD, err)
-	count, _ = s.Count(ctx)
+	count, _ = s.Count(ctx, NoteFilter{})
 	assert.Equal(t, 2, count)
 
 	// get
@@ -87,15 +121,607 @@ func Test_service_CRUD(t *testing.T) {
 	assert.Equal(t, id, note.ID)
 
 	// query
-	notes, _ := s.Query(ctx, 0, 0)
+	notes, _ := s.Query(ctx, NoteFilter{}, NoteSort{}, 0, 0)
 	assert.Equal(t, 2, len(notes))
 
 	// delete
-	_, err = s.Delete(ctx, "none")
+	_, err = s.Delete(ctx, "none", 0)
 	assert.NotNil(t, err)
-	note, err = s.Delete(ctx, id)
+	note, err = s.Delete(ctx, id, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, id, note.ID)
-	count, _ = s.Count(ctx)
+	count, _ = s.Count(ctx, NoteFilter{})
+	assert.Equal(t, 1, count)
+}
+
+func Test_service_authorize(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+
+	owner := auth.WithUser(context.Background(), "owner", "owner")
+	friend := auth.WithUser(context.Background(), "friend", "friend")
+	stranger := auth.WithUser(context.Background(), "stranger", "stranger")
+
+	note, err := s.Create(owner, CreateNoteRequest{Title: "test", Text: "text1"})
+	assert.Nil(t, err)
+	id := note.ID
+
+	// nobody else can see the note before it is shared
+	_, err = s.Get(friend, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.ShareNote(friend, id, ShareNoteRequest{NoteID: id, SharedUserID: "friend"})
+	assert.Equal(t, errors.NotFound(""), err)
+	count, _ := s.Count(friend, NoteFilter{})
+	assert.Zero(t, count)
+
+	_, err = s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, SharedUserID: "friend", Role: entity.RoleEditor})
+	assert.Nil(t, err)
+
+	// an editor of the note can read and write it
+	_, err = s.Get(friend, id)
+	assert.Nil(t, err)
+	note, err = s.Update(friend, id, 0, UpdateNoteRequest{Title: stringPtr("by friend"), Text: stringPtr("text2")})
+	assert.Nil(t, err)
+	assert.Equal(t, "owner", note.UserID)
+	count, _ = s.Count(friend, NoteFilter{})
 	assert.Equal(t, 1, count)
+	shared, _ := s.QuerySharedNotes(friend, NoteFilter{})
+	assert.Equal(t, 1, len(shared))
+	owned, _ := s.QueryByUser(friend, NoteFilter{})
+	assert.Zero(t, len(owned))
+
+	// but cannot delete or reshare it
+	_, err = s.Delete(friend, id, 0)
+	assert.Equal(t, errors.Forbidden(""), err)
+	_, err = s.ShareNote(friend, id, ShareNoteRequest{NoteID: id, SharedUserID: "stranger"})
+	assert.Equal(t, errors.Forbidden(""), err)
+
+	// everyone else gets a not found error
+	_, err = s.Get(stranger, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Update(stranger, id, 0, UpdateNoteRequest{Title: stringPtr("by stranger")})
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Delete(stranger, id, 0)
+	assert.Equal(t, errors.NotFound(""), err)
+
+	_, err = s.Delete(owner, id, 0)
+	assert.Nil(t, err)
+}
+
+func Test_service_revisions(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 3, logger)
+	owner := auth.WithUser(context.Background(), "owner", "owner")
+	stranger := auth.WithUser(context.Background(), "stranger", "stranger")
+
+	note, err := s.Create(owner, CreateNoteRequest{Title: "title", Text: "line1\nline2"})
+	assert.Nil(t, err)
+	id := note.ID
+	_, err = s.Update(owner, id, 0, UpdateNoteRequest{Title: stringPtr("title"), Text: stringPtr("line1\nchanged")})
+	assert.Nil(t, err)
+
+	revisions, err := s.QueryRevisions(owner, id)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(revisions)) {
+		assert.Equal(t, 2, revisions[0].Revision)
+		assert.Equal(t, "owner", revisions[0].UserID)
+	}
+	_, err = s.QueryRevisions(stranger, id)
+	assert.Equal(t, errors.NotFound(""), err)
+
+	rev, err := s.GetRevision(owner, id, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "line1\nline2", rev.Text)
+	_, err = s.GetRevision(owner, id, 5)
+	assert.NotNil(t, err)
+
+	diff, err := s.DiffRevisions(owner, id, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []DiffLine{{DiffEqual, "title"}}, diff.Title)
+	assert.Equal(t, []DiffLine{{DiffEqual, "line1"}, {DiffDelete, "line2"}, {DiffInsert, "changed"}}, diff.Text)
+
+	note, err = s.RestoreRevision(owner, id, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "line1\nline2", note.Text)
+	_, err = s.RestoreRevision(stranger, id, 1)
+	assert.Equal(t, errors.NotFound(""), err)
+
+	// updates leaving the title and text unchanged record no revision
+	_, err = s.Update(owner, id, 0, UpdateNoteRequest{Title: stringPtr("title"), Tags: []string{"work"}})
+	assert.Nil(t, err)
+	revisions, _ = s.QueryRevisions(owner, id)
+	assert.Equal(t, 3, len(revisions))
+
+	// only the latest three revisions are retained
+	_, _ = s.Update(owner, id, 0, UpdateNoteRequest{Title: stringPtr("title"), Text: stringPtr("again")})
+	revisions, _ = s.QueryRevisions(owner, id)
+	if assert.Equal(t, 3, len(revisions)) {
+		assert.Equal(t, 4, revisions[0].Revision)
+		assert.Equal(t, 2, revisions[2].Revision)
+	}
+}
+
+func Test_service_shareRoles(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+	owner := auth.WithUser(context.Background(), "owner", "owner")
+	viewer := auth.WithUser(context.Background(), "viewer", "viewer")
+	commenter := auth.WithUser(context.Background(), "commenter", "commenter")
+
+	note, _ := s.Create(owner, CreateNoteRequest{Title: "test", Text: "text1"})
+	id := note.ID
+
+	// shares default to the viewer role
+	share, err := s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, SharedUserID: "viewer"})
+	assert.Nil(t, err)
+	assert.Equal(t, entity.RoleViewer, share.Role)
+	_, err = s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, SharedUserID: "commenter", Role: entity.RoleCommenter})
+	assert.Nil(t, err)
+	_, err = s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, SharedUserID: "x", Role: "owner"})
+	assert.NotNil(t, err)
+
+	// viewers and commenters can read the note but not change it
+	for _, ctx := range []context.Context{viewer, commenter} {
+		_, err = s.Get(ctx, id)
+		assert.Nil(t, err)
+		_, err = s.Update(ctx, id, 0, UpdateNoteRequest{Title: stringPtr("changed")})
+		assert.Equal(t, errors.Forbidden(""), err)
+		_, err = s.Delete(ctx, id, 0)
+		assert.Equal(t, errors.Forbidden(""), err)
+	}
+
+	// collaborators can see each other
+	shares, err := s.QueryShares(viewer, id)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(shares)) {
+		assert.Equal(t, "commenter", shares[0].SharedUserID)
+		assert.Equal(t, "viewer", shares[1].SharedUserID)
+	}
+
+	// only the owner can change roles
+	_, err = s.UpdateShare(viewer, id, "viewer", UpdateShareRequest{Role: entity.RoleEditor})
+	assert.Equal(t, errors.Forbidden(""), err)
+	_, err = s.UpdateShare(owner, id, "viewer", UpdateShareRequest{Role: ""})
+	assert.NotNil(t, err)
+	share, err = s.UpdateShare(owner, id, "viewer", UpdateShareRequest{Role: entity.RoleEditor})
+	assert.Nil(t, err)
+	assert.Equal(t, entity.RoleEditor, share.Role)
+	_, err = s.Update(viewer, id, 0, UpdateNoteRequest{Title: stringPtr("changed")})
+	assert.Nil(t, err)
+
+	// the owner can revoke anyone's access, and collaborators can give up their own
+	_, err = s.Unshare(commenter, id, "viewer")
+	assert.Equal(t, errors.Forbidden(""), err)
+	_, err = s.Unshare(commenter, id, "commenter")
+	assert.Nil(t, err)
+	_, err = s.Get(commenter, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Unshare(owner, id, "viewer")
+	assert.Nil(t, err)
+	_, err = s.Get(viewer, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Unshare(owner, id, "viewer")
+	assert.NotNil(t, err)
+}
+
+func Test_service_shareByUsername(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{"friend": "friend", "owner": "owner"}, 0, logger)
+	owner := auth.WithUser(context.Background(), "owner", "owner")
+	friend := auth.WithUser(context.Background(), "friend", "friend")
+
+	note, _ := s.Create(owner, CreateNoteRequest{Title: "test", Text: "text1"})
+	id := note.ID
+
+	_, err := s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, Username: "nobody"})
+	assert.Equal(t, errors.NotFound("the user does not exist"), err)
+	_, err = s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, Username: "owner"})
+	assert.Equal(t, errors.BadRequest("a note cannot be shared with its owner"), err)
+
+	share, err := s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, Username: "friend"})
+	assert.Nil(t, err)
+	assert.Equal(t, "friend", share.SharedUserID)
+	_, err = s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, Username: "friend"})
+	assert.Equal(t, errors.Conflict("the note is already shared with this user"), err)
+
+	// only the owner can share a note
+	_, err = s.ShareNote(friend, id, ShareNoteRequest{NoteID: id, Username: "owner"})
+	assert.Equal(t, errors.Forbidden(""), err)
+}
+
+func Test_service_trash(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+	owner := auth.WithUser(context.Background(), "owner", "owner")
+	friend := auth.WithUser(context.Background(), "friend", "friend")
+
+	note, _ := s.Create(owner, CreateNoteRequest{Title: "test", Text: "text1"})
+	id := note.ID
+	_, _ = s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, SharedUserID: "friend"})
+
+	note, err := s.Delete(owner, id, 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, note.DeletedAt)
+
+	// trashed notes are hidden everywhere else
+	_, err = s.Get(owner, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Update(owner, id, 0, UpdateNoteRequest{Title: stringPtr("changed")})
+	assert.Equal(t, errors.NotFound(""), err)
+	notes, _ := s.QueryByUser(owner, NoteFilter{})
+	assert.Zero(t, len(notes))
+	notes, _ = s.QuerySharedNotes(friend, NoteFilter{})
+	assert.Zero(t, len(notes))
+
+	trash, err := s.QueryTrash(owner)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(trash))
+	trash, _ = s.QueryTrash(friend)
+	assert.Zero(t, len(trash))
+
+	// only the owner can restore
+	_, err = s.RestoreFromTrash(friend, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	note, err = s.RestoreFromTrash(owner, id)
+	assert.Nil(t, err)
+	assert.Nil(t, note.DeletedAt)
+	_, err = s.RestoreFromTrash(owner, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Get(friend, id)
+	assert.Nil(t, err)
+}
+
+func Test_service_workspaces(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+	in := func(userID, role string) context.Context {
+		ctx := auth.WithUser(context.Background(), userID, userID)
+		return auth.WithWorkspace(ctx, entity.WorkspaceMember{WorkspaceID: "ws1", UserID: userID, Role: role})
+	}
+	admin, member, guest := in("admin", entity.RoleAdmin), in("member", entity.RoleMember), in("guest", entity.RoleGuest)
+	personal := auth.WithUser(context.Background(), "member", "member")
+
+	// notes created in a workspace are owned by it, except for guests who cannot create any
+	note, err := s.Create(member, CreateNoteRequest{Title: "team", Text: "text1"})
+	assert.Nil(t, err)
+	if assert.NotNil(t, note.WorkspaceID) {
+		assert.Equal(t, "ws1", *note.WorkspaceID)
+	}
+	id := note.ID
+	_, err = s.Create(guest, CreateNoteRequest{Title: "guest", Text: "text1"})
+	assert.Equal(t, errors.Forbidden(""), err)
+	_, _ = s.Create(personal, CreateNoteRequest{Title: "personal", Text: "text1"})
+
+	// listings are scoped to the active workspace
+	count, _ := s.Count(guest, NoteFilter{})
+	assert.Equal(t, 1, count)
+	count, _ = s.Count(personal, NoteFilter{})
+	assert.Equal(t, 1, count)
+	notes, _ := s.Query(personal, NoteFilter{}, NoteSort{}, 0, 10)
+	if assert.Equal(t, 1, len(notes)) {
+		assert.Equal(t, "personal", notes[0].Title)
+	}
+	_, err = s.Get(personal, id)
+	assert.Equal(t, errors.NotFound(""), err)
+
+	// the role of the member decides what they can do with the notes of others
+	_, err = s.Get(guest, id)
+	assert.Nil(t, err)
+	_, err = s.Update(guest, id, 0, UpdateNoteRequest{Title: stringPtr("changed")})
+	assert.Equal(t, errors.Forbidden(""), err)
+	other, _ := s.Create(admin, CreateNoteRequest{Title: "admin", Text: "text1"})
+	_, err = s.Update(member, other.ID, 0, UpdateNoteRequest{Title: stringPtr("changed")})
+	assert.Nil(t, err)
+	_, err = s.Delete(member, other.ID, 0)
+	assert.Equal(t, errors.Forbidden(""), err)
+
+	// the trash of a workspace is shared by its members
+	_, err = s.Delete(admin, id, 0)
+	assert.Nil(t, err)
+	trash, _ := s.QueryTrash(guest)
+	assert.Equal(t, 1, len(trash))
+	trash, _ = s.QueryTrash(personal)
+	assert.Zero(t, len(trash))
+	_, err = s.RestoreFromTrash(guest, id)
+	assert.Equal(t, errors.Forbidden(""), err)
+	_, err = s.RestoreFromTrash(personal, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.RestoreFromTrash(member, id)
+	assert.Nil(t, err)
+}
+
+func Test_service_versions(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	note, _ := s.Create(ctx, CreateNoteRequest{Title: "test", Text: "text1"})
+	assert.Equal(t, 1, note.Version)
+	id := note.ID
+
+	note, err := s.Update(ctx, id, 1, UpdateNoteRequest{Title: stringPtr("first"), Text: stringPtr("text1")})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, note.Version)
+
+	// an update based on a stale version is rejected
+	_, err = s.Update(ctx, id, 1, UpdateNoteRequest{Title: stringPtr("second"), Text: stringPtr("text1")})
+	assert.Equal(t, errors.PreconditionFailed(""), err)
+	note, _ = s.Get(ctx, id)
+	assert.Equal(t, "first", note.Title)
+
+	// an unconditional update always applies
+	note, err = s.Update(ctx, id, 0, UpdateNoteRequest{Title: stringPtr("third"), Text: stringPtr("text1")})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, note.Version)
+
+	_, err = s.Delete(ctx, id, 2)
+	assert.Equal(t, errors.PreconditionFailed(""), err)
+	_, err = s.Delete(ctx, id, 3)
+	assert.Nil(t, err)
+}
+
+func Test_service_tags(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	// tags are normalized and deduplicated
+	note, err := s.Create(ctx, CreateNoteRequest{Title: "test", Text: "text1", Tags: []string{" #Work", "work", "Urgent", " "}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"urgent", "work"}, note.Tags)
+	id := note.ID
+	plain, _ := s.Create(ctx, CreateNoteRequest{Title: "plain", Text: "text2"})
+	assert.Equal(t, []string{}, plain.Tags)
+
+	// filtering with AND and OR semantics
+	notes, _ := s.QueryByUser(ctx, NoteFilter{Tags: []string{"work", "urgent"}})
+	assert.Equal(t, 1, len(notes))
+	notes, _ = s.QueryByUser(ctx, NoteFilter{Tags: []string{"work", "home"}})
+	assert.Zero(t, len(notes))
+	notes, _ = s.QueryByUser(ctx, NoteFilter{Tags: []string{"work", "home"}, AnyTag: true})
+	assert.Equal(t, 1, len(notes))
+	count, _ := s.Count(ctx, NoteFilter{})
+	assert.Equal(t, 2, count)
+
+	// tags are kept unless the update sets them
+	note, err = s.Update(ctx, id, 0, UpdateNoteRequest{Title: stringPtr("test"), Text: stringPtr("text1")})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"urgent", "work"}, note.Tags)
+	note, err = s.Update(ctx, id, 0, UpdateNoteRequest{Title: stringPtr("test"), Text: stringPtr("text1"), Tags: []string{"Home"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"home"}, note.Tags)
+	note, err = s.Update(ctx, id, 0, UpdateNoteRequest{Title: stringPtr("test"), Text: stringPtr("text1"), Tags: []string{}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, note.Tags)
+
+	// validation error
+	_, err = s.Create(ctx, CreateNoteRequest{Title: "test", Text: "text1", Tags: []string{"12345678901234567890123456789012345678901234567890123456789012345"}})
+	assert.NotNil(t, err)
+}
+
+func Test_normalizeTags(t *testing.T) {
+	assert.Nil(t, normalizeTags(nil))
+	assert.Equal(t, []string{}, normalizeTags([]string{}))
+	assert.Equal(t, []string{"work", "to do"}, normalizeTags([]string{"#Work", " to   DO ", "work", ""}))
+}
+
+func Test_service_notebooks(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{notebooks: []entity.Notebook{
+		{ID: "nb1", UserID: "user1", Name: "work"},
+		{ID: "nb2", UserID: "user1", Name: "home"},
+		{ID: "nb3", UserID: "user2", Name: "foreign"},
+	}}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+	owner := auth.WithUser(context.Background(), "user1", "user1")
+	friend := auth.WithUser(context.Background(), "user2", "user2")
+
+	note, err := s.Create(owner, CreateNoteRequest{Title: "test", Text: "text1", NotebookID: "nb1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "nb1", *note.NotebookID)
+	id := note.ID
+	_, err = s.Create(owner, CreateNoteRequest{Title: "test", Text: "text1", NotebookID: "nb3"})
+	assert.Equal(t, errors.BadRequest("the notebook does not exist"), err)
+
+	// move between notebooks, keeping the notebook when it is not given
+	nb2 := "nb2"
+	note, err = s.Update(owner, id, 0, UpdateNoteRequest{Title: stringPtr("test"), Text: stringPtr("text1"), NotebookID: &nb2})
+	assert.Nil(t, err)
+	assert.Equal(t, "nb2", *note.NotebookID)
+	note, err = s.Update(owner, id, 0, UpdateNoteRequest{Title: stringPtr("test"), Text: stringPtr("text2")})
+	assert.Nil(t, err)
+	assert.Equal(t, "nb2", *note.NotebookID)
+
+	// only the owner may move a shared note
+	_, _ = s.ShareNote(owner, id, ShareNoteRequest{NoteID: id, SharedUserID: "user2"})
+	nb3 := "nb3"
+	_, err = s.Update(friend, id, 0, UpdateNoteRequest{Title: stringPtr("test"), Text: stringPtr("text2"), NotebookID: &nb3})
+	assert.Equal(t, errors.Forbidden(""), err)
+
+	// take the note out of its notebook
+	none := ""
+	note, err = s.Update(owner, id, 0, UpdateNoteRequest{Title: stringPtr("test"), Text: stringPtr("text2"), NotebookID: &none})
+	assert.Nil(t, err)
+	assert.Nil(t, note.NotebookID)
+}
+
+func Test_service_Query(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	repo := &mockNoteRepo{items: []entity.Note{
+		{ID: "1", Title: "b", UserID: "user1", CreatedAt: now.Add(-3 * time.Hour), UpdatedAt: now.Add(-time.Hour)},
+		{ID: "2", Title: "a", UserID: "user1", CreatedAt: now.Add(-2 * time.Hour), UpdatedAt: now.Add(-2 * time.Hour)},
+		{ID: "3", Title: "c", UserID: "user1", CreatedAt: now.Add(-time.Hour), UpdatedAt: now.Add(-3 * time.Hour)},
+		{ID: "4", Title: "d", UserID: "user2", CreatedAt: now, UpdatedAt: now},
+	}}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	ids := func(notes []Note) []string {
+		var result []string
+		for _, note := range notes {
+			result = append(result, note.ID)
+		}
+		return result
+	}
+
+	notes, err := s.Query(ctx, NoteFilter{}, NoteSort{Field: SortUpdatedAt, Desc: true}, 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, ids(notes))
+	notes, _ = s.Query(ctx, NoteFilter{}, NoteSort{Field: SortCreatedAt}, 0, 10)
+	assert.Equal(t, []string{"1", "2", "3"}, ids(notes))
+	notes, _ = s.Query(ctx, NoteFilter{}, NoteSort{Field: SortTitle}, 1, 1)
+	assert.Equal(t, []string{"1"}, ids(notes))
+
+	filter := NoteFilter{CreatedAfter: now.Add(-150 * time.Minute), UpdatedBefore: now.Add(-90 * time.Minute)}
+	notes, _ = s.Query(ctx, filter, NoteSort{}, 0, 10)
+	assert.Equal(t, []string{"2", "3"}, ids(notes))
+	count, _ := s.Count(ctx, filter)
+	assert.Equal(t, 2, count)
+}
+
+func Test_service_QueryCursor(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	repo := &mockNoteRepo{}
+	for i, title := range []string{"a", "b", "c", "d", "e"} {
+		repo.items = append(repo.items, entity.Note{ID: title, Title: title, UserID: "user1", UpdatedAt: now.Add(time.Duration(i) * time.Minute)})
+	}
+	s := NewService(repo, NewMemorySearchIndex(repo), mockUsers{}, 0, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+	order := NoteSort{Field: SortUpdatedAt, Desc: true}
+
+	ids := func(notes []Note) []string {
+		var result []string
+		for _, note := range notes {
+			result = append(result, note.ID)
+		}
+		return result
+	}
+
+	// walk forward
+	notes, more, err := s.QueryCursor(ctx, NoteFilter{}, order, nil, 2)
+	assert.Nil(t, err)
+	assert.True(t, more)
+	assert.Equal(t, []string{"e", "d"}, ids(notes))
+	next := order.Cursor(notes[1], false)
+	notes, more, _ = s.QueryCursor(ctx, NoteFilter{}, order, &next, 2)
+	assert.True(t, more)
+	assert.Equal(t, []string{"c", "b"}, ids(notes))
+	next = order.Cursor(notes[1], false)
+	notes, more, _ = s.QueryCursor(ctx, NoteFilter{}, order, &next, 2)
+	assert.False(t, more)
+	assert.Equal(t, []string{"a"}, ids(notes))
+
+	// walk backward
+	prev := order.Cursor(notes[0], true)
+	notes, more, _ = s.QueryCursor(ctx, NoteFilter{}, order, &prev, 2)
+	assert.True(t, more)
+	assert.Equal(t, []string{"c", "b"}, ids(notes))
+
+	// a cursor created for another sort order is rejected
+	other := NoteSort{Field: SortTitle}.Cursor(notes[0], false)
+	_, _, err = s.QueryCursor(ctx, NoteFilter{}, order, &other, 2)
+	assert.Equal(t, errors.BadRequest("the cursor does not match the sort order"), err)
+}
+
+func Test_service_SearchNotes(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{items: []entity.Note{
+		{ID: "1", Title: "groceries", Text: "buy apples", UserID: "user1"},
+		{ID: "2", Title: "apples", Text: "varieties of apples", UserID: "user1"},
+		{ID: "3", Title: "apples", Text: "not mine", UserID: "user2"},
+	}}
+	index := NewMemorySearchIndex(repo)
+	assert.Nil(t, index.Rebuild(context.Background()))
+	s := NewService(repo, index, mockUsers{}, 0, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	count, err := s.CountSearch(ctx, "apples", SearchFullText, NoteFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	results, err := s.SearchNotes(ctx, "apples", SearchFullText, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(results)) {
+		// title matches rank first
+		assert.Equal(t, "2", results[0].ID)
+		assert.Equal(t, "1", results[1].ID)
+		assert.Equal(t, "buy <mark>apples</mark>", results[1].Headline)
+	}
+
+	results, _ = s.SearchNotes(ctx, "apples", SearchFullText, NoteFilter{}, NoteSort{Field: SortRelevance}, 1, 10)
+	assert.Equal(t, 1, len(results))
+
+	results, _ = s.SearchNotes(ctx, "apples -buy", SearchFullText, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, "2", results[0].ID)
+	}
+	results, _ = s.SearchNotes(ctx, "title:apples owner:me", SearchFullText, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, "2", results[0].ID)
+	}
+	count, _ = s.CountSearch(ctx, "owner:user2", SearchFullText, NoteFilter{})
+	assert.Zero(t, count)
+
+	_, err = s.SearchNotes(ctx, "  ", SearchFullText, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	assert.Equal(t, errQueryRequired, err)
+	_, err = s.SearchNotes(ctx, `apples "unterminated`, SearchFullText, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	assert.Equal(t, (&QuerySyntaxError{Pos: 8, Msg: "unterminated quoted phrase"}).BadRequest(), err)
+	_, err = s.SearchNotes(context.Background(), "apples", SearchFullText, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	assert.NotNil(t, err)
+}
+
+func Test_service_SearchNotes_fuzzy(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{items: []entity.Note{
+		{ID: "1", Title: "groceries", Text: "buy apples", UserID: "user1"},
+		{ID: "2", Title: "apples", Text: "varieties of apples", UserID: "user2"},
+	}}
+	index := NewMemorySearchIndex(repo)
+	assert.Nil(t, index.Rebuild(context.Background()))
+	s := NewService(repo, index, mockUsers{}, 0, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	results, err := s.SearchNotes(ctx, "aples", SearchFuzzy, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, "1", results[0].ID)
+		assert.Equal(t, "buy <mark>apples</mark>", results[0].Headline)
+	}
+	results, _ = s.SearchNotes(ctx, "aples", SearchFullText, NoteFilter{}, NoteSort{Field: SortRelevance}, 0, 10)
+	assert.Empty(t, results)
+}
+
+func Test_service_Suggest(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockNoteRepo{items: []entity.Note{
+		{ID: "1", Title: "Groceries", Text: "buy apples", UserID: "user1"},
+		{ID: "2", Title: "Grocery list", Text: "apricots", UserID: "user2"},
+	}}
+	index := NewMemorySearchIndex(repo)
+	assert.Nil(t, index.Rebuild(context.Background()))
+	s := NewService(repo, index, mockUsers{}, 0, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	suggestions, err := s.Suggest(ctx, " gro")
+	assert.Nil(t, err)
+	assert.Equal(t, []Suggestion{{Text: "Groceries", Count: 1}}, suggestions.Titles)
+	assert.Equal(t, []Suggestion{{Text: "groceries", Count: 1}}, suggestions.Terms)
+
+	suggestions, _ = s.Suggest(ctx, "buy ap")
+	assert.Equal(t, []Suggestion{{Text: "apples", Count: 1}}, suggestions.Terms)
+
+	_, err = s.Suggest(ctx, "  ")
+	assert.Equal(t, errors.BadRequest("the prefix must not be empty"), err)
+	_, err = s.Suggest(context.Background(), "gro")
+	assert.NotNil(t, err)
 }
@@ -0,0 +1,259 @@
+package notes
+
+// stem reduces a lower-case English word to its stem using the Porter stemming algorithm, so that words such as
+// "connect", "connected" and "connection" are indexed and searched alike. Words containing anything other than
+// the letters a to z are returned unchanged.
+// See https://tartarus.org/martin/PorterStemmer/def.txt for the definition of the algorithm.
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	s := stemmer{b: []byte(word), k: len(word) - 1}
+	s.step1ab()
+	if s.k > 0 {
+		s.step1c()
+		s.step2()
+		s.step3()
+		s.step4()
+		s.step5()
+	}
+	return string(s.b[:s.k+1])
+}
+
+// stemmer holds the word being stemmed in b[0..k]. j marks the end of the stem before a suffix found by ends.
+type stemmer struct {
+	b    []byte
+	k, j int
+}
+
+// cons reports whether b[i] is a consonant.
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of vowel-consonant sequences in b[0..j].
+func (s *stemmer) m() int {
+	n, i := 0, 0
+	for ; i <= s.j && s.cons(i); i++ {
+	}
+	for {
+		for ; i <= s.j && !s.cons(i); i++ {
+		}
+		if i > s.j {
+			return n
+		}
+		for ; i <= s.j && s.cons(i); i++ {
+		}
+		n++
+		if i > s.j {
+			return n
+		}
+	}
+}
+
+// vowelInStem reports whether b[0..j] contains a vowel.
+func (s *stemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleC reports whether b[i-1..i] is a double consonant.
+func (s *stemmer) doubleC(i int) bool {
+	return i >= 1 && s.b[i] == s.b[i-1] && s.cons(i)
+}
+
+// cvc reports whether b[i-2..i] is consonant-vowel-consonant with the last consonant not being w, x or y.
+func (s *stemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+	switch s.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends reports whether b[0..k] ends with the suffix, setting j to the end of the stem before it if so.
+func (s *stemmer) ends(suffix string) bool {
+	n := len(suffix)
+	if n > s.k+1 || string(s.b[s.k-n+1:s.k+1]) != suffix {
+		return false
+	}
+	s.j = s.k - n
+	return true
+}
+
+// setTo replaces b[j+1..k] with the given string.
+func (s *stemmer) setTo(str string) {
+	s.b = append(s.b[:s.j+1], str...)
+	s.k = s.j + len(str)
+}
+
+// r replaces the suffix found by ends with the given string if the stem has a measure greater than zero.
+func (s *stemmer) r(str string) {
+	if s.m() > 0 {
+		s.setTo(str)
+	}
+}
+
+// replace replaces the first of the suffixes the word ends with by its replacement, if the stem allows it.
+// The suffixes and replacements are given as pairs.
+func (s *stemmer) replace(pairs ...string) {
+	for i := 0; i < len(pairs); i += 2 {
+		if s.ends(pairs[i]) {
+			s.r(pairs[i+1])
+			return
+		}
+	}
+}
+
+// step1ab removes plurals and -ed or -ing, e.g. caresses -> caress, ponies -> poni, meetings -> meet.
+func (s *stemmer) step1ab() {
+	if s.b[s.k] == 's' {
+		if s.ends("sses") {
+			s.k -= 2
+		} else if s.ends("ies") {
+			s.setTo("i")
+		} else if s.b[s.k-1] != 's' {
+			s.k--
+		}
+	}
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.k--
+		}
+	} else if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.k = s.j
+		if s.ends("at") {
+			s.setTo("ate")
+		} else if s.ends("bl") {
+			s.setTo("ble")
+		} else if s.ends("iz") {
+			s.setTo("ize")
+		} else if s.doubleC(s.k) {
+			switch s.b[s.k] {
+			case 'l', 's', 'z':
+			default:
+				s.k--
+			}
+		} else {
+			s.j = s.k
+			if s.m() == 1 && s.cvc(s.k) {
+				s.setTo("e")
+			}
+		}
+	}
+}
+
+// step1c turns a terminal y into i when there is another vowel in the stem.
+func (s *stemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[s.k] = 'i'
+	}
+}
+
+// step2 maps double suffixes to single ones, e.g. -ization -> -ize.
+func (s *stemmer) step2() {
+	if s.k < 1 {
+		return
+	}
+	switch s.b[s.k-1] {
+	case 'a':
+		s.replace("ational", "ate", "tional", "tion")
+	case 'c':
+		s.replace("enci", "ence", "anci", "ance")
+	case 'e':
+		s.replace("izer", "ize")
+	case 'l':
+		s.replace("bli", "ble", "alli", "al", "entli", "ent", "eli", "e", "ousli", "ous")
+	case 'o':
+		s.replace("ization", "ize", "ation", "ate", "ator", "ate")
+	case 's':
+		s.replace("alism", "al", "iveness", "ive", "fulness", "ful", "ousness", "ous")
+	case 't':
+		s.replace("aliti", "al", "iviti", "ive", "biliti", "ble")
+	case 'g':
+		s.replace("logi", "log")
+	}
+}
+
+// step3 deals with -ic-, -full, -ness and the like.
+func (s *stemmer) step3() {
+	switch s.b[s.k] {
+	case 'e':
+		s.replace("icate", "ic", "ative", "", "alize", "al")
+	case 'i':
+		s.replace("iciti", "ic")
+	case 'l':
+		s.replace("ical", "ic", "ful", "")
+	case 's':
+		s.replace("ness", "")
+	}
+}
+
+// step4 removes -ant, -ence and the like from stems with a measure greater than one.
+func (s *stemmer) step4() {
+	if s.k < 1 {
+		return
+	}
+	found := false
+	switch s.b[s.k-1] {
+	case 'a':
+		found = s.ends("al")
+	case 'c':
+		found = s.ends("ance") || s.ends("ence")
+	case 'e':
+		found = s.ends("er")
+	case 'i':
+		found = s.ends("ic")
+	case 'l':
+		found = s.ends("able") || s.ends("ible")
+	case 'n':
+		found = s.ends("ant") || s.ends("ement") || s.ends("ment") || s.ends("ent")
+	case 'o':
+		found = s.ends("ion") && s.j >= 0 && (s.b[s.j] == 's' || s.b[s.j] == 't') || s.ends("ou")
+	case 's':
+		found = s.ends("ism")
+	case 't':
+		found = s.ends("ate") || s.ends("iti")
+	case 'u':
+		found = s.ends("ous")
+	case 'v':
+		found = s.ends("ive")
+	case 'z':
+		found = s.ends("ize")
+	}
+	if found && s.m() > 1 {
+		s.k = s.j
+	}
+}
+
+// step5 removes a final -e and turns a final -ll into -l in stems with a large enough measure.
+func (s *stemmer) step5() {
+	s.j = s.k
+	if s.b[s.k] == 'e' {
+		a := s.m()
+		if a > 1 || a == 1 && !s.cvc(s.k-1) {
+			s.k--
+		}
+	}
+	if s.b[s.k] == 'l' && s.doubleC(s.k) && s.m() > 1 {
+		s.k--
+	}
+}
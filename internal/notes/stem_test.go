@@ -0,0 +1,44 @@
+package notes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_stem(t *testing.T) {
+	tests := map[string]string{
+		"caresses":       "caress",
+		"ponies":         "poni",
+		"cats":           "cat",
+		"feed":           "feed",
+		"agreed":         "agre",
+		"plastered":      "plaster",
+		"motoring":       "motor",
+		"sing":           "sing",
+		"conflated":      "conflat",
+		"troubled":       "troubl",
+		"sized":          "size",
+		"hopping":        "hop",
+		"falling":        "fall",
+		"filing":         "file",
+		"happy":          "happi",
+		"relational":     "relat",
+		"conditional":    "condit",
+		"generalization": "gener",
+		"connection":     "connect",
+		"connected":      "connect",
+		"hopefulness":    "hope",
+		"electrical":     "electr",
+		"adjustment":     "adjust",
+		"controll":       "control",
+		"rate":           "rate",
+		"apples":         "appl",
+		"go":             "go",
+		"text2":          "text2",
+		"café":           "café",
+	}
+	for word, want := range tests {
+		assert.Equal(t, want, stem(word), word)
+	}
+}
@@ -0,0 +1,118 @@
+package savedsearches
+
+import (
+	"net/http"
+	"strconv"
+
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/qiangxue/go-rest-api/pkg/pagination"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, rateLimiter routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler) // the following endpoints require a valid JWT or personal access token
+	r.Use(rateLimiter)
+	read, write := auth.RequireScope(auth.ScopeNotesRead), auth.RequireScope(auth.ScopeNotesWrite)
+	r.Get("/saved-searches/<id>", read, res.get)
+	r.Get("/saved-searches/<id>/notes", read, res.queryNotes)
+	r.Get("/saved-searches", read, res.query)
+	r.Post("/saved-searches", write, res.create)
+	r.Put("/saved-searches/<id>", write, res.update)
+	r.Delete("/saved-searches/<id>", write, res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	search, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(search)
+}
+
+// query returns the saved searches of the current user, or only the pinned ones with "pinned=true".
+func (r resource) query(c *routing.Context) error {
+	pinnedOnly := false
+	if value := c.Query("pinned"); value != "" {
+		var err error
+		if pinnedOnly, err = strconv.ParseBool(value); err != nil {
+			return errors.BadRequest(`pinned must be either "true" or "false"`)
+		}
+	}
+	searches, err := r.service.Query(c.Request.Context(), pinnedOnly)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(searches)
+}
+
+// queryNotes runs a saved search and returns a page of the notes found. The links to the neighbouring pages
+// are sent in the Link header.
+func (r resource) queryNotes(c *routing.Context) error {
+	ctx := c.Request.Context()
+	count, err := r.service.CountNotes(ctx, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	results, err := r.service.QueryNotes(ctx, c.Param("id"), pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = results
+
+	if link := pages.BuildLinkHeader(c.Request.URL.Path, pagination.DefaultPageSize); link != "" {
+		c.Response.Header().Set("Link", link)
+	}
+	return c.Write(pages)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input SavedSearchRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	search, err := r.service.Create(c.Request.Context(), input)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteWithStatus(search, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input SavedSearchRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	search, err := r.service.Update(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(search)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	search, err := r.service.Delete(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(search)
+}
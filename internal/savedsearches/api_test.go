@@ -0,0 +1,56 @@
+package savedsearches
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/notes"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+
+	now := time.Now()
+	repo := &mockSavedSearchRepo{items: []entity.SavedSearch{
+		{ID: "123", UserID: "testuser", Name: "work", Query: "tag:work", Mode: "fulltext", Sort: "relevance",
+			SortOrder: "desc", Tags: []string{}, TagMode: "and", CreatedAt: now, UpdatedAt: now},
+	}}
+	searcher := &mockSearcher{results: []notes.SearchResult{{Note: notes.Note{ID: "1", Title: "found1"}}, {Note: notes.Note{ID: "2", Title: "found2"}}}}
+
+	RegisterHandlers(router.Group(""), NewService(repo, searcher, logger), auth.MockAuthHandler, auth.MockAuthHandler, logger)
+	header := auth.MockAuthHeader()
+	other := auth.MockAuthHeaderFor("otheruser")
+
+	tests := []test.APITestCase{
+		{"get 123", "GET", "/saved-searches/123", "", header, http.StatusOK, `*"query":"tag:work"*`},
+		{"get all", "GET", "/saved-searches", "", header, http.StatusOK, `*"name":"work"*`},
+		{"get pinned", "GET", "/saved-searches?pinned=true", "", header, http.StatusOK, `[]`},
+		{"get pinned invalid", "GET", "/saved-searches?pinned=maybe", "", header, http.StatusBadRequest, ""},
+		{"get other", "GET", "/saved-searches/123", "", other, http.StatusNotFound, ""},
+		{"get all other", "GET", "/saved-searches", "", other, http.StatusOK, `[]`},
+		{"notes", "GET", "/saved-searches/123/notes", "", header, http.StatusOK, `*"total_count":2*`},
+		{"notes paginated", "GET", "/saved-searches/123/notes?per_page=1&page=2", "", header, http.StatusOK, `*"title":"found2"*`},
+		{"notes other", "GET", "/saved-searches/123/notes", "", other, http.StatusNotFound, ""},
+		{"create ok", "POST", "/saved-searches", `{"name":"home","query":"garden","sort":"title","pinned":true}`, header, http.StatusCreated, `*"order":"asc"*`},
+		{"create duplicate", "POST", "/saved-searches", `{"name":"work","query":"plan"}`, header, http.StatusConflict, ""},
+		{"create auth error", "POST", "/saved-searches", `{"name":"test","query":"plan"}`, nil, http.StatusUnauthorized, ""},
+		{"create input error", "POST", "/saved-searches", `{"name":"test","query":""}`, header, http.StatusBadRequest, ""},
+		{"create syntax error", "POST", "/saved-searches", `{"name":"test","query":"shared:maybe"}`, header, http.StatusBadRequest, `*"details":{"position":8}*`},
+		{"get pinned after create", "GET", "/saved-searches?pinned=true", "", header, http.StatusOK, `*"name":"home"*`},
+		{"update ok", "PUT", "/saved-searches/123", `{"name":"office","query":"tag:office","mode":"fuzzy"}`, header, http.StatusOK, `*"mode":"fuzzy"*`},
+		{"update duplicate", "PUT", "/saved-searches/123", `{"name":"home","query":"plan"}`, header, http.StatusConflict, ""},
+		{"update other", "PUT", "/saved-searches/123", `{"name":"mine","query":"plan"}`, other, http.StatusNotFound, ""},
+		{"delete other", "DELETE", "/saved-searches/123", "", other, http.StatusNotFound, ""},
+		{"delete ok", "DELETE", "/saved-searches/123", "", header, http.StatusOK, `*"name":"office"*`},
+		{"delete verify", "DELETE", "/saved-searches/123", "", header, http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
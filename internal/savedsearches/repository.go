@@ -0,0 +1,95 @@
+package savedsearches
+
+import (
+	"context"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Repository encapsulates the logic to access saved searches from the data source.
+type Repository interface {
+	// Get returns the saved search with the specified ID.
+	Get(ctx context.Context, id string) (entity.SavedSearch, error)
+	// GetByName returns the saved search of the given user with the given name.
+	GetByName(ctx context.Context, userID, name string) (entity.SavedSearch, error)
+	// Query returns the saved searches of the given user, the pinned ones first and then by name.
+	// Only the pinned ones are returned if pinnedOnly is true.
+	Query(ctx context.Context, userID string, pinnedOnly bool) ([]entity.SavedSearch, error)
+	// Create saves a new saved search in the storage.
+	Create(ctx context.Context, search entity.SavedSearch) error
+	// Update updates the saved search with given ID in the storage.
+	Update(ctx context.Context, search entity.SavedSearch) error
+	// Delete removes the saved search with given ID from the storage.
+	Delete(ctx context.Context, id string) error
+	// DeleteUserData deletes the saved searches of the given user. It runs within the transaction of the context
+	// deleting the account of the user.
+	DeleteUserData(ctx context.Context, userID string) error
+}
+
+// repository persists saved searches in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new saved search repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get reads the saved search with the specified ID from the database.
+func (r repository) Get(ctx context.Context, id string) (entity.SavedSearch, error) {
+	var search entity.SavedSearch
+	err := r.db.With(ctx).Select().Model(id, &search)
+	return search, err
+}
+
+// GetByName reads the saved search of the given user with the given name from the database.
+func (r repository) GetByName(ctx context.Context, userID, name string) (entity.SavedSearch, error) {
+	var search entity.SavedSearch
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"user_id": userID, "name": name}).One(&search)
+	return search, err
+}
+
+// Query retrieves the saved searches of the given user from the database.
+func (r repository) Query(ctx context.Context, userID string, pinnedOnly bool) ([]entity.SavedSearch, error) {
+	var searches []entity.SavedSearch
+	condition := dbx.HashExp{"user_id": userID}
+	if pinnedOnly {
+		condition["pinned"] = true
+	}
+	err := r.db.With(ctx).
+		Select().
+		Where(condition).
+		OrderBy("pinned DESC", "name").
+		All(&searches)
+	return searches, err
+}
+
+// Create saves a new saved search record in the database.
+func (r repository) Create(ctx context.Context, search entity.SavedSearch) error {
+	return r.db.With(ctx).Model(&search).Insert()
+}
+
+// Update saves the changes to a saved search in the database.
+func (r repository) Update(ctx context.Context, search entity.SavedSearch) error {
+	return r.db.With(ctx).Model(&search).Update()
+}
+
+// Delete deletes the saved search with the specified ID from the database.
+func (r repository) Delete(ctx context.Context, id string) error {
+	search, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return r.db.With(ctx).Model(&search).Delete()
+}
+
+// DeleteUserData deletes the saved searches of the user from the database.
+func (r repository) DeleteUserData(ctx context.Context, userID string) error {
+	_, err := r.db.With(ctx).Delete("saved_searches", dbx.HashExp{"user_id": userID}).Execute()
+	return err
+}
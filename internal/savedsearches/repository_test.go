@@ -0,0 +1,147 @@
+package savedsearches
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	logger, _ := log.NewForTest()
+	db := test.DB(t)
+	test.ResetTables(t, db, "saved_searches")
+	repo := NewRepository(db, logger)
+
+	ctx := context.Background()
+
+	// create
+	now := time.Now()
+	search := entity.SavedSearch{ID: "search1", UserID: "user1", Name: "work", Query: "tag:work", Mode: "fulltext",
+		Sort: "relevance", SortOrder: "desc", Tags: []string{"urgent"}, TagMode: "and", CreatedAt: now, UpdatedAt: now}
+	err := repo.Create(ctx, search)
+	assert.Nil(t, err)
+	search.ID = "search2"
+	err = repo.Create(ctx, search)
+	assert.NotNil(t, err)
+
+	// get
+	search, err = repo.Get(ctx, "search1")
+	assert.Nil(t, err)
+	assert.Equal(t, "tag:work", search.Query)
+	assert.Equal(t, []string{"urgent"}, []string(search.Tags))
+	search, err = repo.GetByName(ctx, "user1", "work")
+	assert.Nil(t, err)
+	assert.Equal(t, "search1", search.ID)
+	_, err = repo.GetByName(ctx, "user2", "work")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// update and query
+	search.Pinned = true
+	err = repo.Update(ctx, search)
+	assert.Nil(t, err)
+	search.ID, search.Name, search.Pinned = "search2", "home", false
+	assert.Nil(t, repo.Create(ctx, search))
+	searches, err := repo.Query(ctx, "user1", false)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(searches)) {
+		assert.Equal(t, "search1", searches[0].ID)
+	}
+	searches, err = repo.Query(ctx, "user1", true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(searches))
+
+	// delete
+	err = repo.Delete(ctx, "search1")
+	assert.Nil(t, err)
+	_, err = repo.Get(ctx, "search1")
+	assert.Equal(t, sql.ErrNoRows, err)
+	err = repo.Delete(ctx, "search1")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// delete the data of a user
+	assert.Nil(t, repo.DeleteUserData(ctx, "user1"))
+	searches, _ = repo.Query(ctx, "user1", false)
+	assert.Empty(t, searches)
+}
+
+type mockSavedSearchRepo struct {
+	items []entity.SavedSearch
+}
+
+func (m *mockSavedSearchRepo) Get(ctx context.Context, id string) (entity.SavedSearch, error) {
+	for _, item := range m.items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return entity.SavedSearch{}, sql.ErrNoRows
+}
+
+func (m *mockSavedSearchRepo) GetByName(ctx context.Context, userID, name string) (entity.SavedSearch, error) {
+	for _, item := range m.items {
+		if item.UserID == userID && item.Name == name {
+			return item, nil
+		}
+	}
+	return entity.SavedSearch{}, sql.ErrNoRows
+}
+
+func (m *mockSavedSearchRepo) Query(ctx context.Context, userID string, pinnedOnly bool) ([]entity.SavedSearch, error) {
+	var searches []entity.SavedSearch
+	for _, item := range m.items {
+		if item.UserID == userID && (item.Pinned || !pinnedOnly) {
+			searches = append(searches, item)
+		}
+	}
+	sort.SliceStable(searches, func(i, j int) bool {
+		if searches[i].Pinned != searches[j].Pinned {
+			return searches[i].Pinned
+		}
+		return searches[i].Name < searches[j].Name
+	})
+	return searches, nil
+}
+
+func (m *mockSavedSearchRepo) Create(ctx context.Context, search entity.SavedSearch) error {
+	m.items = append(m.items, search)
+	return nil
+}
+
+func (m *mockSavedSearchRepo) Update(ctx context.Context, search entity.SavedSearch) error {
+	for i, item := range m.items {
+		if item.ID == search.ID {
+			m.items[i] = search
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockSavedSearchRepo) Delete(ctx context.Context, id string) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items[i] = m.items[len(m.items)-1]
+			m.items = m.items[:len(m.items)-1]
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockSavedSearchRepo) DeleteUserData(ctx context.Context, userID string) error {
+	var searches []entity.SavedSearch
+	for _, item := range m.items {
+		if item.UserID != userID {
+			searches = append(searches, item)
+		}
+	}
+	m.items = searches
+	return nil
+}
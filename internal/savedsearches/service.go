@@ -0,0 +1,266 @@
+package savedsearches
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/internal/notes"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Service encapsulates usecase logic for saved searches.
+type Service interface {
+	Get(ctx context.Context, id string) (SavedSearch, error)
+	Query(ctx context.Context, pinnedOnly bool) ([]SavedSearch, error)
+	Create(ctx context.Context, input SavedSearchRequest) (SavedSearch, error)
+	Update(ctx context.Context, id string, input SavedSearchRequest) (SavedSearch, error)
+	Delete(ctx context.Context, id string) (SavedSearch, error)
+	CountNotes(ctx context.Context, id string) (int, error)
+	QueryNotes(ctx context.Context, id string, offset, limit int) ([]notes.SearchResult, error)
+}
+
+// Searcher runs searches of notes on behalf of the current user. It is implemented by notes.Service.
+type Searcher interface {
+	CountSearch(ctx context.Context, query string, mode notes.SearchMode, filter notes.NoteFilter) (int, error)
+	SearchNotes(ctx context.Context, query string, mode notes.SearchMode, filter notes.NoteFilter, sort notes.NoteSort, offset, limit int) ([]notes.SearchResult, error)
+}
+
+// SavedSearch represents the data about a saved search.
+type SavedSearch struct {
+	entity.SavedSearch
+}
+
+const (
+	// orderAsc and orderDesc are the directions of a sort order.
+	orderAsc  = "asc"
+	orderDesc = "desc"
+	// tagModeAnd and tagModeOr select the notes carrying all or any of the tags of a saved search.
+	tagModeAnd = "and"
+	tagModeOr  = "or"
+)
+
+// SavedSearchRequest represents a request to create a saved search or to replace one.
+// The mode defaults to a full-text search and the sort order to relevance, while the direction defaults to
+// ascending for titles and descending otherwise.
+type SavedSearchRequest struct {
+	Name          string     `json:"name"`
+	Query         string     `json:"query"`
+	Mode          string     `json:"mode"`
+	Sort          string     `json:"sort"`
+	Order         string     `json:"order"`
+	Tags          []string   `json:"tags"`
+	TagMode       string     `json:"tag_mode"`
+	CreatedAfter  *time.Time `json:"created_after"`
+	UpdatedBefore *time.Time `json:"updated_before"`
+	Pinned        bool       `json:"pinned"`
+}
+
+// Validate validates the SavedSearchRequest fields, including the syntax of the query.
+func (m SavedSearchRequest) Validate() error {
+	err := validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 128)),
+		validation.Field(&m.Query, validation.Required, validation.Length(0, 1024)),
+		validation.Field(&m.Mode, validation.In(string(notes.SearchFullText), string(notes.SearchFuzzy))),
+		validation.Field(&m.Sort, validation.In(notes.SortRelevance, notes.SortUpdatedAt, notes.SortCreatedAt, notes.SortTitle)),
+		validation.Field(&m.Order, validation.In(orderAsc, orderDesc)),
+		validation.Field(&m.TagMode, validation.In(tagModeAnd, tagModeOr)),
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := notes.ParseSearchQuery(m.Query); err != nil {
+		return err.(*notes.QuerySyntaxError).BadRequest()
+	}
+	return nil
+}
+
+type service struct {
+	repo     Repository
+	searcher Searcher
+	logger   log.Logger
+}
+
+// NewService creates a new saved search service that runs the saved searches with the given searcher.
+func NewService(repo Repository, searcher Searcher, logger log.Logger) Service {
+	return service{repo, searcher, logger}
+}
+
+// Get returns the saved search with the specified ID if it belongs to the current user.
+func (s service) Get(ctx context.Context, id string) (SavedSearch, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return SavedSearch{}, errors.Unauthorized("")
+	}
+	search, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	if search.UserID != identity.GetID() {
+		return SavedSearch{}, errors.NotFound("")
+	}
+	return SavedSearch{search}, nil
+}
+
+// Query returns the saved searches of the current user, or only the pinned ones if pinnedOnly is true.
+func (s service) Query(ctx context.Context, pinnedOnly bool) ([]SavedSearch, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	items, err := s.repo.Query(ctx, identity.GetID(), pinnedOnly)
+	if err != nil {
+		return nil, err
+	}
+	result := []SavedSearch{}
+	for _, item := range items {
+		result = append(result, SavedSearch{item})
+	}
+	return result, nil
+}
+
+// Create creates a new saved search for the current user.
+func (s service) Create(ctx context.Context, req SavedSearchRequest) (SavedSearch, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if err := req.Validate(); err != nil {
+		return SavedSearch{}, err
+	}
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return SavedSearch{}, errors.Unauthorized("")
+	}
+	if err := s.checkNameAvailable(ctx, identity.GetID(), req.Name); err != nil {
+		return SavedSearch{}, err
+	}
+	now := time.Now()
+	search := entity.SavedSearch{
+		ID:        entity.GenerateID(),
+		UserID:    identity.GetID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	apply(&search, req)
+	if err := s.repo.Create(ctx, search); err != nil {
+		return SavedSearch{}, err
+	}
+	return SavedSearch{search}, nil
+}
+
+// Update replaces the saved search with the specified ID.
+func (s service) Update(ctx context.Context, id string, req SavedSearchRequest) (SavedSearch, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if err := req.Validate(); err != nil {
+		return SavedSearch{}, err
+	}
+	search, err := s.Get(ctx, id)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	if search.Name != req.Name {
+		if err := s.checkNameAvailable(ctx, search.UserID, req.Name); err != nil {
+			return SavedSearch{}, err
+		}
+	}
+	apply(&search.SavedSearch, req)
+	search.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, search.SavedSearch); err != nil {
+		return SavedSearch{}, err
+	}
+	return search, nil
+}
+
+// Delete deletes the saved search with the specified ID.
+func (s service) Delete(ctx context.Context, id string) (SavedSearch, error) {
+	search, err := s.Get(ctx, id)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	if err = s.repo.Delete(ctx, id); err != nil {
+		return SavedSearch{}, err
+	}
+	return search, nil
+}
+
+// CountNotes returns the number of notes found by the saved search with the specified ID.
+func (s service) CountNotes(ctx context.Context, id string) (int, error) {
+	search, err := s.Get(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return s.searcher.CountSearch(ctx, search.Query, notes.SearchMode(search.Mode), filterOf(search.SavedSearch))
+}
+
+// QueryNotes runs the saved search with the specified ID and returns the notes found with the given offset and limit.
+func (s service) QueryNotes(ctx context.Context, id string, offset, limit int) ([]notes.SearchResult, error) {
+	search, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sort := notes.NoteSort{Field: search.Sort, Desc: search.SortOrder == orderDesc}
+	return s.searcher.SearchNotes(ctx, search.Query, notes.SearchMode(search.Mode), filterOf(search.SavedSearch), sort, offset, limit)
+}
+
+// checkNameAvailable returns a conflict error if the user already has a saved search with the given name.
+func (s service) checkNameAvailable(ctx context.Context, userID, name string) error {
+	_, err := s.repo.GetByName(ctx, userID, name)
+	switch err {
+	case nil:
+		return errors.Conflict("a saved search with this name already exists")
+	case sql.ErrNoRows:
+		return nil
+	}
+	return err
+}
+
+// apply copies the fields of the request to the saved search, filling in the defaults of the fields not given.
+func apply(search *entity.SavedSearch, req SavedSearchRequest) {
+	search.Name = req.Name
+	search.Query = req.Query
+	search.Mode = req.Mode
+	if search.Mode == "" {
+		search.Mode = string(notes.SearchFullText)
+	}
+	search.Sort = req.Sort
+	if search.Sort == "" {
+		search.Sort = notes.SortRelevance
+	}
+	search.SortOrder = req.Order
+	if search.SortOrder == "" {
+		search.SortOrder = orderDesc
+		if search.Sort == notes.SortTitle {
+			search.SortOrder = orderAsc
+		}
+	}
+	search.Tags = []string{}
+	seen := map[string]bool{}
+	for _, name := range req.Tags {
+		if name = entity.NormalizeTagName(name); name != "" && !seen[name] {
+			seen[name] = true
+			search.Tags = append(search.Tags, name)
+		}
+	}
+	search.TagMode = req.TagMode
+	if search.TagMode == "" {
+		search.TagMode = tagModeAnd
+	}
+	search.CreatedAfter = req.CreatedAfter
+	search.UpdatedBefore = req.UpdatedBefore
+	search.Pinned = req.Pinned
+}
+
+// filterOf returns the filter of the notes found by the saved search.
+func filterOf(search entity.SavedSearch) notes.NoteFilter {
+	filter := notes.NoteFilter{Tags: search.Tags, AnyTag: search.TagMode == tagModeOr}
+	if search.CreatedAfter != nil {
+		filter.CreatedAfter = *search.CreatedAfter
+	}
+	if search.UpdatedBefore != nil {
+		filter.UpdatedBefore = *search.UpdatedBefore
+	}
+	return filter
+}
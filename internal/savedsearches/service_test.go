@@ -0,0 +1,154 @@
+package savedsearches
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/internal/notes"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSavedSearchRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     SavedSearchRequest
+		wantError bool
+	}{
+		{"success", SavedSearchRequest{Name: "work", Query: "tag:work -draft"}, false},
+		{"all fields", SavedSearchRequest{Name: "work", Query: "plan", Mode: "fuzzy", Sort: "title", Order: "desc", TagMode: "or"}, false},
+		{"name required", SavedSearchRequest{Query: "plan"}, true},
+		{"query required", SavedSearchRequest{Name: "work"}, true},
+		{"query syntax", SavedSearchRequest{Name: "work", Query: `"unterminated`}, true},
+		{"mode", SavedSearchRequest{Name: "work", Query: "plan", Mode: "exact"}, true},
+		{"sort", SavedSearchRequest{Name: "work", Query: "plan", Sort: "text"}, true},
+		{"order", SavedSearchRequest{Name: "work", Query: "plan", Order: "up"}, true},
+		{"tag mode", SavedSearchRequest{Name: "work", Query: "plan", TagMode: "xor"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, _ := log.NewForTest()
+	s := NewService(&mockSavedSearchRepo{}, &mockSearcher{}, logger)
+
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+	other := auth.WithUser(context.Background(), "user2", "user2")
+
+	// unauthenticated
+	_, err := s.Create(context.Background(), SavedSearchRequest{Name: "work", Query: "plan"})
+	assert.Equal(t, errors.Unauthorized(""), err)
+
+	// successful creation with defaults and normalized tags
+	search, err := s.Create(ctx, SavedSearchRequest{Name: " work ", Query: "plan", Tags: []string{"#Urgent", "urgent", " "}})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, search.ID)
+	assert.Equal(t, "work", search.Name)
+	assert.Equal(t, "fulltext", search.Mode)
+	assert.Equal(t, "relevance", search.Sort)
+	assert.Equal(t, "desc", search.SortOrder)
+	assert.Equal(t, "and", search.TagMode)
+	assert.Equal(t, []string{"urgent"}, []string(search.Tags))
+	id := search.ID
+
+	// duplicate names are rejected per user only
+	_, err = s.Create(ctx, SavedSearchRequest{Name: "work", Query: "other"})
+	assert.Equal(t, errors.Conflict("a saved search with this name already exists"), err)
+	_, err = s.Create(other, SavedSearchRequest{Name: "work", Query: "plan"})
+	assert.Nil(t, err)
+
+	// syntax errors in the query report their position
+	_, err = s.Create(ctx, SavedSearchRequest{Name: "broken", Query: `plan "oops`})
+	assert.Equal(t, (&notes.QuerySyntaxError{Pos: 6, Msg: "unterminated quoted phrase"}).BadRequest(), err)
+
+	// get
+	_, err = s.Get(ctx, id)
+	assert.Nil(t, err)
+	_, err = s.Get(other, id)
+	assert.Equal(t, errors.NotFound(""), err)
+
+	// update replaces the saved search, and pinned ones are listed first
+	_, err = s.Create(ctx, SavedSearchRequest{Name: "home", Query: "garden"})
+	assert.Nil(t, err)
+	_, err = s.Update(ctx, id, SavedSearchRequest{Name: "home", Query: "plan"})
+	assert.Equal(t, errors.Conflict("a saved search with this name already exists"), err)
+	search, err = s.Update(ctx, id, SavedSearchRequest{Name: "work", Query: "plan", Sort: "title", Pinned: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "asc", search.SortOrder)
+	assert.Empty(t, search.Tags)
+	_, err = s.Update(other, id, SavedSearchRequest{Name: "mine", Query: "plan"})
+	assert.Equal(t, errors.NotFound(""), err)
+	searches, _ := s.Query(ctx, false)
+	if assert.Equal(t, 2, len(searches)) {
+		assert.Equal(t, id, searches[0].ID)
+	}
+	searches, _ = s.Query(ctx, true)
+	assert.Equal(t, 1, len(searches))
+
+	// delete
+	_, err = s.Delete(other, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Delete(ctx, id)
+	assert.Nil(t, err)
+	_, err = s.Get(ctx, id)
+	assert.NotNil(t, err)
+}
+
+func Test_service_QueryNotes(t *testing.T) {
+	logger, _ := log.NewForTest()
+	searcher := &mockSearcher{results: []notes.SearchResult{{Note: notes.Note{ID: "1"}}, {Note: notes.Note{ID: "2"}}}}
+	s := NewService(&mockSavedSearchRepo{}, searcher, logger)
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+
+	after := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	search, err := s.Create(ctx, SavedSearchRequest{Name: "work", Query: "plan", Mode: "fuzzy", Sort: "updated_at",
+		Order: "asc", Tags: []string{"work"}, TagMode: "or", CreatedAfter: &after})
+	assert.Nil(t, err)
+
+	count, err := s.CountNotes(ctx, search.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	results, err := s.QueryNotes(ctx, search.ID, 1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "plan", searcher.query)
+	assert.Equal(t, notes.SearchFuzzy, searcher.mode)
+	assert.Equal(t, notes.NoteFilter{Tags: []string{"work"}, AnyTag: true, CreatedAfter: after}, searcher.filter)
+	assert.Equal(t, notes.NoteSort{Field: notes.SortUpdatedAt}, searcher.sort)
+
+	// other users cannot run the saved search
+	other := auth.WithUser(context.Background(), "user2", "user2")
+	_, err = s.QueryNotes(other, search.ID, 0, 10)
+	assert.Equal(t, errors.NotFound(""), err)
+}
+
+// mockSearcher returns the same results for any search and records the last one run.
+type mockSearcher struct {
+	results []notes.SearchResult
+	query   string
+	mode    notes.SearchMode
+	filter  notes.NoteFilter
+	sort    notes.NoteSort
+}
+
+func (m *mockSearcher) CountSearch(ctx context.Context, query string, mode notes.SearchMode, filter notes.NoteFilter) (int, error) {
+	m.query, m.mode, m.filter = query, mode, filter
+	return len(m.results), nil
+}
+
+func (m *mockSearcher) SearchNotes(ctx context.Context, query string, mode notes.SearchMode, filter notes.NoteFilter, sort notes.NoteSort, offset, limit int) ([]notes.SearchResult, error) {
+	m.query, m.mode, m.filter, m.sort = query, mode, filter, sort
+	results := m.results[offset:]
+	if limit < len(results) {
+		results = results[:limit]
+	}
+	return results, nil
+}
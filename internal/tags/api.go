@@ -0,0 +1,86 @@
+package tags
+
+import (
+	"net/http"
+
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, rateLimiter routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler) // the following endpoints require a valid JWT or personal access token
+	r.Use(rateLimiter)
+	read, write := auth.RequireScope(auth.ScopeNotesRead), auth.RequireScope(auth.ScopeNotesWrite)
+	r.Get("/tags/<id>", read, res.get)
+	r.Get("/tags", read, res.query)
+	r.Post("/tags", write, res.create)
+	r.Put("/tags/<id>", write, res.update)
+	r.Delete("/tags/<id>", write, res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	tag, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(tag)
+}
+
+func (r resource) query(c *routing.Context) error {
+	tags, err := r.service.Query(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	return c.Write(tags)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input CreateTagRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	tag, err := r.service.Create(c.Request.Context(), input)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteWithStatus(tag, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input UpdateTagRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	tag, err := r.service.Update(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(tag)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	tag, err := r.service.Delete(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(tag)
+}
@@ -0,0 +1,46 @@
+package tags
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+
+	now := time.Now()
+	repo := &mockTagRepo{items: []entity.Tag{
+		{ID: "123", UserID: "testuser", Name: "work", CreatedAt: now, UpdatedAt: now},
+	}}
+
+	RegisterHandlers(router.Group(""), NewService(repo, logger), auth.MockAuthHandler, auth.MockAuthHandler, logger)
+	header := auth.MockAuthHeader()
+	other := auth.MockAuthHeaderFor("otheruser")
+
+	tests := []test.APITestCase{
+		{"get 123", "GET", "/tags/123", "", header, http.StatusOK, `*"name":"work"*`},
+		{"get all", "GET", "/tags", "", header, http.StatusOK, `*"name":"work"*`},
+		{"get other", "GET", "/tags/123", "", other, http.StatusNotFound, ""},
+		{"get all other", "GET", "/tags", "", other, http.StatusOK, `[]`},
+		{"create ok", "POST", "/tags", `{"name":" #Home "}`, header, http.StatusCreated, `*"name":"home"*`},
+		{"create duplicate", "POST", "/tags", `{"name":"WORK"}`, header, http.StatusConflict, ""},
+		{"create auth error", "POST", "/tags", `{"name":"test"}`, nil, http.StatusUnauthorized, ""},
+		{"create input error", "POST", "/tags", `{"name":""}`, header, http.StatusBadRequest, ""},
+		{"update ok", "PUT", "/tags/123", `{"name":"Office"}`, header, http.StatusOK, `*"name":"office"*`},
+		{"update duplicate", "PUT", "/tags/123", `{"name":"home"}`, header, http.StatusConflict, ""},
+		{"update other", "PUT", "/tags/123", `{"name":"mine"}`, other, http.StatusNotFound, ""},
+		{"delete other", "DELETE", "/tags/123", "", other, http.StatusNotFound, ""},
+		{"delete ok", "DELETE", "/tags/123", "", header, http.StatusOK, `*"name":"office"*`},
+		{"delete verify", "DELETE", "/tags/123", "", header, http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
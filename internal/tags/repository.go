@@ -0,0 +1,100 @@
+package tags
+
+import (
+	"context"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Repository encapsulates the logic to access tags from the data source.
+type Repository interface {
+	// Get returns the tag with the specified tag ID.
+	Get(ctx context.Context, id string) (entity.Tag, error)
+	// GetByName returns the tag of the given user with the given normalized name.
+	GetByName(ctx context.Context, userID, name string) (entity.Tag, error)
+	// Query returns the tags of the given user ordered by name.
+	Query(ctx context.Context, userID string) ([]entity.Tag, error)
+	// Create saves a new tag in the storage.
+	Create(ctx context.Context, tag entity.Tag) error
+	// Update updates the tag with given ID in the storage.
+	Update(ctx context.Context, tag entity.Tag) error
+	// Delete removes the tag with given ID from the storage and from every note it is attached to.
+	Delete(ctx context.Context, id string) error
+	// DeleteUserData deletes the tags of the given user and detaches them from the notes. It runs within
+	// the transaction of the context deleting the account of the user.
+	DeleteUserData(ctx context.Context, userID string) error
+}
+
+// repository persists tags in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new tag repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get reads the tag with the specified ID from the database.
+func (r repository) Get(ctx context.Context, id string) (entity.Tag, error) {
+	var tag entity.Tag
+	err := r.db.With(ctx).Select().Model(id, &tag)
+	return tag, err
+}
+
+// GetByName reads the tag of the given user with the given name from the database.
+func (r repository) GetByName(ctx context.Context, userID, name string) (entity.Tag, error) {
+	var tag entity.Tag
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"user_id": userID, "name": name}).One(&tag)
+	return tag, err
+}
+
+// Query retrieves the tags of the given user from the database.
+func (r repository) Query(ctx context.Context, userID string) ([]entity.Tag, error) {
+	var tags []entity.Tag
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"user_id": userID}).
+		OrderBy("name").
+		All(&tags)
+	return tags, err
+}
+
+// Create saves a new tag record in the database.
+func (r repository) Create(ctx context.Context, tag entity.Tag) error {
+	return r.db.With(ctx).Model(&tag).Insert()
+}
+
+// Update saves the changes to a tag in the database.
+func (r repository) Update(ctx context.Context, tag entity.Tag) error {
+	return r.db.With(ctx).Model(&tag).Update()
+}
+
+// Delete deletes the tag with the specified ID from the database, detaching it from its notes first.
+func (r repository) Delete(ctx context.Context, id string) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		tag, err := r.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := r.db.With(ctx).Delete("note_tags", dbx.HashExp{"tag_id": id}).Execute(); err != nil {
+			return err
+		}
+		return r.db.With(ctx).Model(&tag).Delete()
+	})
+}
+
+// DeleteUserData deletes the tags of the user from the database, along with their attachments to notes.
+func (r repository) DeleteUserData(ctx context.Context, userID string) error {
+	_, err := r.db.With(ctx).Delete("note_tags",
+		dbx.NewExp("tag_id IN (SELECT id FROM tags WHERE user_id = {:user})", dbx.Params{"user": userID})).Execute()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.With(ctx).Delete("tags", dbx.HashExp{"user_id": userID}).Execute()
+	return err
+}
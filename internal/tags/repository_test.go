@@ -0,0 +1,142 @@
+package tags
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	logger, _ := log.NewForTest()
+	db := test.DB(t)
+	test.ResetTables(t, db, "tags", "note_tags")
+	repo := NewRepository(db, logger)
+
+	ctx := context.Background()
+
+	// create
+	now := time.Now()
+	err := repo.Create(ctx, entity.Tag{ID: "tag1", UserID: "user1", Name: "work", CreatedAt: now, UpdatedAt: now})
+	assert.Nil(t, err)
+	err = repo.Create(ctx, entity.Tag{ID: "tag2", UserID: "user1", Name: "work", CreatedAt: now, UpdatedAt: now})
+	assert.NotNil(t, err)
+
+	// get
+	tag, err := repo.Get(ctx, "tag1")
+	assert.Nil(t, err)
+	assert.Equal(t, "work", tag.Name)
+	tag, err = repo.GetByName(ctx, "user1", "work")
+	assert.Nil(t, err)
+	assert.Equal(t, "tag1", tag.ID)
+	_, err = repo.GetByName(ctx, "user2", "work")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// update
+	tag.Name = "office"
+	err = repo.Update(ctx, tag)
+	assert.Nil(t, err)
+	tags, err := repo.Query(ctx, "user1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tags))
+	assert.Equal(t, "office", tags[0].Name)
+
+	// delete
+	_, err = db.DB().Insert("note_tags", map[string]interface{}{"note_id": "note1", "tag_id": "tag1"}).Execute()
+	assert.Nil(t, err)
+	err = repo.Delete(ctx, "tag1")
+	assert.Nil(t, err)
+	_, err = repo.Get(ctx, "tag1")
+	assert.Equal(t, sql.ErrNoRows, err)
+	var count int
+	err = db.DB().Select("COUNT(*)").From("note_tags").Row(&count)
+	assert.Nil(t, err)
+	assert.Zero(t, count)
+	err = repo.Delete(ctx, "tag1")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// delete the data of a user
+	assert.Nil(t, repo.Create(ctx, entity.Tag{ID: "tag3", UserID: "user1", Name: "home", CreatedAt: now, UpdatedAt: now}))
+	_, err = db.DB().Insert("note_tags", map[string]interface{}{"note_id": "note1", "tag_id": "tag3"}).Execute()
+	assert.Nil(t, err)
+	assert.Nil(t, repo.DeleteUserData(ctx, "user1"))
+	tags, _ = repo.Query(ctx, "user1")
+	assert.Empty(t, tags)
+	err = db.DB().Select("COUNT(*)").From("note_tags").Row(&count)
+	assert.Nil(t, err)
+	assert.Zero(t, count)
+}
+
+type mockTagRepo struct {
+	items []entity.Tag
+}
+
+func (m *mockTagRepo) Get(ctx context.Context, id string) (entity.Tag, error) {
+	for _, item := range m.items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return entity.Tag{}, sql.ErrNoRows
+}
+
+func (m *mockTagRepo) GetByName(ctx context.Context, userID, name string) (entity.Tag, error) {
+	for _, item := range m.items {
+		if item.UserID == userID && item.Name == name {
+			return item, nil
+		}
+	}
+	return entity.Tag{}, sql.ErrNoRows
+}
+
+func (m *mockTagRepo) Query(ctx context.Context, userID string) ([]entity.Tag, error) {
+	var tags []entity.Tag
+	for _, item := range m.items {
+		if item.UserID == userID {
+			tags = append(tags, item)
+		}
+	}
+	return tags, nil
+}
+
+func (m *mockTagRepo) Create(ctx context.Context, tag entity.Tag) error {
+	m.items = append(m.items, tag)
+	return nil
+}
+
+func (m *mockTagRepo) Update(ctx context.Context, tag entity.Tag) error {
+	for i, item := range m.items {
+		if item.ID == tag.ID {
+			m.items[i] = tag
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockTagRepo) Delete(ctx context.Context, id string) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items[i] = m.items[len(m.items)-1]
+			m.items = m.items[:len(m.items)-1]
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockTagRepo) DeleteUserData(ctx context.Context, userID string) error {
+	var tags []entity.Tag
+	for _, item := range m.items {
+		if item.UserID != userID {
+			tags = append(tags, item)
+		}
+	}
+	m.items = tags
+	return nil
+}
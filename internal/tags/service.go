@@ -0,0 +1,169 @@
+package tags
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Service encapsulates usecase logic for tags.
+type Service interface {
+	Get(ctx context.Context, id string) (Tag, error)
+	Query(ctx context.Context) ([]Tag, error)
+	Create(ctx context.Context, input CreateTagRequest) (Tag, error)
+	Update(ctx context.Context, id string, input UpdateTagRequest) (Tag, error)
+	Delete(ctx context.Context, id string) (Tag, error)
+}
+
+// Tag represents the data about a tag.
+type Tag struct {
+	entity.Tag
+}
+
+// CreateTagRequest represents a tag creation request.
+type CreateTagRequest struct {
+	Name string `json:"name"`
+}
+
+// Validate validates the CreateTagRequest fields.
+func (m CreateTagRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 64)),
+	)
+}
+
+// UpdateTagRequest represents a tag update request.
+type UpdateTagRequest struct {
+	Name string `json:"name"`
+}
+
+// Validate validates the UpdateTagRequest fields.
+func (m UpdateTagRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 64)),
+	)
+}
+
+type service struct {
+	repo   Repository
+	logger log.Logger
+}
+
+// NewService creates a new tag service.
+func NewService(repo Repository, logger log.Logger) Service {
+	return service{repo, logger}
+}
+
+// Get returns the tag with the specified ID if it belongs to the current user.
+func (s service) Get(ctx context.Context, id string) (Tag, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Tag{}, errors.Unauthorized("")
+	}
+	tag, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return Tag{}, err
+	}
+	if tag.UserID != identity.GetID() {
+		return Tag{}, errors.NotFound("")
+	}
+	return Tag{tag}, nil
+}
+
+// Query returns the tags of the current user.
+func (s service) Query(ctx context.Context) ([]Tag, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	items, err := s.repo.Query(ctx, identity.GetID())
+	if err != nil {
+		return nil, err
+	}
+	result := []Tag{}
+	for _, item := range items {
+		result = append(result, Tag{item})
+	}
+	return result, nil
+}
+
+// Create creates a new tag for the current user.
+func (s service) Create(ctx context.Context, req CreateTagRequest) (Tag, error) {
+	req.Name = entity.NormalizeTagName(req.Name)
+	if err := req.Validate(); err != nil {
+		return Tag{}, err
+	}
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Tag{}, errors.Unauthorized("")
+	}
+	if err := s.checkNameAvailable(ctx, identity.GetID(), req.Name); err != nil {
+		return Tag{}, err
+	}
+	now := time.Now()
+	tag := entity.Tag{
+		ID:        entity.GenerateID(),
+		UserID:    identity.GetID(),
+		Name:      req.Name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.repo.Create(ctx, tag); err != nil {
+		return Tag{}, err
+	}
+	return Tag{tag}, nil
+}
+
+// Update renames the tag with the specified ID.
+func (s service) Update(ctx context.Context, id string, req UpdateTagRequest) (Tag, error) {
+	req.Name = entity.NormalizeTagName(req.Name)
+	if err := req.Validate(); err != nil {
+		return Tag{}, err
+	}
+	tag, err := s.Get(ctx, id)
+	if err != nil {
+		return Tag{}, err
+	}
+	if tag.Name == req.Name {
+		return tag, nil
+	}
+	if err := s.checkNameAvailable(ctx, tag.UserID, req.Name); err != nil {
+		return Tag{}, err
+	}
+	tag.Name = req.Name
+	tag.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, tag.Tag); err != nil {
+		return Tag{}, err
+	}
+	return tag, nil
+}
+
+// Delete deletes the tag with the specified ID and removes it from the notes it is attached to.
+func (s service) Delete(ctx context.Context, id string) (Tag, error) {
+	tag, err := s.Get(ctx, id)
+	if err != nil {
+		return Tag{}, err
+	}
+	if err = s.repo.Delete(ctx, id); err != nil {
+		return Tag{}, err
+	}
+	return tag, nil
+}
+
+// checkNameAvailable returns a conflict error if the user already has a tag with the given name.
+func (s service) checkNameAvailable(ctx context.Context, userID, name string) error {
+	_, err := s.repo.GetByName(ctx, userID, name)
+	switch err {
+	case nil:
+		return errors.Conflict("a tag with this name already exists")
+	case sql.ErrNoRows:
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package tags
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTagRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     CreateTagRequest
+		wantError bool
+	}{
+		{"success", CreateTagRequest{Name: "work"}, false},
+		{"required", CreateTagRequest{Name: ""}, true},
+		{"too long", CreateTagRequest{Name: "12345678901234567890123456789012345678901234567890123456789012345"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, _ := log.NewForTest()
+	s := NewService(&mockTagRepo{}, logger)
+
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+	other := auth.WithUser(context.Background(), "user2", "user2")
+
+	// unauthenticated
+	_, err := s.Create(context.Background(), CreateTagRequest{Name: "work"})
+	assert.Equal(t, errors.Unauthorized(""), err)
+
+	// successful creation with a normalized name
+	tag, err := s.Create(ctx, CreateTagRequest{Name: "  #Work   Items "})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, tag.ID)
+	assert.Equal(t, "work items", tag.Name)
+	assert.Equal(t, "user1", tag.UserID)
+	id := tag.ID
+
+	// duplicate names are rejected per user only
+	_, err = s.Create(ctx, CreateTagRequest{Name: "WORK ITEMS"})
+	assert.Equal(t, errors.Conflict("a tag with this name already exists"), err)
+	_, err = s.Create(other, CreateTagRequest{Name: "work items"})
+	assert.Nil(t, err)
+
+	// validation error in creation
+	_, err = s.Create(ctx, CreateTagRequest{Name: " # "})
+	assert.NotNil(t, err)
+
+	// get
+	_, err = s.Get(ctx, id)
+	assert.Nil(t, err)
+	_, err = s.Get(other, id)
+	assert.Equal(t, errors.NotFound(""), err)
+
+	// query
+	tags, _ := s.Query(ctx)
+	assert.Equal(t, 1, len(tags))
+
+	// rename
+	_, err = s.Create(ctx, CreateTagRequest{Name: "home"})
+	assert.Nil(t, err)
+	_, err = s.Update(ctx, id, UpdateTagRequest{Name: "Home"})
+	assert.Equal(t, errors.Conflict("a tag with this name already exists"), err)
+	tag, err = s.Update(ctx, id, UpdateTagRequest{Name: "Office"})
+	assert.Nil(t, err)
+	assert.Equal(t, "office", tag.Name)
+	_, err = s.Update(other, id, UpdateTagRequest{Name: "mine"})
+	assert.Equal(t, errors.NotFound(""), err)
+
+	// delete
+	_, err = s.Delete(other, id)
+	assert.Equal(t, errors.NotFound(""), err)
+	_, err = s.Delete(ctx, id)
+	assert.Nil(t, err)
+	_, err = s.Get(ctx, id)
+	assert.NotNil(t, err)
+	tags, _ = s.Query(ctx)
+	assert.Equal(t, 1, len(tags))
+}
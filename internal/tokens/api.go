@@ -0,0 +1,59 @@
+package tokens
+
+import (
+	"net/http"
+
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers managing personal access tokens.
+// authHandler must not accept personal access tokens, so that a token cannot be used to create others.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, rateLimiter routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler) // the following endpoints require a valid JWT
+	r.Use(rateLimiter)
+	r.Get("/tokens", res.query)
+	r.Post("/tokens", res.create)
+	r.Delete("/tokens/<id>", res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) query(c *routing.Context) error {
+	tokens, err := r.service.Query(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	return c.Write(tokens)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input CreateTokenRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	token, err := r.service.Create(c.Request.Context(), input)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteWithStatus(token, http.StatusCreated)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	token, err := r.service.Delete(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(token)
+}
@@ -0,0 +1,37 @@
+package tokens
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	repo := &mockTokenRepo{items: []entity.PersonalAccessToken{
+		{ID: "t1", UserID: "testuser", Name: "backup", Scopes: []string{auth.ScopeNotesRead}, TokenHash: "hash1"},
+	}}
+	RegisterHandlers(router.Group(""), NewService(repo, logger), auth.MockAuthHandler, auth.MockAuthHandler, logger)
+	header := auth.MockAuthHeader()
+	other := auth.MockAuthHeaderFor("otheruser")
+
+	tests := []test.APITestCase{
+		{"query", "GET", "/tokens", "", header, http.StatusOK, `*"name":"backup"*`},
+		{"query auth error", "GET", "/tokens", "", nil, http.StatusUnauthorized, ""},
+		{"create", "POST", "/tokens", `{"name":"sync","scopes":["notes:read","notes:write"]}`, header, http.StatusCreated, `*"token":"pat_*`},
+		{"create unknown scope", "POST", "/tokens", `{"name":"sync","scopes":["admin"]}`, header, http.StatusBadRequest, ""},
+		{"create expired", "POST", "/tokens", `{"name":"sync","scopes":["share"],"expires_at":"2020-01-01T00:00:00Z"}`, header, http.StatusBadRequest, ""},
+		{"create invalid body", "POST", "/tokens", `"name"`, header, http.StatusBadRequest, ""},
+		{"delete other", "DELETE", "/tokens/t1", "", other, http.StatusNotFound, ""},
+		{"delete", "DELETE", "/tokens/t1", "", header, http.StatusOK, `*"id":"t1"*`},
+		{"delete unknown", "DELETE", "/tokens/t1", "", header, http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
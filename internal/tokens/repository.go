@@ -0,0 +1,96 @@
+package tokens
+
+import (
+	"context"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Repository encapsulates the logic to access personal access tokens from the data source.
+// It also implements auth.AccessTokenFinder.
+type Repository interface {
+	// Get returns the token with the specified ID.
+	Get(ctx context.Context, id string) (entity.PersonalAccessToken, error)
+	// Query returns the tokens of the given user, the newest first.
+	Query(ctx context.Context, userID string) ([]entity.PersonalAccessToken, error)
+	// Create saves a new token in the storage.
+	Create(ctx context.Context, token entity.PersonalAccessToken) error
+	// Delete removes the token with given ID from the storage.
+	Delete(ctx context.Context, id string) error
+	// GetByHash returns the token with the given hash along with the name of its user.
+	GetByHash(ctx context.Context, hash string) (auth.AccessToken, error)
+	// Touch records that the token with the given ID was used at the given time.
+	Touch(ctx context.Context, id string, usedAt time.Time) error
+	// DeleteUserData deletes the tokens of the given user. It runs within the transaction of the context
+	// deleting the account of the user.
+	DeleteUserData(ctx context.Context, userID string) error
+}
+
+// repository persists personal access tokens in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new personal access token repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get reads the token with the specified ID from the database.
+func (r repository) Get(ctx context.Context, id string) (entity.PersonalAccessToken, error) {
+	var token entity.PersonalAccessToken
+	err := r.db.With(ctx).Select().Model(id, &token)
+	return token, err
+}
+
+// Query retrieves the tokens of the given user from the database.
+func (r repository) Query(ctx context.Context, userID string) ([]entity.PersonalAccessToken, error) {
+	var tokens []entity.PersonalAccessToken
+	err := r.db.With(ctx).
+		Select().
+		Where(dbx.HashExp{"user_id": userID}).
+		OrderBy("created_at DESC", "id").
+		All(&tokens)
+	return tokens, err
+}
+
+// Create saves a new token record in the database.
+func (r repository) Create(ctx context.Context, token entity.PersonalAccessToken) error {
+	return r.db.With(ctx).Model(&token).Insert()
+}
+
+// Delete deletes the token with the specified ID from the database.
+func (r repository) Delete(ctx context.Context, id string) error {
+	_, err := r.db.With(ctx).Delete("personal_access_tokens", dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// GetByHash reads the token with the given hash and the name of its user from the database.
+func (r repository) GetByHash(ctx context.Context, hash string) (auth.AccessToken, error) {
+	var token auth.AccessToken
+	err := r.db.With(ctx).
+		Select("personal_access_tokens.*", "users.name AS username").
+		From("personal_access_tokens").
+		InnerJoin("users", dbx.NewExp("users.id = personal_access_tokens.user_id")).
+		Where(dbx.HashExp{"personal_access_tokens.token_hash": hash}).
+		One(&token)
+	return token, err
+}
+
+// Touch saves the last-used time of the token in the database.
+func (r repository) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := r.db.With(ctx).Update("personal_access_tokens", dbx.Params{"last_used_at": usedAt}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// DeleteUserData deletes the tokens of the user from the database.
+func (r repository) DeleteUserData(ctx context.Context, userID string) error {
+	_, err := r.db.With(ctx).Delete("personal_access_tokens", dbx.HashExp{"user_id": userID}).Execute()
+	return err
+}
@@ -0,0 +1,127 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	logger, _ := log.NewForTest()
+	db := test.DB(t)
+	test.ResetTables(t, db, "personal_access_tokens")
+	repo := NewRepository(db, logger)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// create
+	assert.Nil(t, repo.Create(ctx, entity.PersonalAccessToken{ID: "t1", UserID: "1", Name: "backup", Scopes: []string{auth.ScopeNotesRead}, TokenHash: "hash1", CreatedAt: now}))
+	assert.Nil(t, repo.Create(ctx, entity.PersonalAccessToken{ID: "t2", UserID: "1", Name: "sync", Scopes: []string{auth.ScopeNotesRead, auth.ScopeNotesWrite}, TokenHash: "hash2", CreatedAt: now.Add(time.Second)}))
+	token, err := repo.Get(ctx, "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, "backup", token.Name)
+	tokens, err := repo.Query(ctx, "1")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(tokens)) {
+		assert.Equal(t, "t2", tokens[0].ID)
+		assert.Equal(t, 2, len(tokens[0].Scopes))
+	}
+
+	// lookup
+	found, err := repo.GetByHash(ctx, "hash1")
+	assert.Nil(t, err)
+	assert.Equal(t, "t1", found.ID)
+	assert.Equal(t, "demo1", found.Username)
+	_, err = repo.GetByHash(ctx, "unknown")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// touch
+	assert.Nil(t, repo.Touch(ctx, "t1", now))
+	token, _ = repo.Get(ctx, "t1")
+	assert.NotNil(t, token.LastUsedAt)
+
+	// delete
+	assert.Nil(t, repo.Delete(ctx, "t1"))
+	_, err = repo.Get(ctx, "t1")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// delete the data of a user
+	assert.Nil(t, repo.DeleteUserData(ctx, "1"))
+	tokens, _ = repo.Query(ctx, "1")
+	assert.Empty(t, tokens)
+}
+
+type mockTokenRepo struct {
+	items []entity.PersonalAccessToken
+}
+
+func (m *mockTokenRepo) Get(ctx context.Context, id string) (entity.PersonalAccessToken, error) {
+	for _, item := range m.items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return entity.PersonalAccessToken{}, sql.ErrNoRows
+}
+
+func (m *mockTokenRepo) Query(ctx context.Context, userID string) ([]entity.PersonalAccessToken, error) {
+	var tokens []entity.PersonalAccessToken
+	for i := len(m.items) - 1; i >= 0; i-- {
+		if m.items[i].UserID == userID {
+			tokens = append(tokens, m.items[i])
+		}
+	}
+	return tokens, nil
+}
+
+func (m *mockTokenRepo) Create(ctx context.Context, token entity.PersonalAccessToken) error {
+	m.items = append(m.items, token)
+	return nil
+}
+
+func (m *mockTokenRepo) Delete(ctx context.Context, id string) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockTokenRepo) DeleteUserData(ctx context.Context, userID string) error {
+	var tokens []entity.PersonalAccessToken
+	for _, item := range m.items {
+		if item.UserID != userID {
+			tokens = append(tokens, item)
+		}
+	}
+	m.items = tokens
+	return nil
+}
+
+func (m *mockTokenRepo) GetByHash(ctx context.Context, hash string) (auth.AccessToken, error) {
+	for _, item := range m.items {
+		if item.TokenHash == hash {
+			return auth.AccessToken{PersonalAccessToken: item, Username: item.UserID}, nil
+		}
+	}
+	return auth.AccessToken{}, sql.ErrNoRows
+}
+
+func (m *mockTokenRepo) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items[i].LastUsedAt = &usedAt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Service encapsulates usecase logic for personal access tokens.
+type Service interface {
+	Query(ctx context.Context) ([]Token, error)
+	Create(ctx context.Context, input CreateTokenRequest) (NewToken, error)
+	Delete(ctx context.Context, id string) (Token, error)
+}
+
+// Token represents the data about a personal access token.
+type Token struct {
+	entity.PersonalAccessToken
+}
+
+// NewToken represents a personal access token that was just created, along with the token itself,
+// which cannot be retrieved afterwards.
+type NewToken struct {
+	Token
+	Secret string `json:"token"`
+}
+
+// CreateTokenRequest represents a personal access token creation request. The token does not expire
+// if ExpiresAt is nil.
+type CreateTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Validate validates the CreateTokenRequest fields.
+func (m CreateTokenRequest) Validate() error {
+	scopes := make([]interface{}, len(auth.Scopes))
+	for i, scope := range auth.Scopes {
+		scopes[i] = scope
+	}
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 128)),
+		validation.Field(&m.Scopes, validation.Required, validation.Each(validation.In(scopes...))),
+		validation.Field(&m.ExpiresAt, validation.By(inFuture)),
+	)
+}
+
+// inFuture checks that an optional time is after the current time.
+func inFuture(value interface{}) error {
+	if t, _ := value.(*time.Time); t != nil && !t.After(time.Now()) {
+		return validation.NewError("validation_in_future", "must be in the future")
+	}
+	return nil
+}
+
+// secretSize is the number of random bytes in a personal access token.
+const secretSize = 32
+
+type service struct {
+	repo   Repository
+	logger log.Logger
+}
+
+// NewService creates a new personal access token service.
+func NewService(repo Repository, logger log.Logger) Service {
+	return service{repo, logger}
+}
+
+// Query returns the personal access tokens of the current user.
+func (s service) Query(ctx context.Context) ([]Token, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	items, err := s.repo.Query(ctx, identity.GetID())
+	if err != nil {
+		return nil, err
+	}
+	result := []Token{}
+	for _, item := range items {
+		result = append(result, Token{item})
+	}
+	return result, nil
+}
+
+// Create creates a personal access token for the current user. Only the hash of the token is stored.
+func (s service) Create(ctx context.Context, req CreateTokenRequest) (NewToken, error) {
+	if err := req.Validate(); err != nil {
+		return NewToken{}, err
+	}
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return NewToken{}, errors.Unauthorized("")
+	}
+	random := make([]byte, secretSize)
+	if _, err := rand.Read(random); err != nil {
+		return NewToken{}, err
+	}
+	secret := auth.AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+	token := entity.PersonalAccessToken{
+		ID:        entity.GenerateID(),
+		UserID:    identity.GetID(),
+		Name:      req.Name,
+		Scopes:    uniqueScopes(req.Scopes),
+		TokenHash: auth.HashToken(secret),
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.Create(ctx, token); err != nil {
+		return NewToken{}, err
+	}
+	return NewToken{Token{token}, secret}, nil
+}
+
+// Delete revokes a personal access token of the current user.
+func (s service) Delete(ctx context.Context, id string) (Token, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Token{}, errors.Unauthorized("")
+	}
+	token, err := s.repo.Get(ctx, id)
+	if err == sql.ErrNoRows || (err == nil && token.UserID != identity.GetID()) {
+		return Token{}, errors.NotFound("")
+	} else if err != nil {
+		return Token{}, err
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return Token{}, err
+	}
+	return Token{token}, nil
+}
+
+// uniqueScopes returns the scopes without duplicates, in the order they were first given.
+func uniqueScopes(scopes []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	return result
+}
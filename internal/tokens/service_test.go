@@ -0,0 +1,72 @@
+package tokens
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTokenRequest_Validate(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		model     CreateTokenRequest
+		wantError bool
+	}{
+		{"success", CreateTokenRequest{Name: "backup", Scopes: []string{auth.ScopeNotesRead}, ExpiresAt: &future}, false},
+		{"no expiry", CreateTokenRequest{Name: "backup", Scopes: []string{auth.ScopeNotesRead, auth.ScopeShare}}, false},
+		{"name required", CreateTokenRequest{Scopes: []string{auth.ScopeNotesRead}}, true},
+		{"scopes required", CreateTokenRequest{Name: "backup"}, true},
+		{"unknown scope", CreateTokenRequest{Name: "backup", Scopes: []string{"admin"}}, true},
+		{"expired", CreateTokenRequest{Name: "backup", Scopes: []string{auth.ScopeNotesRead}, ExpiresAt: &past}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockTokenRepo{}
+	s := NewService(repo, logger)
+
+	ctx := auth.WithUser(context.Background(), "user1", "user1")
+	other := auth.WithUser(context.Background(), "user2", "user2")
+
+	_, err := s.Create(context.Background(), CreateTokenRequest{Name: "backup", Scopes: []string{auth.ScopeNotesRead}})
+	assert.Equal(t, errors.Unauthorized(""), err)
+
+	// only the hash of the token is stored
+	token, err := s.Create(ctx, CreateTokenRequest{Name: "backup", Scopes: []string{auth.ScopeNotesRead, auth.ScopeNotesRead}})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(token.Secret, auth.AccessTokenPrefix))
+	assert.Equal(t, []string{auth.ScopeNotesRead}, []string(token.Scopes))
+	assert.Equal(t, auth.HashToken(token.Secret), repo.items[0].TokenHash)
+	_, err = s.Create(ctx, CreateTokenRequest{Name: "sync", Scopes: []string{auth.ScopeNotesWrite}})
+	assert.Nil(t, err)
+
+	tokens, _ := s.Query(ctx)
+	if assert.Equal(t, 2, len(tokens)) {
+		assert.Equal(t, "sync", tokens[0].Name)
+	}
+	tokens, _ = s.Query(other)
+	assert.Equal(t, 0, len(tokens))
+
+	// only the owner revokes a token
+	_, err = s.Delete(other, token.ID)
+	assert.Equal(t, errors.NotFound(""), err)
+	deleted, err := s.Delete(ctx, token.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "backup", deleted.Name)
+	_, err = s.Delete(ctx, token.ID)
+	assert.Equal(t, errors.NotFound(""), err)
+}
@@ -0,0 +1,186 @@
+package workspaces
+
+import (
+	"net/http"
+
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers managing workspaces, their members and invitations.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, rateLimiter routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler) // the following endpoints require a valid JWT
+	r.Use(rateLimiter)
+	r.Get("/workspaces", res.query)
+	r.Post("/workspaces", res.create)
+	r.Get("/workspaces/<id>", res.get)
+	r.Put("/workspaces/<id>", res.update)
+	r.Delete("/workspaces/<id>", res.delete)
+	r.Get("/workspaces/<id>/members", res.queryMembers)
+	r.Patch("/workspaces/<id>/members/<user_id>", res.updateMember)
+	r.Delete("/workspaces/<id>/members/<user_id>", res.removeMember)
+	r.Get("/workspaces/<id>/invitations", res.queryInvitations)
+	r.Post("/workspaces/<id>/invitations", res.invite)
+	r.Delete("/workspaces/<id>/invitations/<invitation_id>", res.cancelInvitation)
+	r.Get("/invitations", res.queryUserInvitations)
+	r.Post("/invitations/<id>/accept", res.acceptInvitation)
+	r.Post("/invitations/<id>/decline", res.declineInvitation)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	workspace, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(workspace)
+}
+
+func (r resource) query(c *routing.Context) error {
+	workspaces, err := r.service.Query(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	return c.Write(workspaces)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input WorkspaceRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	workspace, err := r.service.Create(c.Request.Context(), input)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteWithStatus(workspace, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input WorkspaceRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	workspace, err := r.service.Update(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(workspace)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	workspace, err := r.service.Delete(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(workspace)
+}
+
+func (r resource) queryMembers(c *routing.Context) error {
+	members, err := r.service.QueryMembers(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(members)
+}
+
+func (r resource) updateMember(c *routing.Context) error {
+	var input UpdateMemberRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	member, err := r.service.UpdateMember(c.Request.Context(), c.Param("id"), c.Param("user_id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(member)
+}
+
+func (r resource) removeMember(c *routing.Context) error {
+	member, err := r.service.RemoveMember(c.Request.Context(), c.Param("id"), c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(member)
+}
+
+func (r resource) queryInvitations(c *routing.Context) error {
+	invitations, err := r.service.QueryInvitations(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(invitations)
+}
+
+func (r resource) invite(c *routing.Context) error {
+	var input InviteRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+
+	invitation, err := r.service.Invite(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteWithStatus(invitation, http.StatusCreated)
+}
+
+func (r resource) cancelInvitation(c *routing.Context) error {
+	invitation, err := r.service.CancelInvitation(c.Request.Context(), c.Param("id"), c.Param("invitation_id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(invitation)
+}
+
+func (r resource) queryUserInvitations(c *routing.Context) error {
+	invitations, err := r.service.QueryUserInvitations(c.Request.Context())
+	if err != nil {
+		return err
+	}
+
+	return c.Write(invitations)
+}
+
+func (r resource) acceptInvitation(c *routing.Context) error {
+	workspace, err := r.service.AcceptInvitation(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(workspace)
+}
+
+func (r resource) declineInvitation(c *routing.Context) error {
+	invitation, err := r.service.DeclineInvitation(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.Write(invitation)
+}
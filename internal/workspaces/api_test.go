@@ -0,0 +1,63 @@
+package workspaces
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+
+	now := time.Now()
+	repo := &mockWorkspaceRepo{
+		items: []entity.Workspace{{ID: "123", Name: "team", CreatedAt: now, UpdatedAt: now}},
+		members: []entity.WorkspaceMember{
+			{ID: "m1", WorkspaceID: "123", UserID: "testuser", Role: entity.RoleOwner, CreatedAt: now},
+			{ID: "m2", WorkspaceID: "123", UserID: "guestuser", Role: entity.RoleGuest, CreatedAt: now},
+		},
+		invitations: []entity.WorkspaceInvitation{
+			{ID: "i1", WorkspaceID: "123", UserID: "newuser", Role: entity.RoleMember, InvitedBy: "testuser", CreatedAt: now},
+		},
+	}
+
+	RegisterHandlers(router.Group(""), NewService(repo, mockUsers{"other": "otheruser"}, logger), auth.MockAuthHandler, auth.MockAuthHandler, logger)
+	header := auth.MockAuthHeader()
+	guest := auth.MockAuthHeaderFor("guestuser")
+	other := auth.MockAuthHeaderFor("otheruser")
+	invited := auth.MockAuthHeaderFor("newuser")
+
+	tests := []test.APITestCase{
+		{"get 123", "GET", "/workspaces/123", "", header, http.StatusOK, `*"role":"owner"}*`},
+		{"get all", "GET", "/workspaces", "", guest, http.StatusOK, `*"role":"guest"*`},
+		{"get other", "GET", "/workspaces/123", "", other, http.StatusNotFound, ""},
+		{"create ok", "POST", "/workspaces", `{"name":"design"}`, other, http.StatusCreated, `*"role":"owner"*`},
+		{"create auth error", "POST", "/workspaces", `{"name":"design"}`, nil, http.StatusUnauthorized, ""},
+		{"create input error", "POST", "/workspaces", `{"name":""}`, header, http.StatusBadRequest, ""},
+		{"update guest", "PUT", "/workspaces/123", `{"name":"mine"}`, guest, http.StatusForbidden, ""},
+		{"update ok", "PUT", "/workspaces/123", `{"name":"renamed"}`, header, http.StatusOK, `*"name":"renamed"*`},
+		{"get members", "GET", "/workspaces/123/members", "", guest, http.StatusOK, `*"username":"guestuser"*`},
+		{"update member", "PATCH", "/workspaces/123/members/guestuser", `{"role":"member"}`, header, http.StatusOK, `*"role":"member"*`},
+		{"update member input error", "PATCH", "/workspaces/123/members/guestuser", `{"role":"owner"}`, header, http.StatusBadRequest, ""},
+		{"get invitations", "GET", "/workspaces/123/invitations", "", header, http.StatusOK, `*"user_id":"newuser"*`},
+		{"invite ok", "POST", "/workspaces/123/invitations", `{"username":"other","role":"guest"}`, header, http.StatusCreated, `*"role":"guest"*`},
+		{"invite again", "POST", "/workspaces/123/invitations", `{"username":"other"}`, header, http.StatusConflict, ""},
+		{"invite unknown", "POST", "/workspaces/123/invitations", `{"username":"nobody"}`, header, http.StatusNotFound, ""},
+		{"get user invitations", "GET", "/invitations", "", invited, http.StatusOK, `*"id":"i1"*`},
+		{"accept other", "POST", "/invitations/i1/accept", "", other, http.StatusNotFound, ""},
+		{"accept ok", "POST", "/invitations/i1/accept", "", invited, http.StatusOK, `*"role":"member"*`},
+		{"remove member", "DELETE", "/workspaces/123/members/newuser", "", header, http.StatusOK, `*"user_id":"newuser"*`},
+		{"leave", "DELETE", "/workspaces/123/members/guestuser", "", guest, http.StatusOK, ""},
+		{"delete guest", "DELETE", "/workspaces/123", "", guest, http.StatusNotFound, ""},
+		{"delete ok", "DELETE", "/workspaces/123", "", header, http.StatusOK, `*"name":"renamed"*`},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,239 @@
+package workspaces
+
+import (
+	"context"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/pkg/dbcontext"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Repository encapsulates the logic to access workspaces, their members and invitations from the data source.
+type Repository interface {
+	// Get returns the workspace with the specified ID.
+	Get(ctx context.Context, id string) (entity.Workspace, error)
+	// Query returns the workspaces the given user is a member of, along with the role of the user, ordered by name.
+	Query(ctx context.Context, userID string) ([]Workspace, error)
+	// Create saves a new workspace in the storage together with its owner.
+	Create(ctx context.Context, workspace entity.Workspace, owner entity.WorkspaceMember) error
+	// Update updates the workspace with given ID in the storage.
+	Update(ctx context.Context, workspace entity.Workspace) error
+	// Delete removes the workspace with given ID from the storage together with its members and invitations.
+	Delete(ctx context.Context, id string) error
+	// CountNotes returns the number of notes of the workspace that are not in the trash.
+	CountNotes(ctx context.Context, id string) (int, error)
+
+	// GetMember returns the membership of the given user in the given workspace.
+	GetMember(ctx context.Context, workspaceID, userID string) (entity.WorkspaceMember, error)
+	// QueryMembers returns the members of the given workspace along with their names, ordered by name.
+	QueryMembers(ctx context.Context, workspaceID string) ([]Member, error)
+	// UpdateMember saves the changed role of a member.
+	UpdateMember(ctx context.Context, member entity.WorkspaceMember) error
+	// DeleteMember removes the given user from the given workspace.
+	DeleteMember(ctx context.Context, workspaceID, userID string) error
+
+	// GetInvitation returns the invitation with the specified ID.
+	GetInvitation(ctx context.Context, id string) (entity.WorkspaceInvitation, error)
+	// FindInvitation returns the invitation of the given user to the given workspace.
+	FindInvitation(ctx context.Context, workspaceID, userID string) (entity.WorkspaceInvitation, error)
+	// QueryInvitations returns the pending invitations to the given workspace, the newest first.
+	QueryInvitations(ctx context.Context, workspaceID string) ([]entity.WorkspaceInvitation, error)
+	// QueryUserInvitations returns the pending invitations of the given user, the newest first.
+	QueryUserInvitations(ctx context.Context, userID string) ([]entity.WorkspaceInvitation, error)
+	// CreateInvitation saves a new invitation in the storage.
+	CreateInvitation(ctx context.Context, invitation entity.WorkspaceInvitation) error
+	// DeleteInvitation removes the invitation with given ID from the storage.
+	DeleteInvitation(ctx context.Context, id string) error
+	// AcceptInvitation replaces the invitation with the given membership.
+	AcceptInvitation(ctx context.Context, invitationID string, member entity.WorkspaceMember) error
+	// DeleteUserData removes the memberships and invitations of the given user, and attributes the invitations
+	// they sent to entity.DeletedUserID. It returns auth.ErrWorkspaceOwner if the user owns a workspace.
+	// It runs within the transaction of the context deleting the account of the user.
+	DeleteUserData(ctx context.Context, userID string) error
+}
+
+// repository persists workspaces in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new workspace repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get reads the workspace with the specified ID from the database.
+func (r repository) Get(ctx context.Context, id string) (entity.Workspace, error) {
+	var workspace entity.Workspace
+	err := r.db.With(ctx).Select().Model(id, &workspace)
+	return workspace, err
+}
+
+// Query retrieves the workspaces of the given user from the database.
+func (r repository) Query(ctx context.Context, userID string) ([]Workspace, error) {
+	var workspaces []Workspace
+	err := r.db.With(ctx).
+		Select("workspaces.*", "workspace_members.role").
+		From("workspaces").
+		InnerJoin("workspace_members", dbx.NewExp("workspace_members.workspace_id = workspaces.id")).
+		Where(dbx.HashExp{"workspace_members.user_id": userID}).
+		OrderBy("workspaces.name", "workspaces.id").
+		All(&workspaces)
+	return workspaces, err
+}
+
+// Create saves a new workspace record and the membership of its owner in the database within a transaction.
+func (r repository) Create(ctx context.Context, workspace entity.Workspace, owner entity.WorkspaceMember) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := r.db.With(ctx).Model(&workspace).Insert(); err != nil {
+			return err
+		}
+		return r.db.With(ctx).Model(&owner).Insert()
+	})
+}
+
+// Update saves the changes to a workspace in the database.
+func (r repository) Update(ctx context.Context, workspace entity.Workspace) error {
+	return r.db.With(ctx).Model(&workspace).Update()
+}
+
+// Delete deletes the workspace with the specified ID, its members and its invitations from the database
+// within a transaction.
+func (r repository) Delete(ctx context.Context, id string) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		for _, table := range []string{"workspace_members", "workspace_invitations"} {
+			if _, err := r.db.With(ctx).Delete(table, dbx.HashExp{"workspace_id": id}).Execute(); err != nil {
+				return err
+			}
+		}
+		_, err := r.db.With(ctx).Delete("workspaces", dbx.HashExp{"id": id}).Execute()
+		return err
+	})
+}
+
+// CountNotes counts the notes of the workspace in the database that are not in the trash.
+func (r repository) CountNotes(ctx context.Context, id string) (int, error) {
+	var count int
+	err := r.db.With(ctx).
+		Select("COUNT(*)").
+		From("notes").
+		Where(dbx.And(dbx.HashExp{"workspace_id": id}, dbx.NewExp("deleted_at IS NULL"))).
+		Row(&count)
+	return count, err
+}
+
+// GetMember reads the membership of the given user in the given workspace from the database.
+func (r repository) GetMember(ctx context.Context, workspaceID, userID string) (entity.WorkspaceMember, error) {
+	var member entity.WorkspaceMember
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"workspace_id": workspaceID, "user_id": userID}).One(&member)
+	return member, err
+}
+
+// QueryMembers retrieves the members of the given workspace and their names from the database.
+func (r repository) QueryMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	var members []Member
+	err := r.db.With(ctx).
+		Select("workspace_members.*", "users.name AS username").
+		From("workspace_members").
+		InnerJoin("users", dbx.NewExp("users.id = workspace_members.user_id")).
+		Where(dbx.HashExp{"workspace_members.workspace_id": workspaceID}).
+		OrderBy("users.name", "workspace_members.user_id").
+		All(&members)
+	return members, err
+}
+
+// UpdateMember saves the changes to a membership in the database.
+func (r repository) UpdateMember(ctx context.Context, member entity.WorkspaceMember) error {
+	return r.db.With(ctx).Model(&member).Update()
+}
+
+// DeleteMember deletes the membership of the given user in the given workspace from the database.
+func (r repository) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	_, err := r.db.With(ctx).Delete("workspace_members", dbx.HashExp{"workspace_id": workspaceID, "user_id": userID}).Execute()
+	return err
+}
+
+// GetInvitation reads the invitation with the specified ID from the database.
+func (r repository) GetInvitation(ctx context.Context, id string) (entity.WorkspaceInvitation, error) {
+	var invitation entity.WorkspaceInvitation
+	err := r.db.With(ctx).Select().Model(id, &invitation)
+	return invitation, err
+}
+
+// FindInvitation reads the invitation of the given user to the given workspace from the database.
+func (r repository) FindInvitation(ctx context.Context, workspaceID, userID string) (entity.WorkspaceInvitation, error) {
+	var invitation entity.WorkspaceInvitation
+	err := r.db.With(ctx).Select().Where(dbx.HashExp{"workspace_id": workspaceID, "user_id": userID}).One(&invitation)
+	return invitation, err
+}
+
+// QueryInvitations retrieves the invitations to the given workspace from the database.
+func (r repository) QueryInvitations(ctx context.Context, workspaceID string) ([]entity.WorkspaceInvitation, error) {
+	return r.queryInvitations(ctx, dbx.HashExp{"workspace_id": workspaceID})
+}
+
+// QueryUserInvitations retrieves the invitations of the given user from the database.
+func (r repository) QueryUserInvitations(ctx context.Context, userID string) ([]entity.WorkspaceInvitation, error) {
+	return r.queryInvitations(ctx, dbx.HashExp{"user_id": userID})
+}
+
+func (r repository) queryInvitations(ctx context.Context, condition dbx.Expression) ([]entity.WorkspaceInvitation, error) {
+	var invitations []entity.WorkspaceInvitation
+	err := r.db.With(ctx).
+		Select().
+		Where(condition).
+		OrderBy("created_at DESC", "id").
+		All(&invitations)
+	return invitations, err
+}
+
+// CreateInvitation saves a new invitation record in the database.
+func (r repository) CreateInvitation(ctx context.Context, invitation entity.WorkspaceInvitation) error {
+	return r.db.With(ctx).Model(&invitation).Insert()
+}
+
+// DeleteInvitation deletes the invitation with the specified ID from the database.
+func (r repository) DeleteInvitation(ctx context.Context, id string) error {
+	_, err := r.db.With(ctx).Delete("workspace_invitations", dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// AcceptInvitation deletes the invitation and saves the membership in the database within a transaction.
+func (r repository) AcceptInvitation(ctx context.Context, invitationID string, member entity.WorkspaceMember) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := r.DeleteInvitation(ctx, invitationID); err != nil {
+			return err
+		}
+		return r.db.With(ctx).Model(&member).Insert()
+	})
+}
+
+// DeleteUserData removes the memberships and invitations of the user from the database, unless they own a workspace.
+func (r repository) DeleteUserData(ctx context.Context, userID string) error {
+	var owned int
+	err := r.db.With(ctx).
+		Select("COUNT(*)").
+		From("workspace_members").
+		Where(dbx.HashExp{"user_id": userID, "role": entity.RoleOwner}).
+		Row(&owned)
+	if err != nil {
+		return err
+	}
+	if owned > 0 {
+		return auth.ErrWorkspaceOwner
+	}
+	_, err = r.db.With(ctx).Update("workspace_invitations", dbx.Params{"invited_by": entity.DeletedUserID},
+		dbx.HashExp{"invited_by": userID}).Execute()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"workspace_members", "workspace_invitations"} {
+		if _, err := r.db.With(ctx).Delete(table, dbx.HashExp{"user_id": userID}).Execute(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package workspaces
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/test"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	logger, _ := log.NewForTest()
+	db := test.DB(t)
+	test.ResetTables(t, db, "workspace_invitations", "workspace_members", "workspaces", "notes")
+	repo := NewRepository(db, logger)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// create
+	err := repo.Create(ctx,
+		entity.Workspace{ID: "ws1", Name: "team", CreatedAt: now, UpdatedAt: now},
+		entity.WorkspaceMember{ID: "m1", WorkspaceID: "ws1", UserID: "user1", Role: entity.RoleOwner, CreatedAt: now},
+	)
+	assert.Nil(t, err)
+	workspace, err := repo.Get(ctx, "ws1")
+	assert.Nil(t, err)
+	assert.Equal(t, "team", workspace.Name)
+
+	// update
+	workspace.Name = "renamed"
+	assert.Nil(t, repo.Update(ctx, workspace))
+	workspaces, err := repo.Query(ctx, "user1")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(workspaces)) {
+		assert.Equal(t, "renamed", workspaces[0].Name)
+		assert.Equal(t, entity.RoleOwner, workspaces[0].Role)
+	}
+
+	// invitations
+	invitation := entity.WorkspaceInvitation{ID: "i1", WorkspaceID: "ws1", UserID: "user2", Role: entity.RoleGuest, InvitedBy: "user1", CreatedAt: now}
+	assert.Nil(t, repo.CreateInvitation(ctx, invitation))
+	_, err = repo.FindInvitation(ctx, "ws1", "user2")
+	assert.Nil(t, err)
+	invitations, err := repo.QueryUserInvitations(ctx, "user2")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(invitations))
+	assert.Nil(t, repo.AcceptInvitation(ctx, "i1", entity.WorkspaceMember{ID: "m2", WorkspaceID: "ws1", UserID: "user2", Role: entity.RoleGuest, CreatedAt: now}))
+	_, err = repo.GetInvitation(ctx, "i1")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// members
+	member, err := repo.GetMember(ctx, "ws1", "user2")
+	assert.Nil(t, err)
+	member.Role = entity.RoleMember
+	assert.Nil(t, repo.UpdateMember(ctx, member))
+	member, _ = repo.GetMember(ctx, "ws1", "user2")
+	assert.Equal(t, entity.RoleMember, member.Role)
+	assert.Nil(t, repo.DeleteMember(ctx, "ws1", "user2"))
+	_, err = repo.GetMember(ctx, "ws1", "user2")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// notes
+	workspaceID := "ws1"
+	err = db.With(ctx).Model(&entity.Note{ID: "note1", Title: "title1", Text: "text1", UserID: "user1", WorkspaceID: &workspaceID, CreatedAt: now, UpdatedAt: now, Version: 1}).Insert()
+	assert.Nil(t, err)
+	count, err := repo.CountNotes(ctx, "ws1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// delete
+	assert.Nil(t, repo.Delete(ctx, "ws1"))
+	_, err = repo.Get(ctx, "ws1")
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.GetMember(ctx, "ws1", "user1")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+type mockWorkspaceRepo struct {
+	items       []entity.Workspace
+	members     []entity.WorkspaceMember
+	invitations []entity.WorkspaceInvitation
+	notes       []entity.Note
+}
+
+func (m *mockWorkspaceRepo) Get(ctx context.Context, id string) (entity.Workspace, error) {
+	for _, item := range m.items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return entity.Workspace{}, sql.ErrNoRows
+}
+
+func (m *mockWorkspaceRepo) Query(ctx context.Context, userID string) ([]Workspace, error) {
+	var items []Workspace
+	for _, member := range m.members {
+		if member.UserID == userID {
+			workspace, _ := m.Get(ctx, member.WorkspaceID)
+			items = append(items, Workspace{workspace, member.Role})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+func (m *mockWorkspaceRepo) Create(ctx context.Context, workspace entity.Workspace, owner entity.WorkspaceMember) error {
+	m.items = append(m.items, workspace)
+	m.members = append(m.members, owner)
+	return nil
+}
+
+func (m *mockWorkspaceRepo) Update(ctx context.Context, workspace entity.Workspace) error {
+	for i, item := range m.items {
+		if item.ID == workspace.ID {
+			m.items[i] = workspace
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockWorkspaceRepo) Delete(ctx context.Context, id string) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			break
+		}
+	}
+	var members []entity.WorkspaceMember
+	for _, member := range m.members {
+		if member.WorkspaceID != id {
+			members = append(members, member)
+		}
+	}
+	m.members = members
+	var invitations []entity.WorkspaceInvitation
+	for _, invitation := range m.invitations {
+		if invitation.WorkspaceID != id {
+			invitations = append(invitations, invitation)
+		}
+	}
+	m.invitations = invitations
+	return nil
+}
+
+func (m *mockWorkspaceRepo) DeleteUserData(ctx context.Context, userID string) error {
+	var members []entity.WorkspaceMember
+	for _, member := range m.members {
+		if member.UserID == userID && member.Role == entity.RoleOwner {
+			return auth.ErrWorkspaceOwner
+		}
+		if member.UserID != userID {
+			members = append(members, member)
+		}
+	}
+	m.members = members
+	var invitations []entity.WorkspaceInvitation
+	for _, invitation := range m.invitations {
+		if invitation.UserID != userID {
+			invitations = append(invitations, invitation)
+		}
+	}
+	m.invitations = invitations
+	return nil
+}
+
+func (m *mockWorkspaceRepo) CountNotes(ctx context.Context, id string) (int, error) {
+	count := 0
+	for _, note := range m.notes {
+		if note.WorkspaceID != nil && *note.WorkspaceID == id && note.DeletedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockWorkspaceRepo) GetMember(ctx context.Context, workspaceID, userID string) (entity.WorkspaceMember, error) {
+	for _, member := range m.members {
+		if member.WorkspaceID == workspaceID && member.UserID == userID {
+			return member, nil
+		}
+	}
+	return entity.WorkspaceMember{}, sql.ErrNoRows
+}
+
+func (m *mockWorkspaceRepo) QueryMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	var members []Member
+	for _, member := range m.members {
+		if member.WorkspaceID == workspaceID {
+			members = append(members, Member{member, member.UserID})
+		}
+	}
+	return members, nil
+}
+
+func (m *mockWorkspaceRepo) UpdateMember(ctx context.Context, member entity.WorkspaceMember) error {
+	for i, item := range m.members {
+		if item.ID == member.ID {
+			m.members[i] = member
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockWorkspaceRepo) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	for i, member := range m.members {
+		if member.WorkspaceID == workspaceID && member.UserID == userID {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockWorkspaceRepo) GetInvitation(ctx context.Context, id string) (entity.WorkspaceInvitation, error) {
+	for _, invitation := range m.invitations {
+		if invitation.ID == id {
+			return invitation, nil
+		}
+	}
+	return entity.WorkspaceInvitation{}, sql.ErrNoRows
+}
+
+func (m *mockWorkspaceRepo) FindInvitation(ctx context.Context, workspaceID, userID string) (entity.WorkspaceInvitation, error) {
+	for _, invitation := range m.invitations {
+		if invitation.WorkspaceID == workspaceID && invitation.UserID == userID {
+			return invitation, nil
+		}
+	}
+	return entity.WorkspaceInvitation{}, sql.ErrNoRows
+}
+
+func (m *mockWorkspaceRepo) QueryInvitations(ctx context.Context, workspaceID string) ([]entity.WorkspaceInvitation, error) {
+	var invitations []entity.WorkspaceInvitation
+	for _, invitation := range m.invitations {
+		if invitation.WorkspaceID == workspaceID {
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations, nil
+}
+
+func (m *mockWorkspaceRepo) QueryUserInvitations(ctx context.Context, userID string) ([]entity.WorkspaceInvitation, error) {
+	var invitations []entity.WorkspaceInvitation
+	for _, invitation := range m.invitations {
+		if invitation.UserID == userID {
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations, nil
+}
+
+func (m *mockWorkspaceRepo) CreateInvitation(ctx context.Context, invitation entity.WorkspaceInvitation) error {
+	m.invitations = append(m.invitations, invitation)
+	return nil
+}
+
+func (m *mockWorkspaceRepo) DeleteInvitation(ctx context.Context, id string) error {
+	for i, invitation := range m.invitations {
+		if invitation.ID == id {
+			m.invitations = append(m.invitations[:i], m.invitations[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockWorkspaceRepo) AcceptInvitation(ctx context.Context, invitationID string, member entity.WorkspaceMember) error {
+	_ = m.DeleteInvitation(ctx, invitationID)
+	m.members = append(m.members, member)
+	return nil
+}
+
+// mockUsers finds the users whose names are the keys of the map, with the values as their IDs.
+type mockUsers map[string]string
+
+func (m mockUsers) GetByName(ctx context.Context, name string) (entity.User, error) {
+	if id, ok := m[name]; ok {
+		return entity.User{ID: id, Name: name}, nil
+	}
+	return entity.User{}, sql.ErrNoRows
+}
@@ -0,0 +1,461 @@
+package workspaces
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+)
+
+// Service encapsulates usecase logic for workspaces, their members and invitations.
+type Service interface {
+	Get(ctx context.Context, id string) (Workspace, error)
+	Query(ctx context.Context) ([]Workspace, error)
+	Create(ctx context.Context, input WorkspaceRequest) (Workspace, error)
+	Update(ctx context.Context, id string, input WorkspaceRequest) (Workspace, error)
+	Delete(ctx context.Context, id string) (Workspace, error)
+	QueryMembers(ctx context.Context, id string) ([]Member, error)
+	UpdateMember(ctx context.Context, id, userID string, input UpdateMemberRequest) (entity.WorkspaceMember, error)
+	RemoveMember(ctx context.Context, id, userID string) (entity.WorkspaceMember, error)
+	QueryInvitations(ctx context.Context, id string) ([]entity.WorkspaceInvitation, error)
+	Invite(ctx context.Context, id string, input InviteRequest) (entity.WorkspaceInvitation, error)
+	CancelInvitation(ctx context.Context, id, invitationID string) (entity.WorkspaceInvitation, error)
+	QueryUserInvitations(ctx context.Context) ([]entity.WorkspaceInvitation, error)
+	AcceptInvitation(ctx context.Context, invitationID string) (Workspace, error)
+	DeclineInvitation(ctx context.Context, invitationID string) (entity.WorkspaceInvitation, error)
+}
+
+// Workspace represents the data about a workspace, along with the role the current user holds in it.
+type Workspace struct {
+	entity.Workspace
+	Role string `json:"role"`
+}
+
+// Member represents a member of a workspace along with the name of the user.
+type Member struct {
+	entity.WorkspaceMember
+	Username string `json:"username"`
+}
+
+// WorkspaceRequest represents a request to create or rename a workspace.
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+// Validate validates the WorkspaceRequest fields.
+func (m WorkspaceRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 128)),
+	)
+}
+
+// UpdateMemberRequest represents a request to change the role of a member of a workspace.
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// Validate validates the UpdateMemberRequest fields.
+func (m UpdateMemberRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Role, validation.Required, validation.In(entity.RoleAdmin, entity.RoleMember, entity.RoleGuest)),
+	)
+}
+
+// InviteRequest represents a request to invite a user to a workspace. The role defaults to RoleMember.
+type InviteRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// Validate validates the InviteRequest fields.
+func (m InviteRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Username, validation.Required, validation.Length(0, 128)),
+		validation.Field(&m.Role, validation.In(entity.RoleAdmin, entity.RoleMember, entity.RoleGuest)),
+	)
+}
+
+// UserFinder looks up users by their names. It is implemented by auth.UserRepo.
+type UserFinder interface {
+	GetByName(ctx context.Context, name string) (entity.User, error)
+}
+
+// roleRanks orders the roles of the members of a workspace by their privileges.
+var roleRanks = map[string]int{
+	entity.RoleGuest:  1,
+	entity.RoleMember: 2,
+	entity.RoleAdmin:  3,
+	entity.RoleOwner:  4,
+}
+
+type service struct {
+	repo   Repository
+	users  UserFinder
+	logger log.Logger
+}
+
+// NewService creates a new workspace service.
+func NewService(repo Repository, users UserFinder, logger log.Logger) Service {
+	return service{repo, users, logger}
+}
+
+// Get returns the workspace with the specified ID if the current user is a member of it.
+func (s service) Get(ctx context.Context, id string) (Workspace, error) {
+	member, err := s.membership(ctx, id, entity.RoleGuest)
+	if err != nil {
+		return Workspace{}, err
+	}
+	workspace, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	return Workspace{workspace, member.Role}, nil
+}
+
+// Query returns the workspaces the current user is a member of.
+func (s service) Query(ctx context.Context) ([]Workspace, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	items, err := s.repo.Query(ctx, identity.GetID())
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []Workspace{}
+	}
+	return items, nil
+}
+
+// Create creates a new workspace owned by the current user.
+func (s service) Create(ctx context.Context, req WorkspaceRequest) (Workspace, error) {
+	if err := req.Validate(); err != nil {
+		return Workspace{}, err
+	}
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return Workspace{}, errors.Unauthorized("")
+	}
+	now := time.Now()
+	workspace := entity.Workspace{
+		ID:        entity.GenerateID(),
+		Name:      req.Name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	owner := entity.WorkspaceMember{
+		ID:          entity.GenerateID(),
+		WorkspaceID: workspace.ID,
+		UserID:      identity.GetID(),
+		Role:        entity.RoleOwner,
+		CreatedAt:   now,
+	}
+	if err := s.repo.Create(ctx, workspace, owner); err != nil {
+		return Workspace{}, err
+	}
+	return Workspace{workspace, owner.Role}, nil
+}
+
+// Update renames the workspace with the specified ID. It requires an admin or the owner of the workspace.
+func (s service) Update(ctx context.Context, id string, req WorkspaceRequest) (Workspace, error) {
+	if err := req.Validate(); err != nil {
+		return Workspace{}, err
+	}
+	member, err := s.membership(ctx, id, entity.RoleAdmin)
+	if err != nil {
+		return Workspace{}, err
+	}
+	workspace, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	workspace.Name = req.Name
+	workspace.UpdatedAt = time.Now()
+	if err := s.repo.Update(ctx, workspace); err != nil {
+		return Workspace{}, err
+	}
+	return Workspace{workspace, member.Role}, nil
+}
+
+// Delete deletes the workspace with the specified ID. Only the owner may delete a workspace, and only once
+// it no longer holds any notes outside of the trash.
+func (s service) Delete(ctx context.Context, id string) (Workspace, error) {
+	workspace, err := s.Get(ctx, id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if workspace.Role != entity.RoleOwner {
+		return Workspace{}, errors.Forbidden("")
+	}
+	count, err := s.repo.CountNotes(ctx, id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if count > 0 {
+		return Workspace{}, errors.Conflict("the workspace still has notes")
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return Workspace{}, err
+	}
+	return workspace, nil
+}
+
+// QueryMembers returns the members of the workspace with the specified ID.
+func (s service) QueryMembers(ctx context.Context, id string) ([]Member, error) {
+	if _, err := s.membership(ctx, id, entity.RoleGuest); err != nil {
+		return nil, err
+	}
+	members, err := s.repo.QueryMembers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if members == nil {
+		members = []Member{}
+	}
+	return members, nil
+}
+
+// UpdateMember changes the role of a member of the workspace. Admins may change the roles of members and guests,
+// while only the owner may appoint or demote admins. The role of the owner cannot be changed.
+func (s service) UpdateMember(ctx context.Context, id, userID string, req UpdateMemberRequest) (entity.WorkspaceMember, error) {
+	if err := req.Validate(); err != nil {
+		return entity.WorkspaceMember{}, err
+	}
+	current, err := s.membership(ctx, id, entity.RoleAdmin)
+	if err != nil {
+		return entity.WorkspaceMember{}, err
+	}
+	if userID == current.UserID {
+		return entity.WorkspaceMember{}, errors.BadRequest("you cannot change your own role")
+	}
+	member, err := s.getMember(ctx, id, userID)
+	if err != nil {
+		return entity.WorkspaceMember{}, err
+	}
+	if err := s.checkManage(current, member.Role, req.Role); err != nil {
+		return entity.WorkspaceMember{}, err
+	}
+	member.Role = req.Role
+	if err := s.repo.UpdateMember(ctx, member); err != nil {
+		return entity.WorkspaceMember{}, err
+	}
+	return member, nil
+}
+
+// RemoveMember removes a user from the workspace. Members may leave a workspace on their own, except for its owner.
+// Removing other members follows the same rules as changing their roles.
+func (s service) RemoveMember(ctx context.Context, id, userID string) (entity.WorkspaceMember, error) {
+	current, err := s.membership(ctx, id, entity.RoleGuest)
+	if err != nil {
+		return entity.WorkspaceMember{}, err
+	}
+	member := current
+	if userID == current.UserID {
+		if current.Role == entity.RoleOwner {
+			return entity.WorkspaceMember{}, errors.BadRequest("the owner cannot leave the workspace")
+		}
+	} else {
+		if roleRanks[current.Role] < roleRanks[entity.RoleAdmin] {
+			return entity.WorkspaceMember{}, errors.Forbidden("")
+		}
+		if member, err = s.getMember(ctx, id, userID); err != nil {
+			return entity.WorkspaceMember{}, err
+		}
+		if err := s.checkManage(current, member.Role, ""); err != nil {
+			return entity.WorkspaceMember{}, err
+		}
+	}
+	if err := s.repo.DeleteMember(ctx, id, userID); err != nil {
+		return entity.WorkspaceMember{}, err
+	}
+	return member, nil
+}
+
+// QueryInvitations returns the pending invitations to the workspace. It requires an admin or the owner.
+func (s service) QueryInvitations(ctx context.Context, id string) ([]entity.WorkspaceInvitation, error) {
+	if _, err := s.membership(ctx, id, entity.RoleAdmin); err != nil {
+		return nil, err
+	}
+	invitations, err := s.repo.QueryInvitations(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if invitations == nil {
+		invitations = []entity.WorkspaceInvitation{}
+	}
+	return invitations, nil
+}
+
+// Invite invites the user with the given name to the workspace. It requires an admin or the owner,
+// and only the owner may invite admins.
+func (s service) Invite(ctx context.Context, id string, req InviteRequest) (entity.WorkspaceInvitation, error) {
+	if err := req.Validate(); err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	if req.Role == "" {
+		req.Role = entity.RoleMember
+	}
+	current, err := s.membership(ctx, id, entity.RoleAdmin)
+	if err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	if err := s.checkManage(current, "", req.Role); err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	user, err := s.users.GetByName(ctx, req.Username)
+	if err == sql.ErrNoRows {
+		return entity.WorkspaceInvitation{}, errors.NotFound("the user does not exist")
+	} else if err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	if _, err := s.repo.GetMember(ctx, id, user.ID); err == nil {
+		return entity.WorkspaceInvitation{}, errors.Conflict("the user is already a member of the workspace")
+	} else if err != sql.ErrNoRows {
+		return entity.WorkspaceInvitation{}, err
+	}
+	if _, err := s.repo.FindInvitation(ctx, id, user.ID); err == nil {
+		return entity.WorkspaceInvitation{}, errors.Conflict("the user is already invited to the workspace")
+	} else if err != sql.ErrNoRows {
+		return entity.WorkspaceInvitation{}, err
+	}
+	invitation := entity.WorkspaceInvitation{
+		ID:          entity.GenerateID(),
+		WorkspaceID: id,
+		UserID:      user.ID,
+		Role:        req.Role,
+		InvitedBy:   current.UserID,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.repo.CreateInvitation(ctx, invitation); err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	return invitation, nil
+}
+
+// CancelInvitation withdraws a pending invitation to the workspace. It requires an admin or the owner.
+func (s service) CancelInvitation(ctx context.Context, id, invitationID string) (entity.WorkspaceInvitation, error) {
+	if _, err := s.membership(ctx, id, entity.RoleAdmin); err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	invitation, err := s.repo.GetInvitation(ctx, invitationID)
+	if err == sql.ErrNoRows || (err == nil && invitation.WorkspaceID != id) {
+		return entity.WorkspaceInvitation{}, errors.NotFound("")
+	} else if err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	if err := s.repo.DeleteInvitation(ctx, invitationID); err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	return invitation, nil
+}
+
+// QueryUserInvitations returns the pending invitations of the current user.
+func (s service) QueryUserInvitations(ctx context.Context) ([]entity.WorkspaceInvitation, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return nil, errors.Unauthorized("")
+	}
+	invitations, err := s.repo.QueryUserInvitations(ctx, identity.GetID())
+	if err != nil {
+		return nil, err
+	}
+	if invitations == nil {
+		invitations = []entity.WorkspaceInvitation{}
+	}
+	return invitations, nil
+}
+
+// AcceptInvitation makes the current user a member of the workspace they were invited to, with the invited role.
+func (s service) AcceptInvitation(ctx context.Context, invitationID string) (Workspace, error) {
+	invitation, err := s.userInvitation(ctx, invitationID)
+	if err != nil {
+		return Workspace{}, err
+	}
+	workspace, err := s.repo.Get(ctx, invitation.WorkspaceID)
+	if err != nil {
+		return Workspace{}, err
+	}
+	member := entity.WorkspaceMember{
+		ID:          entity.GenerateID(),
+		WorkspaceID: invitation.WorkspaceID,
+		UserID:      invitation.UserID,
+		Role:        invitation.Role,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.repo.AcceptInvitation(ctx, invitation.ID, member); err != nil {
+		return Workspace{}, err
+	}
+	return Workspace{workspace, member.Role}, nil
+}
+
+// DeclineInvitation turns down an invitation of the current user.
+func (s service) DeclineInvitation(ctx context.Context, invitationID string) (entity.WorkspaceInvitation, error) {
+	invitation, err := s.userInvitation(ctx, invitationID)
+	if err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	if err := s.repo.DeleteInvitation(ctx, invitation.ID); err != nil {
+		return entity.WorkspaceInvitation{}, err
+	}
+	return invitation, nil
+}
+
+// membership returns the membership of the current user in the workspace with the specified ID if the user holds
+// at least the given role. Users outside of the workspace get a not found error, so that they cannot tell
+// whether it exists.
+func (s service) membership(ctx context.Context, id, role string) (entity.WorkspaceMember, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return entity.WorkspaceMember{}, errors.Unauthorized("")
+	}
+	member, err := s.repo.GetMember(ctx, id, identity.GetID())
+	if err == sql.ErrNoRows {
+		return entity.WorkspaceMember{}, errors.NotFound("")
+	} else if err != nil {
+		return entity.WorkspaceMember{}, err
+	}
+	if roleRanks[member.Role] < roleRanks[role] {
+		return entity.WorkspaceMember{}, errors.Forbidden("")
+	}
+	return member, nil
+}
+
+// getMember returns the membership of the given user in the workspace, or a not found error.
+func (s service) getMember(ctx context.Context, id, userID string) (entity.WorkspaceMember, error) {
+	member, err := s.repo.GetMember(ctx, id, userID)
+	if err == sql.ErrNoRows {
+		return entity.WorkspaceMember{}, errors.NotFound("the member does not exist")
+	}
+	return member, err
+}
+
+// checkManage verifies that the current member may change a member from one role to another. An empty role
+// stands for a user outside of the workspace. Nobody may change the owner, and only the owner may manage admins.
+func (s service) checkManage(current entity.WorkspaceMember, from, to string) error {
+	if from == entity.RoleOwner {
+		return errors.Forbidden("the owner of the workspace cannot be changed")
+	}
+	if (from == entity.RoleAdmin || to == entity.RoleAdmin) && current.Role != entity.RoleOwner {
+		return errors.Forbidden("only the owner can manage admins")
+	}
+	return nil
+}
+
+// userInvitation returns the invitation with the specified ID if it was sent to the current user.
+func (s service) userInvitation(ctx context.Context, id string) (entity.WorkspaceInvitation, error) {
+	identity := auth.CurrentUser(ctx)
+	if identity == nil {
+		return entity.WorkspaceInvitation{}, errors.Unauthorized("")
+	}
+	invitation, err := s.repo.GetInvitation(ctx, id)
+	if err == sql.ErrNoRows || (err == nil && invitation.UserID != identity.GetID()) {
+		return entity.WorkspaceInvitation{}, errors.NotFound("")
+	}
+	return invitation, err
+}
@@ -0,0 +1,186 @@
+package workspaces
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qiangxue/go-rest-api/internal/auth"
+	"github.com/qiangxue/go-rest-api/internal/entity"
+	"github.com/qiangxue/go-rest-api/internal/errors"
+	"github.com/qiangxue/go-rest-api/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInviteRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     InviteRequest
+		wantError bool
+	}{
+		{"success", InviteRequest{Username: "demo", Role: entity.RoleGuest}, false},
+		{"default role", InviteRequest{Username: "demo"}, false},
+		{"required", InviteRequest{Role: entity.RoleGuest}, true},
+		{"owner", InviteRequest{Username: "demo", Role: entity.RoleOwner}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockWorkspaceRepo{}
+	s := NewService(repo, mockUsers{}, logger)
+
+	owner := auth.WithUser(context.Background(), "user1", "user1")
+	other := auth.WithUser(context.Background(), "user2", "user2")
+
+	// unauthenticated
+	_, err := s.Create(context.Background(), WorkspaceRequest{Name: "team"})
+	assert.Equal(t, errors.Unauthorized(""), err)
+
+	// create
+	workspace, err := s.Create(owner, WorkspaceRequest{Name: "team"})
+	assert.Nil(t, err)
+	assert.Equal(t, entity.RoleOwner, workspace.Role)
+	workspaces, _ := s.Query(owner)
+	assert.Equal(t, 1, len(workspaces))
+
+	// access is limited to the members
+	_, err = s.Get(other, workspace.ID)
+	assert.Equal(t, errors.NotFound(""), err)
+	workspaces, _ = s.Query(other)
+	assert.Equal(t, 0, len(workspaces))
+
+	// update
+	workspace, err = s.Update(owner, workspace.ID, WorkspaceRequest{Name: "renamed"})
+	assert.Nil(t, err)
+	assert.Equal(t, "renamed", workspace.Name)
+
+	// guests cannot rename the workspace
+	repo.members = append(repo.members, entity.WorkspaceMember{ID: "m2", WorkspaceID: workspace.ID, UserID: "user2", Role: entity.RoleGuest})
+	_, err = s.Update(other, workspace.ID, WorkspaceRequest{Name: "mine"})
+	assert.Equal(t, errors.Forbidden(""), err)
+
+	// only the owner can delete an empty workspace
+	_, err = s.Delete(other, workspace.ID)
+	assert.Equal(t, errors.Forbidden(""), err)
+	workspaceID := workspace.ID
+	repo.notes = []entity.Note{{ID: "note1", UserID: "user1", WorkspaceID: &workspaceID}}
+	_, err = s.Delete(owner, workspace.ID)
+	assert.Equal(t, errors.Conflict("the workspace still has notes"), err)
+	now := time.Now()
+	repo.notes[0].DeletedAt = &now
+	_, err = s.Delete(owner, workspace.ID)
+	assert.Nil(t, err)
+	_, err = s.Get(owner, workspace.ID)
+	assert.Equal(t, errors.NotFound(""), err)
+}
+
+func Test_service_members(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockWorkspaceRepo{
+		items: []entity.Workspace{{ID: "ws1", Name: "team"}},
+		members: []entity.WorkspaceMember{
+			{ID: "m1", WorkspaceID: "ws1", UserID: "owner", Role: entity.RoleOwner},
+			{ID: "m2", WorkspaceID: "ws1", UserID: "admin", Role: entity.RoleAdmin},
+			{ID: "m3", WorkspaceID: "ws1", UserID: "member", Role: entity.RoleMember},
+			{ID: "m4", WorkspaceID: "ws1", UserID: "guest", Role: entity.RoleGuest},
+		},
+	}
+	s := NewService(repo, mockUsers{}, logger)
+	as := func(id string) context.Context {
+		return auth.WithUser(context.Background(), id, id)
+	}
+
+	members, err := s.QueryMembers(as("guest"), "ws1")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(members))
+
+	// roles are changed by admins, and admins by the owner only
+	_, err = s.UpdateMember(as("member"), "ws1", "guest", UpdateMemberRequest{Role: entity.RoleMember})
+	assert.Equal(t, errors.Forbidden(""), err)
+	member, err := s.UpdateMember(as("admin"), "ws1", "guest", UpdateMemberRequest{Role: entity.RoleMember})
+	assert.Nil(t, err)
+	assert.Equal(t, entity.RoleMember, member.Role)
+	_, err = s.UpdateMember(as("admin"), "ws1", "member", UpdateMemberRequest{Role: entity.RoleAdmin})
+	assert.Equal(t, errors.Forbidden("only the owner can manage admins"), err)
+	_, err = s.UpdateMember(as("admin"), "ws1", "owner", UpdateMemberRequest{Role: entity.RoleGuest})
+	assert.Equal(t, errors.Forbidden("the owner of the workspace cannot be changed"), err)
+	_, err = s.UpdateMember(as("owner"), "ws1", "owner", UpdateMemberRequest{Role: entity.RoleGuest})
+	assert.Equal(t, errors.BadRequest("you cannot change your own role"), err)
+	_, err = s.UpdateMember(as("owner"), "ws1", "unknown", UpdateMemberRequest{Role: entity.RoleGuest})
+	assert.Equal(t, errors.NotFound("the member does not exist"), err)
+	_, err = s.UpdateMember(as("owner"), "ws1", "member", UpdateMemberRequest{Role: entity.RoleAdmin})
+	assert.Nil(t, err)
+
+	// members leave on their own, except for the owner
+	_, err = s.RemoveMember(as("owner"), "ws1", "owner")
+	assert.Equal(t, errors.BadRequest("the owner cannot leave the workspace"), err)
+	_, err = s.RemoveMember(as("guest"), "ws1", "admin")
+	assert.Equal(t, errors.Forbidden(""), err)
+	_, err = s.RemoveMember(as("admin"), "ws1", "member")
+	assert.Equal(t, errors.Forbidden("only the owner can manage admins"), err)
+	_, err = s.RemoveMember(as("guest"), "ws1", "guest")
+	assert.Nil(t, err)
+	_, err = s.QueryMembers(as("guest"), "ws1")
+	assert.Equal(t, errors.NotFound(""), err)
+}
+
+func Test_service_invitations(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockWorkspaceRepo{
+		items: []entity.Workspace{{ID: "ws1", Name: "team"}},
+		members: []entity.WorkspaceMember{
+			{ID: "m1", WorkspaceID: "ws1", UserID: "owner", Role: entity.RoleOwner},
+			{ID: "m2", WorkspaceID: "ws1", UserID: "admin", Role: entity.RoleAdmin},
+		},
+	}
+	s := NewService(repo, mockUsers{"alice": "alice", "bob": "bob", "admin": "admin"}, logger)
+	as := func(id string) context.Context {
+		return auth.WithUser(context.Background(), id, id)
+	}
+
+	// invite
+	_, err := s.Invite(as("admin"), "ws1", InviteRequest{Username: "unknown"})
+	assert.Equal(t, errors.NotFound("the user does not exist"), err)
+	_, err = s.Invite(as("admin"), "ws1", InviteRequest{Username: "admin"})
+	assert.Equal(t, errors.Conflict("the user is already a member of the workspace"), err)
+	_, err = s.Invite(as("admin"), "ws1", InviteRequest{Username: "alice", Role: entity.RoleAdmin})
+	assert.Equal(t, errors.Forbidden("only the owner can manage admins"), err)
+	invitation, err := s.Invite(as("admin"), "ws1", InviteRequest{Username: "alice"})
+	assert.Nil(t, err)
+	assert.Equal(t, entity.RoleMember, invitation.Role)
+	assert.Equal(t, "admin", invitation.InvitedBy)
+	_, err = s.Invite(as("admin"), "ws1", InviteRequest{Username: "alice"})
+	assert.Equal(t, errors.Conflict("the user is already invited to the workspace"), err)
+	_, err = s.Invite(as("alice"), "ws1", InviteRequest{Username: "bob"})
+	assert.Equal(t, errors.NotFound(""), err)
+
+	// accept
+	invitations, _ := s.QueryUserInvitations(as("alice"))
+	assert.Equal(t, 1, len(invitations))
+	_, err = s.AcceptInvitation(as("bob"), invitation.ID)
+	assert.Equal(t, errors.NotFound(""), err)
+	workspace, err := s.AcceptInvitation(as("alice"), invitation.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, entity.RoleMember, workspace.Role)
+	workspaces, _ := s.Query(as("alice"))
+	assert.Equal(t, 1, len(workspaces))
+
+	// decline and cancel
+	invitation, _ = s.Invite(as("owner"), "ws1", InviteRequest{Username: "bob", Role: entity.RoleAdmin})
+	_, err = s.DeclineInvitation(as("bob"), invitation.ID)
+	assert.Nil(t, err)
+	invitation, _ = s.Invite(as("owner"), "ws1", InviteRequest{Username: "bob"})
+	_, err = s.CancelInvitation(as("alice"), "ws1", invitation.ID)
+	assert.Equal(t, errors.Forbidden(""), err)
+	_, err = s.CancelInvitation(as("admin"), "ws1", invitation.ID)
+	assert.Nil(t, err)
+	invitations, _ = s.QueryInvitations(as("admin"), "ws1")
+	assert.Equal(t, 0, len(invitations))
+}
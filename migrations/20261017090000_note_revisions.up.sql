@@ -0,0 +1,11 @@
+CREATE TABLE note_revisions
+(
+    id         VARCHAR PRIMARY KEY,
+    note_id    VARCHAR NOT NULL,
+    revision   INTEGER NOT NULL,
+    title      VARCHAR NOT NULL,
+    text       VARCHAR NOT NULL,
+    user_id    VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (note_id, revision)
+);
@@ -0,0 +1,17 @@
+CREATE TABLE tags
+(
+    id         VARCHAR PRIMARY KEY,
+    user_id    VARCHAR NOT NULL,
+    name       VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE note_tags
+(
+    note_id VARCHAR NOT NULL,
+    tag_id  VARCHAR NOT NULL,
+    PRIMARY KEY (note_id, tag_id)
+);
+CREATE INDEX note_tags_tag_id_idx ON note_tags (tag_id);
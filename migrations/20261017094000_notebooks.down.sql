@@ -0,0 +1,3 @@
+DROP INDEX notes_notebook_id_idx;
+ALTER TABLE notes DROP COLUMN notebook_id;
+DROP TABLE notebooks;
@@ -0,0 +1,13 @@
+CREATE TABLE notebooks
+(
+    id         VARCHAR PRIMARY KEY,
+    user_id    VARCHAR NOT NULL,
+    parent_id  VARCHAR,
+    name       VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX notebooks_user_id_parent_id_idx ON notebooks (user_id, parent_id);
+
+ALTER TABLE notes ADD COLUMN notebook_id VARCHAR;
+CREATE INDEX notes_notebook_id_idx ON notes (notebook_id);
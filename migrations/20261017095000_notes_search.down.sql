@@ -0,0 +1,4 @@
+DROP INDEX notes_tsv_idx;
+ALTER TABLE notes DROP COLUMN text_searchable;
+ALTER TABLE notes ADD COLUMN text_searchable TSVECTOR;
+CREATE INDEX notes_tsv_idx ON notes USING gin(text_searchable);
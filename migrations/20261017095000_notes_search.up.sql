@@ -0,0 +1,6 @@
+DROP INDEX notes_tsv_idx;
+ALTER TABLE notes DROP COLUMN text_searchable;
+ALTER TABLE notes ADD COLUMN text_searchable TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', text), 'B')
+) STORED;
+CREATE INDEX notes_tsv_idx ON notes USING gin(text_searchable);
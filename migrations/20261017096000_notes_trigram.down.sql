@@ -0,0 +1,3 @@
+DROP INDEX notes_text_trgm_idx;
+DROP INDEX notes_title_trgm_idx;
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,3 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX notes_title_trgm_idx ON notes USING gin (title gin_trgm_ops);
+CREATE INDEX notes_text_trgm_idx ON notes USING gin (text gin_trgm_ops);
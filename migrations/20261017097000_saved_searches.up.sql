@@ -0,0 +1,18 @@
+CREATE TABLE saved_searches
+(
+    id             VARCHAR PRIMARY KEY,
+    user_id        VARCHAR NOT NULL,
+    name           VARCHAR NOT NULL,
+    query          VARCHAR NOT NULL,
+    mode           VARCHAR NOT NULL,
+    sort           VARCHAR NOT NULL,
+    sort_order     VARCHAR NOT NULL,
+    tags           VARCHAR[] NOT NULL DEFAULT '{}',
+    tag_mode       VARCHAR NOT NULL,
+    created_after  TIMESTAMP,
+    updated_before TIMESTAMP,
+    pinned         BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at     TIMESTAMP NOT NULL,
+    updated_at     TIMESTAMP NOT NULL,
+    UNIQUE (user_id, name)
+);
@@ -0,0 +1 @@
+ALTER TABLE shared_notes DROP COLUMN role;
@@ -0,0 +1,3 @@
+-- existing shares keep the write access they were granted
+ALTER TABLE shared_notes ADD COLUMN role VARCHAR NOT NULL DEFAULT 'editor';
+ALTER TABLE shared_notes ALTER COLUMN role DROP DEFAULT;
@@ -0,0 +1,12 @@
+CREATE TABLE share_links
+(
+    id            VARCHAR PRIMARY KEY,
+    note_id       VARCHAR NOT NULL,
+    token         VARCHAR NOT NULL UNIQUE,
+    password_hash VARCHAR NOT NULL DEFAULT '',
+    expires_at    TIMESTAMP,
+    max_views     INTEGER NOT NULL DEFAULT 0,
+    views         INTEGER NOT NULL DEFAULT 0,
+    created_at    TIMESTAMP NOT NULL
+);
+CREATE INDEX share_links_note_id_idx ON share_links (note_id);
@@ -0,0 +1,5 @@
+DROP INDEX notes_workspace_id_idx;
+ALTER TABLE notes DROP COLUMN workspace_id;
+DROP TABLE workspace_invitations;
+DROP TABLE workspace_members;
+DROP TABLE workspaces;
@@ -0,0 +1,33 @@
+CREATE TABLE workspaces
+(
+    id         VARCHAR PRIMARY KEY,
+    name       VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE workspace_members
+(
+    id           VARCHAR PRIMARY KEY,
+    workspace_id VARCHAR NOT NULL,
+    user_id      VARCHAR NOT NULL,
+    role         VARCHAR NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    UNIQUE (workspace_id, user_id)
+);
+CREATE INDEX workspace_members_user_id_idx ON workspace_members (user_id);
+
+CREATE TABLE workspace_invitations
+(
+    id           VARCHAR PRIMARY KEY,
+    workspace_id VARCHAR NOT NULL,
+    user_id      VARCHAR NOT NULL,
+    role         VARCHAR NOT NULL,
+    invited_by   VARCHAR NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    UNIQUE (workspace_id, user_id)
+);
+CREATE INDEX workspace_invitations_user_id_idx ON workspace_invitations (user_id);
+
+ALTER TABLE notes ADD COLUMN workspace_id VARCHAR;
+CREATE INDEX notes_workspace_id_idx ON notes (workspace_id);
@@ -0,0 +1,2 @@
+-- passwords that were hashed since cannot be recovered and keep their hashes
+UPDATE users SET password = substr(password, 8) WHERE password LIKE '$plain$%';
@@ -0,0 +1,4 @@
+-- mark the passwords stored in plain text so that they are hashed on the next successful login. Plain text
+-- passwords may start with "$" too, so only the argon2id and bcrypt hashes are left unmarked.
+UPDATE users SET password = '$plain$' || password
+WHERE password NOT LIKE '$argon2id$%' AND password !~ '^\$2[aby]\$';
@@ -0,0 +1,19 @@
+CREATE TABLE refresh_tokens
+(
+    id              VARCHAR PRIMARY KEY,
+    user_id         VARCHAR NOT NULL,
+    family_id       VARCHAR NOT NULL,
+    token_hash      VARCHAR NOT NULL UNIQUE,
+    access_token_id VARCHAR NOT NULL,
+    expires_at      TIMESTAMP NOT NULL,
+    used_at         TIMESTAMP,
+    revoked_at      TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL
+);
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+CREATE TABLE revoked_tokens
+(
+    id         VARCHAR PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
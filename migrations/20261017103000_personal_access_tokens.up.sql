@@ -0,0 +1,12 @@
+CREATE TABLE personal_access_tokens
+(
+    id           VARCHAR PRIMARY KEY,
+    user_id      VARCHAR NOT NULL,
+    name         VARCHAR NOT NULL,
+    scopes       VARCHAR[] NOT NULL DEFAULT '{}',
+    token_hash   VARCHAR NOT NULL UNIQUE,
+    expires_at   TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL
+);
+CREATE INDEX personal_access_tokens_user_id_idx ON personal_access_tokens (user_id);
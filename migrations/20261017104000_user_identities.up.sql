@@ -0,0 +1,11 @@
+CREATE TABLE user_identities
+(
+    id         VARCHAR PRIMARY KEY,
+    user_id    VARCHAR NOT NULL,
+    issuer     VARCHAR NOT NULL,
+    subject    VARCHAR NOT NULL,
+    email      VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (issuer, subject)
+);
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
@@ -0,0 +1,19 @@
+CREATE TABLE user_totp
+(
+    id             VARCHAR PRIMARY KEY,
+    user_id        VARCHAR NOT NULL UNIQUE,
+    secret         VARCHAR NOT NULL,
+    enabled_at     TIMESTAMP,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at     TIMESTAMP NOT NULL
+);
+
+CREATE TABLE recovery_codes
+(
+    id         VARCHAR PRIMARY KEY,
+    user_id    VARCHAR NOT NULL,
+    code_hash  VARCHAR NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
@@ -0,0 +1,4 @@
+DROP TABLE password_resets;
+
+DROP INDEX users_email_idx;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN email VARCHAR NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX users_email_idx ON users (email) WHERE email <> '';
+
+CREATE TABLE password_resets
+(
+    id         VARCHAR PRIMARY KEY,
+    user_id    VARCHAR NOT NULL,
+    token_hash VARCHAR NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
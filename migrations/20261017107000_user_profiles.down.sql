@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN locale;
+ALTER TABLE users DROP COLUMN timezone;
+ALTER TABLE users DROP COLUMN display_name;
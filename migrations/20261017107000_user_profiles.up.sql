@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN display_name VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN timezone VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN locale VARCHAR NOT NULL DEFAULT '';
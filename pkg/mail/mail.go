@@ -0,0 +1,123 @@
+// Package mail sends plain text emails.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	// Send delivers the message, or returns an error if it cannot be handed over.
+	Send(ctx context.Context, msg Message) error
+}
+
+// ErrInvalidHeader is returned for messages whose recipient or subject span several lines.
+var ErrInvalidHeader = errors.New("the message headers cannot contain line breaks")
+
+// SMTPConfig configures the SMTP server emails are sent through.
+type SMTPConfig struct {
+	Host string
+	Port int
+	// Username and Password authenticate with the server if Username is set. The server has to support TLS
+	// unless it runs on localhost.
+	Username string
+	Password string
+	// From is the sender address of the emails.
+	From string
+}
+
+// smtpMailer sends emails through an SMTP server.
+type smtpMailer struct {
+	config SMTPConfig
+	// send hands a message over to the server. It is replaced in tests.
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTPMailer creates a mailer sending emails through the given SMTP server. The connection is upgraded
+// with STARTTLS when the server supports it.
+func NewSMTPMailer(config SMTPConfig) Mailer {
+	return smtpMailer{config, smtp.SendMail}
+}
+
+// Send sends the message through the SMTP server.
+func (m smtpMailer) Send(ctx context.Context, msg Message) error {
+	data, err := m.format(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	return m.send(addr, auth, m.config.From, []string{msg.To}, data)
+}
+
+// format returns the message with its headers, and its body encoded as quoted-printable.
+func (m smtpMailer) format(msg Message, date time.Time) ([]byte, error) {
+	for _, header := range []string{msg.To, msg.Subject, m.config.From} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.config.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(strings.Replace(msg.Body, "\n", "\r\n", -1))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MemoryMailer keeps the emails in memory instead of sending them. It is meant for tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryMailer creates a new in-memory mailer.
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send keeps the message.
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message{}, m.messages...)
+}
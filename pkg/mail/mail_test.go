@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"context"
+	"net/smtp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTPMailer_Send(t *testing.T) {
+	var addr, from string
+	var to []string
+	var data []byte
+	var auth smtp.Auth
+	mailer := NewSMTPMailer(SMTPConfig{Host: "smtp.example.com", Port: 587, Username: "user", Password: "pass", From: "notes@example.com"}).(smtpMailer)
+	mailer.send = func(a string, au smtp.Auth, f string, t []string, msg []byte) error {
+		addr, auth, from, to, data = a, au, f, t, msg
+		return nil
+	}
+
+	err := mailer.Send(context.Background(), Message{To: "demo@example.com", Subject: "Réinitialiser", Body: "Hello,\nyour code is 123."})
+	assert.Nil(t, err)
+	assert.Equal(t, "smtp.example.com:587", addr)
+	assert.NotNil(t, auth)
+	assert.Equal(t, "notes@example.com", from)
+	assert.Equal(t, []string{"demo@example.com"}, to)
+	assert.Contains(t, string(data), "From: notes@example.com\r\nTo: demo@example.com\r\nSubject: =?utf-8?q?R=C3=A9initialiser?=\r\n")
+	assert.Contains(t, string(data), "\r\n\r\nHello,\r\nyour code is 123.")
+
+	err = mailer.Send(context.Background(), Message{To: "demo@example.com\r\nBcc: other@example.com", Subject: "Hello"})
+	assert.Equal(t, ErrInvalidHeader, err)
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := NewMemoryMailer()
+	assert.Empty(t, mailer.Messages())
+	assert.Nil(t, mailer.Send(context.Background(), Message{To: "demo@example.com", Subject: "Hello", Body: "Hi"}))
+	assert.Equal(t, []Message{{To: "demo@example.com", Subject: "Hello", Body: "Hi"}}, mailer.Messages())
+}
@@ -0,0 +1,143 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CursorVar specifies the query parameter name for the cursor. Its presence in a request selects cursor pagination.
+var CursorVar = "cursor"
+
+// ErrInvalidCursor is returned when a cursor is malformed or its signature does not match.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor identifies a position in a list sorted by a key and then by ID.
+// The items following the position are listed unless Backward is set, in which case the items preceding it are.
+type Cursor struct {
+	// Sort names the sort order the cursor was created for.
+	Sort string `json:"s"`
+	// Key is the sort key of the item at the position.
+	Key string `json:"k"`
+	// ID is the ID of the item at the position.
+	ID string `json:"i"`
+	// Backward makes the cursor point to the items before the position.
+	Backward bool `json:"b,omitempty"`
+}
+
+// CursorPages represents a list of data items fetched with cursor (keyset) pagination.
+// NextCursor and PrevCursor are empty when there are no more items in that direction.
+type CursorPages struct {
+	PerPage    int         `json:"per_page"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+	Items      interface{} `json:"items"`
+}
+
+// NewCursorPages creates a new CursorPages instance with the given page size.
+func NewCursorPages(perPage int) *CursorPages {
+	if perPage <= 0 {
+		perPage = DefaultPageSize
+	}
+	if perPage > MaxPageSize {
+		perPage = MaxPageSize
+	}
+	return &CursorPages{PerPage: perPage}
+}
+
+// NewCursorPagesFromRequest creates a CursorPages object using the page size found in the given HTTP request.
+func NewCursorPagesFromRequest(req *http.Request) *CursorPages {
+	return NewCursorPages(parseInt(req.URL.Query().Get(PageSizeVar), DefaultPageSize))
+}
+
+// Limit returns the LIMIT value that can be used in a SQL statement.
+func (p *CursorPages) Limit() int {
+	return p.PerPage
+}
+
+// BuildLinkHeader returns an HTTP header containing the links to the previous and next pages.
+func (p *CursorPages) BuildLinkHeader(baseURL string, defaultPerPage int) string {
+	if strings.Contains(baseURL, "?") {
+		baseURL += "&"
+	} else {
+		baseURL += "?"
+	}
+	suffix := ""
+	if p.PerPage != defaultPerPage {
+		suffix = fmt.Sprintf("&%v=%v", PageSizeVar, p.PerPage)
+	}
+	var links []string
+	if p.PrevCursor != "" {
+		links = append(links, fmt.Sprintf("<%v%v=%v%v>; rel=\"prev\"", baseURL, CursorVar, p.PrevCursor, suffix))
+	}
+	if p.NextCursor != "" {
+		links = append(links, fmt.Sprintf("<%v%v=%v%v>; rel=\"next\"", baseURL, CursorVar, p.NextCursor, suffix))
+	}
+	return strings.Join(links, ", ")
+}
+
+// Cursors encodes cursors into opaque strings signed with a secret key, and decodes them back.
+// The signature prevents clients from crafting cursors that point anywhere other than where the server sent them.
+type Cursors struct {
+	key []byte
+}
+
+// NewCursors creates a new Cursors instance that signs cursors with the given key.
+func NewCursors(key string) *Cursors {
+	return &Cursors{[]byte(key)}
+}
+
+// Encode returns the signed, URL-safe representation of the cursor.
+func (c *Cursors) Encode(cursor Cursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload))
+}
+
+// Decode verifies the signature of an encoded cursor and returns the cursor.
+// ErrInvalidCursor is returned if the cursor cannot be decoded or has not been signed with the key.
+func (c *Cursors) Decode(value string) (Cursor, error) {
+	var cursor Cursor
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return cursor, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, c.sign(payload)) {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// FromRequest returns the cursor given in the query parameters of the HTTP request.
+// The returned cursor is nil if the parameter is absent or empty, the latter requesting the first page.
+// The ok result reports whether the parameter is present, meaning that cursor pagination is requested.
+func (c *Cursors) FromRequest(req *http.Request) (cursor *Cursor, ok bool, err error) {
+	values, ok := req.URL.Query()[CursorVar]
+	if !ok || values[0] == "" {
+		return nil, ok, nil
+	}
+	decoded, err := c.Decode(values[0])
+	if err != nil {
+		return nil, true, err
+	}
+	return &decoded, true, nil
+}
+
+// sign returns the HMAC-SHA256 signature of the payload.
+func (c *Cursors) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
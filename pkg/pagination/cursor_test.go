@@ -0,0 +1,77 @@
+package pagination
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursors_EncodeDecode(t *testing.T) {
+	cursors := NewCursors("secret")
+	cursor := Cursor{Sort: "updated_at:desc", Key: "2020-01-02T03:04:05Z", ID: "123", Backward: true}
+
+	encoded := cursors.Encode(cursor)
+	decoded, err := cursors.Decode(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	// a cursor signed with another key is rejected
+	_, err = NewCursors("other").Decode(encoded)
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	// a tampered cursor is rejected
+	forged := NewCursors("other").Encode(Cursor{Sort: "updated_at:desc", Key: "x", ID: "1"})
+	_, err = cursors.Decode(strings.Split(forged, ".")[0] + "." + strings.Split(encoded, ".")[1])
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	for _, value := range []string{"", "abc", "a.b.c", "!!.!!"} {
+		_, err = cursors.Decode(value)
+		assert.Equal(t, ErrInvalidCursor, err, value)
+	}
+}
+
+func TestCursors_FromRequest(t *testing.T) {
+	cursors := NewCursors("secret")
+	encoded := cursors.Encode(Cursor{Sort: "title:asc", Key: "a", ID: "1"})
+
+	tests := []struct {
+		tag    string
+		url    string
+		cursor *Cursor
+		ok     bool
+		err    error
+	}{
+		{"absent", "http://example.com?page=2", nil, false, nil},
+		{"first page", "http://example.com?cursor=", nil, true, nil},
+		{"cursor", "http://example.com?cursor=" + encoded, &Cursor{Sort: "title:asc", Key: "a", ID: "1"}, true, nil},
+		{"invalid", "http://example.com?cursor=abc", nil, true, ErrInvalidCursor},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, bytes.NewBufferString(""))
+		cursor, ok, err := cursors.FromRequest(req)
+		assert.Equal(t, test.cursor, cursor, test.tag)
+		assert.Equal(t, test.ok, ok, test.tag)
+		assert.Equal(t, test.err, err, test.tag)
+	}
+}
+
+func TestNewCursorPagesFromRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com?cursor=&per_page=20", bytes.NewBufferString(""))
+	p := NewCursorPagesFromRequest(req)
+	assert.Equal(t, 20, p.PerPage)
+	assert.Equal(t, 20, p.Limit())
+	assert.Equal(t, 1000, NewCursorPages(1001).PerPage)
+	assert.Equal(t, 100, NewCursorPages(0).PerPage)
+}
+
+func TestCursorPages_BuildLinkHeader(t *testing.T) {
+	p := NewCursorPages(20)
+	assert.Equal(t, "", p.BuildLinkHeader("/tokens", 10))
+	p.NextCursor = "n"
+	assert.Equal(t, "</tokens?cursor=n&per_page=20>; rel=\"next\"", p.BuildLinkHeader("/tokens", 10))
+	p.PrevCursor = "p"
+	assert.Equal(t, "</tokens?from=1&cursor=p>; rel=\"prev\", </tokens?from=1&cursor=n>; rel=\"next\"", p.BuildLinkHeader("/tokens?from=1", 20))
+}